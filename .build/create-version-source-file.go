@@ -49,7 +49,10 @@ func main() {
 		"CompatibleVersionDate": compatible_version_date,
 		"Timestamp":             time.Now().Format("2006-01-02 15:04"),
 	}
-	version_code := common.Tprintf(template, data)
+	version_code, err := common.Tprintf(template, data)
+	if err != nil {
+		common.Exit(1, fmt.Sprintf("error filling version template: %s", err))
+	}
 	/*
 	file, err := os.Open(version_dest_file)
 	if err != nil {
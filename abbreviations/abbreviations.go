@@ -143,7 +143,9 @@ func LoadAbbreviations() {
 			for _, item := range abbreviations[arg] {
 				if item != "" {
 					// Replaces possible vars with their value
-					item = common.Tprintf(item, variables)
+					var err error
+					item, err = common.Tprintf(item, variables)
+					common.ErrCheckExitf(err, 1, "error replacing variables in abbreviation %s: %s", arg, err)
 					// adds the replacement items to the new argument list
 					replacement += " " + item
 					new_args = append(new_args, item)
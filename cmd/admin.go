@@ -16,11 +16,16 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
 	"github.com/datacharmer/dbdeployer/sandbox"
 	"github.com/spf13/cobra"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func UnpreserveSandbox(sandbox_dir, sandbox_name string) {
@@ -89,16 +94,10 @@ func PreserveSandbox(sandbox_dir, sandbox_name string) {
 	err := os.Rename(clear, no_clear)
 	common.ErrCheckExitf(err, 1, "Error while renaming script.\n%s", err)
 	template := sandbox.SingleTemplates["sb_locked_template"].Contents
-	var data = common.Smap{
-		"TemplateName": "sb_locked_template",
-		"SandboxDir":   sandbox_name,
-		"AppVersion":   common.VersionDef,
-		"Copyright":    sandbox.Copyright,
-		"ClearCmd":     clear_cmd,
-		"NoClearCmd":   no_clear_cmd,
-	}
+	data := sandbox.LockedClearData(sandbox_name, clear_cmd, no_clear_cmd)
 	template = common.TrimmedLines(template)
-	new_clear_message := common.Tprintf(template, data)
+	new_clear_message, err := common.Tprintf(template, data)
+	common.ErrCheckExitf(err, 1, "error filling template sb_locked_template: %s", err)
 	common.WriteString(new_clear_message, clear)
 	os.Chmod(clear, 0744)
 	fmt.Printf("Sandbox %s locked\n", sandbox_name)
@@ -247,6 +246,226 @@ func RunUpgradeSandbox(cmd *cobra.Command, args []string) {
 	UpgradeSandbox(sandbox_dir, old_sandbox, new_sandbox)
 }
 
+func AddSlave(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		common.Exit(1,
+			"'add-slave' requires the name of a replication sandbox",
+			"Example: dbdeployer admin add-slave rsandbox_5_7_22")
+	}
+	flags := cmd.Flags()
+	sandbox_home := GetAbsolutePathFromFlag(cmd, defaults.SandboxHomeLabel)
+	sandbox_dir := sandbox_home + "/" + args[0]
+	if !common.DirExists(sandbox_dir) {
+		common.Exitf(1, "Directory '%s' not found", sandbox_dir)
+	}
+	master_node, _ := flags.GetInt(defaults.MasterNodeLabel)
+	version, _ := flags.GetString(defaults.VersionLabel)
+	basedir := ""
+	if version != "" {
+		sandbox_binary := GetAbsolutePathFromFlag(cmd, defaults.SandboxBinaryLabel)
+		version = check_if_abridged_version(version, sandbox_binary)
+		basedir = sandbox_binary + "/" + version
+	}
+	node_name, err := sandbox.AddSlave(sandbox_dir, version, basedir, master_node)
+	if err != nil {
+		common.Exitf(1, "Error adding a slave to %s: %s", args[0], err)
+	}
+	fmt.Printf("Node %s added to %s\n", node_name, sandbox_dir)
+}
+
+func RemoveNode(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		common.Exit(1,
+			"'remove-node' requires the name of a sandbox and the name of a node",
+			"Example: dbdeployer admin remove-node rsandbox_5_7_22 node2")
+	}
+	sandbox_home := GetAbsolutePathFromFlag(cmd, defaults.SandboxHomeLabel)
+	sandbox_dir := sandbox_home + "/" + args[0]
+	if !common.DirExists(sandbox_dir) {
+		common.Exitf(1, "Directory '%s' not found", sandbox_dir)
+	}
+	node_name := args[1]
+	// A plain number refers to the node directory with that number
+	if _, err := strconv.Atoi(node_name); err == nil {
+		node_name = defaults.Defaults().NodePrefix + node_name
+	}
+	err := sandbox.RemoveNode(sandbox_dir, node_name)
+	if err != nil {
+		common.Exitf(1, "Error removing node %s from %s: %s", node_name, args[0], err)
+	}
+	fmt.Printf("Node %s removed from %s\n", node_name, sandbox_dir)
+}
+
+func CopySandbox(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		common.Exit(1,
+			"'copy' requires the name of an existing sandbox and the name of the copy",
+			"Example: dbdeployer admin copy msb_5_7_22 msb_5_7_22_baseline")
+	}
+	sandbox_home := GetAbsolutePathFromFlag(cmd, defaults.SandboxHomeLabel)
+	source_dir := sandbox_home + "/" + args[0]
+	dest_dir := sandbox_home + "/" + args[1]
+	if !common.DirExists(source_dir) {
+		common.Exitf(1, "Directory '%s' not found", source_dir)
+	}
+	skip_start, _ := cmd.Flags().GetBool(defaults.SkipStartLabel)
+	err := sandbox.CopySandbox(source_dir, dest_dir, skip_start)
+	if err != nil {
+		common.Exitf(1, "Error copying %s to %s: %s", args[0], args[1], err)
+	}
+	fmt.Printf("Sandbox %s copied to %s\n", args[0], dest_dir)
+}
+
+func MoveSandbox(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		common.Exit(1,
+			"'move' requires the name of a sandbox and its new name or location",
+			"Example: dbdeployer admin move msb_5_7_22 msb_5_7_22_old")
+	}
+	sandbox_home := GetAbsolutePathFromFlag(cmd, defaults.SandboxHomeLabel)
+	sandbox_dir := sandbox_home + "/" + args[0]
+	if !common.DirExists(sandbox_dir) {
+		common.Exitf(1, "Directory '%s' not found", sandbox_dir)
+	}
+	// A bare name is a new name in the same sandbox home
+	new_dir := sandbox_home + "/" + args[1]
+	if strings.Contains(args[1], "/") {
+		new_dir = common.AbsolutePath(args[1])
+	}
+	err := sandbox.MoveSandbox(sandbox_dir, new_dir)
+	if err != nil {
+		common.Exitf(1, "Error moving %s to %s: %s", args[0], new_dir, err)
+	}
+	fmt.Printf("Sandbox %s moved to %s\n", args[0], new_dir)
+}
+
+func SnapshotSandbox(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		common.Exit(1,
+			"'snapshot' requires the name of a sandbox",
+			"Example: dbdeployer admin snapshot msb_5_7_22 baseline")
+	}
+	sandbox_home := GetAbsolutePathFromFlag(cmd, defaults.SandboxHomeLabel)
+	sandbox_dir := sandbox_home + "/" + args[0]
+	if !common.DirExists(sandbox_dir) {
+		common.Exitf(1, "Directory '%s' not found", sandbox_dir)
+	}
+	name := ""
+	if len(args) > 1 {
+		name = args[1]
+	}
+	name, err := sandbox.SnapshotSandbox(sandbox_dir, name)
+	if err != nil {
+		common.Exitf(1, "Error creating a snapshot of %s: %s", args[0], err)
+	}
+	fmt.Printf("Snapshot '%s' of %s created\n", name, args[0])
+}
+
+func RestoreSandbox(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		common.Exit(1,
+			"'restore' requires the name of a sandbox and the name of a snapshot",
+			"Example: dbdeployer admin restore msb_5_7_22 baseline")
+	}
+	sandbox_home := GetAbsolutePathFromFlag(cmd, defaults.SandboxHomeLabel)
+	sandbox_dir := sandbox_home + "/" + args[0]
+	if !common.DirExists(sandbox_dir) {
+		common.Exitf(1, "Directory '%s' not found", sandbox_dir)
+	}
+	err := sandbox.RestoreSandbox(sandbox_dir, args[1])
+	if err != nil {
+		common.Exitf(1, "Error restoring %s: %s", args[0], err)
+	}
+	fmt.Printf("Snapshot '%s' of %s restored\n", args[1], args[0])
+}
+
+// Gets the server process for the sandbox in the first argument,
+// which can be a name in sandbox-home or a full path.
+func server_from_args(cmd *cobra.Command, args []string, command string) *sandbox.ServerProcess {
+	if len(args) < 1 {
+		common.Exit(1,
+			fmt.Sprintf("'%s' requires the name of a sandbox or the path of a sandbox directory", command),
+			fmt.Sprintf("Example: dbdeployer admin %s msb_5_7_22", command))
+	}
+	sandbox_dir := args[0]
+	if !strings.HasPrefix(sandbox_dir, "/") {
+		sandbox_dir = GetAbsolutePathFromFlag(cmd, defaults.SandboxHomeLabel) + "/" + sandbox_dir
+	}
+	if !common.DirExists(sandbox_dir) {
+		common.Exitf(1, "Directory '%s' not found", sandbox_dir)
+	}
+	server, err := sandbox.NewServerProcess(sandbox_dir)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	return server
+}
+
+// Returns the timeout given with --timeout
+func timeout_from_flags(cmd *cobra.Command, default_timeout time.Duration) time.Duration {
+	timeout, _ := cmd.Flags().GetInt(defaults.TimeoutLabel)
+	if timeout <= 0 {
+		return default_timeout
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+func StartServer(cmd *cobra.Command, args []string) {
+	server := server_from_args(cmd, args, "start")
+	custom_mysqld, _ := cmd.Flags().GetString(defaults.CustomMysqldLabel)
+	err := server.Start(context.Background(), custom_mysqld, args[1:], timeout_from_flags(cmd, sandbox.DefaultStartTimeout))
+	common.ErrCheckExitf(err, 1, "%s", err)
+}
+
+func StopServer(cmd *cobra.Command, args []string) {
+	server := server_from_args(cmd, args, "stop")
+	force, _ := cmd.Flags().GetBool(defaults.ForceLabel)
+	var err error
+	if force {
+		err = server.Kill()
+	} else {
+		err = server.Stop(timeout_from_flags(cmd, sandbox.DefaultStopTimeout))
+	}
+	common.ErrCheckExitf(err, 1, "%s", err)
+}
+
+func RestartServer(cmd *cobra.Command, args []string) {
+	server := server_from_args(cmd, args, "restart")
+	custom_mysqld, _ := cmd.Flags().GetString(defaults.CustomMysqldLabel)
+	err := server.Restart(context.Background(), custom_mysqld, args[1:], timeout_from_flags(cmd, sandbox.DefaultStartTimeout))
+	common.ErrCheckExitf(err, 1, "%s", err)
+}
+
+func ShowServerStatus(cmd *cobra.Command, args []string) {
+	server := server_from_args(cmd, args, "status")
+	status := "off"
+	if server.IsRunning() {
+		status = "on"
+	}
+	fmt.Printf("%s %s\n", common.BaseName(server.SandboxDir), status)
+}
+
+func WaitForSandbox(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		common.Exit(1,
+			"'wait' requires the name of a sandbox or the path of a sandbox directory",
+			"Example: dbdeployer admin wait msb_5_7_22")
+	}
+	sandbox_dir := args[0]
+	if !strings.HasPrefix(sandbox_dir, "/") {
+		sandbox_dir = GetAbsolutePathFromFlag(cmd, defaults.SandboxHomeLabel) + "/" + sandbox_dir
+	}
+	if !common.DirExists(sandbox_dir) {
+		common.Exitf(1, "Directory '%s' not found", sandbox_dir)
+	}
+	writable, _ := cmd.Flags().GetBool(defaults.WritableLabel)
+	options := sandbox.ReadinessOptions{
+		Timeout:  timeout_from_flags(cmd, sandbox.DefaultStartTimeout),
+		Writable: writable,
+	}
+	err := sandbox.WaitForSandbox(sandbox_dir, options)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	fmt.Printf("Sandbox %s is ready\n", args[0])
+}
+
 var (
 	adminCmd = &cobra.Command{
 		Use:     "admin",
@@ -281,6 +500,164 @@ The data directory of the old sandbox will be moved to the new one.`,
 		Example: "dbdeployer admin upgrade msb_8_0_11 msb_8_0_12",
 		Run:     RunUpgradeSandbox,
 	}
+	adminAddSlaveCmd = &cobra.Command{
+		Use:   "add-slave sandbox_name",
+		Short: "Adds a slave to a replication sandbox",
+		Long: `Adds a new node to a replication sandbox, as a slave of an existing node.
+The new node is seeded with a dump of its master data, and then
+replicates from it using the replication user of the sandbox.
+By default, the new node uses the same version as its master. A different
+version can be used with --version, as long as it is not lower than the master's.
+Works with master-slave, chain, and tree topologies. In chain and tree
+sandboxes, the master can be any node (--master-node). Adding a slave to a
+chain turns it into a tree.
+The scripts that act on all nodes (start_all, use_all, check_slaves, and so on)
+are updated to include the new node.`,
+		Example: `
+	$ dbdeployer admin add-slave rsandbox_5_7_22
+	$ dbdeployer admin add-slave rsandbox_5_7_22 --version=8.0.12
+	$ dbdeployer admin add-slave tree_msb_5_7_22 --master-node=3
+`,
+		Run: AddSlave,
+	}
+	adminRemoveNodeCmd = &cobra.Command{
+		Use:   "remove-node sandbox_name node_name",
+		Short: "Removes a node from a multiple or replication sandbox",
+		Long: `Stops and deletes one node of a multiple or replication sandbox.
+The node can be indicated by its directory name (node2) or by its number (2).
+The scripts that act on all nodes (start_all, use_all, check_slaves, and so on)
+are updated, and the ports of the node become available.
+Works with multiple, master-slave, chain, and tree topologies.
+Masters can't be removed, and neither can the last slave of a sandbox.`,
+		Example: `
+	$ dbdeployer admin remove-node rsandbox_5_7_22 node2
+	$ dbdeployer admin remove-node multi_msb_5_7_22 3
+`,
+		Run: RemoveNode,
+	}
+	adminCopyCmd = &cobra.Command{
+		Use:   "copy sandbox_name new_sandbox_name",
+		Short: "Copies a single sandbox",
+		Long: `Creates a new single sandbox with the data of an existing one.
+The source sandbox is stopped while its data directory is copied, and
+restarted afterwards, if it was running.
+The new sandbox gets new ports, server ID, and server UUID, and it is
+registered in the catalog like any other sandbox.
+Use it to prepare a baseline sandbox once, and clone it as many times as needed.`,
+		Example: `
+	$ dbdeployer admin copy msb_5_7_22 msb_5_7_22_test
+	$ dbdeployer admin copy msb_8_0_12 msb_8_0_12_copy --skip-start
+`,
+		Run: CopySandbox,
+	}
+	adminMoveCmd = &cobra.Command{
+		Use:     "move sandbox_name new_name_or_location",
+		Aliases: []string{"rename"},
+		Short:   "Moves a sandbox to a new location",
+		Long: `Moves or renames a sandbox.
+The new location can be a new name within the sandbox home, or a full path.
+The sandbox is stopped during the move, and restarted afterwards if it was running.
+Scripts and configuration files are updated to use the new location, and
+the catalog entry follows the sandbox.
+Ports are not changed.`,
+		Example: `
+	$ dbdeployer admin move msb_5_7_22 msb_5_7_22_baseline
+	$ dbdeployer admin move rsandbox_8_0_12 /opt/sandboxes/rsandbox_8_0_12
+`,
+		Run: MoveSandbox,
+	}
+	adminSnapshotCmd = &cobra.Command{
+		Use:   "snapshot sandbox_name [snapshot_name]",
+		Short: "Saves the data of a sandbox",
+		Long: `Saves the data directory of a sandbox into a compressed archive,
+stored in the "snapshots" directory of the sandbox.
+In multiple sandboxes, all nodes are stopped and saved together, so
+that the snapshot is consistent across nodes.
+The sandbox is restarted afterwards, if it was running.
+Without a snapshot name, the current date and time are used.`,
+		Example: `
+	$ dbdeployer admin snapshot msb_5_7_22 baseline
+	$ dbdeployer admin snapshot rsandbox_8_0_12
+`,
+		Run: SnapshotSandbox,
+	}
+	adminRestoreCmd = &cobra.Command{
+		Use:   "restore sandbox_name snapshot_name",
+		Short: "Restores the data of a sandbox from a snapshot",
+		Long: `Replaces the data directory of a sandbox (or of all its nodes)
+with the contents of a snapshot taken with "dbdeployer admin snapshot".
+The sandbox is restarted afterwards, if it was running.`,
+		Example: `
+	$ dbdeployer admin restore msb_5_7_22 baseline
+`,
+		Run: RestoreSandbox,
+	}
+
+	adminStartCmd = &cobra.Command{
+		Use:   "start sandbox_name_or_dir [-- mysqld options]",
+		Short: "Starts the database server of a sandbox",
+		Long: `Starts the database server of a single sandbox, or of a node of a multiple
+sandbox, using the sandbox options file (my.sandbox.cnf). The server is
+launched through mysqld_safe, and the command waits until the pid file and
+the socket are created, or until the timeout expires.
+Options after "--" are passed to the server.
+The sandbox "start" and "restart" scripts call this command.`,
+		Example: `
+	$ dbdeployer admin start msb_5_7_22
+	$ dbdeployer admin start rsandbox_5_7_22/node1 --timeout=30
+	$ dbdeployer admin start msb_5_7_22 -- --general-log=1
+`,
+		Run: StartServer,
+	}
+
+	adminStopCmd = &cobra.Command{
+		Use:   "stop sandbox_name_or_dir",
+		Short: "Stops the database server of a sandbox",
+		Long: `Stops the database server of a single sandbox, or of a node of a multiple
+sandbox. The server is asked to shut down cleanly, and it is killed if it
+does not stop before the timeout. With --force, the server is killed
+without waiting.
+The sandbox "stop" and "send_kill" scripts call this command.`,
+		Example: `
+	$ dbdeployer admin stop msb_5_7_22
+	$ dbdeployer admin stop msb_5_7_22 --force
+`,
+		Run: StopServer,
+	}
+
+	adminRestartCmd = &cobra.Command{
+		Use:   "restart sandbox_name_or_dir [-- mysqld options]",
+		Short: "Restarts the database server of a sandbox",
+		Long: `Stops the database server of a sandbox, and starts it again.
+Options after "--" are passed to the server.`,
+		Run: RestartServer,
+	}
+
+	adminWaitCmd = &cobra.Command{
+		Use:   "wait sandbox_name_or_dir",
+		Short: "Waits until the database servers of a sandbox are ready",
+		Long: `Waits until the database server of a single sandbox, or all the nodes of
+a multiple sandbox, accept connections and answer queries.
+Group replication nodes must also be ONLINE in their group.
+With --writable, the servers must not be read-only.
+If the servers are not ready before the timeout, the command reports
+which check failed and exits with an error.`,
+		Example: `
+	$ dbdeployer admin wait msb_5_7_22
+	$ dbdeployer admin wait group_msb_5_7_22 --timeout=60
+	$ dbdeployer admin wait rsandbox_5_7_22/master --writable
+`,
+		Run: WaitForSandbox,
+	}
+
+	adminStatusCmd = &cobra.Command{
+		Use:   "status sandbox_name_or_dir",
+		Short: "Shows whether the database server of a sandbox is running",
+		Long: `Shows the name of the sandbox followed by "on" or "off".
+The sandbox "status" script calls this command.
+For a more detailed report, use "dbdeployer sandboxes --status".`,
+		Run: ShowServerStatus,
+	}
 )
 
 func init() {
@@ -288,4 +665,27 @@ func init() {
 	adminCmd.AddCommand(adminLockCmd)
 	adminCmd.AddCommand(adminUnlockCmd)
 	adminCmd.AddCommand(adminUpgradeCmd)
+	adminCmd.AddCommand(adminAddSlaveCmd)
+	adminCmd.AddCommand(adminRemoveNodeCmd)
+	adminCmd.AddCommand(adminCopyCmd)
+	adminCmd.AddCommand(adminMoveCmd)
+	adminCmd.AddCommand(adminSnapshotCmd)
+	adminCmd.AddCommand(adminRestoreCmd)
+	adminCmd.AddCommand(adminStartCmd)
+	adminCmd.AddCommand(adminStopCmd)
+	adminCmd.AddCommand(adminRestartCmd)
+	adminCmd.AddCommand(adminStatusCmd)
+	adminCmd.AddCommand(adminWaitCmd)
+
+	adminAddSlaveCmd.Flags().String(defaults.VersionLabel, "", "Version of the new slave (default: the master version)")
+	adminAddSlaveCmd.Flags().Int(defaults.MasterNodeLabel, 1, "Node that will be the master of the new slave")
+	adminCopyCmd.Flags().Bool(defaults.SkipStartLabel, false, "Do not start the new sandbox")
+	adminStartCmd.Flags().String(defaults.CustomMysqldLabel, "", "Uses an alternative mysqld executable from the sandbox basedir")
+	adminStartCmd.Flags().Int(defaults.TimeoutLabel, 0, "Seconds to wait for the server to start (default 180)")
+	adminRestartCmd.Flags().String(defaults.CustomMysqldLabel, "", "Uses an alternative mysqld executable from the sandbox basedir")
+	adminRestartCmd.Flags().Int(defaults.TimeoutLabel, 0, "Seconds to wait for the server to start (default 180)")
+	adminWaitCmd.Flags().Int(defaults.TimeoutLabel, 0, "Seconds to wait for the servers to be ready (default 180)")
+	adminWaitCmd.Flags().Bool(defaults.WritableLabel, false, "The servers must not be read-only")
+	adminStopCmd.Flags().Bool(defaults.ForceLabel, false, "Kills the server without waiting for a clean shutdown")
+	adminStopCmd.Flags().Int(defaults.TimeoutLabel, 0, "Seconds to wait for a clean shutdown before killing the server (default 180)")
 }
@@ -0,0 +1,92 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/sandbox"
+	"github.com/spf13/cobra"
+)
+
+func check_catalog(cmd *cobra.Command) []sandbox.CatalogIssue {
+	if !defaults.IsCatalogEnabled() {
+		common.Exit(1, "The sandboxes catalog is disabled (SKIP_DBDEPLOYER_CATALOG is set)")
+	}
+	sandbox_home := GetAbsolutePathFromFlag(cmd, defaults.SandboxHomeLabel)
+	issues, err := sandbox.CheckCatalog(sandbox_home)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	for _, issue := range issues {
+		fmt.Printf("%-12s %s (%s)\n", issue.Kind, issue.Sandbox, issue.Details)
+	}
+	return issues
+}
+
+func CheckCatalog(cmd *cobra.Command, args []string) {
+	issues := check_catalog(cmd)
+	if len(issues) > 0 {
+		common.Exitf(1, "%d problems found. Run 'dbdeployer catalog repair' to fix them", len(issues))
+	}
+	fmt.Println("The catalog agrees with the installed sandboxes")
+}
+
+func RepairCatalog(cmd *cobra.Command, args []string) {
+	issues := check_catalog(cmd)
+	if len(issues) == 0 {
+		fmt.Println("Nothing to repair")
+		return
+	}
+	err := sandbox.RepairCatalog(issues)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	fmt.Printf("%d problems fixed\n", len(issues))
+}
+
+var (
+	catalogCmd = &cobra.Command{
+		Use:   "catalog",
+		Short: "Checks the sandboxes catalog",
+		Long: `Compares the sandboxes catalog (~/.dbdeployer/sandboxes.json) with the
+sandboxes installed in $SANDBOX_HOME, and optionally fixes the differences.
+The problems that can be found are:
+  orphan       : a catalog entry for a sandbox that does not exist anymore
+                 (for example, a sandbox removed with "rm -rf")
+  unregistered : a sandbox that is not in the catalog
+                 (for example, created with SKIP_DBDEPLOYER_CATALOG set)
+  port         : the ports in the catalog differ from the ones in sbdescription.json`,
+	}
+	catalogCheckCmd = &cobra.Command{
+		Use:   "check",
+		Short: "Reports the differences between catalog and sandboxes",
+		Long: `Reports the differences between the catalog and the installed sandboxes.
+Exits with an error when any problem is found.`,
+		Run: CheckCatalog,
+	}
+	catalogRepairCmd = &cobra.Command{
+		Use:   "repair",
+		Short: "Fixes the differences between catalog and sandboxes",
+		Long: `Removes orphan entries from the catalog, adds the unregistered sandboxes,
+and updates the ports of the catalog using the ones in sbdescription.json.`,
+		Run: RepairCatalog,
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(catalogCmd)
+	catalogCmd.AddCommand(catalogCheckCmd)
+	catalogCmd.AddCommand(catalogRepairCmd)
+}
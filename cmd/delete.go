@@ -94,7 +94,8 @@ func DeleteSandbox(cmd *cobra.Command, args []string) {
 		if sb.Locked {
 			fmt.Printf("Sandbox %s is locked\n", sb.SandboxName)
 		} else {
-			exec_list := sandbox.RemoveSandbox(sandbox_dir, sb.SandboxName, run_concurrently)
+			exec_list, err := sandbox.RemoveSandbox(sandbox_dir, sb.SandboxName, run_concurrently)
+			common.ErrCheckExitf(err, 1, "%s", err)
 			for _, list := range exec_list {
 				exec_lists = append(exec_lists, list)
 			}
@@ -104,7 +105,8 @@ func DeleteSandbox(cmd *cobra.Command, args []string) {
 	for _, sb := range deletion_list {
 		full_path := sandbox_dir + "/" + sb.SandboxName
 		if !sb.Locked {
-			defaults.DeleteFromCatalog(full_path)
+			err := defaults.DeleteFromCatalog(full_path)
+			common.ErrCheckExitf(err, 1, "Error removing %s from the catalog: %s", full_path, err)
 		}
 	}
 }
@@ -16,18 +16,201 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/deployer"
+	"github.com/datacharmer/dbdeployer/sandbox"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"math/rand"
 	"os"
+	"strings"
 )
 
+// Deployment options that can be combined with --from-file.
+// All the others must be set in the file.
+var from_file_options = map[string]bool{
+	defaults.FromFileLabel:        true,
+	defaults.ForceLabel:           true,
+	defaults.SkipStartLabel:       true,
+	defaults.ConcurrentLabel:      true,
+	defaults.LogSBOperationsLabel: true,
+	defaults.DryRunLabel:          true,
+	defaults.JsonLabel:            true,
+}
+
+func DeployFromFile(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	spec_file, _ := flags.GetString(defaults.FromFileLabel)
+	if spec_file == "" {
+		cmd.Help()
+		return
+	}
+	if len(args) > 0 {
+		common.Exitf(1, "Option --%s does not accept arguments (found: %v)", defaults.FromFileLabel, args)
+	}
+	var ignored_options []string
+	flags.Visit(func(flag *pflag.Flag) {
+		if cmd.PersistentFlags().Lookup(flag.Name) != nil && !from_file_options[flag.Name] {
+			ignored_options = append(ignored_options, "--"+flag.Name)
+		}
+	})
+	if len(ignored_options) > 0 {
+		common.Exitf(1, "These options can't be used with --%s (set them in the file instead): %s",
+			defaults.FromFileLabel, strings.Join(ignored_options, ", "))
+	}
+	specs, err := deployer.ReadSpecFile(spec_file)
+	common.ErrCheckExitf(err, 1, "%s", err)
+
+	// Options given on the command line override the ones in the file
+	for N := range specs {
+		if flags.Changed(defaults.ForceLabel) {
+			specs[N].Force, _ = flags.GetBool(defaults.ForceLabel)
+		}
+		if flags.Changed(defaults.SkipStartLabel) {
+			specs[N].SkipStart, _ = flags.GetBool(defaults.SkipStartLabel)
+		}
+		if flags.Changed(defaults.ConcurrentLabel) {
+			specs[N].Concurrent, _ = flags.GetBool(defaults.ConcurrentLabel)
+		}
+	}
+	log_sb_operations, _ := flags.GetBool(defaults.LogSBOperationsLabel)
+	defaults.LogSBOperations = log_sb_operations
+
+	sandbox_home := GetAbsolutePathFromFlag(cmd, defaults.SandboxHomeLabel)
+	sandbox_binary := GetAbsolutePathFromFlag(cmd, defaults.SandboxBinaryLabel)
+	d := deployer.New(sandbox_home, sandbox_binary)
+	if is_dry_run(cmd) {
+		restore_output := discard_output()
+		plan, err := d.Plan(context.Background(), specs)
+		common.ErrCheckExitf(err, 1, "%s", err)
+		restore_output()
+		show_deployment_plan(cmd, plan)
+		return
+	}
+	sandboxes, err := d.DeployAll(context.Background(), specs)
+	for _, sb := range sandboxes {
+		fmt.Printf("Deployed %s %s in %s\n", sb.Type, sb.Version, common.ReplaceLiteralHome(sb.Dir))
+	}
+	common.ErrCheckExitf(err, 1, "%s", err)
+}
+
+func is_dry_run(cmd *cobra.Command) bool {
+	dry_run, _ := cmd.Flags().GetBool(defaults.DryRunLabel)
+	return dry_run
+}
+
+// The deployment functions report their progress as if every action
+// happened. In a dry run, those messages are discarded.
+// When exiting with an error, the output is restored by the clean-up
+// actions, and only the error message is shown.
+func discard_output() (restore func()) {
+	stdout := os.Stdout
+	restore = func() {
+		os.Stdout = stdout
+	}
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	os.Stdout = null
+	common.AddToCleanupStack(func(string) { restore() }, "restore output", "")
+	return
+}
+
+// Runs a deployment in dry-run mode and shows what it would do
+func dry_run_deployment(cmd *cobra.Command, create func() error) {
+	plan := sandbox.StartDryRun()
+	restore_output := discard_output()
+	err := create()
+	sandbox.StopDryRun()
+	common.ErrCheckExitf(err, 1, "%s", err)
+	restore_output()
+	show_deployment_plan(cmd, plan)
+}
+
+func show_deployment_plan(cmd *cobra.Command, plan *sandbox.DeploymentPlan) {
+	as_json, _ := cmd.Flags().GetBool(defaults.JsonLabel)
+	if as_json {
+		out, err := json.MarshalIndent(plan, " ", "\t")
+		common.ErrCheckExitf(err, 1, "error encoding deployment plan: %s", err)
+		fmt.Println(string(out))
+		return
+	}
+	fmt.Printf("# Dry run: nothing was created\n")
+	template := "%-45s %-17s %-8s %-20s %-10s %s\n"
+	fmt.Printf("\n## Sandboxes\n")
+	fmt.Printf(template, "directory", "type", "version", "port", "server-id", "basedir")
+	for _, sb := range plan.Sandboxes {
+		server_id := ""
+		if sb.ServerId > 0 {
+			server_id = fmt.Sprintf("%d", sb.ServerId)
+		}
+		fmt.Printf(template, common.ReplaceLiteralHome(sb.Directory), sb.SBType, sb.Version,
+			int_list_text(sb.Port), server_id, common.ReplaceLiteralHome(sb.Basedir))
+	}
+	var sections = []struct {
+		title string
+		items []string
+	}{
+		{"Directories", plan.Directories},
+		{"Scripts", plan.Scripts},
+		{"Commands", plan.Commands},
+	}
+	for _, section := range sections {
+		fmt.Printf("\n## %s (%d)\n", section.title, len(section.items))
+		for _, item := range section.items {
+			fmt.Printf("%s\n", common.ReplaceLiteralHome(item))
+		}
+	}
+}
+
 var deployCmd = &cobra.Command{
 	Use:   "deploy",
 	Short: "deploy sandboxes",
-	Long:  `Deploys single, multiple, or replicated sandboxes`,
+	Long: `Deploys single, multiple, or replicated sandboxes.
+With --from-file, deploys all the sandboxes described in a YAML or JSON file.
+The file contains a list of sandboxes, each one with the same options
+that would be given on the command line:
+
+  sandboxes:
+    - version: 5.7.22
+      topology: master-slave
+      nodes: 3
+      gtid: true
+      my-cnf-options:
+        - innodb_buffer_pool_size=256M
+      per-node-options:
+        2:
+          - read_only=1
+      post-grants-sql:
+        - create schema test2
+    - version: 8.0.11
+      topology: group
+      single-primary: true
+
+Keys: version, basedir, basedir-name, topology, nodes, ndb-nodes, sandbox-directory,
+port, base-port, db-user, db-password, rpl-user, rpl-password,
+remote-access, bind-address, master-ip, master-list, slave-list, replication-tree,
+single-primary, semi-sync, gtid, repl-crash-safe, master,
+native-auth-plugin, keep-server-uuid, expose-dd-tables, enable-mysqlx,
+disable-mysqlx, enable-general-log, init-general-log, skip-report-host,
+skip-report-port, custom-mysqld, history-dir, my-cnf-file, my-cnf-options,
+node-options, master-options, slave-options, per-node-options,
+init-options, pre-grants-sql, post-grants-sql, pre-grants-sql-file,
+post-grants-sql-file, skip-start, skip-load-grants, force, concurrent,
+templates.
+On the command line, only --force, --skip-start, and --concurrent can be
+combined with --from-file, and they override the values in the file.
+`,
+	Example: `
+	$ dbdeployer deploy --from-file=topology.yaml
+	$ dbdeployer deploy --from-file=topology.json --force
+`,
+	Run: DeployFromFile,
 }
 
 func init() {
@@ -40,6 +223,7 @@ func init() {
 		os.Setenv("MYSQL_TEST_LOGIN_FILE", fmt.Sprintf("/tmp/dont_break_my_sandboxes%d", rand.Int()))
 	}
 	rootCmd.AddCommand(deployCmd)
+	deployCmd.Flags().String(defaults.FromFileLabel, "", "Deploys the sandboxes described in a YAML or JSON file")
 	deployCmd.PersistentFlags().Int(defaults.PortLabel, 0, "Overrides default port")
 	deployCmd.PersistentFlags().Int(defaults.BasePortLabel, 0, "Overrides default base-port (for multiple sandboxes)")
 	deployCmd.PersistentFlags().Bool(defaults.GtidLabel, false, "enables GTID")
@@ -58,6 +242,8 @@ func init() {
 	deployCmd.PersistentFlags().Bool(defaults.EnableGeneralLogLabel, false, "Enables general log for the sandbox (MySQL 5.1+)")
 	deployCmd.PersistentFlags().Bool(defaults.InitGeneralLogLabel, false, "uses general log during initialization (MySQL 5.1+)")
 	deployCmd.PersistentFlags().Bool(defaults.LogSBOperationsLabel, defaults.LogSBOperations, "Logs sandbox operations to a file")
+	deployCmd.PersistentFlags().Bool(defaults.DryRunLabel, false, "Shows the sandboxes, ports, scripts, and commands of the deployment, without creating anything")
+	deployCmd.PersistentFlags().Bool(defaults.JsonLabel, false, "With --dry-run, shows the deployment plan in JSON format")
 
 	set_pflag(deployCmd, defaults.LogLogDirectoryLabel, "", "", defaults.Defaults().LogDirectory, "Where to store dbdeployer logs", false)
 	set_pflag(deployCmd, defaults.RemoteAccessLabel, "", "", defaults.RemoteAccessValue, "defines the database access ", false)
@@ -0,0 +1,79 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/deployer"
+	"github.com/spf13/cobra"
+)
+
+func ExportSpec(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		common.Exit(1,
+			"Sandbox name required.",
+			"You can run 'dbdeployer sandboxes for a list of available deployments'")
+	}
+	flags := cmd.Flags()
+	output, _ := flags.GetString(defaults.OutputLabel)
+	sandbox_home := GetAbsolutePathFromFlag(cmd, defaults.SandboxHomeLabel)
+	sandbox_binary := GetAbsolutePathFromFlag(cmd, defaults.SandboxBinaryLabel)
+	d := deployer.New(sandbox_home, sandbox_binary)
+	var specs []deployer.Spec
+	for _, sandbox_name := range args {
+		spec, err := d.ExportSpec(sandbox_name)
+		common.ErrCheckExitf(err, 1, "%s", err)
+		specs = append(specs, spec)
+	}
+	if output != "" {
+		err := deployer.WriteSpecFile(output, specs)
+		common.ErrCheckExitf(err, 1, "%s", err)
+		fmt.Printf("Deployment spec saved to %s\n", output)
+		return
+	}
+	as_json, _ := flags.GetBool(defaults.JsonLabel)
+	contents, err := deployer.EncodeSpecFile(specs, as_json)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	fmt.Println(string(contents))
+}
+
+var exportSpecCmd = &cobra.Command{
+	Use:   "export-spec sandbox_name [sandbox_name ...]",
+	Short: "Exports a sandbox as a deployment spec",
+	Long: `Exports the full definition of one or more sandboxes as a deployment
+file, which can be used with 'dbdeployer deploy --from-file' to create
+identical sandboxes on this or another host.
+The definition includes version, ports, users, options, topology, and
+the templates that were replaced with --use-template.
+Only sandboxes created with this version of dbdeployer or later can be exported.
+The output is YAML, unless --json is used or the --output file name
+has extension '.json'.`,
+	Example: `
+	$ dbdeployer export-spec rsandbox_5_7_22
+	$ dbdeployer export-spec msb_8_0_11 --output=single.yaml
+	$ dbdeployer export-spec msb_8_0_11 rsandbox_5_7_22 --output=topology.json
+`,
+	Run: ExportSpec,
+}
+
+func init() {
+	rootCmd.AddCommand(exportSpecCmd)
+
+	exportSpecCmd.Flags().String(defaults.OutputLabel, "", "Writes the spec to the given file instead of standard output")
+	exportSpecCmd.Flags().Bool(defaults.JsonLabel, false, "Shows the spec in JSON format")
+}
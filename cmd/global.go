@@ -16,20 +16,86 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/concurrent"
+	"github.com/datacharmer/dbdeployer/defaults"
 	"github.com/spf13/cobra"
 )
 
+// Gets the sandbox selection from the global command flags
+func global_selection(cmd *cobra.Command) common.SandboxSelection {
+	flags := cmd.Flags()
+	version, _ := flags.GetString(defaults.VersionLabel)
+	sb_type, _ := flags.GetString(defaults.TypeLabel)
+	name, _ := flags.GetString(defaults.NameLabel)
+	port_range, _ := flags.GetString(defaults.PortRangeLabel)
+	excluded, _ := flags.GetStringSlice(defaults.ExcludeLabel)
+	selection, err := common.NewSandboxSelection(version, sb_type, name, port_range, excluded)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	return selection
+}
+
+// The outcome of a global command in one sandbox
+type global_result struct {
+	Sandbox  string `json:"sandbox"`
+	Command  string `json:"command"`
+	ExitCode int    `json:"exit-code"`
+	Success  bool   `json:"success"`
+	Output   string `json:"output"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Prints the outcome of a global command in every sandbox, and the
+// number of successes and failures.
+func print_global_summary(results []global_result, as_json bool) {
+	if as_json {
+		out, err := json.MarshalIndent(results, " ", "\t")
+		common.ErrCheckExitf(err, 1, "error encoding results: %s", err)
+		fmt.Println(string(out))
+		return
+	}
+	successes := 0
+	template := "%-30s %-20s %9s  %s\n"
+	fmt.Printf(template, "sandbox", "command", "exit-code", "result")
+	fmt.Printf(template, "-------", "-------", "---------", "------")
+	for _, result := range results {
+		outcome := "ok"
+		if result.Success {
+			successes++
+		} else {
+			outcome = "FAILED"
+			if result.Error != "" {
+				outcome += " (" + result.Error + ")"
+			}
+		}
+		fmt.Printf(template, result.Sandbox, result.Command, fmt.Sprintf("%d", result.ExitCode), outcome)
+	}
+	fmt.Printf("# %d sandboxes: %d succeeded, %d failed\n", len(results), successes, len(results)-successes)
+}
+
 func GlobalRunCommand(cmd *cobra.Command, executable string, args []string, require_args bool, skip_missing bool) {
 	sandbox_dir := GetAbsolutePathFromFlag(cmd, "sandbox-home")
-	run_list := common.SandboxInfoToFileNames(common.GetInstalledSandboxes(sandbox_dir))
+	flags := cmd.Flags()
+	run_concurrently, _ := flags.GetBool(defaults.ConcurrentLabel)
+	keep_going, _ := flags.GetBool(defaults.KeepGoingLabel)
+	json_summary, _ := flags.GetBool(defaults.JsonLabel)
+	collect_results := run_concurrently || keep_going || json_summary
+	selection := global_selection(cmd)
+	run_list := common.SelectSandboxes(sandbox_dir, selection)
 	if len(run_list) == 0 {
+		if !selection.IsEmpty() {
+			common.Exitf(1, "No sandboxes in %s match the selection", sandbox_dir)
+		}
 		common.Exitf(1, "No sandboxes found in %s", sandbox_dir)
 	}
 	if require_args && len(args) < 1 {
 		common.Exitf(1, "Arguments required for command %s", executable)
 	}
+	var results []global_result
+	var operations concurrent.ExecCommands
+	var operation_sandboxes []string
 	for _, sb := range run_list {
 		single_use := true
 		full_dir_path := sandbox_dir + "/" + sb
@@ -42,7 +108,18 @@ func GlobalRunCommand(cmd *cobra.Command, executable string, args []string, requ
 		}
 		if !common.ExecExists(cmd_file) {
 			if skip_missing {
-				fmt.Printf("# Sandbox %s: executable %s not found\n", full_dir_path, executable)
+				if !json_summary {
+					fmt.Printf("# Sandbox %s: executable %s not found\n", full_dir_path, executable)
+				}
+				continue
+			}
+			if keep_going {
+				results = append(results, global_result{
+					Sandbox:  sb,
+					Command:  executable,
+					ExitCode: -1,
+					Error:    fmt.Sprintf("no %s or %s found", executable, executable+"_all"),
+				})
 				continue
 			}
 			common.Exitf(1, "No %s or %s found in %s", executable, executable+"_all", full_dir_path)
@@ -55,6 +132,11 @@ func GlobalRunCommand(cmd *cobra.Command, executable string, args []string, requ
 		for _, arg := range args {
 			cmd_args = append(cmd_args, arg)
 		}
+		if collect_results {
+			operations = append(operations, concurrent.ExecCommand{Cmd: cmd_file, Args: cmd_args})
+			operation_sandboxes = append(operation_sandboxes, sb)
+			continue
+		}
 		var err error
 		fmt.Printf("# Running \"%s\" on %s\n", real_executable, sb)
 		if len(cmd_args) > 0 {
@@ -65,6 +147,45 @@ func GlobalRunCommand(cmd *cobra.Command, executable string, args []string, requ
 		common.ErrCheckExitf(err, 1, "Error while running %s\n", cmd_file)
 		fmt.Println("")
 	}
+	if !collect_results {
+		return
+	}
+	show_result := func(sb string, exec_result concurrent.ExecResult) global_result {
+		result := global_result{
+			Sandbox:  sb,
+			Command:  common.BaseName(exec_result.Command.Cmd),
+			ExitCode: exec_result.ExitCode,
+			Success:  exec_result.Err == nil,
+			Output:   exec_result.Output,
+		}
+		if exec_result.Err != nil {
+			result.Error = exec_result.Err.Error()
+		}
+		if !json_summary {
+			fmt.Printf("# Running \"%s\" on %s\n", result.Command, sb)
+			fmt.Printf("%s\n", result.Output)
+		}
+		return result
+	}
+	if run_concurrently {
+		for N, exec_result := range concurrent.RunParallelTasksWithResults(operations) {
+			results = append(results, show_result(operation_sandboxes[N], exec_result))
+		}
+	} else {
+		for N, operation := range operations {
+			result := show_result(operation_sandboxes[N], concurrent.RunTaskWithResult(operation))
+			results = append(results, result)
+			if !result.Success && !keep_going {
+				break
+			}
+		}
+	}
+	print_global_summary(results, json_summary)
+	for _, result := range results {
+		if !result.Success {
+			common.Exit(1)
+		}
+	}
 }
 
 func StartAllSandboxes(cmd *cobra.Command, args []string) {
@@ -99,11 +220,31 @@ var (
 	globalCmd = &cobra.Command{
 		Use:   "global",
 		Short: "Runs a given command in every sandbox",
-		Long:  `This command can propagate the given action through all sandboxes.`,
+		Long: `This command can propagate the given action through all sandboxes.
+The sandboxes can be restricted using the selection options, which
+are based on each sandbox description (sbdescription.json):
+--version=8.0 selects all 8.0.x sandboxes, while --version=8.0.11 selects
+only that version; --type selects the sandbox type (single, multiple,
+master-slave, group-multi-primary, ...); --name is a regular expression
+for the sandbox name; --port-range selects sandboxes with at least one port
+in the range; --exclude skips the named sandboxes.
+When several options are used, a sandbox must satisfy all of them.
+Normally, the command runs in one sandbox at the time, and stops at the
+first failure. With --concurrent, the command runs in all the selected
+sandboxes at once. With --keep-going, a failure does not stop the command
+from running in the other sandboxes. In both cases, the output of each
+sandbox is shown when its command ends, followed by a summary of
+successes and failures (--json shows the summary, including the output,
+in JSON format). The exit code is 1 if the command failed anywhere.`,
 		Example: `
 	$ dbdeployer global use "select version()"
 	$ dbdeployer global status
 	$ dbdeployer global stop
+	$ dbdeployer global restart --version=8.0
+	$ dbdeployer global use --type=group-multi-primary "select @@port"
+	$ dbdeployer global stop --name='^msb_' --exclude=msb_5_7_22
+	$ dbdeployer global status --port-range=5000-8999
+	$ dbdeployer global restart --concurrent --keep-going
 	`,
 	}
 
@@ -172,4 +313,13 @@ func init() {
 	globalCmd.AddCommand(globalTestReplicationCmd)
 	globalCmd.AddCommand(globalUseCmd)
 
+	globalCmd.PersistentFlags().String(defaults.VersionLabel, "", "Runs the command only in sandboxes of this version (8.0 selects all 8.0.x)")
+	globalCmd.PersistentFlags().String(defaults.TypeLabel, "", "Runs the command only in sandboxes of this type")
+	globalCmd.PersistentFlags().String(defaults.NameLabel, "", "Runs the command only in sandboxes whose name matches this regular expression")
+	globalCmd.PersistentFlags().String(defaults.PortRangeLabel, "", "Runs the command only in sandboxes with a port in this range (min-max)")
+	globalCmd.PersistentFlags().StringSlice(defaults.ExcludeLabel, []string{}, "Does not run the command in these sandboxes")
+	globalCmd.PersistentFlags().Bool(defaults.ConcurrentLabel, false, "Runs the command in all sandboxes concurrently")
+	globalCmd.PersistentFlags().Bool(defaults.KeepGoingLabel, false, "Does not stop at the first failure, and shows a summary at the end")
+	globalCmd.PersistentFlags().Bool(defaults.JsonLabel, false, "Shows the summary of results in JSON format")
+
 }
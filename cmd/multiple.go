@@ -35,7 +35,15 @@ func MultipleSandbox(cmd *cobra.Command, args []string) {
 	if args[0] != sd.BasedirName {
 		origin = sd.BasedirName
 	}
-	sandbox.CreateMultipleSandbox(sd, origin, nodes)
+	if is_dry_run(cmd) {
+		dry_run_deployment(cmd, func() error {
+			_, err := sandbox.CreateMultipleSandbox(sd, origin, nodes)
+			return err
+		})
+		return
+	}
+	_, err := sandbox.CreateMultipleSandbox(sd, origin, nodes)
+	common.ErrCheckExitf(err, 1, "%s", err)
 }
 
 var multipleCmd = &cobra.Command{
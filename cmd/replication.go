@@ -20,6 +20,8 @@ import (
 	"github.com/datacharmer/dbdeployer/defaults"
 	"github.com/datacharmer/dbdeployer/sandbox"
 	"github.com/spf13/cobra"
+	"strconv"
+	"strings"
 	//"fmt"
 )
 
@@ -58,12 +60,51 @@ func ReplicationSandbox(cmd *cobra.Command, args []string) {
 	if sd.SinglePrimary && topology != defaults.GroupLabel {
 		common.Exit(1, "Option 'single-primary' can only be used with 'group' topology ")
 	}
+	sd.NdbNodes, _ = flags.GetInt(defaults.NdbNodesLabel)
+	if flags.Changed(defaults.NdbNodesLabel) && topology != defaults.NdbLabel {
+		common.Exitf(1, "Option '%s' can only be used with '%s' topology", defaults.NdbNodesLabel, defaults.NdbLabel)
+	}
+	sd.ReplicationTree, _ = flags.GetString(defaults.ReplicationTreeLabel)
+	if sd.ReplicationTree != "" {
+		if topology != defaults.TreeLabel {
+			common.Exitf(1, "Option '%s' can only be used with '%s' topology", defaults.ReplicationTreeLabel, defaults.TreeLabel)
+		}
+		masters, err := sandbox.ParseReplicationTree(sd.ReplicationTree)
+		common.ErrCheckExitf(err, 1, "%s", err)
+		// The number of nodes comes from the tree, unless given explicitly
+		if !flags.Changed(defaults.NodesLabel) {
+			nodes = len(masters) + 1
+		}
+	}
+	sd.MasterOptions, _ = flags.GetStringSlice(defaults.MasterOptionsLabel)
+	sd.SlaveOptions, _ = flags.GetStringSlice(defaults.SlaveOptionsLabel)
+	sd.NodeOptions, _ = flags.GetStringSlice(defaults.NodeOptionsLabel)
+	one_node_options, _ := flags.GetStringSlice(defaults.OneNodeOptionsLabel)
+	for _, item := range one_node_options {
+		// format: node_number:option (e.g. 3:innodb_buffer_pool_size=1G)
+		parts := strings.SplitN(item, ":", 2)
+		node_num, err := strconv.Atoi(parts[0])
+		if len(parts) < 2 || err != nil || parts[1] == "" {
+			common.Exitf(1, "Invalid value '%s' for --%s. Expected format: node_number:option", item, defaults.OneNodeOptionsLabel)
+		}
+		if sd.PerNodeOptions == nil {
+			sd.PerNodeOptions = make(map[int][]string)
+		}
+		sd.PerNodeOptions[node_num] = append(sd.PerNodeOptions[node_num], parts[1])
+	}
 	origin := args[0]
 	if args[0] != sd.BasedirName {
 		origin = sd.BasedirName
 	}
 	//fmt.Printf("%#v\n",sd)
-	sandbox.CreateReplicationSandbox(sd, origin, topology, nodes, master_ip, master_list, slave_list)
+	if is_dry_run(cmd) {
+		dry_run_deployment(cmd, func() error {
+			return sandbox.CreateReplicationSandbox(sd, origin, topology, nodes, master_ip, master_list, slave_list)
+		})
+		return
+	}
+	err := sandbox.CreateReplicationSandbox(sd, origin, topology, nodes, master_ip, master_list, slave_list)
+	common.ErrCheckExitf(err, 1, "%s", err)
 }
 
 // replicationCmd represents the replication command
@@ -77,6 +118,29 @@ for  5.7.17+.
 For this command to work, there must be a directory $HOME/opt/mysql/5.7.21, containing
 the binary files from mysql-5.7.21-$YOUR_OS-x86_64.tar.gz
 Use the "unpack" command to get the tarball into the right directory.
+Topology "pxc" deploys a Percona XtraDB Cluster (5.6+), and "galera" a MariaDB
+Galera cluster (10.1+). Both require at least 3 nodes and the Galera library
+(libgalera_smm.so) in the binaries directory. The cluster is bootstrapped
+from the first node by "start_all". State transfers use rsync.
+Topology "ndb" deploys a MySQL NDB Cluster from an NDB tarball: a management
+node, --ndb-nodes data nodes, and --nodes SQL nodes. The cluster configuration
+is in ndb_conf/config.ini, and "ndb_mgm" runs the management client.
+Topology "chain" deploys cascading replication, where each node is the master
+of the next one (node1 -> node2 -> node3). Topology "tree" requires
+--replication-tree, listing the slaves of each master: "1:2,3;2:4,5" means
+that node1 is the master of node2 and node3, and node2 is the master of node4
+and node5. Node 1 is the root of the tree. The relay nodes use
+log-slave-updates, and "check_slaves" shows the replication hierarchy.
+Topology "ring" deploys circular replication (node1 -> node2 -> ... -> node1),
+where every node is master and slave, with its own auto_increment_offset.
+Unlike all-masters, it doesn't need multi-source replication, and works with
+versions before 5.7.9.
+Each node can receive its own options in my.sandbox.cnf:
+--node-options for all nodes, --master-options for the masters,
+--slave-options for the slaves, and --one-node-options for a single node
+(format: node_number:option). In master-slave, the master is node 1.
+In group multi-primary, all-masters, galera, and pxc, every node is both master and slave.
+In chain and tree, the relay nodes are both master and slave. In ring, all nodes are.
 `,
 	//Allowed topologies are "master-slave", "group" (requires 5.7.17+),
 	//"fan-in" and "all-msters" (require 5.7.9+)
@@ -94,21 +158,32 @@ Use the "unpack" command to get the tarball into the right directory.
 		$ dbdeployer deploy --topology=group replication 8.0 --single-primary
 		$ dbdeployer deploy --topology=all-masters replication 5.7
 		$ dbdeployer deploy --topology=fan-in replication 5.7
+		$ dbdeployer deploy --topology=pxc replication pxc5.7.22 --binary-version=5.7.22
+		$ dbdeployer deploy --topology=galera replication 10.2.15
+		$ dbdeployer deploy --topology=ndb replication ndb7.6.6 --binary-version=5.7.22 --ndb-nodes=4
+		$ dbdeployer deploy --topology=chain replication 5.7 --nodes=4
+		$ dbdeployer deploy --topology=tree replication 5.7 --replication-tree="1:2,3;2:4,5"
+		$ dbdeployer deploy --topology=ring replication 5.6
+
+		$ dbdeployer deploy replication 5.7 --master-options=innodb_buffer_pool_size=1G \
+		    --slave-options=read_only=1 --one-node-options=3:log-slave-updates
 	`,
 }
 
 func init() {
 	// rootCmd.AddCommand(replicationCmd)
 	deployCmd.AddCommand(replicationCmd)
-	//replicationCmd.PersistentFlags().StringSliceP("master-options", "", "", "Extra options for the master")
-	//replicationCmd.PersistentFlags().StringSliceP("slave-options", "", "", "Extra options for the slaves")
-	//replicationCmd.PersistentFlags().StringSliceP("node-options", "", "", "Extra options for all nodes")
-	//replicationCmd.PersistentFlags().StringSliceP("one-node-options", "", "", "Extra options for one node (format #:option)")
+	replicationCmd.PersistentFlags().StringSliceP(defaults.MasterOptionsLabel, "", []string{}, "Extra options for the masters")
+	replicationCmd.PersistentFlags().StringSliceP(defaults.SlaveOptionsLabel, "", []string{}, "Extra options for the slaves")
+	replicationCmd.PersistentFlags().StringSliceP(defaults.NodeOptionsLabel, "", []string{}, "Extra options for all nodes")
+	replicationCmd.PersistentFlags().StringSliceP(defaults.OneNodeOptionsLabel, "", []string{}, "Extra options for one node (format #:option)")
 	replicationCmd.PersistentFlags().StringP(defaults.MasterListLabel, "", defaults.MasterListValue, "Which nodes are masters in a multi-source deployment")
 	replicationCmd.PersistentFlags().StringP(defaults.SlaveListLabel, "", defaults.SlaveListValue, "Which nodes are slaves in a multi-source deployment")
 	replicationCmd.PersistentFlags().StringP(defaults.MasterIpLabel, "", defaults.MasterIpValue, "Which IP the slaves will connect to")
 	replicationCmd.PersistentFlags().StringP(defaults.TopologyLabel, "t", defaults.TopologyValue, "Which topology will be installed")
 	replicationCmd.PersistentFlags().IntP(defaults.NodesLabel, "n", defaults.NodesValue, "How many nodes will be installed")
+	replicationCmd.PersistentFlags().String(defaults.ReplicationTreeLabel, "", "Slaves of each master in tree topology (format master:slave,slave;...)")
+	replicationCmd.PersistentFlags().Int(defaults.NdbNodesLabel, defaults.NdbNodesValue, "How many data nodes will be installed in an NDB cluster")
 	replicationCmd.PersistentFlags().BoolP(defaults.SinglePrimaryLabel, "", false, "Using single primary for group replication")
 	replicationCmd.PersistentFlags().BoolP(defaults.SemiSyncLabel, "", false, "Use semi-synchronous plugin")
 	replicationCmd.PersistentFlags().Bool(defaults.ReplHistoryDirLabel, false, "uses the replication directory to store mysql client history")
@@ -16,16 +16,139 @@
 package cmd
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"os"
+	"text/template"
+	"time"
 
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/sandbox"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
 	"strings"
 )
 
+func int_list_text(list []int) string {
+	var items []string
+	for _, n := range list {
+		items = append(items, fmt.Sprintf("%d", n))
+	}
+	return strings.Join(items, " ")
+}
+
+// Writes the sandbox list as CSV, one sandbox per line.
+// Ports and node names are space-separated lists.
+func write_sandboxes_csv(list []sandbox.SandboxListItem, header bool) error {
+	writer := csv.NewWriter(os.Stdout)
+	if header {
+		writer.Write([]string{"name", "type", "version", "ports", "nodes", "locked",
+			"running", "destination", "basedir", "log-directory", "dbdeployer-version", "timestamp"})
+	}
+	for _, sb := range list {
+		var nodes []string
+		for _, node := range sb.Nodes {
+			nodes = append(nodes, node.Name)
+		}
+		writer.Write([]string{sb.Name, sb.SBType, sb.Version, int_list_text(sb.Port),
+			strings.Join(nodes, " "), fmt.Sprintf("%v", sb.Locked), fmt.Sprintf("%v", sb.Running),
+			sb.Destination, sb.Basedir, sb.LogDirectory, sb.DbDeployerVersion, sb.Timestamp})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func size_text(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", size, units[0])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+func server_status_line(template, name, node string, port []int, status *sandbox.ServerStatus) {
+	if status == nil {
+		fmt.Printf(template, name, node, int_list_text(port), "-", "", "", "", "")
+		return
+	}
+	pid, uptime, lag := "", "", ""
+	if status.PID > 0 {
+		pid = fmt.Sprintf("%d", status.PID)
+	}
+	if status.State == sandbox.StateRunning {
+		uptime = (time.Duration(status.Uptime) * time.Second).String()
+		if !status.SocketExists {
+			uptime += " (no socket)"
+		}
+	}
+	if status.ReplicationLag != nil {
+		lag = fmt.Sprintf("%d", *status.ReplicationLag)
+	}
+	fmt.Printf(template, name, node, int_list_text(port), status.State, pid, uptime, size_text(status.DataSize), lag)
+}
+
+// Shows the live state of each sandbox and node
+func ShowSandboxesStatus(list []sandbox.SandboxListItem, header bool) {
+	template := "%-25s %-8s %-6s %-8s %-7s %-20s %-10s %s\n"
+	if header {
+		fmt.Printf(template, "name", "node", "port", "state", "pid", "uptime", "data-size", "lag")
+		fmt.Printf(template, "----", "----", "----", "-----", "---", "------", "---------", "---")
+	}
+	for _, sb := range list {
+		if len(sb.Nodes) == 0 {
+			server_status_line(template, sb.Name, "", sb.Port, sb.Status)
+			continue
+		}
+		for _, node := range sb.Nodes {
+			server_status_line(template, sb.Name, node.Name, node.Port, node.Status)
+		}
+	}
+}
+
+// Shows the sandbox list in a machine-readable format.
+// A non-empty template_text is applied to each sandbox in turn.
+func ShowSandboxesFormatted(list []sandbox.SandboxListItem, format, template_text string, header bool) {
+	if list == nil {
+		list = []sandbox.SandboxListItem{}
+	}
+	if template_text != "" {
+		tmpl, err := template.New("sandboxes").Parse(template_text)
+		common.ErrCheckExitf(err, 1, "error parsing template: %s", err)
+		for _, sb := range list {
+			err = tmpl.Execute(os.Stdout, sb)
+			common.ErrCheckExitf(err, 1, "error executing template for sandbox %s: %s", sb.Name, err)
+			fmt.Println("")
+		}
+		return
+	}
+	switch format {
+	case "json":
+		out, err := json.MarshalIndent(list, " ", "\t")
+		common.ErrCheckExitf(err, 1, "error encoding sandbox list: %s", err)
+		fmt.Println(string(out))
+	case "yaml":
+		out, err := yaml.Marshal(list)
+		common.ErrCheckExitf(err, 1, "error encoding sandbox list: %s", err)
+		fmt.Print(string(out))
+	case "csv":
+		err := write_sandboxes_csv(list, header)
+		common.ErrCheckExitf(err, 1, "error encoding sandbox list: %s", err)
+	default:
+		common.Exitf(1, "unknown format '%s'. Allowed: text json yaml csv", format)
+	}
+}
+
 func ShowSandboxesFromCatalog(current_sandbox_home string, header bool) {
-	sandbox_list := defaults.ReadCatalog()
+	sandbox_list, err := defaults.ReadCatalog()
+	common.ErrCheckExitf(err, 1, "%s", err)
 	if len(sandbox_list) == 0 {
 		return
 	}
@@ -54,6 +177,28 @@ func ShowSandboxes(cmd *cobra.Command, args []string) {
 	SandboxHome, _ := flags.GetString(defaults.SandboxHomeLabel)
 	read_catalog, _ := flags.GetBool(defaults.CatalogLabel)
 	use_header, _ := flags.GetBool(defaults.HeaderLabel)
+	format, _ := flags.GetString(defaults.FormatLabel)
+	template_text, _ := flags.GetString(defaults.TemplateLabel)
+	with_status, _ := flags.GetBool(defaults.StatusLabel)
+	if with_status || format != "text" || template_text != "" {
+		var list []sandbox.SandboxListItem
+		if read_catalog {
+			var err error
+			list, err = sandbox.ListCatalogSandboxes()
+			common.ErrCheckExitf(err, 1, "%s", err)
+		} else {
+			list = sandbox.ListSandboxes(SandboxHome)
+		}
+		if with_status {
+			sandbox.AddSandboxStatus(list)
+		}
+		if format == "text" && template_text == "" {
+			ShowSandboxesStatus(list, use_header)
+		} else {
+			ShowSandboxesFormatted(list, format, template_text, use_header)
+		}
+		return
+	}
 	if read_catalog {
 		ShowSandboxesFromCatalog(SandboxHome, use_header)
 		return
@@ -151,6 +296,14 @@ If sandboxes are installed in a different location, use --sandbox-home to
 indicate where to look.
 Alternatively, using --catalog will list all sandboxes, regardless of where 
 they were deployed.
+Use --format=json|yaml|csv to get the full description of each sandbox,
+including nodes, ports, locked and running state, and log directory.
+With --template, each sandbox is shown using a Go template. For example:
+    dbdeployer sandboxes --template='{{.Name}} {{.Version}} {{.Running}}'
+With --status, each sandbox and node is checked through its pid file and
+socket, showing whether it is running, its PID, uptime, data directory size,
+and, for replication nodes, the replication lag in seconds.
+The status can be combined with --format=json|yaml and with --template.
 `,
 	Aliases: []string{"installed", "deployed"},
 	Run:     ShowSandboxes,
@@ -161,4 +314,7 @@ func init() {
 
 	sandboxesCmd.Flags().BoolP(defaults.CatalogLabel, "", false, "Use sandboxes catalog instead of scanning directory")
 	sandboxesCmd.Flags().BoolP(defaults.HeaderLabel, "", false, "Shows header with catalog output")
+	sandboxesCmd.Flags().StringP(defaults.FormatLabel, "", "text", "Output format (text json yaml csv)")
+	sandboxesCmd.Flags().StringP(defaults.TemplateLabel, "", "", "Go template to apply to each sandbox (overrides --format)")
+	sandboxesCmd.Flags().BoolP(defaults.StatusLabel, "", false, "Shows the live state of each sandbox and node")
 }
@@ -42,6 +42,9 @@ func replace_template(template_name string, file_name string) {
 		Description: sandbox.AllTemplates[group][template_name].Description,
 		Notes:       sandbox.AllTemplates[group][template_name].Notes,
 		Contents:    new_contents,
+		Origin:      sandbox.TEMPLATE_FILE,
+		Family:      sandbox.AllTemplates[group][template_name].Family,
+		Versions:    sandbox.AllTemplates[group][template_name].Versions,
 	}
 	sandbox.AllTemplates[group][template_name] = new_rec
 }
@@ -249,10 +252,8 @@ func FillSdef(cmd *cobra.Command, args []string) sandbox.SandboxDef {
 		sd.ServerId = sd.Port
 	}
 	if gtid {
-		template_name := "gtid_options_56"
-		if common.GreaterOrEqualVersion(sd.Version, []int{5, 7, 0}) {
-			template_name = "gtid_options_57"
-		}
+		template_name, err := sandbox.ResolveTemplate(sandbox.SingleTemplates, "gtid_options", sd.Version)
+		common.ErrCheckExitf(err, 1, "%s", err)
 		if common.GreaterOrEqualVersion(sd.Version, []int{5, 6, 9}) {
 			sd.GtidOptions = sandbox.SingleTemplates[template_name].Contents
 			sd.ReplCrashSafeOptions = sandbox.SingleTemplates["repl_crash_safe_options"].Contents
@@ -278,7 +279,15 @@ func SingleSandbox(cmd *cobra.Command, args []string) {
 	sd = FillSdef(cmd, args)
 	// When deploying a single sandbox, we disable concurrency
 	sd.RunConcurrently = false
-	sandbox.CreateSingleSandbox(sd)
+	if is_dry_run(cmd) {
+		dry_run_deployment(cmd, func() error {
+			_, err := sandbox.CreateSingleSandbox(sd)
+			return err
+		})
+		return
+	}
+	_, err := sandbox.CreateSingleSandbox(sd)
+	common.ErrCheckExitf(err, 1, "%s", err)
 }
 
 var singleCmd = &cobra.Command{
@@ -21,6 +21,7 @@ import (
 	"github.com/datacharmer/dbdeployer/defaults"
 	"github.com/datacharmer/dbdeployer/sandbox"
 	"github.com/spf13/cobra"
+	"io/ioutil"
 	"os"
 	"strings"
 )
@@ -127,6 +128,14 @@ func GetTemplatesDescription(requested string, complete_listing bool) string {
 	out += fmt.Sprintf("# Name   %s    : %s\n", origin, template_name)
 	out += fmt.Sprintf("# Description 	: %s\n", sandbox.AllTemplates[group][template_name].Description)
 	out += fmt.Sprintf("# Notes     	: %s\n", sandbox.AllTemplates[group][template_name].Notes)
+	if sandbox.AllTemplates[group][template_name].Family != "" {
+		versions := sandbox.AllTemplates[group][template_name].Versions
+		if versions == "" {
+			versions = "(default)"
+		}
+		out += fmt.Sprintf("# Family     	: %s\n", sandbox.AllTemplates[group][template_name].Family)
+		out += fmt.Sprintf("# Versions     	: %s\n", versions)
+	}
 	out += fmt.Sprintf("# Length     	: %d\n", len(contents))
 	if complete_listing {
 		out += fmt.Sprintf("##START %s\n", template_name)
@@ -296,6 +305,64 @@ func ImportTemplates(cmd *cobra.Command, args []string) {
 	}
 }
 
+// Reads the templates in dir_name, which has the same layout used by
+// 'templates export'. Files that don't match a known template are reported.
+func read_templates_dir(dir_name string) (sandbox.AllTemplateCollection, []string) {
+	var collections = make(sandbox.AllTemplateCollection)
+	var unknown []string
+	for group_name, group := range sandbox.AllTemplates {
+		group_dir := dir_name + "/" + group_name
+		if !common.DirExists(group_dir) {
+			continue
+		}
+		files, err := ioutil.ReadDir(group_dir)
+		common.ErrCheckExitf(err, 1, "error reading directory %s: %s", group_dir, err)
+		for _, f := range files {
+			if f.IsDir() {
+				continue
+			}
+			template, ok := group[f.Name()]
+			if !ok {
+				unknown = append(unknown, group_name+"/"+f.Name())
+				continue
+			}
+			template.Contents = common.SlurpAsString(group_dir + "/" + f.Name())
+			template.Origin = sandbox.TEMPLATE_FILE
+			if collections[group_name] == nil {
+				collections[group_name] = make(sandbox.TemplateCollection)
+			}
+			collections[group_name][f.Name()] = template
+		}
+	}
+	return collections, unknown
+}
+
+func ValidateTemplates(cmd *cobra.Command, args []string) {
+	collections := sandbox.AllTemplates
+	if len(args) > 0 {
+		dir_name := args[0]
+		if !common.DirExists(dir_name) {
+			common.Exitf(1, "# Directory <%s> doesn't exist", dir_name)
+		}
+		var unknown []string
+		collections, unknown = read_templates_dir(dir_name)
+		for _, name := range unknown {
+			fmt.Printf("# unknown template %s - skipped\n", name)
+		}
+		if len(collections) == 0 {
+			common.Exitf(1, "no templates found in %s", dir_name)
+		}
+	}
+	checked, failures := sandbox.ValidateTemplates(collections)
+	for _, failure := range failures {
+		fmt.Printf("%s\n", failure)
+	}
+	fmt.Printf("# %d templates checked - %d with errors\n", checked, len(failures))
+	if len(failures) > 0 {
+		common.Exit(1)
+	}
+}
+
 func ResetTemplates(cmd *cobra.Command, args []string) {
 	// TODO: loop through the templates directories and remove all the ones that have compatible versions.
 	templates_dir := defaults.ConfigurationDir + "/templates" + common.CompatibleVersion
@@ -352,6 +419,18 @@ to create and manipulate sandboxes.
 		Long:  `Imports a group of templates (or "ALL") from a given directory`,
 		Run:   ImportTemplates,
 	}
+	templatesValidateCmd = &cobra.Command{
+		Use:     "validate [directory_name]",
+		Aliases: []string{"check"},
+		Short:   "Checks templates for syntax errors and missing variables",
+		Long: `Parses the templates and renders them with sample data for single
+sandboxes, replication nodes, and group nodes, reporting syntax errors
+and variables that the sandbox deployment would not provide.
+Without arguments, it checks the templates in use, including the imported ones.
+With a directory (as created by "templates export"), it checks the templates
+in that directory. Use it before "templates import".`,
+		Run: ValidateTemplates,
+	}
 	templatesResetCmd = &cobra.Command{
 		Use:     "reset",
 		Aliases: []string{"remove"},
@@ -369,6 +448,7 @@ func init() {
 	templatesCmd.AddCommand(templatesExportCmd)
 	templatesCmd.AddCommand(templatesImportCmd)
 	templatesCmd.AddCommand(templatesResetCmd)
+	templatesCmd.AddCommand(templatesValidateCmd)
 
 	templatesListCmd.Flags().BoolP(defaults.SimpleLabel, "s", false, "Shows only the template names, without description")
 	templatesDescribeCmd.Flags().BoolP(defaults.WithContentsLabel, "", false, "Shows complete structure and contents")
@@ -0,0 +1,136 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Criteria to select sandboxes, based on their name and on the
+// contents of their sbdescription.json.
+// Empty fields match every sandbox.
+type SandboxSelection struct {
+	Version  string         // "8.0" matches 8.0.x; "8.0.11" matches only that version
+	SBType   string         // sandbox type, as in sbdescription.json
+	Name     *regexp.Regexp // pattern for the sandbox name
+	MinPort  int            // at least one port of the sandbox must be
+	MaxPort  int            // between MinPort and MaxPort
+	Excluded []string       // names of sandboxes to skip
+}
+
+// Creates a selection from the values given on the command line.
+// The port range is either a single port or "min-max".
+func NewSandboxSelection(version, sb_type, name_pattern, port_range string, excluded []string) (SandboxSelection, error) {
+	selection := SandboxSelection{
+		Version:  version,
+		SBType:   sb_type,
+		Excluded: excluded,
+	}
+	if name_pattern != "" {
+		re, err := regexp.Compile(name_pattern)
+		if err != nil {
+			return selection, fmt.Errorf("Invalid name pattern '%s': %s", name_pattern, err)
+		}
+		selection.Name = re
+	}
+	if port_range != "" {
+		limits := strings.Split(port_range, "-")
+		if len(limits) > 2 {
+			return selection, fmt.Errorf("Invalid port range '%s': use 'port' or 'min-max'", port_range)
+		}
+		var ports []int
+		for _, limit := range limits {
+			port, err := strconv.Atoi(strings.TrimSpace(limit))
+			if err != nil {
+				return selection, fmt.Errorf("Invalid port range '%s': use 'port' or 'min-max'", port_range)
+			}
+			ports = append(ports, port)
+		}
+		selection.MinPort = ports[0]
+		selection.MaxPort = ports[len(ports)-1]
+		if selection.MinPort > selection.MaxPort {
+			return selection, fmt.Errorf("Invalid port range '%s': %d is greater than %d", port_range, selection.MinPort, selection.MaxPort)
+		}
+	}
+	return selection, nil
+}
+
+// Tells whether the selection uses the sandbox description
+func (selection SandboxSelection) needs_description() bool {
+	return selection.Version != "" || selection.SBType != "" || selection.MaxPort > 0
+}
+
+// Tells whether the selection has any criteria at all
+func (selection SandboxSelection) IsEmpty() bool {
+	return !selection.needs_description() && selection.Name == nil && len(selection.Excluded) == 0
+}
+
+// Tells whether a sandbox, with the given name and description, is selected.
+// When the selection uses description fields, sandboxes without
+// a description are never selected.
+func (selection SandboxSelection) Matches(name string, sbd *SandboxDescription) bool {
+	for _, excluded := range selection.Excluded {
+		if name == excluded {
+			return false
+		}
+	}
+	if selection.Name != nil && !selection.Name.MatchString(name) {
+		return false
+	}
+	if !selection.needs_description() {
+		return true
+	}
+	if sbd == nil {
+		return false
+	}
+	if selection.Version != "" && sbd.Version != selection.Version && !strings.HasPrefix(sbd.Version, selection.Version+".") {
+		return false
+	}
+	if selection.SBType != "" && sbd.SBType != selection.SBType {
+		return false
+	}
+	if selection.MaxPort > 0 {
+		in_range := false
+		for _, port := range sbd.Port {
+			if port >= selection.MinPort && port <= selection.MaxPort {
+				in_range = true
+			}
+		}
+		if !in_range {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the names of the sandboxes in sandbox_home that match the selection
+func SelectSandboxes(sandbox_home string, selection SandboxSelection) []string {
+	var selected []string
+	for _, name := range SandboxInfoToFileNames(GetInstalledSandboxes(sandbox_home)) {
+		var sbd *SandboxDescription
+		if FileExists(sandbox_home + "/" + name + "/sbdescription.json") {
+			description := ReadSandboxDescription(sandbox_home + "/" + name)
+			sbd = &description
+		}
+		if selection.Matches(name, sbd) {
+			selected = append(selected, name)
+		}
+	}
+	return selected
+}
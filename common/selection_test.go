@@ -0,0 +1,80 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import "testing"
+
+func TestSandboxSelection(t *testing.T) {
+	single := SandboxDescription{SBType: "single", Version: "8.0.11", Port: []int{8011}}
+	group := SandboxDescription{SBType: "group-multi-primary", Version: "5.7.22", Port: []int{20023, 20024, 20025}}
+	var checks = []struct {
+		version    string
+		sb_type    string
+		name       string
+		port_range string
+		excluded   []string
+		sb_name    string
+		sbd        *SandboxDescription
+		expected   bool
+	}{
+		{"", "", "", "", nil, "msb_8_0_11", &single, true},
+		{"", "", "", "", nil, "legacy", nil, true},
+		{"8.0", "", "", "", nil, "msb_8_0_11", &single, true},
+		{"8", "", "", "", nil, "msb_8_0_11", &single, true},
+		{"8.0.1", "", "", "", nil, "msb_8_0_11", &single, false},
+		{"8.0.11", "", "", "", nil, "msb_8_0_11", &single, true},
+		{"8.0", "", "", "", nil, "legacy", nil, false},
+		{"", "group-multi-primary", "", "", nil, "group_msb_5_7_22", &group, true},
+		{"", "group-multi-primary", "", "", nil, "msb_8_0_11", &single, false},
+		{"", "", "^group", "", nil, "group_msb_5_7_22", &group, true},
+		{"", "", "^group", "", nil, "msb_8_0_11", &single, false},
+		{"", "", "", "20000-20023", nil, "group_msb_5_7_22", &group, true},
+		{"", "", "", "20025", nil, "group_msb_5_7_22", &group, true},
+		{"", "", "", "8000-8010", nil, "msb_8_0_11", &single, false},
+		{"", "", "", "", []string{"msb_8_0_11"}, "msb_8_0_11", &single, false},
+		{"", "", "", "", []string{"msb_8_0_11"}, "legacy", nil, true},
+		{"5.7", "group-multi-primary", "msb", "20000-21000", nil, "group_msb_5_7_22", &group, true},
+	}
+	for _, c := range checks {
+		selection, err := NewSandboxSelection(c.version, c.sb_type, c.name, c.port_range, c.excluded)
+		if err != nil {
+			t.Logf("not ok - unexpected error: %s\n", err)
+			t.Fail()
+			continue
+		}
+		result := selection.Matches(c.sb_name, c.sbd)
+		if result == c.expected {
+			t.Logf("ok - %s selected: %v\n", c.sb_name, result)
+		} else {
+			t.Logf("not ok - %s (%+v): expected %v - got %v\n", c.sb_name, c, c.expected, result)
+			t.Fail()
+		}
+	}
+	for _, wrong := range []struct{ name, port_range string }{
+		{"[", ""},
+		{"", "abc"},
+		{"", "9000-8000"},
+		{"", "1-2-3"},
+	} {
+		_, err := NewSandboxSelection("", "", wrong.name, wrong.port_range, nil)
+		if err != nil {
+			t.Logf("ok - error detected for '%s' '%s'\n", wrong.name, wrong.port_range)
+		} else {
+			t.Logf("not ok - no error for '%s' '%s'\n", wrong.name, wrong.port_range)
+			t.Fail()
+		}
+	}
+}
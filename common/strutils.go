@@ -23,6 +23,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 type CleanupFunc func(target string)
@@ -34,8 +35,17 @@ type CleanupRec struct {
 
 type CleanupStack []CleanupRec
 
+// Cleanup actions of one operation, run in reverse order
+// if the operation fails.
+// It is safe to use from several goroutines.
+type CleanupActions struct {
+	mutex   sync.Mutex
+	actions Stack
+}
+
 // var cleanup_actions CleanupStack
-var cleanup_actions = new(Stack)
+// Actions to run before aborting the program
+var cleanup_actions = NewCleanupActions()
 
 // Given a path starting at the HOME directory
 // returns a string where the literal value for $HOME
@@ -191,26 +201,62 @@ func StringToIntSlice(val string) (num_list []int) {
 	return num_list
 }
 
-// Adds an action to the list of clean-up operations
-// to run before aborting the program
-func AddToCleanupStack(cf CleanupFunc, func_name, arg string) {
-	cleanup_actions.Push(CleanupRec{f: cf, label: func_name, target: arg})
+func NewCleanupActions() *CleanupActions {
+	return &CleanupActions{}
 }
 
-// Runs the cleanup actions (usually before Exit)
-func RunCleanupActions() {
-	if cleanup_actions.Len() > 0 {
+// Adds an action to be run if the operation fails
+func (ca *CleanupActions) Add(cf CleanupFunc, func_name, arg string) {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	ca.actions.Push(CleanupRec{f: cf, label: func_name, target: arg})
+}
+
+// Returns the number of pending actions
+func (ca *CleanupActions) Len() int {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	return ca.actions.Len()
+}
+
+// Runs the pending actions, starting from the last one added
+func (ca *CleanupActions) Run() {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	if ca.actions.Len() > 0 {
 		fmt.Printf("# Pre-exit cleanup. \n")
 	}
 	count := 0
-	for cleanup_actions.Len() > 0 {
+	for ca.actions.Len() > 0 {
 		count++
-		cr := cleanup_actions.Pop().(CleanupRec)
+		cr := ca.actions.Pop().(CleanupRec)
 		fmt.Printf("#%d - Executing %s( %s)\n", count, cr.label, cr.target)
 		cr.f(cr.target)
 	}
 }
 
+// Removes the pending actions without running them.
+// It is called when the operation has succeeded, and there is
+// nothing left to undo.
+func (ca *CleanupActions) Discard() {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	for ca.actions.Len() > 0 {
+		ca.actions.Pop()
+	}
+}
+
+// Adds an action to the list of clean-up operations
+// to run before aborting the program
+func AddToCleanupStack(cf CleanupFunc, func_name, arg string) {
+	cleanup_actions.Add(cf, func_name, arg)
+}
+
+// Runs the cleanup actions (usually before Exit)
+func RunCleanupActions() {
+	cleanup_actions.Run()
+}
+
 // Checks the status of error variable and exit with custom message if it is not nil.
 func ErrCheckExitf(err error, exit_code int, format string, args ...interface{}) {
 	if err != nil {
@@ -46,8 +46,19 @@ func TrimmedLines(s string) string {
 
 // Tprintf passed template string is formatted using its operands and returns the resulting string.
 // Spaces are added between operands when neither is a string.
+// Returns an error if the template can't be parsed or executed.
 // Based on code from https://play.golang.org/p/COHKlB2RML
-func Tprintf(tmpl string, data Smap) string {
+func Tprintf(tmpl string, data Smap) (string, error) {
+	return tprintf(tmpl, data, false)
+}
+
+// TprintfStrict works like Tprintf, but it also returns an error
+// when the template uses a variable that is not in data.
+func TprintfStrict(tmpl string, data Smap) (string, error) {
+	return tprintf(tmpl, data, true)
+}
+
+func tprintf(tmpl string, data Smap, strict bool) (string, error) {
 
 	// Adds timestamp and version info
 	timestamp := time.Now()
@@ -60,14 +71,20 @@ func Tprintf(tmpl string, data Smap) string {
 		data["AppVersion"] = VersionDef
 	}
 	// Creates a template
-	t := template.Must(template.New("tmp").Parse(tmpl))
+	t := template.New("tmp")
+	if strict {
+		t = t.Option("missingkey=error")
+	}
+	t, err := t.Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
 	buf := &bytes.Buffer{}
 
-	// If an error occurs, returns an empty string
 	if err := t.Execute(buf, data); err != nil {
-		return ""
+		return "", err
 	}
 
 	// Returns the populated template
-	return buf.String()
+	return buf.String(), nil
 }
@@ -0,0 +1,130 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var version_re = regexp.MustCompile(`^(?:[^.0-9-]+)?(\d+)\.(\d+)\.(\d+)$`)
+var range_limit_re = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)$`)
+
+// Like VersionToList, but without printing anything for invalid versions
+func parse_version(version string) ([]int, error) {
+	return parse_with(version_re, version)
+}
+
+// Parses a version used in a range, which can't have a prefix
+func parse_limit(version string) ([]int, error) {
+	return parse_with(range_limit_re, version)
+}
+
+func parse_with(re *regexp.Regexp, version string) ([]int, error) {
+	matches := re.FindStringSubmatch(strings.TrimSpace(version))
+	if matches == nil {
+		return nil, fmt.Errorf("invalid version '%s': required format x.x.xx", version)
+	}
+	var list []int
+	for _, item := range matches[1:] {
+		n, _ := strconv.Atoi(item)
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// Returns -1, 0, or 1 when v1 is lower than, equal to, or greater than v2
+func compare_versions(v1, v2 []int) int {
+	for N := 0; N < 3; N++ {
+		if v1[N] < v2[N] {
+			return -1
+		}
+		if v1[N] > v2[N] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Tells whether a version satisfies one condition of a range
+func version_condition(version []int, condition string) (bool, error) {
+	condition = strings.TrimSpace(condition)
+	if strings.Contains(condition, "-") {
+		limits := strings.SplitN(condition, "-", 2)
+		low, err := parse_limit(limits[0])
+		if err != nil {
+			return false, err
+		}
+		high, err := parse_limit(limits[1])
+		if err != nil {
+			return false, err
+		}
+		return compare_versions(version, low) >= 0 && compare_versions(version, high) <= 0, nil
+	}
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if !strings.HasPrefix(condition, op) {
+			continue
+		}
+		limit, err := parse_limit(condition[len(op):])
+		if err != nil {
+			return false, err
+		}
+		result := compare_versions(version, limit)
+		switch op {
+		case ">=":
+			return result >= 0, nil
+		case "<=":
+			return result <= 0, nil
+		case ">":
+			return result > 0, nil
+		case "<":
+			return result < 0, nil
+		}
+		return result == 0, nil
+	}
+	limit, err := parse_limit(condition)
+	if err != nil {
+		return false, err
+	}
+	return compare_versions(version, limit) == 0, nil
+}
+
+// Tells whether a version belongs to a range.
+// A range is a comma-separated list of conditions, which must all be true.
+// Each condition is either an interval with both ends included ("5.7.6-5.7.99"),
+// or a comparison with a version (">=8.0.0", "<10.0.0", "=5.6.9", "5.6.9").
+// An empty range includes all versions.
+func VersionInRange(version, version_range string) (bool, error) {
+	parsed_version, err := parse_version(version)
+	if err != nil {
+		return false, err
+	}
+	if strings.TrimSpace(version_range) == "" {
+		return true, nil
+	}
+	for _, condition := range strings.Split(version_range, ",") {
+		ok, err := version_condition(parsed_version, condition)
+		if err != nil {
+			return false, fmt.Errorf("invalid version range '%s': %s", version_range, err)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
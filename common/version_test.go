@@ -90,6 +90,53 @@ func TestGreaterOrEqualVersion(t *testing.T) {
 	}
 }
 
+func TestVersionInRange(t *testing.T) {
+	var checks = []struct {
+		version       string
+		version_range string
+		expected      bool
+	}{
+		{"8.0.11", "", true},
+		{"8.0.11", ">=8.0.0", true},
+		{"5.7.22", ">=8.0.0", false},
+		{"5.7.22", "5.7.6-5.7.99", true},
+		{"5.7.5", "5.7.6-5.7.99", false},
+		{"5.7.6", "5.7.6-5.7.99", true},
+		{"5.7.99", "5.7.6-5.7.99", true},
+		{"5.7.22", ">=5.7.6,<8.0.0", true},
+		{"8.0.0", ">=5.7.6,<8.0.0", false},
+		{"10.2.15", ">=8.0.0,<10.0.0", false},
+		{"5.6.9", "=5.6.9", true},
+		{"5.6.9", "5.6.9", true},
+		{"5.6.10", "<=5.6.9", false},
+		{"5.6.10", ">5.6.9", true},
+		{"ps5.7.22", "5.7.0-5.7.99", true},
+	}
+	for _, c := range checks {
+		result, err := VersionInRange(c.version, c.version_range)
+		if err == nil && result == c.expected {
+			t.Logf("ok - %s in '%s': %v\n", c.version, c.version_range, result)
+		} else {
+			t.Logf("not ok - %s in '%s': expected %v - got %v (%v)\n", c.version, c.version_range, c.expected, result, err)
+			t.Fail()
+		}
+	}
+	for _, wrong := range []struct{ version, version_range string }{
+		{"5.7", ">=5.7.0"},
+		{"5.7.22", ">=5.7"},
+		{"5.7.22", "5.7.0-"},
+		{"5.7.22", "~5.7.0"},
+	} {
+		_, err := VersionInRange(wrong.version, wrong.version_range)
+		if err != nil {
+			t.Logf("ok - error detected for %s in '%s': %s\n", wrong.version, wrong.version_range, err)
+		} else {
+			t.Logf("not ok - no error for %s in '%s'\n", wrong.version, wrong.version_range)
+			t.Fail()
+		}
+	}
+}
+
 func TestCustomUuid(t *testing.T) {
 	var uuid_samples = []UUID_component{
 		//                            12345678 1234 1234 1234 123456789012
@@ -21,6 +21,7 @@ import (
 	"os"
 	"os/exec"
 	"sync"
+	"syscall"
 )
 
 type CommonChan chan *exec.Cmd
@@ -28,10 +29,21 @@ type CommonChan chan *exec.Cmd
 type ExecCommand struct {
 	Cmd  string
 	Args []string
+	// When set, it is called instead of running Cmd,
+	// which then only describes the operation
+	Func func() error
 }
 
 type ExecCommands []ExecCommand
 
+// The outcome of a command run through RunTaskWithResult
+type ExecResult struct {
+	Command  ExecCommand
+	Output   string // standard output and standard error, combined
+	ExitCode int    // -1 if the command could not be started
+	Err      error
+}
+
 type ExecutionList struct {
 	Logger   *defaults.Logger
 	Priority int
@@ -47,6 +59,17 @@ func add_task(num int, wg *sync.WaitGroup, tasks CommonChan, cmd string, args []
 	tasks <- exec.Command(cmd, args...)
 }
 
+func add_func_task(num int, wg *sync.WaitGroup, ec ExecCommand) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := ec.Func()
+		if err != nil {
+			fmt.Printf("Error executing goroutine %d (%s): %s\n", num, ec.Cmd, err)
+		}
+	}()
+}
+
 func start_task(num int, w *sync.WaitGroup, tasks CommonChan) {
 	defer w.Done()
 	var (
@@ -77,6 +100,10 @@ func RunParallelTasks(priority_level int, operations ExecCommands) {
 	var wg sync.WaitGroup
 
 	for N, ec := range operations {
+		if ec.Func != nil {
+			add_func_task(N, &wg, ec)
+			continue
+		}
 		add_task(N, &wg, tasks, ec.Cmd, ec.Args)
 	}
 	close(tasks)
@@ -86,6 +113,50 @@ func RunParallelTasks(priority_level int, operations ExecCommands) {
 	}
 }
 
+// Runs a command and collects its output and exit code
+func RunTaskWithResult(ec ExecCommand) ExecResult {
+	result := ExecResult{Command: ec}
+	if ec.Func != nil {
+		result.Err = ec.Func()
+		if result.Err != nil {
+			result.Output = result.Err.Error()
+			result.ExitCode = 1
+		}
+		return result
+	}
+	out, err := exec.Command(ec.Cmd, ec.Args...).CombinedOutput()
+	result.Output = string(out)
+	result.Err = err
+	if err != nil {
+		result.ExitCode = -1
+		if exit_error, ok := err.(*exec.ExitError); ok {
+			if status, ok := exit_error.Sys().(syscall.WaitStatus); ok {
+				result.ExitCode = status.ExitStatus()
+			}
+		}
+	}
+	return result
+}
+
+// Runs several tasks in parallel, and returns their results
+// in the same order as the operations.
+func RunParallelTasksWithResults(operations ExecCommands) []ExecResult {
+	results := make([]ExecResult, len(operations))
+	var wg sync.WaitGroup
+	for N, ec := range operations {
+		wg.Add(1)
+		go func(N int, ec ExecCommand) {
+			defer wg.Done()
+			results[N] = RunTaskWithResult(ec)
+			if DebugConcurrency {
+				fmt.Printf("goroutine %d command %s exit code %d\n", N, ec.Cmd, results[N].ExitCode)
+			}
+		}(N, ec)
+	}
+	wg.Wait()
+	return results
+}
+
 /*
 //  Given a list of tasks with different priorities
 // This function organizes the queued tasks by priority
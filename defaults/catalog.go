@@ -16,14 +16,16 @@
 package defaults
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
-	//"strings"
-	"encoding/json"
 	"github.com/datacharmer/dbdeployer/common"
-	"time"
 )
 
 type SandboxItem struct {
@@ -47,48 +49,129 @@ const (
 
 var enable_catalog_management bool = true
 
-func setLock(label string) bool {
+// The catalog is protected by an advisory lock (flock) on the lock file.
+// The lock is released by the operating system when the process that
+// holds it terminates, so a crashed process can't block the catalog.
+// The lock file records the PID of the holder, to report who is blocking
+// and whether that process still exists.
+type catalogLock struct {
+	file *os.File
+}
+
+// Returns the PID and the label recorded in the lock file by the
+// process that holds the lock, or 0 and an empty label if there are none
+func lockHolder(lock_file string) (int, string) {
+	if !common.FileExists(lock_file) {
+		return 0, ""
+	}
+	contents, err := ioutil.ReadFile(lock_file)
+	if err != nil {
+		return 0, ""
+	}
+	fields := strings.Fields(string(contents))
+	if len(fields) == 0 {
+		return 0, ""
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, ""
+	}
+	return pid, strings.Join(fields[1:], " ")
+}
+
+// Tells whether the sandboxes catalog is in use.
+// It is disabled by setting SKIP_DBDEPLOYER_CATALOG
+func IsCatalogEnabled() bool {
+	return enable_catalog_management
+}
+
+func setLock(label string) (*catalogLock, error) {
 	if !enable_catalog_management {
-		return true
+		return &catalogLock{}, nil
 	}
 	lock_file := SandboxRegistryLock
 	if !common.DirExists(ConfigurationDir) {
 		common.Mkdir(ConfigurationDir)
 	}
-	elapsed := 0
-	for common.FileExists(lock_file) {
-		elapsed += 1
-		time.Sleep(1000 * time.Millisecond)
-		if elapsed > timeout {
-			return false
+	start := time.Now()
+	for {
+		file, err := os.OpenFile(lock_file, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("error opening lock file %s: %s", lock_file, err)
+		}
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			lock := &catalogLock{file: file}
+			err = file.Truncate(0)
+			if err == nil {
+				_, err = file.WriteAt([]byte(fmt.Sprintf("%d %s\n", os.Getpid(), label)), 0)
+			}
+			if err != nil {
+				releaseLock(lock)
+				return nil, fmt.Errorf("error writing lock file %s: %s", lock_file, err)
+			}
+			return lock, nil
 		}
+		file.Close()
+		if err != syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("error locking %s: %s", lock_file, err)
+		}
+		// The lock is released by the system when its holder exits:
+		// it is always held by a running process
+		if time.Since(start) > timeout*time.Second {
+			holder_desc := "another process"
+			holder, holder_label := lockHolder(lock_file)
+			if holder > 0 {
+				holder_desc = fmt.Sprintf("process %d", holder)
+			}
+			if holder_label != "" {
+				holder_desc += " for " + holder_label
+			}
+			return nil, fmt.Errorf("could not get lock on %s after %d seconds (held by %s)",
+				lock_file, timeout, holder_desc)
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
-	common.WriteString(label, lock_file)
-	return true
 }
 
-func releaseLock() {
-	if !enable_catalog_management {
+func releaseLock(lock *catalogLock) {
+	if lock == nil || lock.file == nil {
 		return
 	}
-	lock_file := SandboxRegistryLock
-	if common.FileExists(lock_file) {
-		os.Remove(lock_file)
-	}
+	lock.file.Truncate(0)
+	syscall.Flock(int(lock.file.Fd()), syscall.LOCK_UN)
+	lock.file.Close()
 }
 
-func WriteCatalog(sc SandboxCatalog) {
+// Writes the catalog to a temporary file, which then replaces the
+// catalog in one step. Readers never see a partially written catalog.
+func WriteCatalog(sc SandboxCatalog) error {
 	if !enable_catalog_management {
-		return
+		return nil
 	}
 	b, err := json.MarshalIndent(sc, " ", "\t")
-	common.ErrCheckExitf(err, 1, "error encoding sandbox catalog: %s", err)
-	json_string := fmt.Sprintf("%s", b)
+	if err != nil {
+		return fmt.Errorf("error encoding sandbox catalog: %s", err)
+	}
 	filename := SandboxRegistry
-	common.WriteString(json_string, filename)
+	tmp_filename := fmt.Sprintf("%s.%d.tmp", filename, os.Getpid())
+	err = common.WriteString(string(b), tmp_filename)
+	if err != nil {
+		os.Remove(tmp_filename)
+		return fmt.Errorf("error writing sandbox catalog: %s", err)
+	}
+	err = os.Rename(tmp_filename, filename)
+	if err != nil {
+		os.Remove(tmp_filename)
+		return fmt.Errorf("error replacing sandbox catalog: %s", err)
+	}
+	return nil
 }
 
-func ReadCatalog() (sc SandboxCatalog) {
+// Returns the sandboxes in the catalog.
+// A missing catalog is an empty one, while a catalog that can't be
+// read or decoded is an error.
+func ReadCatalog() (sc SandboxCatalog, err error) {
 	if !enable_catalog_management {
 		return
 	}
@@ -96,61 +179,86 @@ func ReadCatalog() (sc SandboxCatalog) {
 	if !common.FileExists(filename) {
 		return
 	}
-	sc_blob := common.SlurpAsBytes(filename)
-
-	err := json.Unmarshal(sc_blob, &sc)
-	common.ErrCheckExitf(err, 1, "error decoding sandbox catalog: %s", err)
+	sc_blob, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading sandbox catalog %s: %s", filename, err)
+	}
+	err = json.Unmarshal(sc_blob, &sc)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding sandbox catalog %s: %s", filename, err)
+	}
 	return
 }
 
-func UpdateCatalog(sb_name string, details SandboxItem) {
+func UpdateCatalog(sb_name string, details SandboxItem) error {
 	details.DbDeployerVersion = common.VersionDef
 	details.Timestamp = time.Now().Format(time.UnixDate)
 	details.CommandLine = strings.Join(common.CommandLineArgs, " ")
 	if !enable_catalog_management {
-		return
+		return nil
 	}
-	// fmt.Printf("+%s\n",sb_name)
-	if setLock(sb_name) {
-		// fmt.Printf("+locked\n")
-		current := ReadCatalog()
-		if current == nil {
-			current = make(SandboxCatalog)
-		}
-		current[sb_name] = details
-		WriteCatalog(current)
-		releaseLock()
-		// fmt.Printf("+unlocked\n")
-	} else {
-		fmt.Printf("%s\n", HashLine)
-		fmt.Printf("# Could not get lock on %s\n", SandboxRegistryLock)
-		fmt.Printf("%s\n", HashLine)
+	lock, err := setLock(sb_name)
+	if err != nil {
+		return err
 	}
+	defer releaseLock(lock)
+	current, err := ReadCatalog()
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		current = make(SandboxCatalog)
+	}
+	current[sb_name] = details
+	return WriteCatalog(current)
 }
 
-func DeleteFromCatalog(sb_name string) {
+func DeleteFromCatalog(sb_name string) error {
 	if !enable_catalog_management {
-		return
+		return nil
 	}
-	if setLock(sb_name) {
-		current := ReadCatalog()
-		defer releaseLock()
-		if current == nil {
-			return
-		}
-		//for name, _ := range current {
-		//	if strings.HasPrefix(name, sb_name) {
-		//		delete(current, name)
-		//	}
-		//}
-		delete(current, sb_name)
-		WriteCatalog(current)
-		releaseLock()
-	} else {
-		fmt.Printf("%s\n", HashLine)
-		fmt.Printf("# Could not get lock on %s\n", SandboxRegistryLock)
-		fmt.Printf("%s\n", HashLine)
+	lock, err := setLock(sb_name)
+	if err != nil {
+		return err
+	}
+	defer releaseLock(lock)
+	current, err := ReadCatalog()
+	if err != nil {
+		return err
 	}
+	if current == nil {
+		return nil
+	}
+	delete(current, sb_name)
+	return WriteCatalog(current)
+}
+
+// Changes the name of a catalog entry, when a sandbox is moved.
+// The old entry is removed and the new one is added in the same write.
+func RenameInCatalog(old_name, new_name string) error {
+	if !enable_catalog_management {
+		return nil
+	}
+	lock, err := setLock(new_name)
+	if err != nil {
+		return err
+	}
+	defer releaseLock(lock)
+	current, err := ReadCatalog()
+	if err != nil {
+		return err
+	}
+	details, ok := current[old_name]
+	if !ok {
+		return nil
+	}
+	details.Destination = new_name
+	details.DbDeployerVersion = common.VersionDef
+	details.Timestamp = time.Now().Format(time.UnixDate)
+	details.CommandLine = strings.Join(common.CommandLineArgs, " ")
+	delete(current, old_name)
+	current[new_name] = details
+	return WriteCatalog(current)
 }
 
 func init() {
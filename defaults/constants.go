@@ -66,28 +66,43 @@ const (
 	UseTemplateLabel       = "use-template"
 	SandboxDirectoryLabel  = "sandbox-directory"
 	HistoryDirLabel        = "history-dir"
+	FromFileLabel          = "from-file"
+	DryRunLabel            = "dry-run"
 
 	// Instantiated in cmd/single.go
 	MasterLabel = "master"
 
 	// Instantiated in cmd/replication.go
-	MasterListLabel     = "master-list"
-	MasterListValue     = "1,2"
-	SlaveListLabel      = "slave-list"
-	SlaveListValue      = "3"
-	MasterIpLabel       = "master-ip"
-	MasterIpValue       = "127.0.0.1"
-	TopologyLabel       = "topology"
-	TopologyValue       = "master-slave"
-	NodesLabel          = "nodes"
-	NodesValue          = 3
-	SinglePrimaryLabel  = "single-primary"
-	SemiSyncLabel       = "semi-sync"
-	ReplHistoryDirLabel = "repl-history-dir"
-	MasterSlaveLabel    = "master-slave"
-	GroupLabel          = "group"
-	FanInLabel          = "fan-in"
-	AllMastersLabel     = "all-masters"
+	MasterListLabel      = "master-list"
+	MasterListValue      = "1,2"
+	SlaveListLabel       = "slave-list"
+	SlaveListValue       = "3"
+	MasterIpLabel        = "master-ip"
+	MasterIpValue        = "127.0.0.1"
+	TopologyLabel        = "topology"
+	TopologyValue        = "master-slave"
+	NodesLabel           = "nodes"
+	NodesValue           = 3
+	SinglePrimaryLabel   = "single-primary"
+	SemiSyncLabel        = "semi-sync"
+	ReplHistoryDirLabel  = "repl-history-dir"
+	MasterSlaveLabel     = "master-slave"
+	GroupLabel           = "group"
+	FanInLabel           = "fan-in"
+	AllMastersLabel      = "all-masters"
+	GaleraLabel          = "galera"
+	PxcLabel             = "pxc"
+	NdbLabel             = "ndb"
+	NdbNodesLabel        = "ndb-nodes"
+	NdbNodesValue        = 2
+	ChainLabel           = "chain"
+	TreeLabel            = "tree"
+	RingLabel            = "ring"
+	ReplicationTreeLabel = "replication-tree"
+	MasterOptionsLabel   = "master-options"
+	SlaveOptionsLabel    = "slave-options"
+	NodeOptionsLabel     = "node-options"
+	OneNodeOptionsLabel  = "one-node-options"
 
 	// Instantiated in cmd/unpack.go
 	VerbosityLabel     = "verbosity"
@@ -101,10 +116,30 @@ const (
 	ConfirmLabel     = "confirm"
 
 	// Instantiated in cmd/sandboxes.go
-	CatalogLabel = "catalog"
-	HeaderLabel  = "header"
+	CatalogLabel  = "catalog"
+	HeaderLabel   = "header"
+	FormatLabel   = "format"
+	TemplateLabel = "template"
+	StatusLabel   = "status"
 
 	// Instantiated in cmd/templates.go
 	SimpleLabel       = "simple"
 	WithContentsLabel = "with-contents"
+
+	// Instantiated in cmd/export_spec.go
+	OutputLabel = "output"
+	JsonLabel   = "json"
+
+	// Instantiated in cmd/admin.go
+	VersionLabel    = "version"
+	MasterNodeLabel = "master-node"
+	TimeoutLabel    = "timeout"
+	WritableLabel   = "writable"
+
+	// Instantiated in cmd/global.go
+	TypeLabel      = "type"
+	NameLabel      = "name"
+	PortRangeLabel = "port-range"
+	ExcludeLabel   = "exclude"
+	KeepGoingLabel = "keep-going"
 )
@@ -33,34 +33,38 @@ type DbdeployerDefaults struct {
 	LogDirectory      string `json:"log-directory"`
 
 	//UseConcurrency    			   bool   `json:"use-concurrency"`
-	MasterSlaveBasePort           int `json:"master-slave-base-port"`
-	GroupReplicationBasePort      int `json:"group-replication-base-port"`
-	GroupReplicationSpBasePort    int `json:"group-replication-sp-base-port"`
-	FanInReplicationBasePort      int `json:"fan-in-replication-base-port"`
-	AllMastersReplicationBasePort int `json:"all-masters-replication-base-port"`
-	MultipleBasePort              int `json:"multiple-base-port"`
-	// GaleraBasePort                 int    `json:"galera-base-port"`
-	// PXCBasePort                    int    `json:"pxc-base-port"`
-	// NdbBasePort                    int    `json:"ndb-base-port"`
-	GroupPortDelta    int    `json:"group-port-delta"`
-	MysqlXPortDelta   int    `json:"mysqlx-port-delta"`
-	MasterName        string `json:"master-name"`
-	MasterAbbr        string `json:"master-abbr"`
-	NodePrefix        string `json:"node-prefix"`
-	SlavePrefix       string `json:"slave-prefix"`
-	SlaveAbbr         string `json:"slave-abbr"`
-	SandboxPrefix     string `json:"sandbox-prefix"`
-	MasterSlavePrefix string `json:"master-slave-prefix"`
-	GroupPrefix       string `json:"group-prefix"`
-	GroupSpPrefix     string `json:"group-sp-prefix"`
-	MultiplePrefix    string `json:"multiple-prefix"`
-	FanInPrefix       string `json:"fan-in-prefix"`
-	AllMastersPrefix  string `json:"all-masters-prefix"`
-	ReservedPorts     []int  `json:"reserved-ports"`
-	// GaleraPrefix                   string `json:"galera-prefix"`
-	// PxcPrefix                      string `json:"pxc-prefix"`
-	// NdbPrefix                      string `json:"ndb-prefix"`
-	Timestamp string `json:"timestamp"`
+	MasterSlaveBasePort           int    `json:"master-slave-base-port"`
+	GroupReplicationBasePort      int    `json:"group-replication-base-port"`
+	GroupReplicationSpBasePort    int    `json:"group-replication-sp-base-port"`
+	FanInReplicationBasePort      int    `json:"fan-in-replication-base-port"`
+	AllMastersReplicationBasePort int    `json:"all-masters-replication-base-port"`
+	MultipleBasePort              int    `json:"multiple-base-port"`
+	GaleraBasePort                int    `json:"galera-base-port"`
+	PxcBasePort                   int    `json:"pxc-base-port"`
+	NdbBasePort                   int    `json:"ndb-base-port"`
+	TreeReplicationBasePort       int    `json:"tree-replication-base-port"`
+	GroupPortDelta                int    `json:"group-port-delta"`
+	MysqlXPortDelta               int    `json:"mysqlx-port-delta"`
+	MasterName                    string `json:"master-name"`
+	MasterAbbr                    string `json:"master-abbr"`
+	NodePrefix                    string `json:"node-prefix"`
+	SlavePrefix                   string `json:"slave-prefix"`
+	SlaveAbbr                     string `json:"slave-abbr"`
+	SandboxPrefix                 string `json:"sandbox-prefix"`
+	MasterSlavePrefix             string `json:"master-slave-prefix"`
+	GroupPrefix                   string `json:"group-prefix"`
+	GroupSpPrefix                 string `json:"group-sp-prefix"`
+	MultiplePrefix                string `json:"multiple-prefix"`
+	FanInPrefix                   string `json:"fan-in-prefix"`
+	AllMastersPrefix              string `json:"all-masters-prefix"`
+	ReservedPorts                 []int  `json:"reserved-ports"`
+	GaleraPrefix                  string `json:"galera-prefix"`
+	PxcPrefix                     string `json:"pxc-prefix"`
+	NdbPrefix                     string `json:"ndb-prefix"`
+	ChainPrefix                   string `json:"chain-prefix"`
+	TreePrefix                    string `json:"tree-prefix"`
+	RingPrefix                    string `json:"ring-prefix"`
+	Timestamp                     string `json:"timestamp"`
 }
 
 const (
@@ -102,28 +106,32 @@ var (
 		FanInReplicationBasePort:      14000,
 		AllMastersReplicationBasePort: 15000,
 		MultipleBasePort:              16000,
-		// GaleraBasePort:                17000,
-		// PxcBasePort:                   18000,
-		// NdbBasePort:                   19000,
-		GroupPortDelta:    125,
-		MysqlXPortDelta:   10000,
-		MasterName:        "master",
-		MasterAbbr:        "m",
-		NodePrefix:        "node",
-		SlavePrefix:       "slave",
-		SlaveAbbr:         "s",
-		SandboxPrefix:     "msb_",
-		MasterSlavePrefix: "rsandbox_",
-		GroupPrefix:       "group_msb_",
-		GroupSpPrefix:     "group_sp_msb_",
-		MultiplePrefix:    "multi_msb_",
-		FanInPrefix:       "fan_in_msb_",
-		AllMastersPrefix:  "all_masters_msb_",
-		ReservedPorts:     []int{1186, 3306, 33060},
-		// GaleraPrefix:                  "galera_msb_",
-		// NdbPrefix:                     "ndb_msb_",
-		// PxcPrefix:                     "pxc_msb_",
-		Timestamp: time.Now().Format(time.UnixDate),
+		GaleraBasePort:                17000,
+		PxcBasePort:                   18000,
+		NdbBasePort:                   19000,
+		TreeReplicationBasePort:       20000,
+		GroupPortDelta:                125,
+		MysqlXPortDelta:               10000,
+		MasterName:                    "master",
+		MasterAbbr:                    "m",
+		NodePrefix:                    "node",
+		SlavePrefix:                   "slave",
+		SlaveAbbr:                     "s",
+		SandboxPrefix:                 "msb_",
+		MasterSlavePrefix:             "rsandbox_",
+		GroupPrefix:                   "group_msb_",
+		GroupSpPrefix:                 "group_sp_msb_",
+		MultiplePrefix:                "multi_msb_",
+		FanInPrefix:                   "fan_in_msb_",
+		AllMastersPrefix:              "all_masters_msb_",
+		ReservedPorts:                 []int{1186, 3306, 33060},
+		GaleraPrefix:                  "galera_msb_",
+		NdbPrefix:                     "ndb_msb_",
+		PxcPrefix:                     "pxc_msb_",
+		ChainPrefix:                   "chain_msb_",
+		TreePrefix:                    "tree_msb_",
+		RingPrefix:                    "ring_msb_",
+		Timestamp:                     time.Now().Format(time.UnixDate),
 	}
 	currentDefaults DbdeployerDefaults
 )
@@ -185,6 +193,9 @@ func replace_literal_env_values(defaults DbdeployerDefaults) DbdeployerDefaults
 func ReadDefaultsFile(filename string) (defaults DbdeployerDefaults) {
 	defaults_blob := common.SlurpAsBytes(filename)
 
+	// Values missing from files written by older versions
+	// keep their factory setting
+	defaults = factoryDefaults
 	err := json.Unmarshal(defaults_blob, &defaults)
 	common.ErrCheckExitf(err, 1, "error decoding defaults: %s", err)
 	defaults = expand_environment_variables(defaults)
@@ -207,9 +218,10 @@ func ValidateDefaults(nd DbdeployerDefaults) bool {
 		check_int("multiple-base-port", nd.MultipleBasePort, min_port_value, max_port_value) &&
 		check_int("fan-in-base-port", nd.FanInReplicationBasePort, min_port_value, max_port_value) &&
 		check_int("all-masters-base-port", nd.AllMastersReplicationBasePort, min_port_value, max_port_value) &&
-		// check_int("galera-base-port", nd.GaleraBasePort, min_port_value, max_port_value) &&
-		// check_int("pxc-base-port", nd.PxcBasePort, min_port_value, max_port_value) &&
-		// check_int("ndb-base-port", nd.NdbBasePort, min_port_value, max_port_value) &&
+		check_int("galera-base-port", nd.GaleraBasePort, min_port_value, max_port_value) &&
+		check_int("pxc-base-port", nd.PxcBasePort, min_port_value, max_port_value) &&
+		check_int("ndb-base-port", nd.NdbBasePort, min_port_value, max_port_value) &&
+		check_int("tree-replication-base-port", nd.TreeReplicationBasePort, min_port_value, max_port_value) &&
 		check_int("group-port-delta", nd.GroupPortDelta, 101, 299)
 	check_int("mysqlx-port-delta", nd.MysqlXPortDelta, 2000, 15000)
 	if !all_ints {
@@ -221,9 +233,10 @@ func ValidateDefaults(nd DbdeployerDefaults) bool {
 		nd.MultipleBasePort != nd.MasterSlaveBasePort &&
 		nd.MultipleBasePort != nd.FanInReplicationBasePort &&
 		nd.MultipleBasePort != nd.AllMastersReplicationBasePort &&
-		// nd.MultipleBasePort != nd.NdbBasePort &&
-		// nd.MultipleBasePort != nd.GaleraBasePort &&
-		// nd.MultipleBasePort != nd.PxcBasePort &&
+		nd.MultipleBasePort != nd.NdbBasePort &&
+		nd.MultipleBasePort != nd.GaleraBasePort &&
+		nd.MultipleBasePort != nd.PxcBasePort &&
+		nd.MultipleBasePort != nd.TreeReplicationBasePort &&
 		nd.MultiplePrefix != nd.GroupSpPrefix &&
 		nd.MultiplePrefix != nd.GroupPrefix &&
 		nd.MultiplePrefix != nd.MasterSlavePrefix &&
@@ -231,9 +244,12 @@ func ValidateDefaults(nd DbdeployerDefaults) bool {
 		nd.MultiplePrefix != nd.FanInPrefix &&
 		nd.MultiplePrefix != nd.AllMastersPrefix &&
 		nd.MasterAbbr != nd.SlaveAbbr &&
-		// nd.MultiplePrefix != nd.NdbPrefix &&
-		// nd.MultiplePrefix != nd.GaleraPrefix &&
-		// nd.MultiplePrefix != nd.PxcPrefix &&
+		nd.MultiplePrefix != nd.NdbPrefix &&
+		nd.MultiplePrefix != nd.GaleraPrefix &&
+		nd.MultiplePrefix != nd.PxcPrefix &&
+		nd.MultiplePrefix != nd.ChainPrefix &&
+		nd.MultiplePrefix != nd.TreePrefix &&
+		nd.MultiplePrefix != nd.RingPrefix &&
 		nd.SandboxHome != nd.SandboxBinary
 	if !no_conflicts {
 		fmt.Printf("Conflicts found in defaults values:\n")
@@ -250,6 +266,12 @@ func ValidateDefaults(nd DbdeployerDefaults) bool {
 		nd.GroupPrefix != "" &&
 		nd.GroupSpPrefix != "" &&
 		nd.MultiplePrefix != "" &&
+		nd.GaleraPrefix != "" &&
+		nd.PxcPrefix != "" &&
+		nd.NdbPrefix != "" &&
+		nd.ChainPrefix != "" &&
+		nd.TreePrefix != "" &&
+		nd.RingPrefix != "" &&
 		nd.SandboxHome != "" &&
 		nd.SandboxBinary != ""
 	if !all_strings {
@@ -304,12 +326,14 @@ func UpdateDefaults(label, value string, store_defaults bool) {
 		new_defaults.FanInReplicationBasePort = common.Atoi(value)
 	case "all-masters-base-port":
 		new_defaults.AllMastersReplicationBasePort = common.Atoi(value)
-	// case "ndb-base-port":
-	//	 new_defaults.NdbBasePort = common.Atoi(value)
-	// case "galera-base-port":
-	//	 new_defaults.GaleraBasePort = common.Atoi(value)
-	// case "pxc-base-port":
-	//	 new_defaults.PxcBasePort = common.Atoi(value)
+	case "ndb-base-port":
+		new_defaults.NdbBasePort = common.Atoi(value)
+	case "galera-base-port":
+		new_defaults.GaleraBasePort = common.Atoi(value)
+	case "pxc-base-port":
+		new_defaults.PxcBasePort = common.Atoi(value)
+	case "tree-replication-base-port":
+		new_defaults.TreeReplicationBasePort = common.Atoi(value)
 	case "group-port-delta":
 		new_defaults.GroupPortDelta = common.Atoi(value)
 	case "mysqlx-port-delta":
@@ -340,12 +364,18 @@ func UpdateDefaults(label, value string, store_defaults bool) {
 		new_defaults.AllMastersPrefix = value
 	case "reserved-ports":
 		new_defaults.ReservedPorts = common.StringToIntSlice(value)
-	// case "galera-prefix":
-	// 	new_defaults.GaleraPrefix = value
-	// case "pxc-prefix":
-	// 	new_defaults.PxcPrefix = value
-	// case "ndb-prefix":
-	// 	new_defaults.NdbPrefix = value
+	case "galera-prefix":
+		new_defaults.GaleraPrefix = value
+	case "pxc-prefix":
+		new_defaults.PxcPrefix = value
+	case "ndb-prefix":
+		new_defaults.NdbPrefix = value
+	case "chain-prefix":
+		new_defaults.ChainPrefix = value
+	case "tree-prefix":
+		new_defaults.TreePrefix = value
+	case "ring-prefix":
+		new_defaults.RingPrefix = value
 	default:
 		common.Exitf(1, "Unrecognized label %s", label)
 	}
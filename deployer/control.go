@@ -0,0 +1,168 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/sandbox"
+)
+
+// Reads the connection data of a node from its my.sandbox.cnf
+func read_node(name, dir string) (Node, error) {
+	node := Node{Name: name, Dir: dir}
+	config_file := dir + "/my.sandbox.cnf"
+	if !common.FileExists(config_file) {
+		return node, fmt.Errorf("Configuration file %s not found", config_file)
+	}
+	config := common.ParseConfigFile(config_file)
+	for _, kv := range config["client"] {
+		switch kv.Key {
+		case "user":
+			node.User = kv.Value
+		case "password":
+			node.Password = kv.Value
+		case "port":
+			node.Port = common.Atoi(kv.Value)
+		case "socket":
+			node.Socket = kv.Value
+		}
+	}
+	for _, kv := range config["mysqld"] {
+		if kv.Key == "mysqlx-port" {
+			node.MysqlXPort = common.Atoi(kv.Value)
+		}
+	}
+	return node, nil
+}
+
+// Open returns the handle of a sandbox already installed in SandboxHome
+func (d *Deployer) Open(name string) (*Sandbox, error) {
+	sandbox_dir := d.SandboxHome + "/" + name
+	if !common.FileExists(sandbox_dir + "/sbdescription.json") {
+		return nil, fmt.Errorf("Sandbox %s not found in %s", name, d.SandboxHome)
+	}
+	sbd := common.ReadSandboxDescription(sandbox_dir)
+	sb := Sandbox{
+		Name:    name,
+		Dir:     sandbox_dir,
+		Type:    sbd.SBType,
+		Version: sbd.Version,
+	}
+	if common.FileExists(sandbox_dir + "/my.sandbox.cnf") {
+		node, err := read_node(name, sandbox_dir)
+		if err != nil {
+			return nil, err
+		}
+		sb.Nodes = append(sb.Nodes, node)
+	} else {
+		node_nums := make(map[string]int)
+		for _, inner := range common.GetInstalledSandboxes(sandbox_dir) {
+			node_dir := sandbox_dir + "/" + inner.SandboxName
+			if !common.FileExists(node_dir + "/sbdescription.json") {
+				continue
+			}
+			node, err := read_node(inner.SandboxName, node_dir)
+			if err != nil {
+				return nil, err
+			}
+			node_nums[node.Name] = common.ReadSandboxDescription(node_dir).NodeNum
+			sb.Nodes = append(sb.Nodes, node)
+		}
+		sort.Slice(sb.Nodes, func(i, j int) bool {
+			return node_nums[sb.Nodes[i].Name] < node_nums[sb.Nodes[j].Name]
+		})
+	}
+	if len(sb.Nodes) == 0 {
+		return nil, fmt.Errorf("No nodes found in sandbox %s", sandbox_dir)
+	}
+	sb.User = sb.Nodes[0].User
+	sb.Password = sb.Nodes[0].Password
+	return &sb, nil
+}
+
+// Runs a sandbox script, returning its output
+func run_script(ctx context.Context, script string, args ...string) (string, error) {
+	if !common.ExecExists(script) {
+		return "", fmt.Errorf("Executable '%s' not found", script)
+	}
+	cmd := exec.CommandContext(ctx, script, args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("Error running %s: %s\n%s", script, err, out)
+	}
+	return string(out), nil
+}
+
+// Start starts all the nodes of the sandbox
+func (d *Deployer) Start(ctx context.Context, sb *Sandbox) error {
+	return sandbox.StartSandboxServers(ctx, sb.Dir)
+}
+
+// Stop stops all the nodes of the sandbox
+func (d *Deployer) Stop(ctx context.Context, sb *Sandbox) error {
+	return sandbox.StopSandboxServers(ctx, sb.Dir)
+}
+
+// Returns the PID of a running node, or 0 if the node is not running
+func node_pid(node Node) int {
+	pid_file := fmt.Sprintf("%s/data/mysql_sandbox%d.pid", node.Dir, node.Port)
+	if !common.FileExists(pid_file) {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(common.SlurpAsString(pid_file)))
+	if err != nil {
+		return 0
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return 0
+	}
+	// Signal 0 checks for the existence of the process without
+	// affecting it
+	if process.Signal(syscall.Signal(0)) != nil {
+		return 0
+	}
+	return pid
+}
+
+// Status reports which nodes of the sandbox are running
+func (d *Deployer) Status(ctx context.Context, sb *Sandbox) ([]NodeStatus, error) {
+	var status_list []NodeStatus
+	for _, node := range sb.Nodes {
+		if err := ctx.Err(); err != nil {
+			return status_list, err
+		}
+		pid := node_pid(node)
+		status_list = append(status_list, NodeStatus{Node: node.Name, Running: pid > 0, Pid: pid})
+	}
+	return status_list, nil
+}
+
+// Query runs a query in the given node and returns its output,
+// in tab-separated format without column names
+func (d *Deployer) Query(ctx context.Context, node Node, query string) (string, error) {
+	out, err := run_script(ctx, node.Dir+"/use", "-BN", "-e", query)
+	return strings.TrimRight(out, "\n"), err
+}
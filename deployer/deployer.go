@@ -0,0 +1,474 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package deployer is the entry point for applications that want to
+// create and control sandboxes without going through the command line.
+//
+//	d := deployer.New("", "")
+//	sb, err := d.Deploy(ctx, deployer.Spec{Version: "5.7.22", Topology: "master-slave"})
+//	if err != nil {
+//		// handle the error
+//	}
+//	defer d.Remove(ctx, sb)
+//	result, err := d.Query(ctx, sb.Nodes[0], "select @@server_id")
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/sandbox"
+)
+
+// Topologies accepted by Spec.Topology
+const (
+	TopologySingle      = "single"
+	TopologyMultiple    = "multiple"
+	TopologyMasterSlave = defaults.MasterSlaveLabel
+	TopologyGroup       = defaults.GroupLabel
+	TopologyFanIn       = defaults.FanInLabel
+	TopologyAllMasters  = defaults.AllMastersLabel
+	TopologyGalera      = defaults.GaleraLabel
+	TopologyPxc         = defaults.PxcLabel
+	TopologyNdb         = defaults.NdbLabel
+	TopologyChain       = defaults.ChainLabel
+	TopologyTree        = defaults.TreeLabel
+	TopologyRing        = defaults.RingLabel
+)
+
+// Spec describes the deployment to be created.
+// Only Version is mandatory. Every other field, when left empty, gets
+// the same default that the command line would use.
+// The field tags are the keys used in deployment files (see ReadSpecFile)
+type Spec struct {
+	Version           string            `json:"version" yaml:"version"`                                               // MySQL version (x.xx.xx)
+	Basedir           string            `json:"basedir,omitempty" yaml:"basedir,omitempty"`                           // Directory containing the binaries. Default: SandboxBinary/BasedirName
+	BasedirName       string            `json:"basedir-name,omitempty" yaml:"basedir-name,omitempty"`                 // Name of the binaries directory within SandboxBinary. Default: Version
+	Topology          string            `json:"topology,omitempty" yaml:"topology,omitempty"`                         // single (default), multiple, master-slave, group, fan-in, all-masters, galera, pxc, ndb, chain, tree, ring
+	Nodes             int               `json:"nodes,omitempty" yaml:"nodes,omitempty"`                               // Number of nodes for multiple and replication topologies. Default: 3
+	DirName           string            `json:"sandbox-directory,omitempty" yaml:"sandbox-directory,omitempty"`       // Name of the sandbox directory. Default: same as the command line
+	Port              int               `json:"port,omitempty" yaml:"port,omitempty"`                                 // Port of a single sandbox. Default: derived from the version
+	BasePort          int               `json:"base-port,omitempty" yaml:"base-port,omitempty"`                       // Base port for multiple nodes. Default: derived from the version
+	DbUser            string            `json:"db-user,omitempty" yaml:"db-user,omitempty"`                           // Database user. Default: msandbox
+	DbPassword        string            `json:"db-password,omitempty" yaml:"db-password,omitempty"`                   // Database password. Default: msandbox
+	RplUser           string            `json:"rpl-user,omitempty" yaml:"rpl-user,omitempty"`                         // Replication user. Default: rsandbox
+	RplPassword       string            `json:"rpl-password,omitempty" yaml:"rpl-password,omitempty"`                 // Replication password. Default: rsandbox
+	RemoteAccess      string            `json:"remote-access,omitempty" yaml:"remote-access,omitempty"`               // Host allowed to connect with DbUser. Default: 127.%
+	BindAddress       string            `json:"bind-address,omitempty" yaml:"bind-address,omitempty"`                 // Address the server listens to. Default: 127.0.0.1
+	MasterIp          string            `json:"master-ip,omitempty" yaml:"master-ip,omitempty"`                       // Master IP for replication. Default: 127.0.0.1
+	MasterList        string            `json:"master-list,omitempty" yaml:"master-list,omitempty"`                   // Masters for fan-in replication. Default: 1,2
+	SlaveList         string            `json:"slave-list,omitempty" yaml:"slave-list,omitempty"`                     // Slaves for fan-in replication. Default: 3
+	NdbNodes          int               `json:"ndb-nodes,omitempty" yaml:"ndb-nodes,omitempty"`                       // Data nodes for NDB cluster. Default: 2
+	ReplicationTree   string            `json:"replication-tree,omitempty" yaml:"replication-tree,omitempty"`         // Slaves of each master for tree topology ("1:2,3;2:4,5")
+	SinglePrimary     bool              `json:"single-primary,omitempty" yaml:"single-primary,omitempty"`             // Single primary mode for group replication
+	SemiSync          bool              `json:"semi-sync,omitempty" yaml:"semi-sync,omitempty"`                       // Semi-synchronous replication for master-slave
+	Gtid              bool              `json:"gtid,omitempty" yaml:"gtid,omitempty"`                                 // Enables GTID
+	ReplCrashSafe     bool              `json:"repl-crash-safe,omitempty" yaml:"repl-crash-safe,omitempty"`           // Enables replication crash safe options
+	Master            bool              `json:"master,omitempty" yaml:"master,omitempty"`                             // Makes a single sandbox ready for replication
+	NativeAuthPlugin  bool              `json:"native-auth-plugin,omitempty" yaml:"native-auth-plugin,omitempty"`     // Uses the native password plugin in 8.0.4+
+	KeepServerUuid    bool              `json:"keep-server-uuid,omitempty" yaml:"keep-server-uuid,omitempty"`         // Does not change the server UUID
+	ExposeDdTables    bool              `json:"expose-dd-tables,omitempty" yaml:"expose-dd-tables,omitempty"`         // Shows data dictionary tables in 8.0+
+	EnableMysqlX      bool              `json:"enable-mysqlx,omitempty" yaml:"enable-mysqlx,omitempty"`               // Enables the MySQLX plugin in 5.7.12+
+	DisableMysqlX     bool              `json:"disable-mysqlx,omitempty" yaml:"disable-mysqlx,omitempty"`             // Disables the MySQLX plugin in 8.0.11+
+	EnableGeneralLog  bool              `json:"enable-general-log,omitempty" yaml:"enable-general-log,omitempty"`     // Enables the general log
+	InitGeneralLog    bool              `json:"init-general-log,omitempty" yaml:"init-general-log,omitempty"`         // Uses the general log during initialization
+	SkipReportHost    bool              `json:"skip-report-host,omitempty" yaml:"skip-report-host,omitempty"`         // Does not include report-host in my.sandbox.cnf
+	SkipReportPort    bool              `json:"skip-report-port,omitempty" yaml:"skip-report-port,omitempty"`         // Does not include report-port in my.sandbox.cnf
+	CustomMysqld      string            `json:"custom-mysqld,omitempty" yaml:"custom-mysqld,omitempty"`               // Alternative mysqld in the same directory as mysqld
+	HistoryDir        string            `json:"history-dir,omitempty" yaml:"history-dir,omitempty"`                   // Where to store the mysql client history
+	MyCnfFile         string            `json:"my-cnf-file,omitempty" yaml:"my-cnf-file,omitempty"`                   // Alternative source file for my.sandbox.cnf
+	MyCnfOptions      []string          `json:"my-cnf-options,omitempty" yaml:"my-cnf-options,omitempty"`             // Options added to my.sandbox.cnf
+	NodeOptions       []string          `json:"node-options,omitempty" yaml:"node-options,omitempty"`                 // Options added to my.sandbox.cnf of all replication nodes
+	MasterOptions     []string          `json:"master-options,omitempty" yaml:"master-options,omitempty"`             // Options added to my.sandbox.cnf of the masters
+	SlaveOptions      []string          `json:"slave-options,omitempty" yaml:"slave-options,omitempty"`               // Options added to my.sandbox.cnf of the slaves
+	PerNodeOptions    map[int][]string  `json:"per-node-options,omitempty" yaml:"per-node-options,omitempty"`         // Options added to my.sandbox.cnf of a given node
+	InitOptions       []string          `json:"init-options,omitempty" yaml:"init-options,omitempty"`                 // Options used during database initialization
+	PreGrantsSql      []string          `json:"pre-grants-sql,omitempty" yaml:"pre-grants-sql,omitempty"`             // Queries executed before loading grants
+	PostGrantsSql     []string          `json:"post-grants-sql,omitempty" yaml:"post-grants-sql,omitempty"`           // Queries executed after loading grants
+	PreGrantsSqlFile  string            `json:"pre-grants-sql-file,omitempty" yaml:"pre-grants-sql-file,omitempty"`   // SQL file executed before loading grants
+	PostGrantsSqlFile string            `json:"post-grants-sql-file,omitempty" yaml:"post-grants-sql-file,omitempty"` // SQL file executed after loading grants
+	SkipStart         bool              `json:"skip-start,omitempty" yaml:"skip-start,omitempty"`                     // Does not start the database server after deployment
+	SkipLoadGrants    bool              `json:"skip-load-grants,omitempty" yaml:"skip-load-grants,omitempty"`         // Does not load the grants
+	Force             bool              `json:"force,omitempty" yaml:"force,omitempty"`                               // Overwrites an existing sandbox with the same name
+	Concurrent        bool              `json:"concurrent,omitempty" yaml:"concurrent,omitempty"`                     // Deploys the nodes concurrently
+	Templates         map[string]string `json:"templates,omitempty" yaml:"templates,omitempty"`                       // Templates replacing the built-in ones (name: contents)
+}
+
+// Node is a single database server within a sandbox
+type Node struct {
+	Name       string // Name of the node (same as the sandbox name for single deployments)
+	Dir        string // Directory of the node
+	Port       int    // Port of the server
+	MysqlXPort int    // MySQLX port, if enabled
+	Socket     string // Unix socket of the server
+	User       string // Database user
+	Password   string // Database password
+}
+
+// Sandbox is the handle to a deployed sandbox
+type Sandbox struct {
+	Name        string // Name of the sandbox directory
+	Dir         string // Full path of the sandbox
+	Type        string // Sandbox type, as recorded in sbdescription.json
+	Version     string // MySQL version
+	User        string // Database user
+	Password    string // Database password
+	RplUser     string // Replication user
+	RplPassword string // Replication password
+	Nodes       []Node // Database servers. A single sandbox has only one node
+}
+
+// NodeStatus reports whether a node is running
+type NodeStatus struct {
+	Node    string
+	Running bool
+	Pid     int
+}
+
+// Deployer creates and controls sandboxes
+type Deployer struct {
+	SandboxHome   string // Where the sandboxes are installed
+	SandboxBinary string // Where the expanded tarballs are
+}
+
+// New returns a Deployer.
+// Empty arguments are replaced by the values in dbdeployer defaults
+func New(sandbox_home, sandbox_binary string) *Deployer {
+	if sandbox_home == "" {
+		sandbox_home = defaults.Defaults().SandboxHome
+	}
+	if sandbox_binary == "" {
+		sandbox_binary = defaults.Defaults().SandboxBinary
+	}
+	return &Deployer{SandboxHome: sandbox_home, SandboxBinary: sandbox_binary}
+}
+
+func default_dir_name(spec Spec) string {
+	version_name := common.VersionToName(spec.Version)
+	if spec.BasedirName != spec.Version {
+		version_name = spec.BasedirName
+	}
+	switch spec.Topology {
+	case TopologyMultiple:
+		return defaults.Defaults().MultiplePrefix + version_name
+	case TopologyMasterSlave:
+		return defaults.Defaults().MasterSlavePrefix + version_name
+	case TopologyGroup:
+		if spec.SinglePrimary {
+			return defaults.Defaults().GroupSpPrefix + version_name
+		}
+		return defaults.Defaults().GroupPrefix + version_name
+	case TopologyFanIn:
+		return defaults.Defaults().FanInPrefix + version_name
+	case TopologyAllMasters:
+		return defaults.Defaults().AllMastersPrefix + version_name
+	case TopologyGalera:
+		return defaults.Defaults().GaleraPrefix + version_name
+	case TopologyPxc:
+		return defaults.Defaults().PxcPrefix + version_name
+	case TopologyNdb:
+		return defaults.Defaults().NdbPrefix + version_name
+	case TopologyChain:
+		return defaults.Defaults().ChainPrefix + version_name
+	case TopologyTree:
+		return defaults.Defaults().TreePrefix + version_name
+	case TopologyRing:
+		return defaults.Defaults().RingPrefix + version_name
+	}
+	return defaults.Defaults().SandboxPrefix + version_name
+}
+
+func fill_string(value *string, default_value string) {
+	if *value == "" {
+		*value = default_value
+	}
+}
+
+// Fills the empty fields of a Spec with their default values,
+// and checks that the request can be satisfied
+func (d *Deployer) normalize_spec(spec Spec) (Spec, error) {
+	if !common.IsVersion(spec.Version) {
+		return spec, fmt.Errorf("Invalid version '%s'", spec.Version)
+	}
+	fill_string(&spec.Topology, TopologySingle)
+	if spec.Basedir != "" {
+		fill_string(&spec.BasedirName, common.BaseName(spec.Basedir))
+	}
+	fill_string(&spec.BasedirName, spec.Version)
+	fill_string(&spec.Basedir, path.Join(d.SandboxBinary, spec.BasedirName))
+	fill_string(&spec.DbUser, defaults.DbUserValue)
+	fill_string(&spec.DbPassword, defaults.DbPasswordValue)
+	fill_string(&spec.RplUser, defaults.RplUserValue)
+	fill_string(&spec.RplPassword, defaults.RplPasswordValue)
+	fill_string(&spec.RemoteAccess, defaults.RemoteAccessValue)
+	fill_string(&spec.BindAddress, defaults.BindAddressValue)
+	fill_string(&spec.MasterIp, defaults.MasterIpValue)
+	if spec.Topology == TopologyFanIn {
+		fill_string(&spec.MasterList, defaults.MasterListValue)
+		fill_string(&spec.SlaveList, defaults.SlaveListValue)
+	}
+	if spec.ReplicationTree != "" {
+		if spec.Topology != TopologyTree {
+			return spec, fmt.Errorf("replication-tree can only be used with tree topology")
+		}
+		masters, err := sandbox.ParseReplicationTree(spec.ReplicationTree)
+		if err != nil {
+			return spec, err
+		}
+		tree_nodes := len(masters) + 1
+		if spec.Nodes == 0 {
+			spec.Nodes = tree_nodes
+		}
+		if spec.Nodes != tree_nodes {
+			return spec, fmt.Errorf("replication-tree has %d nodes, but %d nodes were requested", tree_nodes, spec.Nodes)
+		}
+	} else if spec.Topology == TopologyTree {
+		return spec, fmt.Errorf("tree topology requires a replication-tree")
+	}
+	switch spec.Topology {
+	case TopologySingle:
+	case TopologyMultiple, TopologyMasterSlave, TopologyGroup, TopologyFanIn, TopologyAllMasters,
+		TopologyGalera, TopologyPxc, TopologyNdb, TopologyChain, TopologyTree, TopologyRing:
+		if spec.Nodes == 0 {
+			spec.Nodes = defaults.NodesValue
+		}
+	default:
+		return spec, fmt.Errorf("Unrecognized topology '%s'", spec.Topology)
+	}
+	fill_string(&spec.DirName, default_dir_name(spec))
+	if spec.Port == 0 {
+		spec.Port = common.VersionToPort(spec.Version)
+	}
+	if spec.Gtid && !common.GreaterOrEqualVersion(spec.Version, []int{5, 6, 9}) {
+		return spec, &sandbox.UnsupportedVersionError{Feature: "GTID", Version: spec.Version, MinVersion: "5.6.9"}
+	}
+	if spec.ReplCrashSafe && !common.GreaterOrEqualVersion(spec.Version, []int{5, 6, 2}) {
+		return spec, &sandbox.UnsupportedVersionError{Feature: "replication crash safe", Version: spec.Version, MinVersion: "5.6.2"}
+	}
+	if spec.EnableMysqlX && spec.DisableMysqlX {
+		return spec, fmt.Errorf("enable-mysqlx and disable-mysqlx cannot be used together")
+	}
+	if spec.Master && spec.Topology != TopologySingle {
+		return spec, fmt.Errorf("master can only be used with a single sandbox")
+	}
+	if spec.SemiSync {
+		if spec.Topology != TopologyMasterSlave {
+			return spec, fmt.Errorf("semi-sync is only available with master-slave topology")
+		}
+		if !common.GreaterOrEqualVersion(spec.Version, []int{5, 5, 1}) {
+			return spec, &sandbox.UnsupportedVersionError{Feature: "semi-sync", Version: spec.Version, MinVersion: "5.5.1"}
+		}
+	}
+	if spec.NdbNodes != 0 && spec.Topology != TopologyNdb {
+		return spec, fmt.Errorf("ndb-nodes can only be used with ndb topology")
+	}
+	if spec.SinglePrimary && spec.Topology != TopologyGroup {
+		return spec, fmt.Errorf("single-primary can only be used with group topology")
+	}
+	if len(spec.PerNodeOptions) > 0 && spec.Topology == TopologySingle {
+		return spec, fmt.Errorf("per-node options require a topology with multiple nodes")
+	}
+	role_options := len(spec.NodeOptions) + len(spec.MasterOptions) + len(spec.SlaveOptions)
+	if role_options > 0 && (spec.Topology == TopologySingle || spec.Topology == TopologyMultiple) {
+		return spec, fmt.Errorf("node, master, and slave options require a replication topology")
+	}
+	for node := range spec.PerNodeOptions {
+		if node < 1 || node > spec.Nodes {
+			return spec, fmt.Errorf("per-node options given for node %d. Valid nodes: 1 to %d", node, spec.Nodes)
+		}
+	}
+	return spec, nil
+}
+
+// Converts a Spec into the sandbox definition used by the sandbox package
+func (d *Deployer) spec_to_sdef(spec Spec) (sandbox.SandboxDef, error) {
+	sdef := sandbox.SandboxDef{
+		Version:           spec.Version,
+		Basedir:           spec.Basedir,
+		BasedirName:       spec.BasedirName,
+		SandboxDir:        d.SandboxHome,
+		DirName:           spec.DirName,
+		Port:              spec.Port,
+		BasePort:          spec.BasePort,
+		LoadGrants:        !spec.SkipStart && !spec.SkipLoadGrants,
+		SkipStart:         spec.SkipStart,
+		DbUser:            spec.DbUser,
+		DbPassword:        spec.DbPassword,
+		RplUser:           spec.RplUser,
+		RplPassword:       spec.RplPassword,
+		RemoteAccess:      spec.RemoteAccess,
+		BindAddress:       spec.BindAddress,
+		MyCnfOptions:      spec.MyCnfOptions,
+		InitOptions:       spec.InitOptions,
+		PreGrantsSql:      spec.PreGrantsSql,
+		PostGrantsSql:     spec.PostGrantsSql,
+		PreGrantsSqlFile:  spec.PreGrantsSqlFile,
+		PostGrantsSqlFile: spec.PostGrantsSqlFile,
+		NdbNodes:          spec.NdbNodes,
+		ReplicationTree:   spec.ReplicationTree,
+		NodeOptions:       spec.NodeOptions,
+		MasterOptions:     spec.MasterOptions,
+		SlaveOptions:      spec.SlaveOptions,
+		PerNodeOptions:    spec.PerNodeOptions,
+		SinglePrimary:     spec.SinglePrimary,
+		Force:             spec.Force,
+		NativeAuthPlugin:  spec.NativeAuthPlugin,
+		KeepUuid:          spec.KeepServerUuid,
+		ExposeDdTables:    spec.ExposeDdTables,
+		EnableMysqlX:      spec.EnableMysqlX,
+		DisableMysqlX:     spec.DisableMysqlX,
+		EnableGeneralLog:  spec.EnableGeneralLog,
+		InitGeneralLog:    spec.InitGeneralLog,
+		SkipReportHost:    spec.SkipReportHost,
+		SkipReportPort:    spec.SkipReportPort,
+		CustomMysqld:      spec.CustomMysqld,
+		HistoryDir:        spec.HistoryDir,
+		MyCnfFile:         spec.MyCnfFile,
+		RunConcurrently:   spec.Concurrent && spec.Topology != TopologySingle,
+		Templates:         spec.Templates,
+	}
+	err := sandbox.CheckDeploymentTemplates(sdef)
+	if err != nil {
+		return sdef, err
+	}
+	single_templates := sandbox.DeploymentTemplates(sdef, sandbox.SingleTemplates)
+	if spec.Topology != TopologySingle {
+		sdef.ReplOptions = single_templates["replication_options"].Contents
+	}
+	if spec.Master {
+		sdef.ReplOptions = single_templates["replication_options"].Contents
+		sdef.ServerId = spec.Port
+	}
+	if spec.Gtid {
+		template_name, err := sandbox.ResolveTemplate(single_templates, "gtid_options", spec.Version)
+		if err != nil {
+			return sdef, err
+		}
+		sdef.GtidOptions = single_templates[template_name].Contents
+		sdef.ReplCrashSafeOptions = single_templates["repl_crash_safe_options"].Contents
+		sdef.ReplOptions = single_templates["replication_options"].Contents
+		sdef.ServerId = spec.Port
+	}
+	if spec.ReplCrashSafe && sdef.ReplCrashSafeOptions == "" {
+		sdef.ReplCrashSafeOptions = single_templates["repl_crash_safe_options"].Contents
+	}
+	if spec.SemiSync {
+		sdef.SemiSyncOptions = single_templates["semisync_master_options"].Contents
+	}
+	return sdef, nil
+}
+
+// Deploy creates the sandbox described by spec and returns its handle.
+// The context is checked before each node is created, and while waiting
+// for each server to start. A deployment interrupted by the context
+// is removed, and the context error is returned.
+// Deployments can run at the same time, also when they replace templates
+// (spec.Templates), as the replaced templates are seen only by their
+// own deployment.
+func (d *Deployer) Deploy(ctx context.Context, spec Spec) (*Sandbox, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	spec, err := d.normalize_spec(spec)
+	if err != nil {
+		return nil, err
+	}
+	if !common.DirExists(d.SandboxHome) {
+		err = os.MkdirAll(d.SandboxHome, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating directory %s: %s", d.SandboxHome, err)
+		}
+	}
+	err = d.create_sandbox(ctx, spec, nil)
+	if err != nil {
+		return nil, err
+	}
+	sb, err := d.Open(spec.DirName)
+	if err != nil {
+		return nil, err
+	}
+	sb.RplUser = spec.RplUser
+	sb.RplPassword = spec.RplPassword
+	return sb, nil
+}
+
+// Plan returns what deploying the given specs would do (sandboxes, ports,
+// scripts, and commands), without creating directories, running commands,
+// writing operation logs, or changing the catalog.
+// Plans are computed one at a time: concurrent calls wait for each other.
+func (d *Deployer) Plan(ctx context.Context, specs []Spec) (*sandbox.DeploymentPlan, error) {
+	plan := sandbox.StartDryRun()
+	defer sandbox.StopDryRun()
+	for _, spec := range specs {
+		if err := ctx.Err(); err != nil {
+			return plan, err
+		}
+		spec, err := d.normalize_spec(spec)
+		if err != nil {
+			return plan, err
+		}
+		// The sandboxes planned so far are not installed, but
+		// the following ones must not use their ports
+		var planned_ports []int
+		for _, sb := range plan.Sandboxes {
+			planned_ports = append(planned_ports, sb.Port...)
+		}
+		err = d.create_sandbox(ctx, spec, planned_ports)
+		if err != nil {
+			return plan, err
+		}
+	}
+	return plan, nil
+}
+
+// Runs the sandbox package function that creates the sandbox for a normalized spec
+func (d *Deployer) create_sandbox(ctx context.Context, spec Spec, more_installed_ports []int) error {
+	sdef, err := d.spec_to_sdef(spec)
+	if err != nil {
+		return err
+	}
+	sdef.Context = ctx
+	sdef.InstalledPorts = common.GetInstalledPorts(d.SandboxHome)
+	for _, p := range defaults.Defaults().ReservedPorts {
+		sdef.InstalledPorts = append(sdef.InstalledPorts, p)
+	}
+	sdef.InstalledPorts = append(sdef.InstalledPorts, more_installed_ports...)
+	switch spec.Topology {
+	case TopologySingle:
+		_, err = sandbox.CreateSingleSandbox(sdef)
+	case TopologyMultiple:
+		_, err = sandbox.CreateMultipleSandbox(sdef, spec.BasedirName, spec.Nodes)
+	default:
+		err = sandbox.CreateReplicationSandbox(sdef, spec.BasedirName, spec.Topology, spec.Nodes,
+			spec.MasterIp, spec.MasterList, spec.SlaveList)
+	}
+	return err
+}
+
+// Remove stops the sandbox, deletes its directory, and removes it from the catalog
+func (d *Deployer) Remove(ctx context.Context, sb *Sandbox) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := sandbox.RemoveSandbox(common.DirName(sb.Dir), sb.Name, false)
+	if err != nil {
+		return err
+	}
+	return defaults.DeleteFromCatalog(sb.Dir)
+}
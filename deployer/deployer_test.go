@@ -0,0 +1,262 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/sandbox"
+)
+
+type spec_rec struct {
+	spec     Spec
+	dir_name string
+	nodes    int
+	port     int
+}
+
+func TestNormalizeSpec(t *testing.T) {
+	d := New("/tmp/sandboxes", "/tmp/opt/mysql")
+	var specs = []spec_rec{
+		{Spec{Version: "5.7.22"}, "msb_5_7_22", 0, 5722},
+		{Spec{Version: "8.0.11", Topology: TopologyMultiple}, "multi_msb_8_0_11", 3, 8011},
+		{Spec{Version: "5.7.22", Topology: TopologyMasterSlave, Nodes: 2}, "rsandbox_5_7_22", 2, 5722},
+		{Spec{Version: "8.0.11", Topology: TopologyGroup, SinglePrimary: true}, "group_sp_msb_8_0_11", 3, 8011},
+		{Spec{Version: "5.7.22", DirName: "mysandbox", Port: 9000}, "mysandbox", 0, 9000},
+		{Spec{Version: "5.7.22", Topology: TopologyPxc}, "pxc_msb_5_7_22", 3, 5722},
+		{Spec{Version: "5.7.22", Topology: TopologyNdb, NdbNodes: 4, Nodes: 2}, "ndb_msb_5_7_22", 2, 5722},
+		{Spec{Version: "5.6.40", Topology: TopologyChain}, "chain_msb_5_6_40", 3, 5640},
+		{Spec{Version: "5.6.40", Topology: TopologyRing, Nodes: 4}, "ring_msb_5_6_40", 4, 5640},
+		{Spec{Version: "5.7.22", Topology: TopologyTree, ReplicationTree: "1:2,3;2:4,5"}, "tree_msb_5_7_22", 5, 5722},
+	}
+	for _, sr := range specs {
+		spec, err := d.normalize_spec(sr.spec)
+		if err != nil {
+			t.Logf("not ok - unexpected error for %#v: %s\n", sr.spec, err)
+			t.Fail()
+			continue
+		}
+		if spec.DirName == sr.dir_name && spec.Nodes == sr.nodes && spec.Port == sr.port {
+			t.Logf("ok - %s %s: %s %d %d\n", spec.Topology, spec.Version, spec.DirName, spec.Nodes, spec.Port)
+		} else {
+			t.Logf("not ok - %s %s: expected %s %d %d - found %s %d %d\n", spec.Topology, spec.Version,
+				sr.dir_name, sr.nodes, sr.port, spec.DirName, spec.Nodes, spec.Port)
+			t.Fail()
+		}
+		if spec.Basedir != "/tmp/opt/mysql/"+spec.Version {
+			t.Logf("not ok - unexpected base directory %s\n", spec.Basedir)
+			t.Fail()
+		}
+	}
+
+	_, err := d.normalize_spec(Spec{Version: "5.7.22", Topology: "star"})
+	if err == nil {
+		t.Logf("not ok - unknown topology accepted\n")
+		t.Fail()
+	}
+	_, err = d.normalize_spec(Spec{Version: "5.7.22", Topology: TopologyGroup, NdbNodes: 2})
+	if err == nil {
+		t.Logf("not ok - ndb-nodes accepted for group topology\n")
+		t.Fail()
+	}
+	_, err = d.normalize_spec(Spec{Version: "5.7.22", Topology: TopologyTree})
+	if err == nil {
+		t.Logf("not ok - tree topology accepted without replication-tree\n")
+		t.Fail()
+	}
+	_, err = d.normalize_spec(Spec{Version: "5.7.22", Topology: TopologyTree, ReplicationTree: "1:2;2:3", Nodes: 4})
+	if err == nil {
+		t.Logf("not ok - replication-tree accepted with mismatched nodes\n")
+		t.Fail()
+	}
+	_, err = d.normalize_spec(Spec{Version: "5.5.48", Gtid: true})
+	if _, ok := err.(*sandbox.UnsupportedVersionError); ok {
+		t.Logf("ok - GTID refused for 5.5.48: %s\n", err)
+	} else {
+		t.Logf("not ok - expected UnsupportedVersionError, got %#v\n", err)
+		t.Fail()
+	}
+}
+
+func TestSpecToSdef(t *testing.T) {
+	d := New("/tmp/sandboxes", "/tmp/opt/mysql")
+	spec, _ := d.normalize_spec(Spec{Version: "5.7.22", Topology: TopologyMasterSlave, Gtid: true, SkipStart: true})
+	sdef, err := d.spec_to_sdef(spec)
+	if err != nil {
+		t.Fatalf("not ok - %s", err)
+	}
+	if sdef.SandboxDir != "/tmp/sandboxes" || sdef.BasedirName != "5.7.22" {
+		t.Logf("not ok - unexpected directories %s %s\n", sdef.SandboxDir, sdef.BasedirName)
+		t.Fail()
+	}
+	if sdef.GtidOptions == "" || sdef.ReplOptions == "" {
+		t.Logf("not ok - GTID options not set\n")
+		t.Fail()
+	}
+	if sdef.LoadGrants {
+		t.Logf("not ok - grants should not be loaded when the start is skipped\n")
+		t.Fail()
+	}
+	// The GTID options come from the variant of the template
+	// that applies to the version
+	var gtid_checks = []struct {
+		version  string
+		template string
+	}{
+		{"5.6.40", "gtid_options_56"},
+		{"5.7.22", "gtid_options_57"},
+		{"8.0.11", "gtid_options_57"},
+	}
+	for _, c := range gtid_checks {
+		spec, _ := d.normalize_spec(Spec{Version: c.version, Topology: TopologySingle, Gtid: true, SkipStart: true})
+		sdef, err := d.spec_to_sdef(spec)
+		if err == nil && sdef.GtidOptions == sandbox.SingleTemplates[c.template].Contents {
+			t.Logf("ok - GTID options for %s from %s\n", c.version, c.template)
+		} else {
+			t.Logf("not ok - GTID options for %s: expected %s (%v)\n", c.version, c.template, err)
+			t.Fail()
+		}
+	}
+
+	// Templates in the spec are seen by its deployment only
+	gtid_contents := sandbox.SingleTemplates["gtid_options_57"].Contents
+	spec, _ = d.normalize_spec(Spec{Version: "5.7.22", Topology: TopologySingle, Gtid: true, SkipStart: true,
+		Templates: map[string]string{"gtid_options_57": "# custom GTID options"}})
+	sdef, err = d.spec_to_sdef(spec)
+	if err == nil && sdef.GtidOptions == "# custom GTID options" {
+		t.Logf("ok - GTID options from the spec templates\n")
+	} else {
+		t.Logf("not ok - GTID options not replaced: '%s' (%v)\n", sdef.GtidOptions, err)
+		t.Fail()
+	}
+	if sandbox.SingleTemplates["gtid_options_57"].Contents != gtid_contents {
+		t.Logf("not ok - built-in gtid_options_57 changed by a deployment\n")
+		t.Fail()
+	}
+	spec.Templates = map[string]string{"no_such_template": ""}
+	_, err = d.spec_to_sdef(spec)
+	if err != nil {
+		t.Logf("ok - unknown template refused: %s\n", err)
+	} else {
+		t.Logf("not ok - unknown template accepted\n")
+		t.Fail()
+	}
+}
+
+func TestReadSpecFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spec_file")
+	if err != nil {
+		t.Fatalf("can't create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	var contents = map[string]string{
+		"topology.yaml": `
+sandboxes:
+  - version: 5.7.22
+    topology: master-slave
+    nodes: 3
+    my-cnf-options:
+      - innodb_buffer_pool_size=256M
+    per-node-options:
+      2:
+        - read_only=1
+  - version: 8.0.11
+`,
+		"topology.json": `{"sandboxes": [
+	{"version": "5.7.22", "topology": "master-slave", "nodes": 3,
+	 "my-cnf-options": ["innodb_buffer_pool_size=256M"],
+	 "per-node-options": {"2": ["read_only=1"]}},
+	{"version": "8.0.11"}]}`,
+	}
+	for name, text := range contents {
+		fname := dir + "/" + name
+		ioutil.WriteFile(fname, []byte(text), 0644)
+		specs, err := ReadSpecFile(fname)
+		if err != nil {
+			t.Logf("not ok - error reading %s: %s\n", name, err)
+			t.Fail()
+			continue
+		}
+		if len(specs) == 2 && specs[0].Nodes == 3 && specs[1].Version == "8.0.11" &&
+			len(specs[0].MyCnfOptions) == 1 && specs[0].PerNodeOptions[2][0] == "read_only=1" {
+			t.Logf("ok - %s decoded\n", name)
+		} else {
+			t.Logf("not ok - %s decoded as %#v\n", name, specs)
+			t.Fail()
+		}
+	}
+
+	fname := dir + "/wrong.yaml"
+	ioutil.WriteFile(fname, []byte("sandboxes:\n  - version: 5.7.22\n    topolgy: group\n"), 0644)
+	_, err = ReadSpecFile(fname)
+	if err != nil {
+		t.Logf("ok - unknown key detected: %s\n", err)
+	} else {
+		t.Logf("not ok - unknown key not detected\n")
+		t.Fail()
+	}
+}
+
+func TestSpecFromDefinition(t *testing.T) {
+	d := New("/tmp/sandboxes", "/tmp/opt/mysql")
+	var specs = []Spec{
+		{Version: "5.7.22", Port: 9000, MyCnfOptions: []string{"max_connections=100"}, Master: true},
+		{Version: "5.7.22", Topology: TopologyMasterSlave, Nodes: 3, Gtid: true, SemiSync: true,
+			PerNodeOptions: map[int][]string{2: []string{"read_only=1"}}},
+		{Version: "8.0.11", Topology: TopologyGroup, SinglePrimary: true, BasePort: 20000},
+		{Version: "8.0.11", BasedirName: "ps8.0.11", DirName: "ps8", SkipLoadGrants: true},
+	}
+	for _, orig := range specs {
+		spec, err := d.normalize_spec(orig)
+		if err != nil {
+			t.Logf("not ok - unexpected error for %#v: %s\n", orig, err)
+			t.Fail()
+			continue
+		}
+		sdef, err := d.spec_to_sdef(spec)
+		if err != nil {
+			t.Logf("not ok - unexpected error for %#v: %s\n", orig, err)
+			t.Fail()
+			continue
+		}
+		sdef.BasePort = spec.BasePort
+		dd := sandbox.DeploymentDefinition{Topology: spec.Topology, Nodes: spec.Nodes, Sdef: sdef}
+		exported := spec_from_definition(dd)
+		replayed, err := d.normalize_spec(exported)
+		if err != nil {
+			t.Logf("not ok - exported spec rejected: %s\n", err)
+			t.Fail()
+			continue
+		}
+		replayed_sdef, err := d.spec_to_sdef(replayed)
+		if err != nil {
+			t.Logf("not ok - unexpected error for exported spec: %s\n", err)
+			t.Fail()
+			continue
+		}
+		expected, _ := json.Marshal(sdef)
+		found, _ := json.Marshal(replayed_sdef)
+		if string(expected) == string(found) {
+			t.Logf("ok - %s %s exported\n", spec.Topology, spec.DirName)
+		} else {
+			t.Logf("not ok - %s %s exported\n  expected: %s\n  found:    %s\n", spec.Topology, spec.DirName, expected, found)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"github.com/datacharmer/dbdeployer/sandbox"
+)
+
+// Converts the definition saved at creation time into a Spec.
+// Paths that only make sense on the current host (sandbox home,
+// binaries directory) are left out, so that the spec can be deployed
+// on a different machine.
+func spec_from_definition(dd sandbox.DeploymentDefinition) Spec {
+	sdef := dd.Sdef
+	spec := Spec{
+		Version:          sdef.Version,
+		Topology:         dd.Topology,
+		DirName:          sdef.DirName,
+		DbUser:           sdef.DbUser,
+		DbPassword:       sdef.DbPassword,
+		RplUser:          sdef.RplUser,
+		RplPassword:      sdef.RplPassword,
+		RemoteAccess:     sdef.RemoteAccess,
+		BindAddress:      sdef.BindAddress,
+		SinglePrimary:    sdef.SinglePrimary,
+		SemiSync:         sdef.SemiSyncOptions != "",
+		Gtid:             sdef.GtidOptions != "",
+		NativeAuthPlugin: sdef.NativeAuthPlugin,
+		KeepServerUuid:   sdef.KeepUuid,
+		ExposeDdTables:   sdef.ExposeDdTables,
+		EnableMysqlX:     sdef.EnableMysqlX,
+		DisableMysqlX:    sdef.DisableMysqlX,
+		EnableGeneralLog: sdef.EnableGeneralLog,
+		InitGeneralLog:   sdef.InitGeneralLog,
+		SkipReportHost:   sdef.SkipReportHost,
+		SkipReportPort:   sdef.SkipReportPort,
+		CustomMysqld:     sdef.CustomMysqld,
+		HistoryDir:       sdef.HistoryDir,
+		MyCnfOptions:     sdef.MyCnfOptions,
+		NodeOptions:      sdef.NodeOptions,
+		MasterOptions:    sdef.MasterOptions,
+		SlaveOptions:     sdef.SlaveOptions,
+		PerNodeOptions:   sdef.PerNodeOptions,
+		InitOptions:      sdef.InitOptions,
+		PreGrantsSql:     sdef.PreGrantsSql,
+		PostGrantsSql:    sdef.PostGrantsSql,
+		SkipStart:        sdef.SkipStart,
+		SkipLoadGrants:   !sdef.LoadGrants && !sdef.SkipStart,
+		Templates:        dd.Templates,
+	}
+	if sdef.BasedirName != sdef.Version {
+		spec.BasedirName = sdef.BasedirName
+	}
+	spec.ReplCrashSafe = sdef.ReplCrashSafeOptions != "" && !spec.Gtid
+	if dd.Topology == TopologySingle {
+		spec.Port = sdef.Port
+		spec.Master = sdef.ReplOptions != "" && !spec.Gtid
+	} else {
+		spec.Nodes = dd.Nodes
+		spec.BasePort = sdef.BasePort
+		spec.MasterIp = dd.MasterIp
+		if dd.Topology == TopologyNdb {
+			spec.NdbNodes = sdef.NdbNodes
+		}
+		if dd.Topology == TopologyTree {
+			spec.ReplicationTree = sdef.ReplicationTree
+			// A sandbox where nodes were removed has gaps in the tree
+			compact, err := sandbox.CompactReplicationTree(sdef.ReplicationTree)
+			if err == nil {
+				spec.ReplicationTree = compact
+			}
+		}
+		if dd.Topology == TopologyFanIn {
+			spec.MasterList = dd.MasterList
+			spec.SlaveList = dd.SlaveList
+		}
+	}
+	return spec
+}
+
+// ExportSpec returns the Spec that reproduces an installed sandbox
+func (d *Deployer) ExportSpec(name string) (Spec, error) {
+	dd, err := sandbox.ReadDeploymentDefinition(d.SandboxHome + "/" + name)
+	if err != nil {
+		return Spec{}, err
+	}
+	return spec_from_definition(dd), nil
+}
@@ -0,0 +1,111 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// SpecFile is the content of a deployment file.
+//
+//	sandboxes:
+//	  - version: 5.7.22
+//	    topology: master-slave
+//	    nodes: 3
+//	    gtid: true
+//	    my-cnf-options:
+//	      - innodb_buffer_pool_size=256M
+//	    per-node-options:
+//	      2:
+//	        - read_only=1
+//	  - version: 8.0.11
+//	    sandbox-directory: single_8
+type SpecFile struct {
+	Sandboxes []Spec `json:"sandboxes" yaml:"sandboxes"`
+}
+
+// Returns true if the file name indicates JSON contents
+func is_json_file(filename string) bool {
+	return strings.ToLower(path.Ext(filename)) == ".json"
+}
+
+// ReadSpecFile reads a deployment file, in YAML or JSON format.
+// Files with extension '.json' are decoded as JSON, all others as YAML.
+func ReadSpecFile(filename string) ([]Spec, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading deployment file %s: %s", filename, err)
+	}
+	var spec_file SpecFile
+	if is_json_file(filename) {
+		err = json.Unmarshal(contents, &spec_file)
+	} else {
+		err = yaml.UnmarshalStrict(contents, &spec_file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding deployment file %s: %s", filename, err)
+	}
+	if len(spec_file.Sandboxes) == 0 {
+		return nil, fmt.Errorf("No sandboxes defined in deployment file %s", filename)
+	}
+	for N, spec := range spec_file.Sandboxes {
+		if spec.Version == "" {
+			return nil, fmt.Errorf("Sandbox #%d in deployment file %s has no version", N+1, filename)
+		}
+	}
+	return spec_file.Sandboxes, nil
+}
+
+// EncodeSpecFile returns the contents of a deployment file for the
+// given specs, in JSON or YAML format
+func EncodeSpecFile(specs []Spec, as_json bool) ([]byte, error) {
+	spec_file := SpecFile{Sandboxes: specs}
+	if as_json {
+		return json.MarshalIndent(spec_file, "", "  ")
+	}
+	return yaml.Marshal(spec_file)
+}
+
+// WriteSpecFile saves the specs into a deployment file.
+// The format is chosen with the same rules used by ReadSpecFile.
+func WriteSpecFile(filename string, specs []Spec) error {
+	contents, err := EncodeSpecFile(specs, is_json_file(filename))
+	if err != nil {
+		return fmt.Errorf("Error encoding deployment file %s: %s", filename, err)
+	}
+	return ioutil.WriteFile(filename, contents, 0644)
+}
+
+// DeployAll creates all the sandboxes in the list, stopping at the first error.
+// It returns the handles of the sandboxes that were created.
+func (d *Deployer) DeployAll(ctx context.Context, specs []Spec) ([]*Sandbox, error) {
+	var sandboxes []*Sandbox
+	for _, spec := range specs {
+		sb, err := d.Deploy(ctx, spec)
+		if err != nil {
+			return sandboxes, err
+		}
+		sandboxes = append(sandboxes, sb)
+	}
+	return sandboxes, nil
+}
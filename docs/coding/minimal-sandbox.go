@@ -70,13 +70,15 @@ func main() {
 	}
 
 	// Calls the sandbox creation
-	sandbox.CreateSingleSandbox(sdef)
+	_, err := sandbox.CreateSingleSandbox(sdef)
+	common.ErrCheckExitf(err, 1, "%s", err)
 
 	// Invokes the sandbox self-testing script
 	common.Run_cmd(sandbox_home + "/msb_5_7_22/test_sb")
 
 	// Removes the sandbox from disk
-	sandbox.RemoveSandbox(sandbox_home, "msb_5_7_22", false)
+	_, err = sandbox.RemoveSandbox(sandbox_home, "msb_5_7_22", false)
+	common.ErrCheckExitf(err, 1, "%s", err)
 
 	// Removes the sandbox from dbdeployer catalog
 	defaults.DeleteFromCatalog(sandbox_home + "/msb_5_7_22")
@@ -87,7 +87,8 @@ func main() {
 	}
 
 	// Calls the sandbox creation
-	sandbox.CreateSingleSandbox(sdef)
+	_, err := sandbox.CreateSingleSandbox(sdef)
+	common.ErrCheckExitf(err, 1, "%s", err)
 
 	sdef.Version = version2
 	sdef.Basedir = basedir2
@@ -95,15 +96,18 @@ func main() {
 	sdef.Port = port2
 
 	// Calls the sandbox creation for the second sandbox
-	sandbox.CreateSingleSandbox(sdef)
+	_, err = sandbox.CreateSingleSandbox(sdef)
+	common.ErrCheckExitf(err, 1, "%s", err)
 
 	// Invokes the sandbox self-testing script
 	common.Run_cmd(sandbox_home + "/" + sandbox_name1 + "/test_sb")
 	common.Run_cmd(sandbox_home + "/" + sandbox_name2 + "/test_sb")
 
 	// Removes the sandbox from disk
-	sandbox.RemoveSandbox(sandbox_home, sandbox_name1, false)
-	sandbox.RemoveSandbox(sandbox_home, sandbox_name2, false)
+	_, err = sandbox.RemoveSandbox(sandbox_home, sandbox_name1, false)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	_, err = sandbox.RemoveSandbox(sandbox_home, sandbox_name2, false)
+	common.ErrCheckExitf(err, 1, "%s", err)
 
 	// Removes the sandbox from dbdeployer catalog
 	defaults.DeleteFromCatalog(sandbox_home + "/" + sandbox_name1)
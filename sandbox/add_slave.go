@@ -0,0 +1,238 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+)
+
+// AddSlave creates a new node in a replication sandbox, and makes it a
+// slave of the given master node. The new node is seeded with a dump of
+// the master data. When basedir is empty, the new node uses the same
+// binaries as the master.
+// The scripts that operate on all nodes are generated again, to include
+// the new node. It returns the name of the new node directory.
+// If the new node can't be created or seeded, it is stopped and removed.
+func AddSlave(sandbox_dir, version, basedir string, master_node int) (slave_dir string, err error) {
+	dd, err := ReadDeploymentDefinition(sandbox_dir)
+	if err != nil {
+		return "", err
+	}
+	switch dd.Topology {
+	case "master-slave", ChainTopology, TreeTopology:
+	default:
+		return "", fmt.Errorf("Can't add a slave to sandbox %s (topology '%s'). Supported topologies: master-slave, chain, tree",
+			common.BaseName(sandbox_dir), dd.Topology)
+	}
+	nodes := sandbox_nodes(sandbox_dir)
+	master, ok := nodes[master_node]
+	if !ok {
+		return "", fmt.Errorf("Node %d not found in sandbox %s", master_node, common.BaseName(sandbox_dir))
+	}
+	if dd.Topology == "master-slave" && master_node != 1 {
+		return "", fmt.Errorf("In a master-slave sandbox, new slaves can only replicate from the master (node 1)")
+	}
+	if basedir == "" {
+		version = master.Description.Version
+		basedir = master.Description.Basedir
+	}
+	if !common.DirExists(basedir) {
+		return "", &MissingBasedirError{Basedir: basedir}
+	}
+	if version != master.Description.Version && !common.GreaterOrEqualVersion(version, common.VersionToList(master.Description.Version)) {
+		return "", fmt.Errorf("The version of the new slave (%s) can't be lower than the master version (%s)", version, master.Description.Version)
+	}
+
+	fname, logger := defaults.NewLogger(common.LogDirName(), "add-slave")
+	parent_sdef := dd.Sdef
+	parent_sdef.SandboxDir = sandbox_dir
+	node_num := 0
+	max_port := 0
+	for N, node := range nodes {
+		if N > node_num {
+			node_num = N
+		}
+		for _, port := range node.Description.Port {
+			if port > max_port {
+				max_port = port
+			}
+		}
+	}
+	node_num++
+	node_label := defaults.Defaults().NodePrefix
+	slave_label := defaults.Defaults().SlavePrefix
+
+	sdef := dd.Sdef
+	sdef.Version = version
+	sdef.Basedir = basedir
+	sdef.BasedirName = common.BaseName(basedir)
+	sdef.SandboxDir = sandbox_dir
+	sdef.LogFileName = common.ReplaceLiteralHome(fname)
+	sdef.Logger = logger
+	sdef.InstalledPorts = common.GetInstalledPorts(common.DirName(sandbox_dir))
+	sdef.Port = common.FindFreePort(max_port+1, sdef.InstalledPorts, 1)
+	sdef.MysqlXPort = 0
+	sdef.MorePorts = nil
+	sdef.ServerId = node_num * 100
+	sdef.NodeNum = node_num
+	sdef.Multi = true
+	sdef.LoadGrants = false
+	sdef.SkipStart = false
+	sdef.RunConcurrently = false
+	sdef.ReplOptions = DeploymentTemplates(sdef, SingleTemplates)["replication_options"].Contents
+	sdef.PerNodeOptions = map[int][]string{node_num: append(append([]string{}, sdef.NodeOptions...), sdef.SlaveOptions...)}
+	if sdef.SemiSyncOptions != "" {
+		sdef.SemiSyncOptions = DeploymentTemplates(sdef, SingleTemplates)["semisync_slave_options"].Contents
+	}
+	if sdef.HistoryDir == "REPL_DIR" {
+		sdef.HistoryDir = sandbox_dir
+	}
+	if dd.Topology == "master-slave" {
+		// Slaves of a master-slave sandbox are numbered from 1, and
+		// the master is node 1
+		sdef.DirName = fmt.Sprintf("%s%d", node_label, node_num-1)
+		sdef.Prompt = fmt.Sprintf("%s%d", slave_label, node_num-1)
+		sdef.SBType = "replication-node"
+	} else {
+		sdef.DirName = fmt.Sprintf("%s%d", node_label, node_num)
+		sdef.Prompt = sdef.DirName
+		sdef.SBType = dd.Topology + "-node"
+	}
+	if common.DirExists(sandbox_dir + "/" + sdef.DirName) {
+		return "", fmt.Errorf("Directory %s/%s already exists", sandbox_dir, sdef.DirName)
+	}
+	logger.Printf("Adding node %d (%s) to %s as slave of node %d\n", node_num, sdef.DirName, sandbox_dir, master_node)
+
+	// The relay nodes of a tree write the events that they receive
+	// into their binary log, or their slaves would not get them
+	if dd.Topology != "master-slave" && master_node != 1 && !has_slaves(dd, master_node) {
+		logger.Printf("Adding log-slave-updates to node %d\n", master_node)
+		err, _ = common.Run_cmd_with_args(sandbox_dir+"/"+master.Name+"/add_option", []string{"log-slave-updates"})
+		if err != nil {
+			return "", fmt.Errorf("Error adding log-slave-updates to %s: %s", master.Name, err)
+		}
+	}
+
+	// Until it is seeded, a failure stops the new node and removes its directory
+	finish_cleanup := start_cleanup(&sdef)
+	defer func() {
+		finish_cleanup(err)
+	}()
+	fmt.Printf("Installing and starting %s\n", sdef.DirName)
+	_, err = CreateSingleSandbox(sdef)
+	if err != nil {
+		return "", err
+	}
+
+	seed_script := "initialize_" + sdef.DirName
+	sdef.Cleanup.Add(common.RmdirAll, "RmdirAll", sandbox_dir+"/"+seed_script)
+	err = write_script(logger, DeploymentTemplates(sdef, ReplicationTemplates), seed_script, "add_slave_template", sandbox_dir,
+		add_slave_data(logger, sdef, dd.MasterIp, master), true)
+	if err != nil {
+		return "", err
+	}
+	fmt.Println(common.ReplaceLiteralHome(sandbox_dir) + "/" + seed_script)
+	err, _ = common.Run_cmd(sandbox_dir + "/" + seed_script)
+	if err != nil {
+		return "", fmt.Errorf("Error seeding %s from %s: %s", sdef.DirName, master.Name, err)
+	}
+	// The new node is complete. The following steps only register it
+	finish_cleanup(nil)
+
+	// Registers the new node in the description, in the catalog, and
+	// in the deployment definition of the sandbox
+	slave_desc := common.ReadSandboxDescription(sandbox_dir + "/" + sdef.DirName)
+	sb_desc := common.ReadSandboxDescription(sandbox_dir)
+	sb_desc.Nodes++
+	sb_desc.Port = append(sb_desc.Port, slave_desc.Port...)
+	common.WriteSandboxDescription(sandbox_dir, sb_desc)
+	catalog, err := defaults.ReadCatalog()
+	if err != nil {
+		return "", err
+	}
+	if sb_item, ok := catalog[sandbox_dir]; ok {
+		sb_item.Nodes = append(sb_item.Nodes, sdef.DirName)
+		sb_item.Port = append(sb_item.Port, slave_desc.Port...)
+		err = defaults.UpdateCatalog(sandbox_dir, sb_item)
+		if err != nil {
+			return "", err
+		}
+	}
+	nodes[node_num] = NodeDescription{Name: sdef.DirName, Description: slave_desc}
+	if dd.Topology != "master-slave" {
+		masters, err := replication_tree_masters(dd)
+		if err != nil {
+			return "", err
+		}
+		masters[node_num] = master_node
+		// A chain with a slave outside the chain becomes a tree
+		dd.Topology = TreeTopology
+		dd.Sdef.ReplicationTree = format_replication_tree(masters)
+	}
+	dd.Nodes++
+	err = WriteDeploymentDefinition(sandbox_dir, dd)
+	if err != nil {
+		return "", err
+	}
+	err = write_all_nodes_scripts(logger, parent_sdef, dd, nodes)
+	if err != nil {
+		return "", err
+	}
+	return sdef.DirName, nil
+}
+
+// Returns the data used by the script that seeds a new slave
+// with the data of its master
+func add_slave_data(logger *defaults.Logger, sdef SandboxDef, master_ip string, master NodeDescription) common.Smap {
+	master_auto_position, change_master_extra := change_master_options(logger, sdef)
+	dump_options := "--master-data=1"
+	if sdef.GtidOptions != "" {
+		dump_options = "--set-gtid-purged=ON"
+	}
+	return common.Smap{
+		"Copyright":          Copyright,
+		"AppVersion":         common.VersionDef,
+		"DateTime":           time.Now().Format(time.UnixDate),
+		"SandboxDir":         sdef.SandboxDir,
+		"MasterDir":          master.Name,
+		"SlaveDir":           sdef.DirName,
+		"MasterIp":           master_ip,
+		"MasterPort":         master.Description.Port[0],
+		"RplUser":            sdef.RplUser,
+		"RplPassword":        sdef.RplPassword,
+		"MasterAutoPosition": master_auto_position,
+		"ChangeMasterExtra":  change_master_extra,
+		"DumpOptions":        dump_options,
+	}
+}
+
+// Tells whether a node of a chain or tree sandbox is already a master
+func has_slaves(dd DeploymentDefinition, node int) bool {
+	masters, err := replication_tree_masters(dd)
+	if err != nil {
+		return false
+	}
+	for _, master := range masters {
+		if master == node {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+)
+
+// Kinds of disagreement between the catalog and the file system
+const (
+	CatalogOrphan       = "orphan"       // Catalog entry without a sandbox directory
+	CatalogUnregistered = "unregistered" // Sandbox directory without a catalog entry
+	CatalogPortMismatch = "port"         // Different ports in the catalog and in sbdescription.json
+)
+
+type CatalogIssue struct {
+	Kind    string // One of CatalogOrphan, CatalogUnregistered, CatalogPortMismatch
+	Sandbox string // Full path of the sandbox directory
+	Details string // What was found
+}
+
+// Returns the ports as a sorted string, to compare lists regardless of order
+func ports_text(ports []int) string {
+	sorted := append([]int{}, ports...)
+	sort.Ints(sorted)
+	return fmt.Sprintf("%v", sorted)
+}
+
+// Builds the catalog entry of an installed sandbox from its description
+func catalog_item_from_description(sandbox_dir string) defaults.SandboxItem {
+	sbd := common.ReadSandboxDescription(sandbox_dir)
+	item := defaults.SandboxItem{
+		Origin:      sbd.Basedir,
+		SBType:      sbd.SBType,
+		Version:     sbd.Version,
+		Port:        sbd.Port,
+		Nodes:       []string{},
+		Destination: sandbox_dir,
+	}
+	if sbd.LogFile != "" {
+		item.LogDirectory = common.DirName(sbd.LogFile)
+	}
+	nodes := sandbox_nodes(sandbox_dir)
+	var node_numbers []int
+	for N := range nodes {
+		node_numbers = append(node_numbers, N)
+	}
+	sort.Ints(node_numbers)
+	for _, N := range node_numbers {
+		item.Nodes = append(item.Nodes, nodes[N].Name)
+	}
+	return item
+}
+
+// CheckCatalog compares the sandboxes catalog with the sandboxes installed
+// in sandbox_home. Catalog entries for sandboxes in other directories are
+// checked for existence and ports, but only sandbox_home is searched for
+// unregistered sandboxes.
+func CheckCatalog(sandbox_home string) ([]CatalogIssue, error) {
+	var issues []CatalogIssue
+	catalog, err := defaults.ReadCatalog()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range catalog {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !common.FileExists(name + "/sbdescription.json") {
+			issues = append(issues, CatalogIssue{
+				Kind:    CatalogOrphan,
+				Sandbox: name,
+				Details: "directory not found",
+			})
+			continue
+		}
+		sbd := common.ReadSandboxDescription(name)
+		if ports_text(sbd.Port) != ports_text(catalog[name].Port) {
+			issues = append(issues, CatalogIssue{
+				Kind:    CatalogPortMismatch,
+				Sandbox: name,
+				Details: fmt.Sprintf("catalog: %v - sbdescription.json: %v", catalog[name].Port, sbd.Port),
+			})
+		}
+	}
+	for _, sb := range common.GetInstalledSandboxes(sandbox_home) {
+		sandbox_dir := sandbox_home + "/" + sb.SandboxName
+		if _, ok := catalog[sandbox_dir]; ok {
+			continue
+		}
+		// Sandboxes created by old versions, without a description,
+		// can't be registered
+		if !common.FileExists(sandbox_dir + "/sbdescription.json") {
+			continue
+		}
+		issues = append(issues, CatalogIssue{
+			Kind:    CatalogUnregistered,
+			Sandbox: sandbox_dir,
+			Details: "not in the catalog",
+		})
+	}
+	return issues, nil
+}
+
+// RepairCatalog fixes the issues found by CheckCatalog.
+// Orphan entries are removed, unregistered sandboxes are added, and
+// the ports of the catalog are replaced by the ones in sbdescription.json
+func RepairCatalog(issues []CatalogIssue) error {
+	var err error
+	for _, issue := range issues {
+		switch issue.Kind {
+		case CatalogOrphan:
+			err = defaults.DeleteFromCatalog(issue.Sandbox)
+		case CatalogUnregistered:
+			err = defaults.UpdateCatalog(issue.Sandbox, catalog_item_from_description(issue.Sandbox))
+		case CatalogPortMismatch:
+			var catalog defaults.SandboxCatalog
+			catalog, err = defaults.ReadCatalog()
+			if err != nil {
+				break
+			}
+			item := catalog[issue.Sandbox]
+			item.Port = common.ReadSandboxDescription(issue.Sandbox).Port
+			err = defaults.UpdateCatalog(issue.Sandbox, item)
+		}
+		if err != nil {
+			return fmt.Errorf("Error repairing %s (%s): %s", issue.Sandbox, issue.Kind, err)
+		}
+	}
+	return nil
+}
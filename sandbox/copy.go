@@ -0,0 +1,150 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+)
+
+// Tells whether the server of a single sandbox is running,
+// by checking that the process in its pid file exists.
+// A pid file left by a server that crashed is not enough.
+func is_running(sandbox_dir string, port int) bool {
+	return process_exists(read_pid(fmt.Sprintf("%s/data/mysql_sandbox%d.pid", sandbox_dir, port)))
+}
+
+// CopySandbox creates a new single sandbox with the data of an existing one.
+// The source sandbox is stopped while its data directory is copied, and
+// restarted afterwards if it was running.
+// The new sandbox gets its own ports, server ID, and server UUID, and its
+// scripts are generated from the templates, as in a regular deployment.
+func CopySandbox(source_dir, dest_dir string, skip_start bool) error {
+	dd, err := ReadDeploymentDefinition(source_dir)
+	if err != nil {
+		return err
+	}
+	if dd.Topology != "single" {
+		return fmt.Errorf("Sandbox %s has topology '%s'. Only single sandboxes can be copied",
+			common.BaseName(source_dir), dd.Topology)
+	}
+	if common.DirExists(dest_dir) {
+		return fmt.Errorf("Directory %s already exists", dest_dir)
+	}
+	fname, logger := defaults.NewLogger(common.LogDirName(), "copy")
+
+	sdef := dd.Sdef
+	sdef.SandboxDir = common.DirName(dest_dir)
+	sdef.DirName = common.BaseName(dest_dir)
+	sdef.LogFileName = common.ReplaceLiteralHome(fname)
+	sdef.Logger = logger
+	sdef.InstalledPorts = common.GetInstalledPorts(sdef.SandboxDir)
+	sdef.Port = common.FindFreePort(sdef.Port, sdef.InstalledPorts, 1)
+	sdef.MysqlXPort = 0
+	sdef.MorePorts = nil
+	sdef.Force = false
+	sdef.LoadGrants = false
+	sdef.SkipStart = true
+	sdef.SkipInit = true
+	// Single sandboxes with replication enabled use the port as server ID
+	if sdef.ServerId > 0 {
+		sdef.ServerId = sdef.Port
+	}
+	if sdef.HistoryDir == source_dir {
+		sdef.HistoryDir = dest_dir
+	}
+	logger.Printf("Copying sandbox %s to %s (port %d)\n", source_dir, dest_dir, sdef.Port)
+	_, err = CreateSingleSandbox(sdef)
+	if err != nil {
+		return err
+	}
+
+	source_desc := common.ReadSandboxDescription(source_dir)
+	was_running := is_running(source_dir, source_desc.Port[0])
+	if was_running {
+		logger.Printf("Stopping %s\n", source_dir)
+		err = stop_server(source_dir)
+		if err != nil {
+			return fmt.Errorf("Error stopping %s: %s", source_dir, err)
+		}
+	}
+	logger.Printf("Copying data directory from %s\n", source_dir)
+	err = os.RemoveAll(dest_dir + "/data")
+	if err == nil {
+		err, _ = common.Run_cmd_with_args("cp", []string{"-pR", source_dir + "/data", dest_dir + "/data"})
+	}
+	if err != nil {
+		err = fmt.Errorf("Error copying data directory from %s to %s: %s", source_dir, dest_dir, err)
+	}
+	if was_running {
+		logger.Printf("Starting %s\n", source_dir)
+		start_err := start_server(context.Background(), source_dir, dd.Sdef.CustomMysqld)
+		if start_err != nil {
+			start_err = fmt.Errorf("Error restarting %s: %s", source_dir, start_err)
+			if err != nil {
+				start_err = fmt.Errorf("%s. %s", err, start_err)
+			}
+			return start_err
+		}
+	}
+	if err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s/data/mysql_sandbox%d.pid", dest_dir, source_desc.Port[0]))
+
+	// The copy must not have the same server UUID as the source.
+	// When the sandbox keeps the UUID generated by the server, the
+	// server will generate a new one.
+	uuid_sdef := sdef
+	uuid_sdef.SandboxDir = dest_dir
+	uuid_fname, new_uuid := FixServerUuid(uuid_sdef)
+	if uuid_fname != "" {
+		if sdef.KeepUuid {
+			os.Remove(uuid_fname)
+		} else {
+			logger.Printf("Writing custom UUID %s\n", new_uuid)
+			err = common.WriteString("[auto]\n"+new_uuid+"\n", uuid_fname)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// The definition of the copy must describe a regular deployment
+	new_dd, err := ReadDeploymentDefinition(dest_dir)
+	if err != nil {
+		return err
+	}
+	new_dd.Sdef.SkipInit = false
+	new_dd.Sdef.SkipStart = dd.Sdef.SkipStart
+	new_dd.Sdef.LoadGrants = dd.Sdef.LoadGrants
+	err = WriteDeploymentDefinition(dest_dir, new_dd)
+	if err != nil {
+		return err
+	}
+	if !skip_start {
+		logger.Printf("Starting %s\n", dest_dir)
+		err = start_server(context.Background(), dest_dir, sdef.CustomMysqld)
+		if err != nil {
+			return fmt.Errorf("Error starting %s: %s", dest_dir, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+)
+
+// File where the full definition of a sandbox is saved at creation time
+const DefinitionFileName = "sbdefinition.json"
+
+// Everything needed to deploy the same sandbox again.
+// Unlike sbdescription.json, which describes the result of a
+// deployment, this record keeps the request that produced it.
+type DeploymentDefinition struct {
+	Topology          string            `json:"topology"`
+	Origin            string            `json:"origin"`
+	Nodes             int               `json:"nodes"`
+	MasterIp          string            `json:"master-ip,omitempty"`
+	MasterList        string            `json:"master-list,omitempty"`
+	SlaveList         string            `json:"slave-list,omitempty"`
+	Sdef              SandboxDef        `json:"sandbox-definition"`
+	Templates         map[string]string `json:"templates,omitempty"`
+	DbDeployerVersion string            `json:"dbdeployer-version"`
+	Timestamp         string            `json:"timestamp"`
+}
+
+// A node of a multiple sandbox, with the name of its directory
+type NodeDescription struct {
+	Name        string
+	Description common.SandboxDescription
+}
+
+// Returns the templates that were replaced by the user,
+// either with --use-template or with files in the configuration directory
+func ChangedTemplates() map[string]string {
+	changed := make(map[string]string)
+	for _, group := range AllTemplates {
+		for name, template := range group {
+			if template.Origin == TEMPLATE_FILE {
+				changed[name] = template.Contents
+			}
+		}
+	}
+	return changed
+}
+
+// DeploymentTemplates returns the templates of a collection as a
+// deployment sees them: the ones named in sdef.Templates have the
+// contents given there. The shared collections are not changed, so
+// that deployments using different templates can run at the same time.
+func DeploymentTemplates(sdef SandboxDef, collection TemplateCollection) TemplateCollection {
+	if len(sdef.Templates) == 0 {
+		return collection
+	}
+	replaced := make(TemplateCollection)
+	for name, template := range collection {
+		if contents, ok := sdef.Templates[name]; ok {
+			template.Contents = contents
+			template.Origin = TEMPLATE_FILE
+		}
+		replaced[name] = template
+	}
+	return replaced
+}
+
+// Returns an error if a template replaced in the deployment
+// is not one of the built-in templates
+func CheckDeploymentTemplates(sdef SandboxDef) error {
+	for name := range sdef.Templates {
+		found := false
+		for _, group := range AllTemplates {
+			if _, ok := group[name]; ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Template %s not found", name)
+		}
+	}
+	return nil
+}
+
+// Removes from a sandbox definition the parts that depend on the
+// current state of the host, and embeds the contents of the
+// files it refers to, so that the definition can be used elsewhere.
+func portable_sdef(sdef SandboxDef) (SandboxDef, error) {
+	sdef.Logger = nil
+	sdef.LogFileName = ""
+	sdef.InstalledPorts = nil
+	sdef.MorePorts = nil
+	if sdef.MyCnfFile != "" {
+		options := GetOptionsFromFile(sdef.MyCnfFile)
+		sdef.MyCnfOptions = append(sdef.MyCnfOptions, options...)
+		sdef.MyCnfFile = ""
+	}
+	for _, sql_file := range []*string{&sdef.PreGrantsSqlFile, &sdef.PostGrantsSqlFile} {
+		if *sql_file == "" {
+			continue
+		}
+		contents, err := ioutil.ReadFile(*sql_file)
+		if err != nil {
+			return sdef, fmt.Errorf("Error reading %s: %s", *sql_file, err)
+		}
+		statement := strings.TrimRight(string(contents), "; \t\n")
+		if sql_file == &sdef.PreGrantsSqlFile {
+			sdef.PreGrantsSql = append([]string{statement}, sdef.PreGrantsSql...)
+		} else {
+			sdef.PostGrantsSql = append([]string{statement}, sdef.PostGrantsSql...)
+		}
+		*sql_file = ""
+	}
+	return sdef, nil
+}
+
+// Saves the deployment definition in the sandbox directory
+func WriteDeploymentDefinition(sandbox_dir string, dd DeploymentDefinition) error {
+	var err error
+	dd.Templates = ChangedTemplates()
+	for name, contents := range dd.Sdef.Templates {
+		dd.Templates[name] = contents
+	}
+	dd.Sdef, err = portable_sdef(dd.Sdef)
+	if err != nil {
+		return err
+	}
+	dd.DbDeployerVersion = common.VersionDef
+	dd.Timestamp = time.Now().Format(time.UnixDate)
+	if is_dry_run() {
+		plan_script(sandbox_dir + "/" + DefinitionFileName)
+		return nil
+	}
+	b, err := json.MarshalIndent(dd, " ", "\t")
+	if err != nil {
+		return fmt.Errorf("error encoding deployment definition: %s", err)
+	}
+	return common.WriteString(string(b), sandbox_dir+"/"+DefinitionFileName)
+}
+
+// Reads the deployment definition from the sandbox directory
+func ReadDeploymentDefinition(sandbox_dir string) (dd DeploymentDefinition, err error) {
+	filename := sandbox_dir + "/" + DefinitionFileName
+	if !common.FileExists(filename) {
+		return dd, fmt.Errorf("File %s not found. The sandbox was created by an older version of dbdeployer", filename)
+	}
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return dd, err
+	}
+	err = json.Unmarshal(contents, &dd)
+	if err != nil {
+		return dd, fmt.Errorf("error decoding deployment definition %s: %s", filename, err)
+	}
+	return dd, nil
+}
+
+// Returns the descriptions of the nodes of a multiple sandbox,
+// indexed by node number
+func sandbox_nodes(sandbox_dir string) map[int]NodeDescription {
+	nodes := make(map[int]NodeDescription)
+	for _, sb := range common.GetInstalledSandboxes(sandbox_dir) {
+		node_dir := sandbox_dir + "/" + sb.SandboxName
+		if !common.FileExists(node_dir + "/sbdescription.json") {
+			continue
+		}
+		sbd := common.ReadSandboxDescription(node_dir)
+		if sbd.NodeNum > 0 {
+			nodes[sbd.NodeNum] = NodeDescription{Name: sb.SandboxName, Description: sbd}
+		}
+	}
+	return nodes
+}
+
+// Returns the port of the first node of a multiple sandbox.
+// All multiple topologies assign base port + 1 to their first node.
+func first_node_port(sandbox_dir string) int {
+	node, ok := sandbox_nodes(sandbox_dir)[1]
+	if ok && len(node.Description.Port) > 0 {
+		return node.Description.Port[0]
+	}
+	return 0
+}
@@ -0,0 +1,232 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/concurrent"
+	"github.com/datacharmer/dbdeployer/defaults"
+)
+
+// PlannedSandbox describes a sandbox (or a node) that a deployment would create
+type PlannedSandbox struct {
+	Directory string `json:"directory"`
+	SBType    string `json:"type"`
+	Version   string `json:"version"`
+	Basedir   string `json:"basedir"`
+	Port      []int  `json:"port"`
+	NodeNum   int    `json:"node-num,omitempty"`
+	ServerId  int    `json:"server-id,omitempty"`
+}
+
+// DeploymentPlan collects what a deployment would do, when running in dry-run mode
+type DeploymentPlan struct {
+	Sandboxes   []PlannedSandbox `json:"sandboxes"`
+	Directories []string         `json:"directories"`
+	Scripts     []string         `json:"scripts"`  // scripts and other files written in the sandboxes
+	Commands    []string         `json:"commands"` // commands run after writing the scripts
+}
+
+// When not nil, the deployment functions record their actions here
+// instead of executing them
+var dry_run_plan *DeploymentPlan
+
+// Held from StartDryRun to StopDryRun, so that only one dry run
+// at a time can use the plan
+var dry_run_mutex sync.Mutex
+
+// Protects dry_run_plan, which can be read and updated by
+// goroutines other than the one that started the dry run
+var plan_mutex sync.Mutex
+
+// Value of defaults.LogSBOperations before the dry run started
+var dry_run_saved_logging bool
+
+// StartDryRun makes the deployment functions compute ports, directories,
+// scripts and commands without creating or running anything.
+// The actions are collected in the returned plan.
+// Operation logging is disabled until StopDryRun is called.
+// If another dry run is in progress, StartDryRun waits for it to finish.
+// Deployments that are not dry runs must not run at the same time.
+func StartDryRun() *DeploymentPlan {
+	dry_run_mutex.Lock()
+	plan := &DeploymentPlan{
+		Sandboxes:   []PlannedSandbox{},
+		Directories: []string{},
+		Scripts:     []string{},
+		Commands:    []string{},
+	}
+	plan_mutex.Lock()
+	dry_run_plan = plan
+	plan_mutex.Unlock()
+	dry_run_saved_logging = defaults.LogSBOperations
+	defaults.LogSBOperations = false
+	return plan
+}
+
+// StopDryRun restores the normal behavior of the deployment functions
+func StopDryRun() {
+	plan_mutex.Lock()
+	active := dry_run_plan != nil
+	dry_run_plan = nil
+	plan_mutex.Unlock()
+	if !active {
+		return
+	}
+	defaults.LogSBOperations = dry_run_saved_logging
+	dry_run_mutex.Unlock()
+}
+
+func is_dry_run() bool {
+	plan_mutex.Lock()
+	defer plan_mutex.Unlock()
+	return dry_run_plan != nil
+}
+
+// Calls the given function with the current plan, while holding the lock.
+// Does nothing if there is no dry run in progress
+func update_plan(update func(plan *DeploymentPlan)) {
+	plan_mutex.Lock()
+	defer plan_mutex.Unlock()
+	if dry_run_plan != nil {
+		update(dry_run_plan)
+	}
+}
+
+// Records a script or another file that the deployment would write
+func plan_script(file_name string) {
+	update_plan(func(plan *DeploymentPlan) {
+		plan.Scripts = append(plan.Scripts, file_name)
+	})
+}
+
+func plan_command(c string, args []string) {
+	command := strings.TrimSpace(c + " " + strings.Join(args, " "))
+	update_plan(func(plan *DeploymentPlan) {
+		plan.Commands = append(plan.Commands, command)
+	})
+}
+
+// The functions below replace the ones in common and defaults
+// for the operations that a dry run should only record.
+
+func make_dir(dir string) {
+	if is_dry_run() {
+		update_plan(func(plan *DeploymentPlan) {
+			plan.Directories = append(plan.Directories, dir)
+		})
+		return
+	}
+	common.Mkdir(dir)
+}
+
+// Creates the top directory of a deployment, which is
+// removed if the deployment fails
+func make_top_dir(cleanup *common.CleanupActions, dir string) {
+	make_dir(dir)
+	if !is_dry_run() {
+		cleanup.Add(common.RmdirAll, "RmdirAll", dir)
+	}
+}
+
+func run_cmd(c string) (error, string) {
+	if is_dry_run() {
+		plan_command(c, nil)
+		return nil, ""
+	}
+	return common.Run_cmd(c)
+}
+
+func run_cmd_ctrl(c string, silent bool) (error, string) {
+	if is_dry_run() {
+		plan_command(c, nil)
+		return nil, ""
+	}
+	return common.Run_cmd_ctrl(c, silent)
+}
+
+func run_cmd_with_args(c string, args []string) (error, string) {
+	if is_dry_run() {
+		plan_command(c, args)
+		return nil, ""
+	}
+	return common.Run_cmd_with_args(c, args)
+}
+
+func run_tasks_by_priority(exec_lists []concurrent.ExecutionList) {
+	if is_dry_run() {
+		sorted_list := make([]concurrent.ExecutionList, len(exec_lists))
+		copy(sorted_list, exec_lists)
+		sort.SliceStable(sorted_list, func(i, j int) bool {
+			return sorted_list[i].Priority < sorted_list[j].Priority
+		})
+		for _, item := range sorted_list {
+			plan_command(item.Command.Cmd, item.Command.Args)
+		}
+		return
+	}
+	concurrent.RunParallelTasksByPriority(exec_lists)
+}
+
+func write_sandbox_description(sandbox_dir string, sb_desc common.SandboxDescription, server_id int) {
+	if is_dry_run() {
+		update_plan(func(plan *DeploymentPlan) {
+			plan.Sandboxes = append(plan.Sandboxes, PlannedSandbox{
+				Directory: sandbox_dir,
+				SBType:    sb_desc.SBType,
+				Version:   sb_desc.Version,
+				Basedir:   sb_desc.Basedir,
+				Port:      sb_desc.Port,
+				NodeNum:   sb_desc.NodeNum,
+				ServerId:  server_id,
+			})
+		})
+		plan_script(sandbox_dir + "/sbdescription.json")
+		return
+	}
+	common.WriteSandboxDescription(sandbox_dir, sb_desc)
+}
+
+// Adds a sandbox to the catalog. The entry is removed if the deployment fails
+func update_catalog(cleanup *common.CleanupActions, sandbox_dir string, sb_item defaults.SandboxItem) error {
+	if is_dry_run() {
+		return nil
+	}
+	err := defaults.UpdateCatalog(sandbox_dir, sb_item)
+	if err == nil {
+		cleanup.Add(delete_from_catalog, "DeleteFromCatalog", sandbox_dir)
+	}
+	return err
+}
+
+func delete_from_catalog(sandbox_dir string) {
+	err := defaults.DeleteFromCatalog(sandbox_dir)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+	}
+}
+
+func wait_for_sandbox(sandbox_dir string, options ReadinessOptions) error {
+	if is_dry_run() {
+		return nil
+	}
+	return WaitForSandbox(sandbox_dir, options)
+}
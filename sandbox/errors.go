@@ -0,0 +1,69 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+
+	"github.com/datacharmer/dbdeployer/common"
+)
+
+// The sandbox creation functions return these errors instead of
+// terminating the program, so that callers using dbdeployer as a
+// library can decide what to do with a failure.
+// The command line layer turns them into an exit.
+
+// Returned when a port needed by the sandbox is already in use
+type PortConflictError struct {
+	Caller      string
+	SandboxType string
+	Port        int
+}
+
+func (e *PortConflictError) Error() string {
+	return fmt.Sprintf("Port conflict detected for %s (%s). Port %d is already used", e.SandboxType, e.Caller, e.Port)
+}
+
+// Returned when the directory containing the MySQL binaries is not found
+type MissingBasedirError struct {
+	Basedir string
+}
+
+func (e *MissingBasedirError) Error() string {
+	return fmt.Sprintf("Base directory %s does not exist", e.Basedir)
+}
+
+// Returned when a sandbox to be overwritten or removed is locked
+type LockedDirectoryError struct {
+	SandboxDir string
+}
+
+func (e *LockedDirectoryError) Error() string {
+	return fmt.Sprintf("Sandbox in %s is locked. Cannot be overwritten\nYou can unlock it with 'dbdeployer admin unlock %s'",
+		e.SandboxDir, common.BaseName(e.SandboxDir))
+}
+
+// Returned when a feature or topology is not available for
+// the requested MySQL version
+type UnsupportedVersionError struct {
+	Feature    string
+	Version    string
+	MinVersion string
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("%s requires MySQL %s or greater (found %s)", e.Feature, e.MinVersion, e.Version)
+}
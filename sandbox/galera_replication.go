@@ -0,0 +1,362 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/concurrent"
+	"github.com/datacharmer/dbdeployer/defaults"
+)
+
+// Flavors of Galera clusters
+const (
+	GaleraFlavor = "galera" // MariaDB Galera Cluster
+	PxcFlavor    = "pxc"    // Percona XtraDB Cluster
+)
+
+// Each Galera node uses three ports besides the database one:
+// group communication, incremental state transfer (IST),
+// and state snapshot transfer (SST)
+const galera_ports_per_node = 3
+
+// Returns true if the version can run a Galera cluster:
+// MariaDB 10.1+ or a MySQL 5.6+ build with wsrep.
+// GreaterOrEqualVersion can't be used here, as it excludes MariaDB 10
+func is_galera_version(version string) bool {
+	vlist := common.VersionToList(version)
+	major, minor := vlist[0], vlist[1]
+	if major == 10 {
+		return minor >= 1
+	}
+	return common.GreaterOrEqualVersion(version, []int{5, 6, 0})
+}
+
+// Returns the full path of the Galera library inside a basedir
+func find_galera_library(basedir string) (string, error) {
+	candidates := []string{
+		"lib/libgalera_smm.so",
+		"lib/galera/libgalera_smm.so",
+		"lib/galera-4/libgalera_smm.so",
+		"lib/galera-3/libgalera_smm.so",
+		"lib64/galera/libgalera_smm.so",
+		"lib64/galera-4/libgalera_smm.so",
+		"lib64/galera-3/libgalera_smm.so",
+	}
+	for _, candidate := range candidates {
+		library := basedir + "/" + candidate
+		if common.FileExists(library) {
+			return library, nil
+		}
+	}
+	return "", fmt.Errorf("Galera library (libgalera_smm.so) not found in %s", basedir)
+}
+
+// Returns the state snapshot transfer (SST) method for a cluster.
+// PXC 8.0 dropped rsync, and only supports xtrabackup-v2, which needs
+// the xtrabackup binaries that come with the PXC tarball (bin/pxc_extra)
+// or are installed in $PATH
+func galera_sst_method(flavor, version, basedir string) (string, error) {
+	if flavor != PxcFlavor || !common.GreaterOrEqualVersion(version, []int{8, 0, 0}) {
+		return "rsync", nil
+	}
+	if common.DirExists(basedir+"/bin/pxc_extra") || common.ExecExists(basedir+"/bin/xtrabackup") || common.ExecExists("xtrabackup") {
+		return "xtrabackup-v2", nil
+	}
+	return "", fmt.Errorf("PXC %s needs xtrabackup for state snapshot transfer (wsrep_sst_method=xtrabackup-v2), "+
+		"but it was found neither in %s/bin/pxc_extra nor in $PATH", version, basedir)
+}
+
+// Returns the data used by the scripts of a Galera cluster sandbox
+func galera_data(sdef SandboxDef, master_ip string, base_port, nodes int) common.Smap {
+	node_label := defaults.Defaults().NodePrefix
+	var data common.Smap = common.Smap{
+		"Copyright":    Copyright,
+		"AppVersion":   common.VersionDef,
+		"DateTime":     time.Now().Format(time.UnixDate),
+		"SandboxDir":   sdef.SandboxDir,
+		"MasterIp":     master_ip,
+		"NodeLabel":    node_label,
+		"RplUser":      sdef.RplUser,
+		"RplPassword":  sdef.RplPassword,
+		"Nodes":        []common.Smap{},
+		"ReverseNodes": []common.Smap{},
+	}
+	for i := 1; i <= nodes; i++ {
+		node_data := common.Smap{
+			"Node":       i,
+			"NodePort":   base_port + i,
+			"NodeLabel":  node_label,
+			"SandboxDir": sdef.SandboxDir,
+		}
+		data["Nodes"] = append(data["Nodes"].([]common.Smap), node_data)
+		data["ReverseNodes"] = append([]common.Smap{node_data}, data["ReverseNodes"].([]common.Smap)...)
+	}
+	return data
+}
+
+// Returns the data used by the Galera options of a node.
+// The node uses galera_port for group communication, and
+// the two following ports for IST and SST
+func galera_options_data(sdef SandboxDef, provider, sst_method, extra_options, master_ip string, cluster_address []string, node, galera_port int) common.Smap {
+	return common.Smap{
+		"Provider":       provider,
+		"ClusterName":    common.BaseName(sdef.SandboxDir),
+		"ClusterAddress": strings.Join(cluster_address, ","),
+		"NodeLabel":      defaults.Defaults().NodePrefix,
+		"Node":           node,
+		"MasterIp":       master_ip,
+		"GaleraPort":     galera_port,
+		"IstPort":        galera_port + 1,
+		"SstPort":        galera_port + 2,
+		"SstMethod":      sst_method,
+		"ExtraOptions":   extra_options,
+	}
+}
+
+func CreateGaleraReplication(sdef SandboxDef, origin string, nodes int, master_ip string, flavor string) (err error) {
+	finish_cleanup := start_cleanup(&sdef)
+	defer func() {
+		finish_cleanup(err)
+	}()
+	var exec_lists []concurrent.ExecutionList
+
+	fname, logger := defaults.NewLogger(common.LogDirName(), flavor)
+	sdef.LogFileName = common.ReplaceLiteralHome(fname)
+	vList := common.VersionToList(sdef.Version)
+	rev := vList[2]
+	base_port := sdef.Port + defaults.Defaults().GaleraBasePort + (rev * 100)
+	if flavor == PxcFlavor {
+		base_port = sdef.Port + defaults.Defaults().PxcBasePort + (rev * 100)
+	}
+	if sdef.BasePort > 0 {
+		base_port = sdef.BasePort
+	}
+
+	if nodes < 3 {
+		return fmt.Errorf("Can't run a %s cluster with less than 3 nodes", flavor)
+	}
+	provider, err := find_galera_library(sdef.Basedir)
+	if err != nil {
+		return err
+	}
+	sst_method, err := galera_sst_method(flavor, sdef.Version, sdef.Basedir)
+	if err != nil {
+		return err
+	}
+	if common.DirExists(sdef.SandboxDir) {
+		sdef, err = CheckDirectory(sdef)
+		if err != nil {
+			return err
+		}
+	}
+	// FindFreePort returns the first free port, but base_port will be used
+	// with a counter. Thus the availability will be checked using
+	// "base_port + 1"
+	first_port := common.FindFreePort(base_port+1, sdef.InstalledPorts, nodes)
+	base_port = first_port - 1
+	base_galera_port := base_port + defaults.Defaults().GroupPortDelta
+	first_galera_port := common.FindFreePort(base_galera_port+1, sdef.InstalledPorts, nodes*galera_ports_per_node)
+	base_galera_port = first_galera_port - 1
+	for check_port := base_port + 1; check_port < base_port+nodes+1; check_port++ {
+		err = CheckPort("CreateGaleraReplication", sdef.SandboxDir, sdef.InstalledPorts, check_port)
+		if err != nil {
+			return err
+		}
+	}
+	for check_port := base_galera_port + 1; check_port < base_galera_port+nodes*galera_ports_per_node+1; check_port++ {
+		err = CheckPort("CreateGaleraReplication-galera", sdef.SandboxDir, sdef.InstalledPorts, check_port)
+		if err != nil {
+			return err
+		}
+	}
+	base_mysqlx_port, err := get_base_mysqlx_port(base_port, sdef, nodes)
+	if err != nil {
+		return err
+	}
+	all_nodes := node_range(1, nodes)
+	sdef.PerNodeOptions = node_options_by_role(sdef, nodes, all_nodes, all_nodes)
+
+	// When requested, the nodes are started by the cluster start script,
+	// which bootstraps the cluster from the first node
+	skip_start := sdef.SkipStart
+	load_grants := sdef.LoadGrants
+	sdef.SkipStart = true
+	sdef.LoadGrants = false
+
+	make_top_dir(sdef.Cleanup, sdef.SandboxDir)
+	logger.Printf("Creating directory %s\n", sdef.SandboxDir)
+	node_label := defaults.Defaults().NodePrefix
+	data := galera_data(sdef, master_ip, base_port, nodes)
+	var cluster_address []string
+	for i := 1; i <= nodes; i++ {
+		galera_port := base_galera_port + (i-1)*galera_ports_per_node + 1
+		cluster_address = append(cluster_address, fmt.Sprintf("%s:%d", master_ip, galera_port))
+	}
+	logger.Printf("Creating cluster address %s\n", strings.Join(cluster_address, ","))
+
+	sb_type := flavor
+	extra_options := ""
+	switch flavor {
+	case GaleraFlavor:
+		extra_options = "wsrep_on=ON"
+	case PxcFlavor:
+		extra_options = "pxc_strict_mode=ENFORCING"
+		// Cluster traffic encryption requires SSL certificates in every node
+		if common.GreaterOrEqualVersion(sdef.Version, []int{8, 0, 0}) {
+			extra_options += "\npxc-encrypt-cluster-traffic=OFF"
+		}
+	}
+	logger.Printf("Defining cluster type %s\n", sb_type)
+
+	sb_desc := common.SandboxDescription{
+		Basedir: sdef.Basedir,
+		SBType:  sb_type,
+		Version: sdef.Version,
+		Port:    []int{},
+		Nodes:   nodes,
+		NodeNum: 0,
+		LogFile: sdef.LogFileName,
+	}
+
+	sb_item := defaults.SandboxItem{
+		Origin:      sb_desc.Basedir,
+		SBType:      sb_desc.SBType,
+		Version:     sdef.Version,
+		Port:        []int{},
+		Nodes:       []string{},
+		Destination: sdef.SandboxDir,
+	}
+
+	if sdef.LogFileName != "" {
+		sb_item.LogDirectory = common.DirName(sdef.LogFileName)
+	}
+
+	for i := 1; i <= nodes; i++ {
+		galera_port := base_galera_port + (i-1)*galera_ports_per_node + 1
+		ist_port := galera_port + 1
+		sst_port := galera_port + 2
+		sdef.DirName = fmt.Sprintf("%s%d", node_label, i)
+		sdef.Port = base_port + i
+		sdef.MorePorts = []int{galera_port, ist_port, sst_port}
+		sdef.ServerId = i * 100
+		sb_item.Nodes = append(sb_item.Nodes, sdef.DirName)
+		sb_item.Port = append(sb_item.Port, sdef.Port)
+		sb_desc.Port = append(sb_desc.Port, sdef.Port)
+		sb_item.Port = append(sb_item.Port, sdef.MorePorts...)
+		sb_desc.Port = append(sb_desc.Port, sdef.MorePorts...)
+
+		if !sdef.RunConcurrently {
+			installation_message := "Installing %s %d\n"
+			fmt.Printf(installation_message, node_label, i)
+			logger.Printf(installation_message, node_label, i)
+		}
+		galera_options, err := common.Tprintf(DeploymentTemplates(sdef, GaleraTemplates)["galera_replication_options"].Contents,
+			galera_options_data(sdef, provider, sst_method, extra_options, master_ip, cluster_address, i, galera_port))
+		if err != nil {
+			return err
+		}
+		sdef.ReplOptions = DeploymentTemplates(sdef, SingleTemplates)["replication_options"].Contents + fmt.Sprintf("\n%s\n", galera_options)
+		if has_feature(sdef.Version, "mysqlx-default") {
+			sdef.MysqlXPort = base_mysqlx_port + i
+			if !sdef.DisableMysqlX {
+				sb_desc.Port = append(sb_desc.Port, base_mysqlx_port+i)
+				sb_item.Port = append(sb_item.Port, base_mysqlx_port+i)
+				logger.Printf("adding port %d to node %d\n", base_mysqlx_port+i, i)
+			}
+		}
+		sdef.Multi = true
+		sdef.Prompt = fmt.Sprintf("%s%d", node_label, i)
+		sdef.SBType = flavor + "-node"
+		sdef.NodeNum = i
+		logger.Printf("Create single sandbox for node %d\n", i)
+		exec_list, err := CreateSingleSandbox(sdef)
+		if err != nil {
+			return err
+		}
+		for _, list := range exec_list {
+			exec_lists = append(exec_lists, list)
+		}
+		data_node := node_script_data(sdef.SandboxDir, i, sdef.Port)
+		logger.Printf("Create node script for node %d\n", i)
+		err = write_script(logger, DeploymentTemplates(sdef, MultipleTemplates), fmt.Sprintf("n%d", i), "node_template", sdef.SandboxDir, data_node, true)
+		if err != nil {
+			return err
+		}
+	}
+	logger.Printf("Writing sandbox description in %s\n", sdef.SandboxDir)
+	write_sandbox_description(sdef.SandboxDir, sb_desc, 0)
+	err = update_catalog(sdef.Cleanup, sdef.SandboxDir, sb_item)
+	if err != nil {
+		return err
+	}
+
+	logger.Printf("Writing %s cluster scripts\n", flavor)
+	sb_galera := ScriptBatch{
+		tc:         DeploymentTemplates(sdef, GaleraTemplates),
+		logger:     logger,
+		data:       data,
+		sandboxDir: sdef.SandboxDir,
+		scripts: []ScriptDef{
+			{"start_all", "galera_start_template", true},
+			{"stop_all", "galera_stop_template", true},
+			{"check_nodes", "galera_check_nodes_template", true},
+		},
+	}
+	sb_multiple := ScriptBatch{
+		tc:         DeploymentTemplates(sdef, MultipleTemplates),
+		logger:     logger,
+		data:       data,
+		sandboxDir: sdef.SandboxDir,
+		scripts: []ScriptDef{
+			{"restart_all", "restart_multi_template", true},
+			{"status_all", "status_multi_template", true},
+			{"test_sb_all", "test_sb_multi_template", true},
+			{"clear_all", "clear_multi_template", true},
+			{"send_kill_all", "send_kill_multi_template", true},
+			{"use_all", "use_multi_template", true},
+		},
+	}
+	for _, sb := range []ScriptBatch{sb_galera, sb_multiple} {
+		err = write_scripts(sb)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Printf("Running parallel tasks\n")
+	run_tasks_by_priority(exec_lists)
+	if !skip_start {
+		fmt.Println(common.ReplaceLiteralHome(sdef.SandboxDir) + "/start_all")
+		logger.Printf("Starting the cluster\n")
+		run_cmd(sdef.SandboxDir + "/start_all")
+		if load_grants {
+			// The grants loaded in the first node are replicated to the others
+			first_node := fmt.Sprintf("%s/%s1", sdef.SandboxDir, node_label)
+			logger.Printf("Loading grants in the first node\n")
+			run_cmd_with_args(first_node+"/load_grants", []string{"pre_grants.sql"})
+			run_cmd(first_node + "/load_grants")
+			run_cmd_with_args(first_node+"/load_grants", []string{"post_grants.sql"})
+		}
+		run_cmd(sdef.SandboxDir + "/check_nodes")
+	}
+	fmt.Printf("Replication directory installed in %s\n", common.ReplaceLiteralHome(sdef.SandboxDir))
+	fmt.Printf("run 'dbdeployer usage multiple' for basic instructions'\n")
+	return nil
+}
@@ -0,0 +1,97 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+// Templates for Galera and Percona XtraDB Cluster
+
+var (
+	galera_start_template string = `#!/bin/sh
+{{.Copyright}}
+# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
+SBDIR={{.SandboxDir}}
+echo "# executing 'start' on $SBDIR"
+# The first node creates the cluster. The others join it.
+{{ range .Nodes }}
+if [ "{{.Node}}" = "1" ]
+then
+    echo 'executing "start --wsrep-new-cluster" on {{.NodeLabel}} {{.Node}}'
+    $SBDIR/{{.NodeLabel}}{{.Node}}/start --wsrep-new-cluster "$@"
+else
+    echo 'executing "start" on {{.NodeLabel}} {{.Node}}'
+    $SBDIR/{{.NodeLabel}}{{.Node}}/start "$@"
+fi
+{{end}}
+`
+	galera_stop_template string = `#!/bin/sh
+{{.Copyright}}
+# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
+SBDIR={{.SandboxDir}}
+echo "# executing 'stop' on $SBDIR"
+# Nodes are stopped in reverse order, so that the first node,
+# which is the last one to leave the cluster, can bootstrap it again.
+{{ range .ReverseNodes }}
+echo 'executing "stop" on {{.NodeLabel}} {{.Node}}'
+$SBDIR/{{.NodeLabel}}{{.Node}}/stop "$@"
+{{end}}
+`
+	galera_check_nodes_template string = `#!/bin/sh
+{{.Copyright}}
+# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
+multi_sb={{.SandboxDir}}
+
+CHECK_NODE="select variable_name, variable_value from information_schema.global_status where variable_name in ('wsrep_cluster_size', 'wsrep_cluster_status', 'wsrep_local_state_comment', 'wsrep_ready', 'wsrep_connected')"
+{{ range .Nodes}}
+	echo "# Node {{.Node}}"
+	$multi_sb/{{.NodeLabel}}{{.Node}}/use -t -e "$CHECK_NODE"
+{{end}}
+`
+	galera_replication_options string = `
+binlog_format=ROW
+default_storage_engine=InnoDB
+innodb_autoinc_lock_mode=2
+wsrep_provider={{.Provider}}
+wsrep_cluster_name={{.ClusterName}}
+wsrep_cluster_address=gcomm://{{.ClusterAddress}}
+wsrep_node_name={{.NodeLabel}}{{.Node}}
+wsrep_node_address={{.MasterIp}}
+wsrep_provider_options="gmcast.listen_addr=tcp://{{.MasterIp}}:{{.GaleraPort}};ist.recv_addr={{.MasterIp}}:{{.IstPort}}"
+wsrep_sst_receive_address={{.MasterIp}}:{{.SstPort}}
+wsrep_sst_method={{.SstMethod}}
+{{.ExtraOptions}}
+`
+	GaleraTemplates = TemplateCollection{
+		"galera_start_template": TemplateDesc{
+			Description: "Starts all nodes, bootstrapping the cluster from the first one",
+			Notes:       "",
+			Contents:    galera_start_template,
+		},
+		"galera_stop_template": TemplateDesc{
+			Description: "Stops all nodes, leaving the first one as the last",
+			Notes:       "",
+			Contents:    galera_stop_template,
+		},
+		"galera_check_nodes_template": TemplateDesc{
+			Description: "Checks the status of the cluster nodes",
+			Notes:       "",
+			Contents:    galera_check_nodes_template,
+		},
+		"galera_replication_options": TemplateDesc{
+			Description: "Replication options for a Galera node",
+			Notes:       "",
+			Contents:    galera_replication_options,
+		},
+	}
+)
@@ -17,7 +17,6 @@ package sandbox
 
 import (
 	"fmt"
-	"os"
 	"regexp"
 	"time"
 
@@ -46,9 +45,9 @@ loose-group-replication-single-primary-mode=off
 `
 )
 
-func get_base_mysqlx_port(base_port int, sdef SandboxDef, nodes int) int {
+func get_base_mysqlx_port(base_port int, sdef SandboxDef, nodes int) (int, error) {
 	base_mysqlx_port := base_port + defaults.Defaults().MysqlXPortDelta
-	if common.GreaterOrEqualVersion(sdef.Version, []int{8, 0, 11}) {
+	if has_feature(sdef.Version, "mysqlx-default") {
 		// FindFreePort returns the first free port, but base_port will be used
 		// with a counter. Thus the availability will be checked using
 		// "base_port + 1"
@@ -56,13 +55,73 @@ func get_base_mysqlx_port(base_port int, sdef SandboxDef, nodes int) int {
 		base_mysqlx_port = first_group_port - 1
 		for N := 1; N <= nodes; N++ {
 			check_port := base_mysqlx_port + N
-			CheckPort("get_base_mysqlx_port", sdef.SandboxDir, sdef.InstalledPorts, check_port)
+			err := CheckPort("get_base_mysqlx_port", sdef.SandboxDir, sdef.InstalledPorts, check_port)
+			if err != nil {
+				return base_mysqlx_port, err
+			}
 		}
 	}
-	return base_mysqlx_port
+	return base_mysqlx_port, nil
 }
 
-func CreateGroupReplication(sdef SandboxDef, origin string, nodes int, master_ip string) {
+// Returns the data used by the scripts of a group replication sandbox
+func group_data(sdef SandboxDef, master_ip, master_list, slave_list string, base_port, nodes int) common.Smap {
+	timestamp := time.Now()
+	slave_label := defaults.Defaults().SlavePrefix
+	slave_abbr := defaults.Defaults().SlaveAbbr
+	master_abbr := defaults.Defaults().MasterAbbr
+	master_label := defaults.Defaults().MasterName
+	node_label := defaults.Defaults().NodePrefix
+	change_master_extra := ""
+	//if common.GreaterOrEqualVersion(sdef.Version, []int{8,0,4}) {
+	//	if !sdef.NativeAuthPlugin {
+	//		change_master_extra = ", GET_MASTER_PUBLIC_KEY=1"
+	//	}
+	//}
+	var data common.Smap = common.Smap{
+		"Copyright":         Copyright,
+		"AppVersion":        common.VersionDef,
+		"DateTime":          timestamp.Format(time.UnixDate),
+		"SandboxDir":        sdef.SandboxDir,
+		"MasterIp":          master_ip,
+		"MasterList":        master_list,
+		"NodeLabel":         node_label,
+		"SlaveList":         slave_list,
+		"RplUser":           sdef.RplUser,
+		"RplPassword":       sdef.RplPassword,
+		"SlaveLabel":        slave_label,
+		"SlaveAbbr":         slave_abbr,
+		"ChangeMasterExtra": change_master_extra,
+		"MasterLabel":       master_label,
+		"MasterAbbr":        master_abbr,
+		"Nodes":             []common.Smap{},
+	}
+	for i := 1; i <= nodes; i++ {
+		data["Nodes"] = append(data["Nodes"].([]common.Smap), common.Smap{
+			"Copyright":         Copyright,
+			"AppVersion":        common.VersionDef,
+			"DateTime":          timestamp.Format(time.UnixDate),
+			"Node":              i,
+			"NodePort":          base_port + i,
+			"MasterIp":          master_ip,
+			"NodeLabel":         node_label,
+			"SlaveLabel":        slave_label,
+			"SlaveAbbr":         slave_abbr,
+			"ChangeMasterExtra": change_master_extra,
+			"MasterLabel":       master_label,
+			"MasterAbbr":        master_abbr,
+			"SandboxDir":        sdef.SandboxDir,
+			"RplUser":           sdef.RplUser,
+			"RplPassword":       sdef.RplPassword})
+	}
+	return data
+}
+
+func CreateGroupReplication(sdef SandboxDef, origin string, nodes int, master_ip string) (err error) {
+	finish_cleanup := start_cleanup(&sdef)
+	defer func() {
+		finish_cleanup(err)
+	}()
 	var exec_lists []concurrent.ExecutionList
 
 	fname, logger := defaults.NewLogger(common.LogDirName(), "group-replication")
@@ -79,11 +138,13 @@ func CreateGroupReplication(sdef SandboxDef, origin string, nodes int, master_ip
 
 	base_server_id := 0
 	if nodes < 3 {
-		fmt.Println("Can't run group replication with less than 3 nodes")
-		os.Exit(1)
+		return fmt.Errorf("Can't run group replication with less than 3 nodes")
 	}
 	if common.DirExists(sdef.SandboxDir) {
-		sdef = CheckDirectory(sdef)
+		sdef, err = CheckDirectory(sdef)
+		if err != nil {
+			return err
+		}
 	}
 	// FindFreePort returns the first free port, but base_port will be used
 	// with a counter. Thus the availability will be checked using
@@ -94,20 +155,23 @@ func CreateGroupReplication(sdef SandboxDef, origin string, nodes int, master_ip
 	first_group_port = common.FindFreePort(base_group_port+1, sdef.InstalledPorts, nodes)
 	base_group_port = first_group_port - 1
 	for check_port := base_port + 1; check_port < base_port+nodes+1; check_port++ {
-		CheckPort("CreateGroupReplication", sdef.SandboxDir, sdef.InstalledPorts, check_port)
+		err = CheckPort("CreateGroupReplication", sdef.SandboxDir, sdef.InstalledPorts, check_port)
+		if err != nil {
+			return err
+		}
 	}
 	for check_port := base_group_port + 1; check_port < base_group_port+nodes+1; check_port++ {
-		CheckPort("CreateGroupReplication-group", sdef.SandboxDir, sdef.InstalledPorts, check_port)
+		err = CheckPort("CreateGroupReplication-group", sdef.SandboxDir, sdef.InstalledPorts, check_port)
+		if err != nil {
+			return err
+		}
+	}
+	base_mysqlx_port, err := get_base_mysqlx_port(base_port, sdef, nodes)
+	if err != nil {
+		return err
 	}
-	base_mysqlx_port := get_base_mysqlx_port(base_port, sdef, nodes)
-	common.Mkdir(sdef.SandboxDir)
-	common.AddToCleanupStack(common.Rmdir, "Rmdir", sdef.SandboxDir)
+	make_top_dir(sdef.Cleanup, sdef.SandboxDir)
 	logger.Printf("Creating directory %s\n", sdef.SandboxDir)
-	timestamp := time.Now()
-	slave_label := defaults.Defaults().SlavePrefix
-	slave_abbr := defaults.Defaults().SlaveAbbr
-	master_abbr := defaults.Defaults().MasterAbbr
-	master_label := defaults.Defaults().MasterName
 	master_list := make_nodes_list(nodes)
 	slave_list := master_list
 	if sdef.SinglePrimary {
@@ -119,35 +183,29 @@ func CreateGroupReplication(sdef SandboxDef, origin string, nodes int, master_ip
 			}
 			slave_list += fmt.Sprintf("%d", N)
 		}
-		mlist := nodes_list_to_int_slice(master_list, nodes)
-		slist := nodes_list_to_int_slice(slave_list, nodes)
-		check_node_lists(nodes, mlist, slist)
+		mlist, err := nodes_list_to_int_slice(master_list, nodes)
+		if err != nil {
+			return err
+		}
+		slist, err := nodes_list_to_int_slice(slave_list, nodes)
+		if err != nil {
+			return err
+		}
+		err = check_node_lists(nodes, mlist, slist)
+		if err != nil {
+			return err
+		}
 	}
-	change_master_extra := ""
-	node_label := defaults.Defaults().NodePrefix
-	//if common.GreaterOrEqualVersion(sdef.Version, []int{8,0,4}) {
-	//	if !sdef.NativeAuthPlugin {
-	//		change_master_extra = ", GET_MASTER_PUBLIC_KEY=1"
-	//	}
-	//}
-	var data common.Smap = common.Smap{
-		"Copyright":         Copyright,
-		"AppVersion":        common.VersionDef,
-		"DateTime":          timestamp.Format(time.UnixDate),
-		"SandboxDir":        sdef.SandboxDir,
-		"MasterIp":          master_ip,
-		"MasterList":        master_list,
-		"NodeLabel":         node_label,
-		"SlaveList":         slave_list,
-		"RplUser":           sdef.RplUser,
-		"RplPassword":       sdef.RplPassword,
-		"SlaveLabel":        slave_label,
-		"SlaveAbbr":         slave_abbr,
-		"ChangeMasterExtra": change_master_extra,
-		"MasterLabel":       master_label,
-		"MasterAbbr":        master_abbr,
-		"Nodes":             []common.Smap{},
+	// In multi-primary mode, every node is both a master and a slave
+	masters := node_range(1, nodes)
+	slaves := masters
+	if sdef.SinglePrimary {
+		slaves = node_range(2, nodes)
+		masters = []int{1}
 	}
+	sdef.PerNodeOptions = node_options_by_role(sdef, nodes, masters, slaves)
+	node_label := defaults.Defaults().NodePrefix
+	data := group_data(sdef, master_ip, master_list, slave_list, base_port, nodes)
 	connection_string := ""
 	for i := 0; i < nodes; i++ {
 		group_port := base_group_port + i + 1
@@ -191,22 +249,6 @@ func CreateGroupReplication(sdef SandboxDef, origin string, nodes int, master_ip
 
 	for i := 1; i <= nodes; i++ {
 		group_port := base_group_port + i
-		data["Nodes"] = append(data["Nodes"].([]common.Smap), common.Smap{
-			"Copyright":         Copyright,
-			"AppVersion":        common.VersionDef,
-			"DateTime":          timestamp.Format(time.UnixDate),
-			"Node":              i,
-			"MasterIp":          master_ip,
-			"NodeLabel":         node_label,
-			"SlaveLabel":        slave_label,
-			"SlaveAbbr":         slave_abbr,
-			"ChangeMasterExtra": change_master_extra,
-			"MasterLabel":       master_label,
-			"MasterAbbr":        master_abbr,
-			"SandboxDir":        sdef.SandboxDir,
-			"RplUser":           sdef.RplUser,
-			"RplPassword":       sdef.RplPassword})
-
 		sdef.DirName = fmt.Sprintf("%s%d", node_label, i)
 		sdef.Port = base_port + i
 		sdef.MorePorts = []int{group_port}
@@ -225,14 +267,14 @@ func CreateGroupReplication(sdef SandboxDef, origin string, nodes int, master_ip
 			fmt.Printf(installation_message, node_label, i)
 			logger.Printf(installation_message, node_label, i)
 		}
-		sdef.ReplOptions = SingleTemplates["replication_options"].Contents + fmt.Sprintf("\n%s\n%s\n", GroupReplOptions, single_multi_primary)
+		sdef.ReplOptions = DeploymentTemplates(sdef, SingleTemplates)["replication_options"].Contents + fmt.Sprintf("\n%s\n%s\n", GroupReplOptions, single_multi_primary)
 		re_master_ip := regexp.MustCompile(`127\.0\.0\.1`)
 		sdef.ReplOptions = re_master_ip.ReplaceAllString(sdef.ReplOptions, master_ip)
-		sdef.ReplOptions += fmt.Sprintf("\n%s\n", SingleTemplates["gtid_options_57"].Contents)
-		sdef.ReplOptions += fmt.Sprintf("\n%s\n", SingleTemplates["repl_crash_safe_options"].Contents)
+		sdef.ReplOptions += fmt.Sprintf("\n%s\n", DeploymentTemplates(sdef, SingleTemplates)["gtid_options_57"].Contents)
+		sdef.ReplOptions += fmt.Sprintf("\n%s\n", DeploymentTemplates(sdef, SingleTemplates)["repl_crash_safe_options"].Contents)
 		sdef.ReplOptions += fmt.Sprintf("\nloose-group-replication-local-address=%s:%d\n", master_ip, group_port)
 		sdef.ReplOptions += fmt.Sprintf("\nloose-group-replication-group-seeds=%s\n", connection_string)
-		if common.GreaterOrEqualVersion(sdef.Version, []int{8, 0, 11}) {
+		if has_feature(sdef.Version, "mysqlx-default") {
 			sdef.MysqlXPort = base_mysqlx_port + i
 			if !sdef.DisableMysqlX {
 				sb_desc.Port = append(sb_desc.Port, base_mysqlx_port+i)
@@ -247,53 +289,86 @@ func CreateGroupReplication(sdef SandboxDef, origin string, nodes int, master_ip
 		sdef.NodeNum = i
 		// fmt.Printf("%#v\n",sdef)
 		logger.Printf("Create single sandbox for node %d\n", i)
-		exec_list := CreateSingleSandbox(sdef)
+		exec_list, err := CreateSingleSandbox(sdef)
+		if err != nil {
+			return err
+		}
 		for _, list := range exec_list {
 			exec_lists = append(exec_lists, list)
 		}
-		var data_node common.Smap = common.Smap{
-			"Copyright":         Copyright,
-			"AppVersion":        common.VersionDef,
-			"DateTime":          timestamp.Format(time.UnixDate),
-			"Node":              i,
-			"NodeLabel":         node_label,
-			"MasterLabel":       master_label,
-			"MasterAbbr":        master_abbr,
-			"ChangeMasterExtra": change_master_extra,
-			"SlaveLabel":        slave_label,
-			"SlaveAbbr":         slave_abbr,
-			"SandboxDir":        sdef.SandboxDir,
-		}
+		data_node := node_script_data(sdef.SandboxDir, i, sdef.Port)
 		logger.Printf("Create node script for node %d\n", i)
-		write_script(logger, MultipleTemplates, fmt.Sprintf("n%d", i), "node_template", sdef.SandboxDir, data_node, true)
+		err = write_script(logger, DeploymentTemplates(sdef, MultipleTemplates), fmt.Sprintf("n%d", i), "node_template", sdef.SandboxDir, data_node, true)
+		if err != nil {
+			return err
+		}
 	}
 	logger.Printf("Writing sandbox description in %s\n", sdef.SandboxDir)
-	common.WriteSandboxDescription(sdef.SandboxDir, sb_desc)
-	defaults.UpdateCatalog(sdef.SandboxDir, sb_item)
+	write_sandbox_description(sdef.SandboxDir, sb_desc, 0)
+	err = update_catalog(sdef.Cleanup, sdef.SandboxDir, sb_item)
+	if err != nil {
+		return err
+	}
 
 	logger.Printf("Writing group replication scripts\n")
-	write_script(logger, MultipleTemplates, "start_all", "start_multi_template", sdef.SandboxDir, data, true)
-	write_script(logger, MultipleTemplates, "restart_all", "restart_multi_template", sdef.SandboxDir, data, true)
-	write_script(logger, MultipleTemplates, "status_all", "status_multi_template", sdef.SandboxDir, data, true)
-	write_script(logger, MultipleTemplates, "test_sb_all", "test_sb_multi_template", sdef.SandboxDir, data, true)
-	write_script(logger, MultipleTemplates, "stop_all", "stop_multi_template", sdef.SandboxDir, data, true)
-	write_script(logger, MultipleTemplates, "clear_all", "clear_multi_template", sdef.SandboxDir, data, true)
-	write_script(logger, MultipleTemplates, "send_kill_all", "send_kill_multi_template", sdef.SandboxDir, data, true)
-	write_script(logger, MultipleTemplates, "use_all", "use_multi_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, "use_all_slaves", "multi_source_use_slaves_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, "use_all_masters", "multi_source_use_masters_template", sdef.SandboxDir, data, true)
-	write_script(logger, GroupTemplates, "initialize_nodes", "init_nodes_template", sdef.SandboxDir, data, true)
-	write_script(logger, GroupTemplates, "check_nodes", "check_nodes_template", sdef.SandboxDir, data, true)
-	//write_script(logger, ReplicationTemplates, "test_replication", "test_replication_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, "test_replication", "multi_source_test_template", sdef.SandboxDir, data, true)
+	sb_multiple := ScriptBatch{
+		tc:         DeploymentTemplates(sdef, MultipleTemplates),
+		logger:     logger,
+		data:       data,
+		sandboxDir: sdef.SandboxDir,
+		scripts: []ScriptDef{
+			{"start_all", "start_multi_template", true},
+			{"restart_all", "restart_multi_template", true},
+			{"status_all", "status_multi_template", true},
+			{"test_sb_all", "test_sb_multi_template", true},
+			{"stop_all", "stop_multi_template", true},
+			{"clear_all", "clear_multi_template", true},
+			{"send_kill_all", "send_kill_multi_template", true},
+			{"use_all", "use_multi_template", true},
+		},
+	}
+	sb_repl := ScriptBatch{
+		tc:         DeploymentTemplates(sdef, ReplicationTemplates),
+		logger:     logger,
+		data:       data,
+		sandboxDir: sdef.SandboxDir,
+		scripts: []ScriptDef{
+			{"use_all_slaves", "multi_source_use_slaves_template", true},
+			{"use_all_masters", "multi_source_use_masters_template", true},
+			//{"test_replication", "test_replication_template", true},
+			{"test_replication", "multi_source_test_template", true},
+		},
+	}
+	sb_group := ScriptBatch{
+		tc:         DeploymentTemplates(sdef, GroupTemplates),
+		logger:     logger,
+		data:       data,
+		sandboxDir: sdef.SandboxDir,
+		scripts: []ScriptDef{
+			{"initialize_nodes", "init_nodes_template", true},
+			{"check_nodes", "check_nodes_template", true},
+		},
+	}
+	for _, sb := range []ScriptBatch{sb_multiple, sb_repl, sb_group} {
+		err = write_scripts(sb)
+		if err != nil {
+			return err
+		}
+	}
 
 	logger.Printf("Running parallel tasks\n")
-	concurrent.RunParallelTasksByPriority(exec_lists)
+	run_tasks_by_priority(exec_lists)
 	if !sdef.SkipStart {
 		fmt.Println(common.ReplaceLiteralHome(sdef.SandboxDir) + "/initialize_nodes")
 		logger.Printf("Running group replication initialization script\n")
-		common.Run_cmd(sdef.SandboxDir + "/initialize_nodes")
+		run_cmd(sdef.SandboxDir + "/initialize_nodes")
+		logger.Printf("Waiting for group members to be ONLINE\n")
+		err := wait_for_sandbox(sdef.SandboxDir, ReadinessOptions{Timeout: DefaultStartTimeout})
+		if err != nil {
+			return err
+		}
 	}
 	fmt.Printf("Replication directory installed in %s\n", common.ReplaceLiteralHome(sdef.SandboxDir))
 	fmt.Printf("run 'dbdeployer usage multiple' for basic instructions'\n")
+	return nil
 }
@@ -0,0 +1,153 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"sort"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+)
+
+// A node of a sandbox, as shown in sandbox listings
+type NodeListItem struct {
+	Name    string        `json:"name" yaml:"name"`
+	NodeNum int           `json:"node-num" yaml:"node-num"`
+	Port    []int         `json:"port" yaml:"port"`
+	Running bool          `json:"running" yaml:"running"`
+	Status  *ServerStatus `json:"status,omitempty" yaml:"status,omitempty"`
+}
+
+// A sandbox, as shown in sandbox listings. It collects the data of
+// sbdescription.json, of the catalog, and the current state of the sandbox.
+type SandboxListItem struct {
+	Name              string         `json:"name" yaml:"name"`
+	Destination       string         `json:"destination" yaml:"destination"`
+	SBType            string         `json:"type" yaml:"type"`
+	Version           string         `json:"version" yaml:"version"`
+	Basedir           string         `json:"basedir" yaml:"basedir"`
+	Port              []int          `json:"port" yaml:"port"`
+	Nodes             []NodeListItem `json:"nodes" yaml:"nodes"`
+	Locked            bool           `json:"locked" yaml:"locked"`
+	Running           bool           `json:"running" yaml:"running"`
+	LogDirectory      string         `json:"log-directory" yaml:"log-directory"`
+	DbDeployerVersion string         `json:"dbdeployer-version" yaml:"dbdeployer-version"`
+	Timestamp         string         `json:"timestamp" yaml:"timestamp"`
+	CommandLine       string         `json:"command-line" yaml:"command-line"`
+	Status            *ServerStatus  `json:"status,omitempty" yaml:"status,omitempty"` // single sandboxes only
+}
+
+// Fills a listing item with the description and state of an installed sandbox
+func describe_sandbox(item *SandboxListItem) {
+	sandbox_dir := item.Destination
+	item.Locked = common.FileExists(sandbox_dir+"/no_clear") || common.FileExists(sandbox_dir+"/no_clear_all")
+	if !common.FileExists(sandbox_dir + "/sbdescription.json") {
+		return
+	}
+	sbd := common.ReadSandboxDescription(sandbox_dir)
+	item.SBType = sbd.SBType
+	item.Version = sbd.Version
+	item.Basedir = sbd.Basedir
+	item.Port = sbd.Port
+	item.DbDeployerVersion = sbd.DbDeployerVersion
+	item.Timestamp = sbd.Timestamp
+	item.CommandLine = sbd.CommandLine
+	if item.LogDirectory == "" && sbd.LogFile != "" {
+		item.LogDirectory = common.DirName(sbd.LogFile)
+	}
+	if sbd.Nodes == 0 {
+		item.Running = len(sbd.Port) > 0 && is_running(sandbox_dir, sbd.Port[0])
+		return
+	}
+	nodes := sandbox_nodes(sandbox_dir)
+	var node_numbers []int
+	for N := range nodes {
+		node_numbers = append(node_numbers, N)
+	}
+	sort.Ints(node_numbers)
+	for _, N := range node_numbers {
+		node := nodes[N]
+		node_item := NodeListItem{
+			Name:    node.Name,
+			NodeNum: N,
+			Port:    node.Description.Port,
+			Running: len(node.Description.Port) > 0 && is_running(sandbox_dir+"/"+node.Name, node.Description.Port[0]),
+		}
+		item.Running = item.Running || node_item.Running
+		item.Nodes = append(item.Nodes, node_item)
+	}
+}
+
+// ListSandboxes returns the sandboxes installed in sandbox_home
+func ListSandboxes(sandbox_home string) []SandboxListItem {
+	var list []SandboxListItem
+	for _, sb := range common.GetInstalledSandboxes(sandbox_home) {
+		item := SandboxListItem{
+			Name:        sb.SandboxName,
+			Destination: sandbox_home + "/" + sb.SandboxName,
+			Nodes:       []NodeListItem{},
+		}
+		if !common.FileExists(item.Destination+"/sbdescription.json") &&
+			!common.FileExists(item.Destination+"/start") &&
+			!common.FileExists(item.Destination+"/start_all") {
+			continue
+		}
+		describe_sandbox(&item)
+		list = append(list, item)
+	}
+	return list
+}
+
+// ListCatalogSandboxes returns the sandboxes registered in the catalog,
+// regardless of where they were installed. The state of sandboxes whose
+// directory does not exist is taken from the catalog.
+func ListCatalogSandboxes() ([]SandboxListItem, error) {
+	var list []SandboxListItem
+	catalog, err := defaults.ReadCatalog()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range catalog {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sb := catalog[name]
+		item := SandboxListItem{
+			Name:              common.BaseName(name),
+			Destination:       sb.Destination,
+			SBType:            sb.SBType,
+			Version:           sb.Version,
+			Basedir:           sb.Origin,
+			Port:              sb.Port,
+			LogDirectory:      sb.LogDirectory,
+			DbDeployerVersion: sb.DbDeployerVersion,
+			Timestamp:         sb.Timestamp,
+			CommandLine:       sb.CommandLine,
+			Nodes:             []NodeListItem{},
+		}
+		for _, node := range sb.Nodes {
+			item.Nodes = append(item.Nodes, NodeListItem{Name: node})
+		}
+		if common.DirExists(sb.Destination) {
+			item.Nodes = []NodeListItem{}
+			describe_sandbox(&item)
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
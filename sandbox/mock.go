@@ -99,16 +99,36 @@ func create_mock_version(version string) {
 		common.Exitf(1, "unhandled operating system %s", currentOs)
 	}
 	libmysqlclient_file_name := fmt.Sprintf("libmysqlclient.%s", extension)
-	write_script(logger, MockTemplates, "mysqld", "no_op_mock_template",
-		version_dir+"/bin", empty_data, true)
-	write_script(logger, MockTemplates, "mysql", "no_op_mock_template",
-		version_dir+"/bin", empty_data, true)
-	write_script(logger, MockTemplates, "mysql_install_db", "no_op_mock_template",
-		version_dir+"/scripts", empty_data, true)
-	write_script(logger, MockTemplates, libmysqlclient_file_name, "no_op_mock_template",
-		version_dir+"/lib", empty_data, true)
-	write_script(logger, MockTemplates, "mysqld_safe", "mysqld_safe_mock_template",
-		version_dir+"/bin", empty_data, true)
+	for _, sb := range []ScriptBatch{
+		{
+			tc:         MockTemplates,
+			logger:     logger,
+			data:       empty_data,
+			sandboxDir: version_dir + "/bin",
+			scripts: []ScriptDef{
+				{"mysqld", "no_op_mock_template", true},
+				{"mysql", "mysql_mock_template", true},
+				{"mysqld_safe", "mysqld_safe_mock_template", true},
+			},
+		},
+		{
+			tc:         MockTemplates,
+			logger:     logger,
+			data:       empty_data,
+			sandboxDir: version_dir + "/scripts",
+			scripts:    []ScriptDef{{"mysql_install_db", "no_op_mock_template", true}},
+		},
+		{
+			tc:         MockTemplates,
+			logger:     logger,
+			data:       empty_data,
+			sandboxDir: version_dir + "/lib",
+			scripts:    []ScriptDef{{libmysqlclient_file_name, "no_op_mock_template", true}},
+		},
+	} {
+		err := write_scripts(sb)
+		common.ErrCheckExitf(err, 1, "error creating mock version %s: %s", version, err)
+	}
 }
 
 func init() {
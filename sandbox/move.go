@@ -0,0 +1,232 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+)
+
+// Tells whether the server of a single sandbox, or any of the
+// nodes of a multiple sandbox, is running
+func sandbox_is_running(sandbox_dir string) bool {
+	sbd := common.ReadSandboxDescription(sandbox_dir)
+	if sbd.Nodes == 0 {
+		return len(sbd.Port) > 0 && is_running(sandbox_dir, sbd.Port[0])
+	}
+	for _, node := range sandbox_nodes(sandbox_dir) {
+		if len(node.Description.Port) > 0 && is_running(sandbox_dir+"/"+node.Name, node.Description.Port[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stops a sandbox, if it is running. It returns whether the sandbox
+// was running, so that the caller can restart it.
+func stop_sandbox(sandbox_dir string) (bool, error) {
+	if !sandbox_is_running(sandbox_dir) {
+		return false, nil
+	}
+	stop_script := "stop"
+	if common.ReadSandboxDescription(sandbox_dir).Nodes > 0 {
+		stop_script = "stop_all"
+	}
+	err, _ := common.Run_cmd(sandbox_dir + "/" + stop_script)
+	if err != nil {
+		return true, fmt.Errorf("Error stopping %s: %s", sandbox_dir, err)
+	}
+	return true, nil
+}
+
+// Starts a single sandbox, or all the nodes of a multiple sandbox
+func start_sandbox(sandbox_dir string) error {
+	start_script := "start"
+	if common.ReadSandboxDescription(sandbox_dir).Nodes > 0 {
+		start_script = "start_all"
+	}
+	err, _ := common.Run_cmd(sandbox_dir + "/" + start_script)
+	if err != nil {
+		return fmt.Errorf("Error starting %s: %s", sandbox_dir, err)
+	}
+	return nil
+}
+
+// Replaces the old sandbox path with the new one in the files that
+// were generated from templates: the files in the sandbox directory and
+// in its immediate subdirectories. Data directories, snapshots, and
+// binary files are not touched.
+func relocate_files(sandbox_dir, old_path, new_path string) error {
+	skip_dirs := map[string]bool{"data": true, "tmp": true, "ndb_data": true, SnapshotDir: true}
+	files, err := filepath.Glob(sandbox_dir + "/*")
+	if err != nil {
+		return err
+	}
+	inner_files, err := filepath.Glob(sandbox_dir + "/*/*")
+	if err != nil {
+		return err
+	}
+	for _, fname := range inner_files {
+		if !skip_dirs[common.BaseName(common.DirName(fname))] {
+			files = append(files, fname)
+		}
+	}
+	for _, fname := range files {
+		info, err := os.Lstat(fname)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || filepath.Ext(fname) == ".json" {
+			continue
+		}
+		contents, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return err
+		}
+		if bytes.IndexByte(contents, 0) >= 0 || !bytes.Contains(contents, []byte(old_path)) {
+			continue
+		}
+		contents = bytes.Replace(contents, []byte(old_path), []byte(new_path), -1)
+		err = ioutil.WriteFile(fname, contents, info.Mode())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MoveSandbox relocates a sandbox to a new directory.
+// The sandbox is stopped during the move, and restarted if it was running.
+// The generated files embed the sandbox path. Replacing it gives the same
+// files that a new deployment would generate, while keeping the changes
+// made after deployment (added options, added or removed nodes).
+// The description, definition, and catalog entry follow the sandbox to
+// the new location.
+func MoveSandbox(sandbox_dir, new_dir string) error {
+	if !common.FileExists(sandbox_dir + "/sbdescription.json") {
+		return fmt.Errorf("Directory %s is not a sandbox", sandbox_dir)
+	}
+	if common.DirExists(new_dir) {
+		return fmt.Errorf("Directory %s already exists", new_dir)
+	}
+	if !common.DirExists(common.DirName(new_dir)) {
+		return fmt.Errorf("Directory %s not found", common.DirName(new_dir))
+	}
+	_, logger := defaults.NewLogger(common.LogDirName(), "move")
+	logger.Printf("Moving sandbox %s to %s\n", sandbox_dir, new_dir)
+
+	sbd := common.ReadSandboxDescription(sandbox_dir)
+	logger.Printf("Stopping %s\n", sandbox_dir)
+	was_running, err := stop_sandbox(sandbox_dir)
+	if err != nil {
+		return err
+	}
+	err, _ = common.Run_cmd_with_args("mv", []string{sandbox_dir, new_dir})
+	if err != nil {
+		return fmt.Errorf("Error moving %s to %s: %s", sandbox_dir, new_dir, err)
+	}
+	err = relocate_sandbox(sandbox_dir, new_dir, sbd)
+	if err != nil {
+		logger.Printf("Moving %s back to %s after error: %s\n", new_dir, sandbox_dir, err)
+		err = undo_move(sandbox_dir, new_dir, err)
+		if was_running {
+			logger.Printf("Starting %s\n", sandbox_dir)
+			err = restart_after(sandbox_dir, err)
+		}
+		return err
+	}
+	// NDB management nodes keep a cached copy of the configuration,
+	// with the old paths. Without it, the configuration is read again
+	// from config.ini
+	cached_configs, _ := filepath.Glob(new_dir + "/ndb_conf/ndb_*_config.bin.*")
+	for _, fname := range cached_configs {
+		os.Remove(fname)
+	}
+	if was_running {
+		logger.Printf("Starting %s\n", new_dir)
+		return start_sandbox(new_dir)
+	}
+	return nil
+}
+
+// Updates the files, definition, and catalog entry of a sandbox that
+// was moved from old_dir to new_dir
+func relocate_sandbox(old_dir, new_dir string, sbd common.SandboxDescription) error {
+	err := relocate_files(new_dir, old_dir, new_dir)
+	if err != nil {
+		return err
+	}
+	common.WriteSandboxDescription(new_dir, sbd)
+	dd, err := ReadDeploymentDefinition(new_dir)
+	if err == nil {
+		if dd.Sdef.SandboxDir == common.DirName(old_dir) {
+			dd.Sdef.SandboxDir = common.DirName(new_dir)
+		}
+		dd.Sdef.DirName = common.BaseName(new_dir)
+		if dd.Sdef.HistoryDir == old_dir {
+			dd.Sdef.HistoryDir = new_dir
+		}
+		err = WriteDeploymentDefinition(new_dir, dd)
+		if err != nil {
+			return err
+		}
+	}
+	return defaults.RenameInCatalog(old_dir, new_dir)
+}
+
+// Puts back a sandbox that was moved from old_dir to new_dir, after
+// relocate_sandbox failed with move_err.
+// The catalog is only changed by the last step of relocate_sandbox,
+// and it does not need to be restored.
+func undo_move(old_dir, new_dir string, move_err error) error {
+	var undo_errors []string
+	// The definition file is not changed by relocate_files, and is
+	// restored separately
+	dd, err := ReadDeploymentDefinition(new_dir)
+	if err == nil {
+		if dd.Sdef.SandboxDir == common.DirName(new_dir) {
+			dd.Sdef.SandboxDir = common.DirName(old_dir)
+		}
+		dd.Sdef.DirName = common.BaseName(old_dir)
+		if dd.Sdef.HistoryDir == new_dir {
+			dd.Sdef.HistoryDir = old_dir
+		}
+		err = WriteDeploymentDefinition(new_dir, dd)
+		if err != nil {
+			undo_errors = append(undo_errors, err.Error())
+		}
+	}
+	err = relocate_files(new_dir, new_dir, old_dir)
+	if err != nil {
+		undo_errors = append(undo_errors, err.Error())
+	}
+	err, _ = common.Run_cmd_with_args("mv", []string{new_dir, old_dir})
+	if err != nil {
+		undo_errors = append(undo_errors, fmt.Sprintf("Error moving %s to %s: %s", new_dir, old_dir, err))
+	}
+	if len(undo_errors) > 0 {
+		return fmt.Errorf("%s. Besides, the move could not be fully undone: %s",
+			move_err, strings.Join(undo_errors, "; "))
+	}
+	return move_err
+}
@@ -24,31 +24,32 @@ import (
 	"strings"
 )
 
-func check_node_lists(nodes int, mlist, slist []int) {
+func check_node_lists(nodes int, mlist, slist []int) error {
 	for _, N := range mlist {
 		if N > nodes {
-			common.Exitf(1, "Master num '%d' greater than number of nodes (%d)", N, nodes)
+			return fmt.Errorf("Master num '%d' greater than number of nodes (%d)", N, nodes)
 		}
 	}
 	for _, N := range slist {
 		if N > nodes {
-			common.Exitf(1, "Slave num '%d' greater than number of nodes (%d)", N, nodes)
+			return fmt.Errorf("Slave num '%d' greater than number of nodes (%d)", N, nodes)
 		}
 	}
 	for _, M := range mlist {
 		for _, S := range slist {
 			if S == M {
-				common.Exitf(1, "Overlapping values: %d is in both master and slave list", M)
+				return fmt.Errorf("Overlapping values: %d is in both master and slave list", M)
 			}
 		}
 	}
 	total_nodes := len(mlist) + len(slist)
 	if total_nodes != nodes {
-		common.Exitf(1, "Mismatched values: masters (%d) + slaves (%d) = %d. Expected: %d", len(mlist), len(slist), total_nodes, nodes)
+		return fmt.Errorf("Mismatched values: masters (%d) + slaves (%d) = %d. Expected: %d", len(mlist), len(slist), total_nodes, nodes)
 	}
+	return nil
 }
 
-func nodes_list_to_int_slice(nodes_list string, nodes int) (int_list []int) {
+func nodes_list_to_int_slice(nodes_list string, nodes int) (int_list []int, err error) {
 	separator := " "
 	if common.Includes(nodes_list, ",") {
 		separator = ","
@@ -64,12 +65,14 @@ func nodes_list_to_int_slice(nodes_list string, nodes int) (int_list []int) {
 	list := strings.Split(nodes_list, separator)
 	// fmt.Printf("# separator: <%s> %#v\n",separator, list)
 	if len(list) == 0 {
-		common.Exitf(1, "Empty nodes list given (%s)", nodes_list)
+		return int_list, fmt.Errorf("Empty nodes list given (%s)", nodes_list)
 	}
 	for _, s := range list {
 		if s != "" {
 			num, err := strconv.Atoi(s)
-			common.ErrCheckExitf(err, 1, "Error converting node number '%s' to int", s)
+			if err != nil {
+				return int_list, fmt.Errorf("Error converting node number '%s' to int", s)
+			}
 			int_list = append(int_list, num)
 		}
 	}
@@ -89,14 +92,18 @@ func make_nodes_list(nodes int) (nodes_list string) {
 	return nodes_list
 }
 
-func CreateAllMastersReplication(sdef SandboxDef, origin string, nodes int, master_ip string) {
+func CreateAllMastersReplication(sdef SandboxDef, origin string, nodes int, master_ip string) (err error) {
+	finish_cleanup := start_cleanup(&sdef)
+	defer func() {
+		finish_cleanup(err)
+	}()
 	sdef.SBType = "all-masters"
 
 	fname, logger := defaults.NewLogger(common.LogDirName(), "all-masters")
 	sdef.LogFileName = common.ReplaceLiteralHome(fname)
 	sdef.Logger = logger
-	sdef.GtidOptions = SingleTemplates["gtid_options_57"].Contents
-	sdef.ReplCrashSafeOptions = SingleTemplates["repl_crash_safe_options"].Contents
+	sdef.GtidOptions = DeploymentTemplates(sdef, SingleTemplates)["gtid_options_57"].Contents
+	sdef.ReplCrashSafeOptions = DeploymentTemplates(sdef, SingleTemplates)["repl_crash_safe_options"].Contents
 	if sdef.DirName == "" {
 		sdef.DirName += defaults.Defaults().AllMastersPrefix + common.VersionToName(origin)
 	}
@@ -105,42 +112,77 @@ func CreateAllMastersReplication(sdef SandboxDef, origin string, nodes int, mast
 	if sdef.BasePort == 0 {
 		sdef.BasePort = defaults.Defaults().AllMastersReplicationBasePort
 	}
-	master_abbr := defaults.Defaults().MasterAbbr
-	slave_abbr := defaults.Defaults().SlaveAbbr
-	master_label := defaults.Defaults().MasterName
-	slave_label := defaults.Defaults().SlavePrefix
-	data := CreateMultipleSandbox(sdef, origin, nodes)
+	// Every node is both a master and a slave
+	all_nodes := node_range(1, nodes)
+	sdef.PerNodeOptions = node_options_by_role(sdef, nodes, all_nodes, all_nodes)
+	data, err := CreateMultipleSandbox(sdef, origin, nodes)
+	if err != nil {
+		return err
+	}
 
 	sdef.SandboxDir = data["SandboxDir"].(string)
 	master_list := make_nodes_list(nodes)
-	slist := nodes_list_to_int_slice(master_list, nodes)
-	data["MasterIp"] = master_ip
-	data["MasterAbbr"] = master_abbr
-	data["MasterLabel"] = master_label
-	data["MasterList"] = normalize_node_list(master_list)
-	data["SlaveAbbr"] = slave_abbr
-	data["SlaveLabel"] = slave_label
-	data["SlaveList"] = normalize_node_list(master_list)
-	data["RplUser"] = sdef.RplUser
-	data["RplPassword"] = sdef.RplPassword
-	data["NodeLabel"] = defaults.Defaults().NodePrefix
+	slist, err := nodes_list_to_int_slice(master_list, nodes)
+	if err != nil {
+		return err
+	}
+	add_multi_source_data(sdef, data, master_ip, master_list, master_list)
 	logger.Printf("Writing master and slave scripts in %s\n", sdef.SandboxDir)
 	for _, node := range slist {
 		data["Node"] = node
-		write_script(logger, ReplicationTemplates, fmt.Sprintf("s%d", node), "slave_template", sandbox_dir, data, true)
-		write_script(logger, ReplicationTemplates, fmt.Sprintf("m%d", node), "slave_template", sandbox_dir, data, true)
+		err = write_scripts(ScriptBatch{
+			tc:         DeploymentTemplates(sdef, ReplicationTemplates),
+			logger:     logger,
+			data:       data,
+			sandboxDir: sandbox_dir,
+			scripts: []ScriptDef{
+				{fmt.Sprintf("s%d", node), "slave_template", true},
+				{fmt.Sprintf("m%d", node), "slave_template", true},
+			},
+		})
+		if err != nil {
+			return err
+		}
 	}
 	logger.Printf("Writing all-masters replication scripts in %s\n", sdef.SandboxDir)
-	write_script(logger, ReplicationTemplates, "test_replication", "multi_source_test_template", sandbox_dir, data, true)
-	write_script(logger, ReplicationTemplates, "use_all_slaves", "multi_source_use_slaves_template", sandbox_dir, data, true)
-	write_script(logger, ReplicationTemplates, "use_all_masters", "multi_source_use_masters_template", sandbox_dir, data, true)
-	write_script(logger, ReplicationTemplates, "check_ms_nodes", "check_multi_source_template", sandbox_dir, data, true)
-	write_script(logger, ReplicationTemplates, "initialize_ms_nodes", "multi_source_template", sandbox_dir, data, true)
+	err = write_scripts(ScriptBatch{
+		tc:         DeploymentTemplates(sdef, ReplicationTemplates),
+		logger:     logger,
+		data:       data,
+		sandboxDir: sandbox_dir,
+		scripts: []ScriptDef{
+			{"test_replication", "multi_source_test_template", true},
+			{"use_all_slaves", "multi_source_use_slaves_template", true},
+			{"use_all_masters", "multi_source_use_masters_template", true},
+			{"check_ms_nodes", "check_multi_source_template", true},
+			{"initialize_ms_nodes", "multi_source_template", true},
+		},
+	})
+	if err != nil {
+		return err
+	}
 	if !sdef.SkipStart {
 		logger.Printf("Initializing all-masters replication \n")
 		fmt.Println(common.ReplaceLiteralHome(sandbox_dir) + "/initialize_ms_nodes")
-		common.Run_cmd(sandbox_dir + "/initialize_ms_nodes")
+		run_cmd(sandbox_dir + "/initialize_ms_nodes")
 	}
+	return nil
+}
+
+// Adds to the data of a multiple sandbox, as created by multiple_data,
+// the data used by the scripts of all-masters and fan-in topologies.
+// The node of the slave scripts is set while writing them
+func add_multi_source_data(sdef SandboxDef, data common.Smap, master_ip, master_list, slave_list string) {
+	data["MasterIp"] = master_ip
+	data["MasterAbbr"] = defaults.Defaults().MasterAbbr
+	data["MasterLabel"] = defaults.Defaults().MasterName
+	data["MasterList"] = normalize_node_list(master_list)
+	data["SlaveAbbr"] = defaults.Defaults().SlaveAbbr
+	data["SlaveLabel"] = defaults.Defaults().SlavePrefix
+	data["SlaveList"] = normalize_node_list(slave_list)
+	data["RplUser"] = sdef.RplUser
+	data["RplPassword"] = sdef.RplPassword
+	data["NodeLabel"] = defaults.Defaults().NodePrefix
 }
 
 func normalize_node_list(list string) string {
@@ -148,14 +190,18 @@ func normalize_node_list(list string) string {
 	return re.ReplaceAllString(list, " ")
 }
 
-func CreateFanInReplication(sdef SandboxDef, origin string, nodes int, master_ip, master_list, slave_list string) {
+func CreateFanInReplication(sdef SandboxDef, origin string, nodes int, master_ip, master_list, slave_list string) (err error) {
+	finish_cleanup := start_cleanup(&sdef)
+	defer func() {
+		finish_cleanup(err)
+	}()
 	sdef.SBType = "fan-in"
 
 	fname, logger := defaults.NewLogger(common.LogDirName(), "fan-in")
 	sdef.LogFileName = fname
 	sdef.Logger = logger
-	sdef.GtidOptions = SingleTemplates["gtid_options_57"].Contents
-	sdef.ReplCrashSafeOptions = SingleTemplates["repl_crash_safe_options"].Contents
+	sdef.GtidOptions = DeploymentTemplates(sdef, SingleTemplates)["gtid_options_57"].Contents
+	sdef.ReplCrashSafeOptions = DeploymentTemplates(sdef, SingleTemplates)["repl_crash_safe_options"].Contents
 	if sdef.DirName == "" {
 		sdef.DirName = defaults.Defaults().FanInPrefix + common.VersionToName(origin)
 	}
@@ -164,44 +210,62 @@ func CreateFanInReplication(sdef SandboxDef, origin string, nodes int, master_ip
 	}
 	sandbox_dir := sdef.SandboxDir
 	sdef.SandboxDir = common.DirName(sdef.SandboxDir)
-	mlist := nodes_list_to_int_slice(master_list, nodes)
-	slist := nodes_list_to_int_slice(slave_list, nodes)
-	check_node_lists(nodes, mlist, slist)
-	data := CreateMultipleSandbox(sdef, origin, nodes)
+	mlist, err := nodes_list_to_int_slice(master_list, nodes)
+	if err != nil {
+		return err
+	}
+	slist, err := nodes_list_to_int_slice(slave_list, nodes)
+	if err != nil {
+		return err
+	}
+	err = check_node_lists(nodes, mlist, slist)
+	if err != nil {
+		return err
+	}
+	sdef.PerNodeOptions = node_options_by_role(sdef, nodes, mlist, slist)
+	data, err := CreateMultipleSandbox(sdef, origin, nodes)
+	if err != nil {
+		return err
+	}
 
 	sdef.SandboxDir = data["SandboxDir"].(string)
-	master_abbr := defaults.Defaults().MasterAbbr
-	slave_abbr := defaults.Defaults().SlaveAbbr
-	master_label := defaults.Defaults().MasterName
-	slave_label := defaults.Defaults().SlavePrefix
-	data["MasterList"] = normalize_node_list(master_list)
-	data["SlaveList"] = normalize_node_list(slave_list)
-	data["MasterAbbr"] = master_abbr
-	data["MasterLabel"] = master_label
-	data["SlaveAbbr"] = slave_abbr
-	data["SlaveLabel"] = slave_label
-	data["RplUser"] = sdef.RplUser
-	data["RplPassword"] = sdef.RplPassword
-	data["NodeLabel"] = defaults.Defaults().NodePrefix
-	data["MasterIp"] = master_ip
+	add_multi_source_data(sdef, data, master_ip, master_list, slave_list)
 	logger.Printf("Writing master and slave scripts in %s\n", sdef.SandboxDir)
 	for _, slave := range slist {
 		data["Node"] = slave
-		write_script(logger, ReplicationTemplates, fmt.Sprintf("s%d", slave), "slave_template", sandbox_dir, data, true)
+		err = write_script(logger, DeploymentTemplates(sdef, ReplicationTemplates), fmt.Sprintf("s%d", slave), "slave_template", sandbox_dir, data, true)
+		if err != nil {
+			return err
+		}
 	}
 	for _, master := range mlist {
 		data["Node"] = master
-		write_script(logger, ReplicationTemplates, fmt.Sprintf("m%d", master), "slave_template", sandbox_dir, data, true)
+		err = write_script(logger, DeploymentTemplates(sdef, ReplicationTemplates), fmt.Sprintf("m%d", master), "slave_template", sandbox_dir, data, true)
+		if err != nil {
+			return err
+		}
 	}
 	logger.Printf("writing fan-in replication scripts in %s\n", sdef.SandboxDir)
-	write_script(logger, ReplicationTemplates, "test_replication", "multi_source_test_template", sandbox_dir, data, true)
-	write_script(logger, ReplicationTemplates, "check_ms_nodes", "check_multi_source_template", sandbox_dir, data, true)
-	write_script(logger, ReplicationTemplates, "use_all_slaves", "multi_source_use_slaves_template", sandbox_dir, data, true)
-	write_script(logger, ReplicationTemplates, "use_all_masters", "multi_source_use_masters_template", sandbox_dir, data, true)
-	write_script(logger, ReplicationTemplates, "initialize_ms_nodes", "multi_source_template", sandbox_dir, data, true)
+	err = write_scripts(ScriptBatch{
+		tc:         DeploymentTemplates(sdef, ReplicationTemplates),
+		logger:     logger,
+		data:       data,
+		sandboxDir: sandbox_dir,
+		scripts: []ScriptDef{
+			{"test_replication", "multi_source_test_template", true},
+			{"check_ms_nodes", "check_multi_source_template", true},
+			{"use_all_slaves", "multi_source_use_slaves_template", true},
+			{"use_all_masters", "multi_source_use_masters_template", true},
+			{"initialize_ms_nodes", "multi_source_template", true},
+		},
+	})
+	if err != nil {
+		return err
+	}
 	if !sdef.SkipStart {
 		logger.Printf("Initializing fan-in replication\n")
 		fmt.Println(common.ReplaceLiteralHome(sandbox_dir) + "/initialize_ms_nodes")
-		common.Run_cmd(sandbox_dir + "/initialize_ms_nodes")
+		run_cmd(sandbox_dir + "/initialize_ms_nodes")
 	}
+	return nil
 }
@@ -31,10 +31,67 @@ type Node struct {
 	Name     string
 }
 
-func CreateMultipleSandbox(sdef SandboxDef, origin string, nodes int) common.Smap {
+// Returns the data used by the scripts of a multiple sandbox,
+// given the port of each node
+func multiple_data(sandbox_dir string, node_ports map[int]int) common.Smap {
+	timestamp := time.Now()
+	var data common.Smap = common.Smap{
+		"Copyright":  Copyright,
+		"AppVersion": common.VersionDef,
+		"DateTime":   timestamp.Format(time.UnixDate),
+		"SandboxDir": sandbox_dir,
+		"Nodes":      []common.Smap{},
+	}
+	for _, N := range sorted_nodes(node_ports) {
+		data["Nodes"] = append(data["Nodes"].([]common.Smap), node_script_data(sandbox_dir, N, node_ports[N]))
+	}
+	return data
+}
+
+// Returns the data used by the script that runs the client
+// of a node in a multiple sandbox (n1, n2, ...)
+func node_script_data(sandbox_dir string, node, node_port int) common.Smap {
+	return common.Smap{
+		"Copyright":  Copyright,
+		"AppVersion": common.VersionDef,
+		"DateTime":   time.Now().Format(time.UnixDate),
+		"Node":       node,
+		"NodePort":   node_port,
+		"NodeLabel":  defaults.Defaults().NodePrefix,
+		"SandboxDir": sandbox_dir,
+	}
+}
+
+// Writes the scripts that operate on all the nodes of a multiple sandbox
+func write_multiple_scripts(logger *defaults.Logger, sdef SandboxDef, data common.Smap) error {
+	return write_scripts(ScriptBatch{
+		tc:         DeploymentTemplates(sdef, MultipleTemplates),
+		logger:     logger,
+		data:       data,
+		sandboxDir: data["SandboxDir"].(string),
+		scripts: []ScriptDef{
+			{"start_all", "start_multi_template", true},
+			{"restart_all", "restart_multi_template", true},
+			{"status_all", "status_multi_template", true},
+			{"test_sb_all", "test_sb_multi_template", true},
+			{"stop_all", "stop_multi_template", true},
+			{"clear_all", "clear_multi_template", true},
+			{"send_kill_all", "send_kill_multi_template", true},
+			{"use_all", "use_multi_template", true},
+		},
+	})
+}
+
+func CreateMultipleSandbox(sdef SandboxDef, origin string, nodes int) (data common.Smap, err error) {
 
 	var exec_lists []concurrent.ExecutionList
 
+	finish_cleanup := start_cleanup(&sdef)
+	defer func() {
+		finish_cleanup(err)
+	}()
+
+	original_sdef := sdef
 	sb_type := sdef.SBType
 	if sb_type == "" {
 		sb_type = "multiple"
@@ -48,7 +105,7 @@ func CreateMultipleSandbox(sdef SandboxDef, origin string, nodes int) common.Sma
 	}
 	Basedir := sdef.Basedir
 	if !common.DirExists(Basedir) {
-		common.Exitf(1, "Base directory %s does not exist", Basedir)
+		return common.Smap{}, &MissingBasedirError{Basedir: Basedir}
 	}
 	if sdef.DirName == "" {
 		sdef.SandboxDir += "/" + defaults.Defaults().MultiplePrefix + common.VersionToName(origin)
@@ -56,7 +113,13 @@ func CreateMultipleSandbox(sdef SandboxDef, origin string, nodes int) common.Sma
 		sdef.SandboxDir += "/" + sdef.DirName
 	}
 	if common.DirExists(sdef.SandboxDir) {
-		sdef = CheckDirectory(sdef)
+		sdef, err = CheckDirectory(sdef)
+		if err != nil {
+			return common.Smap{}, err
+		}
+	}
+	if nodes < 2 {
+		return common.Smap{}, fmt.Errorf("Only one node requested. For single sandbox deployment, use the 'single' command")
 	}
 
 	vList := common.VersionToList(sdef.Version)
@@ -71,28 +134,26 @@ func CreateMultipleSandbox(sdef SandboxDef, origin string, nodes int) common.Sma
 	first_port := common.FindFreePort(base_port+1, sdef.InstalledPorts, nodes)
 	base_port = first_port - 1
 	for check_port := base_port + 1; check_port < base_port+nodes; check_port++ {
-		CheckPort("CreateMultipleSandbox", sdef.SandboxDir, sdef.InstalledPorts, check_port)
+		err = CheckPort("CreateMultipleSandbox", sdef.SandboxDir, sdef.InstalledPorts, check_port)
+		if err != nil {
+			return common.Smap{}, err
+		}
+	}
+	base_mysqlx_port, err := get_base_mysqlx_port(base_port, sdef, nodes)
+	if err != nil {
+		return common.Smap{}, err
 	}
-	base_mysqlx_port := get_base_mysqlx_port(base_port, sdef, nodes)
-	common.Mkdir(sdef.SandboxDir)
+	make_top_dir(sdef.Cleanup, sdef.SandboxDir)
 	logger.Printf("Created directory %s\n", sdef.SandboxDir)
 	logger.Printf("Multiple Sandbox Definition: %s\n", SandboxDefToJson(sdef))
 
-	common.AddToCleanupStack(common.Rmdir, "Rmdir", sdef.SandboxDir)
-
-	sdef.ReplOptions = SingleTemplates["replication_options"].Contents
+	sdef.ReplOptions = DeploymentTemplates(sdef, SingleTemplates)["replication_options"].Contents
 	base_server_id := 0
-	if nodes < 2 {
-		common.Exit(1, "Only one node requested. For single sandbox deployment, use the 'single' command")
-	}
-	timestamp := time.Now()
-	var data common.Smap = common.Smap{
-		"Copyright":  Copyright,
-		"AppVersion": common.VersionDef,
-		"DateTime":   timestamp.Format(time.UnixDate),
-		"SandboxDir": sdef.SandboxDir,
-		"Nodes":      []common.Smap{},
+	node_ports := make(map[int]int)
+	for i := 1; i <= nodes; i++ {
+		node_ports[i] = base_port + i
 	}
+	data = multiple_data(sdef.SandboxDir, node_ports)
 
 	sb_desc := common.SandboxDescription{
 		Basedir: Basedir,
@@ -121,22 +182,13 @@ func CreateMultipleSandbox(sdef SandboxDef, origin string, nodes int) common.Sma
 	node_label := defaults.Defaults().NodePrefix
 	for i := 1; i <= nodes; i++ {
 		sdef.Port = base_port + i
-		data["Nodes"] = append(data["Nodes"].([]common.Smap), common.Smap{
-			"Copyright":  Copyright,
-			"AppVersion": common.VersionDef,
-			"DateTime":   timestamp.Format(time.UnixDate),
-			"Node":       i,
-			"NodePort":   sdef.Port,
-			"NodeLabel":  node_label,
-			"SandboxDir": sdef.SandboxDir,
-		})
 		sdef.LoadGrants = true
 		sdef.DirName = fmt.Sprintf("%s%d", node_label, i)
 		sdef.ServerId = (base_server_id + i) * 100
 		sb_item.Nodes = append(sb_item.Nodes, sdef.DirName)
 		sb_item.Port = append(sb_item.Port, sdef.Port)
 		sb_desc.Port = append(sb_desc.Port, sdef.Port)
-		if common.GreaterOrEqualVersion(sdef.Version, []int{8, 0, 11}) {
+		if has_feature(sdef.Version, "mysqlx-default") {
 			sdef.MysqlXPort = base_mysqlx_port + i
 			if !sdef.DisableMysqlX {
 				sb_desc.Port = append(sb_desc.Port, base_mysqlx_port+i)
@@ -153,40 +205,52 @@ func CreateMultipleSandbox(sdef SandboxDef, origin string, nodes int) common.Sma
 			logger.Printf("installing and starting %s %d", node_label, i)
 		}
 		logger.Printf("Creating single sandbox for node %d\n", i)
-		exec_list := CreateSingleSandbox(sdef)
+		exec_list, err := CreateSingleSandbox(sdef)
+		if err != nil {
+			return common.Smap{}, err
+		}
 		for _, list := range exec_list {
 			exec_lists = append(exec_lists, list)
 		}
 
-		var data_node common.Smap = common.Smap{
-			"Node":       i,
-			"NodePort":   sdef.Port,
-			"NodeLabel":  node_label,
-			"SandboxDir": sdef.SandboxDir,
-			"Copyright":  Copyright,
-		}
+		data_node := node_script_data(sdef.SandboxDir, i, sdef.Port)
 		logger.Printf("Creating node script for node %d\n", i)
 		logger.Printf("Defining multiple sandbox node inner data: %v\n", SmapToJson(data_node))
-		write_script(logger, MultipleTemplates, fmt.Sprintf("n%d", i), "node_template", sdef.SandboxDir, data_node, true)
+		err = write_script(logger, DeploymentTemplates(sdef, MultipleTemplates), fmt.Sprintf("n%d", i), "node_template", sdef.SandboxDir, data_node, true)
+		if err != nil {
+			return common.Smap{}, err
+		}
 	}
 	logger.Printf("Write sandbox description\n")
-	common.WriteSandboxDescription(sdef.SandboxDir, sb_desc)
-	defaults.UpdateCatalog(sdef.SandboxDir, sb_item)
+	write_sandbox_description(sdef.SandboxDir, sb_desc, 0)
+	err = update_catalog(sdef.Cleanup, sdef.SandboxDir, sb_item)
+	if err != nil {
+		return common.Smap{}, err
+	}
+	if sb_type == "multiple" {
+		original_sdef.BasePort = base_port
+		logger.Printf("Write multiple sandbox definition\n")
+		err = WriteDeploymentDefinition(sdef.SandboxDir, DeploymentDefinition{
+			Topology: "multiple",
+			Origin:   origin,
+			Nodes:    nodes,
+			Sdef:     original_sdef,
+		})
+		if err != nil {
+			return common.Smap{}, err
+		}
+	}
 
 	logger.Printf("Write multiple sandbox scripts\n")
-	write_script(logger, MultipleTemplates, "start_all", "start_multi_template", sdef.SandboxDir, data, true)
-	write_script(logger, MultipleTemplates, "restart_all", "restart_multi_template", sdef.SandboxDir, data, true)
-	write_script(logger, MultipleTemplates, "status_all", "status_multi_template", sdef.SandboxDir, data, true)
-	write_script(logger, MultipleTemplates, "test_sb_all", "test_sb_multi_template", sdef.SandboxDir, data, true)
-	write_script(logger, MultipleTemplates, "stop_all", "stop_multi_template", sdef.SandboxDir, data, true)
-	write_script(logger, MultipleTemplates, "clear_all", "clear_multi_template", sdef.SandboxDir, data, true)
-	write_script(logger, MultipleTemplates, "send_kill_all", "send_kill_multi_template", sdef.SandboxDir, data, true)
-	write_script(logger, MultipleTemplates, "use_all", "use_multi_template", sdef.SandboxDir, data, true)
+	err = write_multiple_scripts(logger, sdef, data)
+	if err != nil {
+		return common.Smap{}, err
+	}
 
 	logger.Printf("Run concurrent tasks\n")
-	concurrent.RunParallelTasksByPriority(exec_lists)
+	run_tasks_by_priority(exec_lists)
 
 	fmt.Printf("%s directory installed in %s\n", sb_type, common.ReplaceLiteralHome(sdef.SandboxDir))
 	fmt.Printf("run 'dbdeployer usage multiple' for basic instructions'\n")
-	return data
+	return data, nil
 }
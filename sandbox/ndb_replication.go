@@ -0,0 +1,313 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/concurrent"
+	"github.com/datacharmer/dbdeployer/defaults"
+)
+
+// Programs that must be in the basedir of an NDB cluster tarball
+var ndb_executables = []string{"ndb_mgmd", "ndbd", "ndb_mgm", "ndb_waiter"}
+
+// Node IDs in config.ini: the management node is 1,
+// then come the data nodes, followed by the SQL nodes.
+func ndb_data_node_id(node int) int {
+	return node + 1
+}
+
+func ndb_sql_node_id(ndb_nodes, node int) int {
+	return ndb_nodes + node + 1
+}
+
+// Returns the data used by the scripts and the configuration of
+// a NDB cluster sandbox
+func ndb_data(sdef SandboxDef, master_ip string, management_port, base_port, ndb_nodes, nodes int) common.Smap {
+	node_label := defaults.Defaults().NodePrefix
+	no_of_replicas := 1
+	if ndb_nodes%2 == 0 {
+		no_of_replicas = 2
+	}
+	var data common.Smap = common.Smap{
+		"Copyright":      Copyright,
+		"AppVersion":     common.VersionDef,
+		"DateTime":       time.Now().Format(time.UnixDate),
+		"SandboxDir":     sdef.SandboxDir,
+		"Basedir":        sdef.Basedir,
+		"MasterIp":       master_ip,
+		"ManagementPort": management_port,
+		"NoOfReplicas":   no_of_replicas,
+		"NodeLabel":      node_label,
+		"Nodes":          []common.Smap{},
+		"DataNodes":      []common.Smap{},
+	}
+	for i := 1; i <= ndb_nodes; i++ {
+		data["DataNodes"] = append(data["DataNodes"].([]common.Smap), common.Smap{
+			"NodeId":     ndb_data_node_id(i),
+			"ServerPort": management_port + i,
+			"MasterIp":   master_ip,
+			"SandboxDir": sdef.SandboxDir,
+		})
+	}
+	for i := 1; i <= nodes; i++ {
+		data["Nodes"] = append(data["Nodes"].([]common.Smap), common.Smap{
+			"Node":       i,
+			"NodeId":     ndb_sql_node_id(ndb_nodes, i),
+			"NodePort":   base_port + i,
+			"NodeLabel":  node_label,
+			"MasterIp":   master_ip,
+			"SandboxDir": sdef.SandboxDir,
+		})
+	}
+	return data
+}
+
+func CreateNdbReplication(sdef SandboxDef, origin string, nodes int, master_ip string) (err error) {
+	finish_cleanup := start_cleanup(&sdef)
+	defer func() {
+		finish_cleanup(err)
+	}()
+	var exec_lists []concurrent.ExecutionList
+
+	fname, logger := defaults.NewLogger(common.LogDirName(), "ndb")
+	sdef.LogFileName = common.ReplaceLiteralHome(fname)
+	vList := common.VersionToList(sdef.Version)
+	rev := vList[2]
+	base_port := sdef.Port + defaults.Defaults().NdbBasePort + (rev * 100)
+	if sdef.BasePort > 0 {
+		base_port = sdef.BasePort
+	}
+	ndb_nodes := sdef.NdbNodes
+	if ndb_nodes == 0 {
+		ndb_nodes = defaults.NdbNodesValue
+	}
+	if ndb_nodes < 1 {
+		return fmt.Errorf("An NDB cluster needs at least one data node")
+	}
+	if nodes < 1 {
+		return fmt.Errorf("An NDB cluster needs at least one SQL node")
+	}
+	for _, executable := range ndb_executables {
+		if !common.ExecExists(sdef.Basedir + "/bin/" + executable) {
+			return fmt.Errorf("%s not found in %s/bin. An NDB cluster tarball is required", executable, sdef.Basedir)
+		}
+	}
+	if common.DirExists(sdef.SandboxDir) {
+		sdef, err = CheckDirectory(sdef)
+		if err != nil {
+			return err
+		}
+	}
+	// FindFreePort returns the first free port, but base_port will be used
+	// with a counter. Thus the availability will be checked using
+	// "base_port + 1"
+	first_port := common.FindFreePort(base_port+1, sdef.InstalledPorts, nodes)
+	base_port = first_port - 1
+	// The cluster ports are the management port followed by one port for each data node
+	cluster_ports := ndb_nodes + 1
+	management_port := common.FindFreePort(base_port+defaults.Defaults().GroupPortDelta+1, sdef.InstalledPorts, cluster_ports)
+	for check_port := base_port + 1; check_port < base_port+nodes+1; check_port++ {
+		err = CheckPort("CreateNdbReplication", sdef.SandboxDir, sdef.InstalledPorts, check_port)
+		if err != nil {
+			return err
+		}
+	}
+	for check_port := management_port; check_port < management_port+cluster_ports; check_port++ {
+		err = CheckPort("CreateNdbReplication-cluster", sdef.SandboxDir, sdef.InstalledPorts, check_port)
+		if err != nil {
+			return err
+		}
+	}
+	base_mysqlx_port, err := get_base_mysqlx_port(base_port, sdef, nodes)
+	if err != nil {
+		return err
+	}
+	sdef.PerNodeOptions = node_options_by_role(sdef, nodes, nil, nil)
+
+	// The SQL nodes can only start after the data nodes,
+	// which are started by the cluster start script
+	skip_start := sdef.SkipStart
+	load_grants := sdef.LoadGrants
+	sdef.SkipStart = true
+	sdef.LoadGrants = false
+
+	make_top_dir(sdef.Cleanup, sdef.SandboxDir)
+	logger.Printf("Creating directory %s\n", sdef.SandboxDir)
+	make_dir(sdef.SandboxDir + "/ndb_conf")
+	make_dir(sdef.SandboxDir + "/ndb_data")
+	node_label := defaults.Defaults().NodePrefix
+	data := ndb_data(sdef, master_ip, management_port, base_port, ndb_nodes, nodes)
+
+	sb_desc := common.SandboxDescription{
+		Basedir: sdef.Basedir,
+		SBType:  "ndb",
+		Version: sdef.Version,
+		Port:    []int{management_port},
+		Nodes:   nodes,
+		NodeNum: 0,
+		LogFile: sdef.LogFileName,
+	}
+
+	sb_item := defaults.SandboxItem{
+		Origin:      sb_desc.Basedir,
+		SBType:      sb_desc.SBType,
+		Version:     sdef.Version,
+		Port:        []int{management_port},
+		Nodes:       []string{},
+		Destination: sdef.SandboxDir,
+	}
+
+	if sdef.LogFileName != "" {
+		sb_item.LogDirectory = common.DirName(sdef.LogFileName)
+	}
+
+	for i := 1; i <= ndb_nodes; i++ {
+		node_id := ndb_data_node_id(i)
+		server_port := management_port + i
+		make_dir(fmt.Sprintf("%s/ndb_data/ndbnode%d", sdef.SandboxDir, node_id))
+		sb_desc.Port = append(sb_desc.Port, server_port)
+		sb_item.Port = append(sb_item.Port, server_port)
+	}
+
+	for i := 1; i <= nodes; i++ {
+		sdef.DirName = fmt.Sprintf("%s%d", node_label, i)
+		sdef.Port = base_port + i
+		sdef.ServerId = i * 100
+		// The ports in use are collected from the nodes descriptions.
+		// The first SQL node carries the ports of the cluster.
+		sdef.MorePorts = nil
+		if i == 1 {
+			for port := management_port; port < management_port+cluster_ports; port++ {
+				sdef.MorePorts = append(sdef.MorePorts, port)
+			}
+		}
+		sb_item.Nodes = append(sb_item.Nodes, sdef.DirName)
+		sb_item.Port = append(sb_item.Port, sdef.Port)
+		sb_desc.Port = append(sb_desc.Port, sdef.Port)
+
+		if !sdef.RunConcurrently {
+			installation_message := "Installing %s %d\n"
+			fmt.Printf(installation_message, node_label, i)
+			logger.Printf(installation_message, node_label, i)
+		}
+		sdef.ReplOptions = DeploymentTemplates(sdef, SingleTemplates)["replication_options"].Contents +
+			fmt.Sprintf("\nndbcluster\nndb-connectstring=%s:%d\nndb-nodeid=%d\n", master_ip, management_port, ndb_sql_node_id(ndb_nodes, i))
+		if has_feature(sdef.Version, "mysqlx-default") {
+			sdef.MysqlXPort = base_mysqlx_port + i
+			if !sdef.DisableMysqlX {
+				sb_desc.Port = append(sb_desc.Port, base_mysqlx_port+i)
+				sb_item.Port = append(sb_item.Port, base_mysqlx_port+i)
+				logger.Printf("adding port %d to node %d\n", base_mysqlx_port+i, i)
+			}
+		}
+		sdef.Multi = true
+		sdef.Prompt = fmt.Sprintf("%s%d", node_label, i)
+		sdef.SBType = "ndb-node"
+		sdef.NodeNum = i
+		logger.Printf("Create single sandbox for SQL node %d\n", i)
+		exec_list, err := CreateSingleSandbox(sdef)
+		if err != nil {
+			return err
+		}
+		for _, list := range exec_list {
+			exec_lists = append(exec_lists, list)
+		}
+		data_node := node_script_data(sdef.SandboxDir, i, sdef.Port)
+		logger.Printf("Create node script for node %d\n", i)
+		err = write_script(logger, DeploymentTemplates(sdef, MultipleTemplates), fmt.Sprintf("n%d", i), "node_template", sdef.SandboxDir, data_node, true)
+		if err != nil {
+			return err
+		}
+	}
+	logger.Printf("Writing sandbox description in %s\n", sdef.SandboxDir)
+	write_sandbox_description(sdef.SandboxDir, sb_desc, 0)
+	err = update_catalog(sdef.Cleanup, sdef.SandboxDir, sb_item)
+	if err != nil {
+		return err
+	}
+
+	logger.Printf("Writing NDB cluster configuration and scripts\n")
+	sb_config := ScriptBatch{
+		tc:         DeploymentTemplates(sdef, NdbTemplates),
+		logger:     logger,
+		data:       data,
+		sandboxDir: sdef.SandboxDir + "/ndb_conf",
+		scripts: []ScriptDef{
+			{"config.ini", "ndb_config_template", false},
+		},
+	}
+	sb_ndb := ScriptBatch{
+		tc:         DeploymentTemplates(sdef, NdbTemplates),
+		logger:     logger,
+		data:       data,
+		sandboxDir: sdef.SandboxDir,
+		scripts: []ScriptDef{
+			{"start_cluster", "ndb_start_cluster_template", true},
+			{"stop_cluster", "ndb_stop_cluster_template", true},
+			{"start_all", "ndb_start_template", true},
+			{"stop_all", "ndb_stop_template", true},
+			{"status_all", "ndb_status_template", true},
+			{"ndb_mgm", "ndb_mgm_template", true},
+			{"check_nodes", "ndb_check_nodes_template", true},
+			{"clear_all", "ndb_clear_template", true},
+		},
+	}
+	sb_multiple := ScriptBatch{
+		tc:         DeploymentTemplates(sdef, MultipleTemplates),
+		logger:     logger,
+		data:       data,
+		sandboxDir: sdef.SandboxDir,
+		scripts: []ScriptDef{
+			{"restart_all", "restart_multi_template", true},
+			{"test_sb_all", "test_sb_multi_template", true},
+			{"send_kill_all", "send_kill_multi_template", true},
+			{"use_all", "use_multi_template", true},
+		},
+	}
+	for _, sb := range []ScriptBatch{sb_config, sb_ndb, sb_multiple} {
+		err = write_scripts(sb)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Printf("Running parallel tasks\n")
+	run_tasks_by_priority(exec_lists)
+	if !skip_start {
+		fmt.Println(common.ReplaceLiteralHome(sdef.SandboxDir) + "/start_all")
+		logger.Printf("Starting the cluster\n")
+		run_cmd(sdef.SandboxDir + "/start_all")
+		if load_grants {
+			// Users and grants are not stored in NDB tables,
+			// and must be loaded in every SQL node
+			for i := 1; i <= nodes; i++ {
+				node_dir := fmt.Sprintf("%s/%s%d", sdef.SandboxDir, node_label, i)
+				logger.Printf("Loading grants in node %d\n", i)
+				run_cmd_with_args(node_dir+"/load_grants", []string{"pre_grants.sql"})
+				run_cmd(node_dir + "/load_grants")
+				run_cmd_with_args(node_dir+"/load_grants", []string{"post_grants.sql"})
+			}
+		}
+		run_cmd(sdef.SandboxDir + "/check_nodes")
+	}
+	fmt.Printf("NDB cluster directory installed in %s\n", common.ReplaceLiteralHome(sdef.SandboxDir))
+	fmt.Printf("run 'dbdeployer usage multiple' for basic instructions'\n")
+	return nil
+}
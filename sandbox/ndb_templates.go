@@ -0,0 +1,200 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+// Templates for MySQL NDB Cluster
+
+var (
+	ndb_config_template string = `
+[ndbd default]
+NoOfReplicas={{.NoOfReplicas}}
+DataMemory=80M
+
+[ndb_mgmd]
+NodeId=1
+HostName={{.MasterIp}}
+PortNumber={{.ManagementPort}}
+DataDir={{.SandboxDir}}/ndb_conf
+{{range .DataNodes}}
+[ndbd]
+NodeId={{.NodeId}}
+HostName={{.MasterIp}}
+ServerPort={{.ServerPort}}
+DataDir={{.SandboxDir}}/ndb_data/ndbnode{{.NodeId}}
+{{end}}
+{{range .Nodes}}
+[mysqld]
+NodeId={{.NodeId}}
+HostName={{.MasterIp}}
+{{end}}
+# Free slots for client programs
+[api]
+[api]
+`
+	ndb_start_cluster_template string = `#!/bin/sh
+{{.Copyright}}
+# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
+SBDIR={{.SandboxDir}}
+BASEDIR={{.Basedir}}
+CONNECT_STRING={{.MasterIp}}:{{.ManagementPort}}
+[ -z "$NDB_TIMEOUT" ] && NDB_TIMEOUT=180
+
+# The first start of the cluster uses --initial,
+# which creates the configuration cache and the data node files
+INITIAL=""
+if [ ! -f $SBDIR/ndb_conf/initialized ]
+then
+    INITIAL="--initial"
+fi
+echo 'executing "ndb_mgmd"'
+$BASEDIR/bin/ndb_mgmd --config-file=$SBDIR/ndb_conf/config.ini \
+    --configdir=$SBDIR/ndb_conf --ndb-nodeid=1 $INITIAL
+{{range .DataNodes}}
+echo 'executing "ndbd" on data node {{.NodeId}}'
+$BASEDIR/bin/ndbd --ndb-connectstring=$CONNECT_STRING --ndb-nodeid={{.NodeId}} $INITIAL
+{{end}}
+echo "# waiting for the data nodes"
+$BASEDIR/bin/ndb_waiter --ndb-connectstring=$CONNECT_STRING --timeout=$NDB_TIMEOUT > /dev/null
+if [ "$?" != "0" ]
+then
+    echo "data nodes not started after $NDB_TIMEOUT seconds"
+    exit 1
+fi
+touch $SBDIR/ndb_conf/initialized
+`
+	ndb_stop_cluster_template string = `#!/bin/sh
+{{.Copyright}}
+# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
+BASEDIR={{.Basedir}}
+CONNECT_STRING={{.MasterIp}}:{{.ManagementPort}}
+# Stops the data nodes and the management node
+echo 'executing "shutdown" on the cluster'
+$BASEDIR/bin/ndb_mgm --ndb-connectstring=$CONNECT_STRING -e shutdown
+`
+	ndb_start_template string = `#!/bin/sh
+{{.Copyright}}
+# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
+SBDIR={{.SandboxDir}}
+echo "# executing 'start' on $SBDIR"
+$SBDIR/start_cluster || exit 1
+{{range .Nodes}}
+echo 'executing "start" on {{.NodeLabel}} {{.Node}}'
+$SBDIR/{{.NodeLabel}}{{.Node}}/start "$@"
+{{end}}
+`
+	ndb_stop_template string = `#!/bin/sh
+{{.Copyright}}
+# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
+SBDIR={{.SandboxDir}}
+echo "# executing 'stop' on $SBDIR"
+{{range .Nodes}}
+echo 'executing "stop" on {{.NodeLabel}} {{.Node}}'
+$SBDIR/{{.NodeLabel}}{{.Node}}/stop "$@"
+{{end}}
+$SBDIR/stop_cluster
+`
+	ndb_clear_template string = `#!/bin/sh
+{{.Copyright}}
+# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
+SBDIR={{.SandboxDir}}
+echo "# executing 'clear' on $SBDIR"
+$SBDIR/stop_all
+{{range .Nodes}}
+echo 'executing "clear" on {{.NodeLabel}} {{.Node}}'
+$SBDIR/{{.NodeLabel}}{{.Node}}/clear "$@"
+{{end}}
+# Removes the cluster data, so that the next start will be an initial one
+echo 'removing NDB data'
+rm -f $SBDIR/ndb_conf/initialized $SBDIR/ndb_conf/ndb_1_config.bin.*
+{{range .DataNodes}}
+rm -rf $SBDIR/ndb_data/ndbnode{{.NodeId}}/*
+{{end}}
+`
+	ndb_status_template string = `#!/bin/sh
+{{.Copyright}}
+# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
+SBDIR={{.SandboxDir}}
+echo "NDB CLUSTER  $SBDIR"
+$SBDIR/check_nodes
+{{ range .Nodes }}
+nstatus=$($SBDIR/{{.NodeLabel}}{{.Node}}/status )
+if [ -f $SBDIR/{{.NodeLabel}}{{.Node}}/data/mysql_sandbox{{.NodePort}}.pid ]
+then
+	nport=$($SBDIR/{{.NodeLabel}}{{.Node}}/use -BN -e "show variables like 'port'")
+fi
+echo "{{.NodeLabel}}{{.Node}} : $nstatus  -  $nport ({{.NodePort}})"
+{{end}}
+`
+	ndb_mgm_template string = `#!/bin/sh
+{{.Copyright}}
+# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
+# Runs the NDB management client connected to this cluster
+{{.Basedir}}/bin/ndb_mgm --ndb-connectstring={{.MasterIp}}:{{.ManagementPort}} "$@"
+`
+	ndb_check_nodes_template string = `#!/bin/sh
+{{.Copyright}}
+# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
+SBDIR={{.SandboxDir}}
+$SBDIR/ndb_mgm -e show
+`
+	NdbTemplates = TemplateCollection{
+		"ndb_config_template": TemplateDesc{
+			Description: "Configuration of the NDB cluster (config.ini)",
+			Notes:       "",
+			Contents:    ndb_config_template,
+		},
+		"ndb_start_cluster_template": TemplateDesc{
+			Description: "Starts the management node and the data nodes",
+			Notes:       "",
+			Contents:    ndb_start_cluster_template,
+		},
+		"ndb_stop_cluster_template": TemplateDesc{
+			Description: "Shuts down the data nodes and the management node",
+			Notes:       "",
+			Contents:    ndb_stop_cluster_template,
+		},
+		"ndb_start_template": TemplateDesc{
+			Description: "Starts the management node, the data nodes, and the SQL nodes",
+			Notes:       "",
+			Contents:    ndb_start_template,
+		},
+		"ndb_stop_template": TemplateDesc{
+			Description: "Stops the SQL nodes and shuts down the cluster",
+			Notes:       "",
+			Contents:    ndb_stop_template,
+		},
+		"ndb_clear_template": TemplateDesc{
+			Description: "Removes data from the SQL nodes and from the cluster",
+			Notes:       "",
+			Contents:    ndb_clear_template,
+		},
+		"ndb_status_template": TemplateDesc{
+			Description: "Shows the status of the cluster and of the SQL nodes",
+			Notes:       "",
+			Contents:    ndb_status_template,
+		},
+		"ndb_mgm_template": TemplateDesc{
+			Description: "Runs the NDB management client",
+			Notes:       "",
+			Contents:    ndb_mgm_template,
+		},
+		"ndb_check_nodes_template": TemplateDesc{
+			Description: "Shows the nodes of the cluster",
+			Notes:       "",
+			Contents:    ndb_check_nodes_template,
+		},
+	}
+)
@@ -0,0 +1,473 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+)
+
+// Code in this module starts and stops database servers directly,
+// without going through the sandbox scripts.
+
+const (
+	DefaultStartTimeout = 180 * time.Second
+	DefaultStopTimeout  = 180 * time.Second
+	kill_timeout        = 30 * time.Second
+	poll_interval       = 100 * time.Millisecond
+	mysqld_safe_pid     = "mysqld_safe.pid" // in the sandbox tmp directory
+)
+
+// A database server in a single sandbox directory
+type ServerProcess struct {
+	SandboxDir string
+	Basedir    string
+	Port       int
+	PidFile    string
+	Socket     string
+}
+
+// Returns the path of the dbdeployer executable, to be used in scripts.
+// When the caller is not dbdeployer (e.g. a test), the executable is
+// looked up in the PATH when the script runs.
+func dbdeployer_executable() string {
+	if defaults.UsingDbDeployer {
+		executable, err := os.Executable()
+		if err == nil {
+			return executable
+		}
+	}
+	return "dbdeployer"
+}
+
+// Returns the PID stored in a pid file, or 0 if it can't be read
+func read_pid(pid_file string) int {
+	contents, err := ioutil.ReadFile(pid_file)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil || pid < 0 {
+		return 0
+	}
+	return pid
+}
+
+func process_exists(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// Waits until check() returns true, or the timeout expires
+func wait_for(timeout time.Duration, check func() bool) bool {
+	return wait_for_context(context.Background(), timeout, check)
+}
+
+// Waits until check() returns true, the timeout expires,
+// or the context is done
+func wait_for_context(ctx context.Context, timeout time.Duration, check func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if check() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(poll_interval):
+		}
+	}
+}
+
+// Creates a process manager for the server in a single sandbox directory
+// (either a single sandbox or a node of a multiple one)
+func NewServerProcess(sandbox_dir string) (*ServerProcess, error) {
+	if !common.FileExists(sandbox_dir + "/sbdescription.json") {
+		return nil, fmt.Errorf("Directory %s is not a sandbox", sandbox_dir)
+	}
+	sbd := common.ReadSandboxDescription(sandbox_dir)
+	if sbd.Nodes > 0 || len(sbd.Port) == 0 {
+		return nil, fmt.Errorf("Sandbox %s is not a single server. Use its nodes instead", sandbox_dir)
+	}
+	return &ServerProcess{
+		SandboxDir: sandbox_dir,
+		Basedir:    sbd.Basedir,
+		Port:       sbd.Port[0],
+		PidFile:    fmt.Sprintf("%s/data/mysql_sandbox%d.pid", sandbox_dir, sbd.Port[0]),
+		Socket:     sandbox_socket(sandbox_dir),
+	}, nil
+}
+
+// Returns the PID of the server, if it is running, or 0
+func (p *ServerProcess) Pid() int {
+	pid := read_pid(p.PidFile)
+	if process_exists(pid) {
+		return pid
+	}
+	return 0
+}
+
+// Tells whether the server is running
+func (p *ServerProcess) IsRunning() bool {
+	return p.Pid() > 0
+}
+
+// Removes the pid file and socket left by a server that is no longer running
+func (p *ServerProcess) remove_stale_files() {
+	if common.FileExists(p.PidFile) {
+		os.Remove(p.PidFile)
+		if p.Socket != "" && common.FileExists(p.Socket) {
+			os.Remove(p.Socket)
+		}
+	}
+	os.Remove(p.SandboxDir + "/tmp/" + mysqld_safe_pid)
+}
+
+// Returns the PID of the mysqld_safe process that watches the server, or 0
+func (p *ServerProcess) mysqld_safe_pid() int {
+	pid := read_pid(p.SandboxDir + "/tmp/" + mysqld_safe_pid)
+	if process_exists(pid) {
+		return pid
+	}
+	// The server was started by an older script: look for its mysqld_safe
+	out, err := exec.Command("ps", "-eo", "pid,args").Output()
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.Contains(line, "mysqld_safe") && strings.Contains(line, "defaults-file="+p.SandboxDir+"/") {
+			fields := strings.Fields(line)
+			pid, err := strconv.Atoi(fields[0])
+			if err == nil {
+				return pid
+			}
+		}
+	}
+	return 0
+}
+
+// Starts the server through mysqld_safe, using the sandbox options file.
+// A custom_mysqld, if given, is the name of the server executable in basedir/bin.
+// Extra options in mysqld_args are passed to the server.
+// It waits until both the pid file and the socket exist, and the server
+// accepts connections, or the timeout expires. If the context is done
+// while waiting, the server is stopped and the context error is returned.
+func (p *ServerProcess) Start(ctx context.Context, custom_mysqld string, mysqld_args []string, timeout time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if p.IsRunning() {
+		fmt.Printf("sandbox server already started (found pid file %s)\n", p.PidFile)
+		return nil
+	}
+	p.remove_stale_files()
+	mysqld_safe := p.Basedir + "/bin/mysqld_safe"
+	if !common.ExecExists(mysqld_safe) {
+		return fmt.Errorf("mysqld_safe not found in %s/bin/", p.Basedir)
+	}
+	args := []string{"--defaults-file=" + p.SandboxDir + "/my.sandbox.cnf"}
+	if custom_mysqld != "" {
+		args = append(args, "--mysqld="+custom_mysqld)
+	}
+	args = append(args, mysqld_args...)
+	cmd := exec.Command(mysqld_safe, args...)
+	cmd.Dir = p.Basedir
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("LD_LIBRARY_PATH=%s/lib:%s/lib/mysql:%s", p.Basedir, p.Basedir, os.Getenv("LD_LIBRARY_PATH")),
+		fmt.Sprintf("DYLD_LIBRARY_PATH=%s/lib:%s/lib/mysql:%s", p.Basedir, p.Basedir, os.Getenv("DYLD_LIBRARY_PATH")),
+		// Disables .mylogin.cnf, which would bypass --defaults-file
+		fmt.Sprintf("MYSQL_TEST_LOGIN_FILE=/tmp/dont_break_my_sandboxes%d", os.Getpid()))
+	// The server must survive the end of this process and of its terminal
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if os.Getenv("SBDEBUG") != "" {
+		log_file, err := os.Create(p.SandboxDir + "/start.log")
+		if err != nil {
+			return err
+		}
+		defer log_file.Close()
+		cmd.Stdout = log_file
+		cmd.Stderr = log_file
+	}
+	deadline := time.Now().Add(timeout)
+	err := cmd.Start()
+	if err != nil {
+		return fmt.Errorf("Error starting %s: %s", mysqld_safe, err)
+	}
+	common.WriteString(fmt.Sprintf("%d", cmd.Process.Pid), p.SandboxDir+"/tmp/"+mysqld_safe_pid)
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	// mysqld_safe may exit after launching the server.
+	// Only a failure before the pid file appears is an error.
+	var start_error error
+	attempts := 0
+	started := wait_for_context(ctx, timeout, func() bool {
+		select {
+		case err := <-exited:
+			start_error = err
+		default:
+		}
+		if common.FileExists(p.PidFile) {
+			return p.Socket == "" || common.FileExists(p.Socket)
+		}
+		attempts++
+		if attempts%10 == 0 {
+			fmt.Print(".")
+		}
+		return start_error != nil
+	})
+	if ctx.Err() != nil {
+		fmt.Println(" sandbox server start interrupted")
+		p.Stop(DefaultStopTimeout)
+		return ctx.Err()
+	}
+	if start_error != nil && !common.FileExists(p.PidFile) {
+		fmt.Println(" sandbox server not started")
+		return fmt.Errorf("mysqld_safe failed for %s: %s", p.SandboxDir, start_error)
+	}
+	if !started {
+		fmt.Println(" sandbox server not started yet")
+		return fmt.Errorf("Server in %s not started after %s", p.SandboxDir, timeout)
+	}
+	remaining := time.Until(deadline)
+	if remaining < poll_interval {
+		remaining = poll_interval
+	}
+	err = p.wait_ready(ctx, ReadinessOptions{Timeout: remaining})
+	if ctx.Err() != nil {
+		fmt.Println(" sandbox server start interrupted")
+		p.Stop(DefaultStopTimeout)
+		return ctx.Err()
+	}
+	if err != nil {
+		fmt.Println(" sandbox server not ready")
+		if readiness_error, ok := err.(*ReadinessError); ok && readiness_error.Timeout > 0 {
+			readiness_error.Timeout = timeout
+		}
+		return err
+	}
+	fmt.Println(" sandbox server started")
+	return nil
+}
+
+// Stops the server gracefully, and forces its termination if it does not
+// stop within the timeout.
+func (p *ServerProcess) Stop(timeout time.Duration) error {
+	pid := p.Pid()
+	if pid == 0 {
+		p.remove_stale_files()
+		return nil
+	}
+	fmt.Printf("stop %s\n", p.SandboxDir)
+	// mysqld shuts down cleanly on SIGTERM, and mysqld_safe
+	// does not restart a server after a clean shutdown
+	err := syscall.Kill(pid, syscall.SIGTERM)
+	if err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("Error stopping server %d in %s: %s", pid, p.SandboxDir, err)
+	}
+	if wait_for(timeout, func() bool { return !process_exists(pid) }) {
+		p.remove_stale_files()
+		return nil
+	}
+	return p.Kill()
+}
+
+// Terminates the server forcibly. Its mysqld_safe is killed first,
+// so that it can't restart the server.
+func (p *ServerProcess) Kill() error {
+	safe_pid := p.mysqld_safe_pid()
+	if safe_pid > 0 {
+		syscall.Kill(safe_pid, syscall.SIGKILL)
+	}
+	pid := p.Pid()
+	if pid == 0 {
+		p.remove_stale_files()
+		return nil
+	}
+	fmt.Printf("Attempting normal termination --- kill -15 %d\n", pid)
+	syscall.Kill(pid, syscall.SIGTERM)
+	if !wait_for(kill_timeout, func() bool { return !process_exists(pid) }) {
+		fmt.Printf("SERVER UNRESPONSIVE --- kill -9 %d\n", pid)
+		err := syscall.Kill(pid, syscall.SIGKILL)
+		if err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("Error killing server %d in %s: %s", pid, p.SandboxDir, err)
+		}
+		wait_for(kill_timeout, func() bool { return !process_exists(pid) })
+	}
+	p.remove_stale_files()
+	return nil
+}
+
+// Stops the server, and starts it again with the given options
+func (p *ServerProcess) Restart(ctx context.Context, custom_mysqld string, mysqld_args []string, timeout time.Duration) error {
+	err := p.Stop(DefaultStopTimeout)
+	if err != nil {
+		return err
+	}
+	return p.Start(ctx, custom_mysqld, mysqld_args, timeout)
+}
+
+// Starts the server in a single sandbox directory, with default options
+func start_server(ctx context.Context, sandbox_dir, custom_mysqld string) error {
+	if is_dry_run() {
+		plan_command(sandbox_dir+"/start", nil)
+		return nil
+	}
+	server, err := NewServerProcess(sandbox_dir)
+	if err != nil {
+		return err
+	}
+	return server.Start(ctx, custom_mysqld, nil, DefaultStartTimeout)
+}
+
+// Stops the server in a single sandbox directory
+func stop_server(sandbox_dir string) error {
+	server, err := NewServerProcess(sandbox_dir)
+	if err != nil {
+		return err
+	}
+	return server.Stop(DefaultStopTimeout)
+}
+
+// Stops the server in a single sandbox directory, as a cleanup action
+func stop_server_on_cleanup(sandbox_dir string) {
+	err := stop_server(sandbox_dir)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+	}
+}
+
+// Returns the alternative mysqld recorded for a sandbox, if any
+func sandbox_custom_mysqld(sandbox_dir string) string {
+	dd, err := ReadDeploymentDefinition(sandbox_dir)
+	if err != nil {
+		return ""
+	}
+	return dd.Sdef.CustomMysqld
+}
+
+// Returns the node directories of a multiple sandbox, sorted by node number
+func sorted_node_dirs(sandbox_dir string) []string {
+	nodes := sandbox_nodes(sandbox_dir)
+	var node_nums []int
+	for num := range nodes {
+		node_nums = append(node_nums, num)
+	}
+	sort.Ints(node_nums)
+	var dirs []string
+	for _, num := range node_nums {
+		dirs = append(dirs, sandbox_dir+"/"+nodes[num].Name)
+	}
+	return dirs
+}
+
+// Runs one of the cluster scripts of a NDB sandbox
+func run_ndb_cluster_script(ctx context.Context, sandbox_dir, script string) error {
+	script = sandbox_dir + "/" + script
+	if !common.ExecExists(script) {
+		return fmt.Errorf("Script %s not found. The sandbox was created by an older version of dbdeployer", script)
+	}
+	out, err := exec.CommandContext(ctx, script).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Error running %s: %s\n%s", script, err, out)
+	}
+	return nil
+}
+
+// StartSandboxServers starts the database servers of a sandbox,
+// without using the sandbox scripts.
+// In a multiple sandbox, the nodes are started in order, and
+// the context is checked before each node.
+func StartSandboxServers(ctx context.Context, sandbox_dir string) error {
+	if common.FileExists(sandbox_dir + "/my.sandbox.cnf") {
+		return start_server(ctx, sandbox_dir, sandbox_custom_mysqld(sandbox_dir))
+	}
+	sbd := common.ReadSandboxDescription(sandbox_dir)
+	if sbd.SBType == "ndb" {
+		err := run_ndb_cluster_script(ctx, sandbox_dir, "start_cluster")
+		if err != nil {
+			return err
+		}
+	}
+	node_dirs := sorted_node_dirs(sandbox_dir)
+	if len(node_dirs) == 0 {
+		return fmt.Errorf("No nodes found in sandbox %s", sandbox_dir)
+	}
+	for i, node_dir := range node_dirs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		server, err := NewServerProcess(node_dir)
+		if err != nil {
+			return err
+		}
+		var mysqld_args []string
+		// The first node creates the cluster. The others join it.
+		if i == 0 && (sbd.SBType == GaleraFlavor || sbd.SBType == PxcFlavor) {
+			mysqld_args = []string{"--wsrep-new-cluster"}
+		}
+		err = server.Start(ctx, sandbox_custom_mysqld(node_dir), mysqld_args, DefaultStartTimeout)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StopSandboxServers stops the database servers of a sandbox,
+// without using the sandbox scripts.
+// In a multiple sandbox, the nodes are stopped in reverse order, and
+// the context is checked before each node.
+func StopSandboxServers(ctx context.Context, sandbox_dir string) error {
+	if common.FileExists(sandbox_dir + "/my.sandbox.cnf") {
+		return stop_server(sandbox_dir)
+	}
+	node_dirs := sorted_node_dirs(sandbox_dir)
+	if len(node_dirs) == 0 {
+		return fmt.Errorf("No nodes found in sandbox %s", sandbox_dir)
+	}
+	for i := len(node_dirs) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := stop_server(node_dirs[i])
+		if err != nil {
+			return err
+		}
+	}
+	if common.ReadSandboxDescription(sandbox_dir).SBType == "ndb" {
+		return run_ndb_cluster_script(ctx, sandbox_dir, "stop_cluster")
+	}
+	return nil
+}
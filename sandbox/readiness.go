@@ -0,0 +1,221 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+)
+
+const (
+	CheckConnection = "connection"
+	CheckWritable   = "writable"
+	CheckGroup      = "group membership"
+)
+
+// What makes a server ready, besides accepting connections
+type ReadinessOptions struct {
+	Timeout  time.Duration
+	Writable bool // the server must not be read-only
+	Group    bool // the node must be ONLINE in its replication group
+}
+
+// The reason why a server did not become ready
+type ReadinessError struct {
+	SandboxDir string
+	Check      string // the check that failed
+	Details    string // the last answer from the server
+	Timeout    time.Duration
+}
+
+func (e *ReadinessError) Error() string {
+	message := fmt.Sprintf("Server in %s not ready: %s check failed", e.SandboxDir, e.Check)
+	if e.Timeout > 0 {
+		message += fmt.Sprintf(" after %s", e.Timeout)
+	}
+	if e.Details != "" {
+		message += ": " + e.Details
+	}
+	return message
+}
+
+// Client errors meaning that the server can't be reached yet:
+// 2002 and 2003 (can't connect), 2006 and 2013 (connection lost)
+var not_reachable_re = regexp.MustCompile(`ERROR 20(02|03|06|13)`)
+
+// Client error for a refused login (1045)
+var access_denied_re = regexp.MustCompile(`ERROR 1045`)
+
+// Runs a query with the sandbox client, and returns its output without headers.
+// Until the grants are loaded, the sandbox user does not exist, and only root
+// without password can log in: the query is tried again that way when the
+// sandbox user is refused.
+func (p *ServerProcess) run_query(query string) (string, error) {
+	client := p.Basedir + "/bin/mysql"
+	if !common.ExecExists(client) {
+		return "", fmt.Errorf("client %s not found", client)
+	}
+	result, err := run_client(client, []string{"--defaults-file=" + p.SandboxDir + "/my.sandbox.cnf"}, query)
+	if err != nil && access_denied_re.MatchString(err.Error()) {
+		root_login := []string{"--no-defaults", "--user=root"}
+		if p.Socket != "" {
+			root_login = append(root_login, "--socket="+p.Socket)
+		} else {
+			root_login = append(root_login, "--protocol=tcp", "--host=127.0.0.1", fmt.Sprintf("--port=%d", p.Port))
+		}
+		root_result, root_err := run_client(client, root_login, query)
+		if root_err == nil {
+			return root_result, nil
+		}
+	}
+	return result, err
+}
+
+// Runs the client with the given login options, returning its trimmed output
+func run_client(client string, login []string, query string) (string, error) {
+	args := append(login, "-BN", "-e", query)
+	out, err := exec.Command(client, args...).CombinedOutput()
+	result := strings.TrimSpace(string(out))
+	if err != nil {
+		if result == "" {
+			result = err.Error()
+		}
+		return "", fmt.Errorf("%s", result)
+	}
+	return result, nil
+}
+
+// Runs the readiness checks once. It returns the name of the failed check,
+// if any, with the answer that made it fail. Fatal is set when waiting
+// longer would not help.
+func (p *ServerProcess) probe(options ReadinessOptions) (check, details string, fatal bool) {
+	out, err := p.run_query("SELECT 1")
+	if err != nil {
+		return CheckConnection, err.Error(), !not_reachable_re.MatchString(err.Error())
+	}
+	if out != "1" {
+		return CheckConnection, fmt.Sprintf("unexpected answer '%s' to 'SELECT 1'", out), false
+	}
+	if options.Writable {
+		out, err = p.run_query("SHOW GLOBAL VARIABLES LIKE '%read_only'")
+		if err != nil {
+			return CheckWritable, err.Error(), false
+		}
+		for _, line := range strings.Split(out, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && (fields[0] == "read_only" || fields[0] == "super_read_only") && fields[1] != "OFF" {
+				return CheckWritable, fields[0] + " is " + fields[1], false
+			}
+		}
+	}
+	if options.Group {
+		out, err = p.run_query("SELECT MEMBER_STATE FROM performance_schema.replication_group_members WHERE MEMBER_ID=@@server_uuid")
+		if err != nil {
+			return CheckGroup, err.Error(), false
+		}
+		if out != "ONLINE" {
+			if out == "" {
+				out = "not a member"
+			}
+			return CheckGroup, "member state is " + out, false
+		}
+	}
+	return "", "", false
+}
+
+// Waits until the server accepts connections and passes the requested checks,
+// or the timeout expires. Errors that won't go away by waiting, such as
+// wrong credentials, are reported immediately.
+func (p *ServerProcess) WaitReady(options ReadinessOptions) error {
+	return p.wait_ready(context.Background(), options)
+}
+
+// Same as WaitReady, but it also stops waiting when the context is done
+func (p *ServerProcess) wait_ready(ctx context.Context, options ReadinessOptions) error {
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultStartTimeout
+	}
+	if !p.IsRunning() && !common.FileExists(p.PidFile) {
+		return &ReadinessError{SandboxDir: p.SandboxDir, Check: CheckConnection, Details: "server not running"}
+	}
+	var check, details string
+	var fatal bool
+	ready := wait_for_context(ctx, options.Timeout, func() bool {
+		check, details, fatal = p.probe(options)
+		return check == "" || fatal
+	})
+	if check == "" {
+		return nil
+	}
+	readiness_error := &ReadinessError{SandboxDir: p.SandboxDir, Check: check, Details: details}
+	if !ready {
+		readiness_error.Timeout = options.Timeout
+	}
+	return readiness_error
+}
+
+// WaitForSandbox waits until the server of a single sandbox, or all the
+// nodes of a multiple sandbox, are ready. Group replication nodes must
+// also be ONLINE in their group.
+func WaitForSandbox(sandbox_dir string, options ReadinessOptions) error {
+	if !common.FileExists(sandbox_dir + "/sbdescription.json") {
+		return fmt.Errorf("Directory %s is not a sandbox", sandbox_dir)
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultStartTimeout
+	}
+	var dirs []string
+	if common.ReadSandboxDescription(sandbox_dir).Nodes == 0 {
+		dirs = append(dirs, sandbox_dir)
+	} else {
+		nodes := sandbox_nodes(sandbox_dir)
+		var node_numbers []int
+		for N := range nodes {
+			node_numbers = append(node_numbers, N)
+		}
+		sort.Ints(node_numbers)
+		for _, N := range node_numbers {
+			dirs = append(dirs, sandbox_dir+"/"+nodes[N].Name)
+		}
+	}
+	deadline := time.Now().Add(options.Timeout)
+	for _, dir := range dirs {
+		server, err := NewServerProcess(dir)
+		if err != nil {
+			return err
+		}
+		node_options := options
+		node_options.Timeout = time.Until(deadline)
+		if node_options.Timeout <= 0 {
+			node_options.Timeout = poll_interval
+		}
+		if common.ReadSandboxDescription(dir).SBType == "group-node" {
+			node_options.Group = true
+		}
+		err = server.WaitReady(node_options)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+)
+
+// Returns the ports of the first list that are not in the second one
+func remove_ports(ports, removed []int) []int {
+	var result []int
+	for _, port := range ports {
+		found := false
+		for _, r := range removed {
+			if port == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, port)
+		}
+	}
+	return result
+}
+
+// RemoveNode stops and deletes a node of a multiple or replication
+// sandbox. The node is identified by its directory name (e.g. "node2").
+// The description, the catalog entry, and the deployment definition of
+// the sandbox are updated, and the scripts that operate on all nodes
+// are generated again without the removed node.
+// Masters can't be removed, as their slaves would be left without
+// a source of data.
+func RemoveNode(sandbox_dir, node_name string) error {
+	dd, err := ReadDeploymentDefinition(sandbox_dir)
+	if err != nil {
+		return err
+	}
+	switch dd.Topology {
+	case "multiple", "master-slave", ChainTopology, TreeTopology:
+	default:
+		return fmt.Errorf("Can't remove a node from sandbox %s (topology '%s'). Supported topologies: multiple, master-slave, chain, tree",
+			common.BaseName(sandbox_dir), dd.Topology)
+	}
+	if common.ExecExists(sandbox_dir + "/no_clear_all") {
+		return fmt.Errorf("Sandbox %s is locked", common.BaseName(sandbox_dir))
+	}
+	nodes := sandbox_nodes(sandbox_dir)
+	node_num := 0
+	for N, node := range nodes {
+		if node.Name == node_name {
+			node_num = N
+		}
+	}
+	if node_num == 0 {
+		return fmt.Errorf("Node %s not found in sandbox %s", node_name, common.BaseName(sandbox_dir))
+	}
+	node := nodes[node_num]
+	min_nodes := 1
+	switch dd.Topology {
+	case "master-slave":
+		if node_num == 1 {
+			return fmt.Errorf("The master of sandbox %s can't be removed", common.BaseName(sandbox_dir))
+		}
+		min_nodes = 2
+	case ChainTopology, TreeTopology:
+		if node_num == 1 || has_slaves(dd, node_num) {
+			return fmt.Errorf("Node %s is a master in sandbox %s and can't be removed", node_name, common.BaseName(sandbox_dir))
+		}
+		min_nodes = 2
+	}
+	if len(nodes) <= min_nodes {
+		return fmt.Errorf("Sandbox %s has only %d nodes. Use 'dbdeployer delete' to remove the whole sandbox",
+			common.BaseName(sandbox_dir), len(nodes))
+	}
+
+	_, logger := defaults.NewLogger(common.LogDirName(), "remove-node")
+	logger.Printf("Removing node %d (%s) from %s\n", node_num, node_name, sandbox_dir)
+	node_dir := sandbox_dir + "/" + node_name
+	fmt.Printf("Stopping %s\n", node_name)
+	err = stop_server(node_dir)
+	if err != nil {
+		return fmt.Errorf("Error stopping %s: %s", node_name, err)
+	}
+	err = os.RemoveAll(node_dir)
+	if err != nil {
+		return fmt.Errorf("Error removing %s: %s", node_dir, err)
+	}
+	scripts := []string{fmt.Sprintf("n%d", node_num), "initialize_" + node_name}
+	if dd.Topology == "master-slave" {
+		scripts = append(scripts, fmt.Sprintf("s%d", node_num-1))
+	}
+	for _, script := range scripts {
+		if common.FileExists(sandbox_dir + "/" + script) {
+			logger.Printf("Removing script %s\n", script)
+			os.Remove(sandbox_dir + "/" + script)
+		}
+	}
+
+	// The ports of the node become available to other sandboxes
+	sb_desc := common.ReadSandboxDescription(sandbox_dir)
+	sb_desc.Nodes--
+	sb_desc.Port = remove_ports(sb_desc.Port, node.Description.Port)
+	common.WriteSandboxDescription(sandbox_dir, sb_desc)
+	catalog, err := defaults.ReadCatalog()
+	if err != nil {
+		return err
+	}
+	if sb_item, ok := catalog[sandbox_dir]; ok {
+		var catalog_nodes []string
+		for _, name := range sb_item.Nodes {
+			if name != node_name {
+				catalog_nodes = append(catalog_nodes, name)
+			}
+		}
+		sb_item.Nodes = catalog_nodes
+		sb_item.Port = remove_ports(sb_item.Port, node.Description.Port)
+		err = defaults.UpdateCatalog(sandbox_dir, sb_item)
+		if err != nil {
+			return err
+		}
+	}
+	if dd.Topology == TreeTopology {
+		masters, err := replication_tree_masters(dd)
+		if err != nil {
+			return err
+		}
+		delete(masters, node_num)
+		dd.Sdef.ReplicationTree = format_replication_tree(masters)
+	}
+	// Only the last node of a chain can be removed, and
+	// what remains is still a chain
+	dd.Nodes--
+	err = WriteDeploymentDefinition(sandbox_dir, dd)
+	if err != nil {
+		return err
+	}
+	delete(nodes, node_num)
+	parent_sdef := dd.Sdef
+	parent_sdef.SandboxDir = sandbox_dir
+	return write_all_nodes_scripts(logger, parent_sdef, dd, nodes)
+}
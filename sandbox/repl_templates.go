@@ -509,6 +509,77 @@ fi
 exit 0
 `
 
+	tree_init_slaves_template string = `#!/bin/sh
+{{.Copyright}}
+# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
+SBDIR={{.SandboxDir}}
+cd $SBDIR
+
+# Replication starts from the beginning of each master's binary logs
+$SBDIR/use_all 'reset master'
+
+{{ range .Slaves }}
+# workaround for Bug#89959
+$SBDIR/{{.NodeLabel}}{{.MasterNode}}/use -h {{.MasterIp}} -u {{.RplUser}} -p{{.RplPassword}} -e 'set @a=1'
+echo "initializing {{.NodeLabel}}{{.Node}} as slave of {{.NodeLabel}}{{.MasterNode}}"
+echo 'CHANGE MASTER TO  master_host="{{.MasterIp}}",  master_port={{.MasterPort}},  master_user="{{.RplUser}}",  master_password="{{.RplPassword}}" {{.MasterAutoPosition}} {{.ChangeMasterExtra}}' | $SBDIR/{{.NodeLabel}}{{.Node}}/use -u root
+$SBDIR/{{.NodeLabel}}{{.Node}}/use -u root -e 'START SLAVE'
+{{end}}
+`
+	tree_check_slaves_template string = `#!/bin/sh
+{{.Copyright}}
+# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
+SBDIR={{.SandboxDir}}
+{{ range .TreeNodes }}
+echo "{{.Indent}}# {{.NodeLabel}}{{.Node}}{{if .MasterNode}} - slave of {{.NodeLabel}}{{.MasterNode}}{{end}}{{if .SlaveList}} - master of {{.SlaveList}}{{end}}"
+port=$($SBDIR/{{.NodeLabel}}{{.Node}}/use -BN -e "show variables like 'port'")
+server_id=$($SBDIR/{{.NodeLabel}}{{.Node}}/use -BN -e "show variables like 'server_id'")
+echo "{{.Indent}}$port - $server_id"
+{{if .SlaveList}}$SBDIR/{{.NodeLabel}}{{.Node}}/use -e 'show master status\G' | grep "File\|Position\|Executed" | sed 's/^/{{.Indent}}/'{{end}}
+{{if .MasterNode}}$SBDIR/{{.NodeLabel}}{{.Node}}/use -e 'show slave status\G' | grep "\(Running:\|Master_Port\|Master_Log_Pos\|\<Master_Log_File\|Retrieved\|Executed\|Auto_Position\)" | sed 's/^/{{.Indent}}/'{{end}}
+{{end}}
+`
+	add_slave_template string = `#!/bin/sh
+{{.Copyright}}
+# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
+
+# Seeds {{.SlaveDir}} with the data of {{.MasterDir}}, and starts replication.
+# This script is called by 'dbdeployer admin add-slave'
+SBDIR={{.SandboxDir}}
+MASTER=$SBDIR/{{.MasterDir}}
+SLAVE=$SBDIR/{{.SlaveDir}}
+DUMP_FILE=$SLAVE/seed_dump.sql
+cd $SBDIR
+
+echo "# dumping data from {{.MasterDir}}"
+$MASTER/my sqldump --all-databases --single-transaction --routines --triggers --events --flush-privileges {{.DumpOptions}} > $DUMP_FILE
+exit_code=$?
+if [ "$exit_code" != "0" ]
+then
+	echo "Error dumping data from {{.MasterDir}}"
+	exit $exit_code
+fi
+# workaround for Bug#89959
+$MASTER/use -h {{.MasterIp}} -u {{.RplUser}} -p{{.RplPassword}} -e 'set @a=1'
+
+# The new node has no grants yet: root is running without password
+export NOPASSWORD=1
+echo "# initializing {{.SlaveDir}} as slave of {{.MasterDir}}"
+$SLAVE/use -u root -e 'RESET MASTER'
+echo 'CHANGE MASTER TO  master_host="{{.MasterIp}}",  master_port={{.MasterPort}},  master_user="{{.RplUser}}",  master_password="{{.RplPassword}}" {{.MasterAutoPosition}} {{.ChangeMasterExtra}}' | $SLAVE/use -u root
+echo "# loading data into {{.SlaveDir}}"
+$SLAVE/use -u root < $DUMP_FILE
+exit_code=$?
+if [ "$exit_code" != "0" ]
+then
+	echo "Error loading data into {{.SlaveDir}}"
+	exit $exit_code
+fi
+# The dump has loaded the grants of the master
+unset NOPASSWORD
+$SLAVE/use -u root -e 'START SLAVE'
+rm -f $DUMP_FILE
+`
 	ReplicationTemplates = TemplateCollection{
 		"init_slaves_template": TemplateDesc{
 			Description: "Initialize slaves after deployment",
@@ -615,5 +686,20 @@ exit 0
 			Notes:       "fan-in and all-masters",
 			Contents:    check_multi_source_template,
 		},
+		"add_slave_template": TemplateDesc{
+			Description: "Seeds a new slave with the data of its master",
+			Notes:       "Used by 'admin add-slave'",
+			Contents:    add_slave_template,
+		},
+		"tree_init_slaves_template": TemplateDesc{
+			Description: "Initializes slaves in chain, tree, and ring replication",
+			Notes:       "Each slave replicates from its own master",
+			Contents:    tree_init_slaves_template,
+		},
+		"tree_check_slaves_template": TemplateDesc{
+			Description: "Checks replication status following the replication tree",
+			Notes:       "chain, tree, and ring",
+			Contents:    tree_check_slaves_template,
+		},
 	}
 )
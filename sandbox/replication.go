@@ -17,6 +17,7 @@ package sandbox
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/datacharmer/dbdeployer/common"
@@ -32,59 +33,85 @@ type Slave struct {
 	MasterPort int
 }
 
-func CreateMasterSlaveReplication(sdef SandboxDef, origin string, nodes int, master_ip string) {
-
-	var exec_lists []concurrent.ExecutionList
+// Returns the node numbers from first to last, inclusive
+func node_range(first, last int) (list []int) {
+	for N := first; N <= last; N++ {
+		list = append(list, N)
+	}
+	return list
+}
 
-	fname, logger := defaults.NewLogger(common.LogDirName(), "master-slave-replication")
-	sdef.LogFileName = fname
-	sdef.ReplOptions = SingleTemplates["replication_options"].Contents
-	vList := common.VersionToList(sdef.Version)
-	rev := vList[2]
-	base_port := sdef.Port + defaults.Defaults().MasterSlaveBasePort + (rev * 100)
-	if sdef.BasePort > 0 {
-		base_port = sdef.BasePort
+// Checks that the options for single nodes refer to existing nodes
+func check_per_node_options(sdef SandboxDef, nodes int) error {
+	for N := range sdef.PerNodeOptions {
+		if N < 1 || N > nodes {
+			return fmt.Errorf("Options given for node %d. Valid nodes: 1 to %d", N, nodes)
+		}
 	}
-	base_server_id := 0
-	sdef.DirName = defaults.Defaults().MasterName
-	// FindFreePort returns the first free port, but base_port will be used
-	// with a counter. Thus the availability will be checked using
-	// "base_port + 1"
-	first_port := common.FindFreePort(base_port+1, sdef.InstalledPorts, nodes)
-	base_port = first_port - 1
-	base_mysqlx_port := get_base_mysqlx_port(base_port, sdef, nodes)
-	for check_port := base_port + 1; check_port < base_port+nodes+1; check_port++ {
-		CheckPort("CreateMasterSlaveReplication", sdef.SandboxDir, sdef.InstalledPorts, check_port)
+	return nil
+}
+
+// Combines the options for all nodes, for masters, for slaves, and for
+// single nodes into one list per node number.
+// The options for a single node come last, so that they take precedence.
+func node_options_by_role(sdef SandboxDef, nodes int, masters, slaves []int) map[int][]string {
+	options := make(map[int][]string)
+	for N := 1; N <= nodes; N++ {
+		var node_options []string
+		node_options = append(node_options, sdef.NodeOptions...)
+		for _, M := range masters {
+			if M == N {
+				node_options = append(node_options, sdef.MasterOptions...)
+			}
+		}
+		for _, S := range slaves {
+			if S == N {
+				node_options = append(node_options, sdef.SlaveOptions...)
+			}
+		}
+		node_options = append(node_options, sdef.PerNodeOptions[N]...)
+		if len(node_options) > 0 {
+			options[N] = node_options
+		}
 	}
+	return options
+}
 
-	if nodes < 2 {
-		common.Exit(1, "Can't run replication with less than 2 nodes")
+// Returns the node numbers of a map of ports, in ascending order
+func sorted_nodes(node_ports map[int]int) []int {
+	var nodes []int
+	for N := range node_ports {
+		nodes = append(nodes, N)
 	}
-	common.Mkdir(sdef.SandboxDir)
-	logger.Printf("Created directory %s\n", sdef.SandboxDir)
-	logger.Printf("Replication Sandbox Definition: %s\n", SandboxDefToJson(sdef))
-	common.AddToCleanupStack(common.Rmdir, "Rmdir", sdef.SandboxDir)
-	sdef.Port = base_port + 1
-	sdef.ServerId = (base_server_id + 1) * 100
-	sdef.LoadGrants = false
-	master_port := sdef.Port
-	change_master_extra := ""
-	master_auto_position := ""
+	sort.Ints(nodes)
+	return nodes
+}
+
+// Returns the clauses that CHANGE MASTER needs for the slaves of a sandbox:
+// MASTER_AUTO_POSITION when GTID is enabled, and the ones that depend on the version
+func change_master_options(logger *defaults.Logger, sdef SandboxDef) (master_auto_position, change_master_extra string) {
 	if sdef.GtidOptions != "" {
 		master_auto_position += ", MASTER_AUTO_POSITION=1"
 		logger.Printf("Adding MASTER_AUTO_POSITION to slaves setup\n")
 	}
-	if common.GreaterOrEqualVersion(sdef.Version, []int{8, 0, 4}) {
+	if has_feature(sdef.Version, "caching-sha2-password") {
 		if !sdef.NativeAuthPlugin {
 			change_master_extra += ", GET_MASTER_PUBLIC_KEY=1"
 			logger.Printf("Adding GET_MASTER_PUBLIC_KEY to slaves setup \n")
 		}
 	}
-	slaves := nodes - 1
+	return
+}
+
+// Returns the data used by the master-slave scripts, given the port of
+// the master and the port of each slave
+func master_slave_data(logger *defaults.Logger, sdef SandboxDef, master_ip string, master_port int, slave_ports map[int]int) common.Smap {
+	master_auto_position, change_master_extra := change_master_options(logger, sdef)
 	master_abbr := defaults.Defaults().MasterAbbr
 	master_label := defaults.Defaults().MasterName
 	slave_label := defaults.Defaults().SlavePrefix
 	slave_abbr := defaults.Defaults().SlaveAbbr
+	node_label := defaults.Defaults().NodePrefix
 	timestamp := time.Now()
 
 	var data common.Smap = common.Smap{
@@ -93,7 +120,7 @@ func CreateMasterSlaveReplication(sdef SandboxDef, origin string, nodes int, mas
 		"DateTime":           timestamp.Format(time.UnixDate),
 		"SandboxDir":         sdef.SandboxDir,
 		"MasterLabel":        master_label,
-		"MasterPort":         sdef.Port,
+		"MasterPort":         master_port,
 		"SlaveLabel":         slave_label,
 		"MasterAbbr":         master_abbr,
 		"MasterIp":           master_ip,
@@ -104,6 +131,128 @@ func CreateMasterSlaveReplication(sdef SandboxDef, origin string, nodes int, mas
 		"MasterAutoPosition": master_auto_position,
 		"Slaves":             []common.Smap{},
 	}
+	for _, N := range sorted_nodes(slave_ports) {
+		data["Slaves"] = append(data["Slaves"].([]common.Smap), common.Smap{
+			"Copyright":          Copyright,
+			"AppVersion":         common.VersionDef,
+			"DateTime":           timestamp.Format(time.UnixDate),
+			"Node":               N,
+			"NodeLabel":          node_label,
+			"NodePort":           slave_ports[N],
+			"SlaveLabel":         slave_label,
+			"MasterAbbr":         master_abbr,
+			"SlaveAbbr":          slave_abbr,
+			"SandboxDir":         sdef.SandboxDir,
+			"MasterPort":         master_port,
+			"MasterIp":           master_ip,
+			"ChangeMasterExtra":  change_master_extra,
+			"MasterAutoPosition": master_auto_position,
+			"RplUser":            sdef.RplUser,
+			"RplPassword":        sdef.RplPassword})
+	}
+	return data
+}
+
+// Writes the scripts that operate on all the nodes of a master-slave sandbox
+func write_master_slave_scripts(logger *defaults.Logger, sdef SandboxDef, data common.Smap) error {
+	sandbox_dir := data["SandboxDir"].(string)
+	slave_label := data["SlaveLabel"].(string)
+	slave_abbr := data["SlaveAbbr"].(string)
+	for _, data_slave := range data["Slaves"].([]common.Smap) {
+		N := data_slave["Node"].(int)
+		logger.Printf("Create slave script %d\n", N)
+		err := write_scripts(ScriptBatch{
+			tc:         DeploymentTemplates(sdef, ReplicationTemplates),
+			logger:     logger,
+			data:       data_slave,
+			sandboxDir: sandbox_dir,
+			scripts: []ScriptDef{
+				{fmt.Sprintf("%s%d", slave_abbr, N), "slave_template", true},
+				{fmt.Sprintf("n%d", N+1), "slave_template", true},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return write_scripts(ScriptBatch{
+		tc:         DeploymentTemplates(sdef, ReplicationTemplates),
+		logger:     logger,
+		data:       data,
+		sandboxDir: sandbox_dir,
+		scripts: []ScriptDef{
+			{"start_all", "start_all_template", true},
+			{"restart_all", "restart_all_template", true},
+			{"status_all", "status_all_template", true},
+			{"test_sb_all", "test_sb_all_template", true},
+			{"stop_all", "stop_all_template", true},
+			{"clear_all", "clear_all_template", true},
+			{"send_kill_all", "send_kill_all_template", true},
+			{"use_all", "use_all_template", true},
+			{"use_all_slaves", "use_all_slaves_template", true},
+			{"use_all_masters", "use_all_masters_template", true},
+			{"initialize_" + slave_label + "s", "init_slaves_template", true},
+			{"check_" + slave_label + "s", "check_slaves_template", true},
+			{data["MasterAbbr"].(string), "master_template", true},
+			{"n1", "master_template", true},
+			{"test_replication", "test_replication_template", true},
+		},
+	})
+}
+
+func CreateMasterSlaveReplication(sdef SandboxDef, origin string, nodes int, master_ip string) (err error) {
+	finish_cleanup := start_cleanup(&sdef)
+	defer func() {
+		finish_cleanup(err)
+	}()
+
+	var exec_lists []concurrent.ExecutionList
+
+	fname, logger := defaults.NewLogger(common.LogDirName(), "master-slave-replication")
+	sdef.LogFileName = fname
+	sdef.ReplOptions = DeploymentTemplates(sdef, SingleTemplates)["replication_options"].Contents
+	vList := common.VersionToList(sdef.Version)
+	rev := vList[2]
+	base_port := sdef.Port + defaults.Defaults().MasterSlaveBasePort + (rev * 100)
+	if sdef.BasePort > 0 {
+		base_port = sdef.BasePort
+	}
+	base_server_id := 0
+	sdef.DirName = defaults.Defaults().MasterName
+	// FindFreePort returns the first free port, but base_port will be used
+	// with a counter. Thus the availability will be checked using
+	// "base_port + 1"
+	first_port := common.FindFreePort(base_port+1, sdef.InstalledPorts, nodes)
+	base_port = first_port - 1
+	base_mysqlx_port, err := get_base_mysqlx_port(base_port, sdef, nodes)
+	if err != nil {
+		return err
+	}
+	for check_port := base_port + 1; check_port < base_port+nodes+1; check_port++ {
+		err = CheckPort("CreateMasterSlaveReplication", sdef.SandboxDir, sdef.InstalledPorts, check_port)
+		if err != nil {
+			return err
+		}
+	}
+
+	if nodes < 2 {
+		return fmt.Errorf("Can't run replication with less than 2 nodes")
+	}
+	make_top_dir(sdef.Cleanup, sdef.SandboxDir)
+	logger.Printf("Created directory %s\n", sdef.SandboxDir)
+	logger.Printf("Replication Sandbox Definition: %s\n", SandboxDefToJson(sdef))
+	sdef.Port = base_port + 1
+	sdef.ServerId = (base_server_id + 1) * 100
+	sdef.LoadGrants = false
+	master_port := sdef.Port
+	slaves := nodes - 1
+	master_label := defaults.Defaults().MasterName
+	slave_label := defaults.Defaults().SlavePrefix
+	slave_ports := make(map[int]int)
+	for i := 1; i <= slaves; i++ {
+		slave_ports[i] = base_port + i + 1
+	}
+	data := master_slave_data(logger, sdef, master_ip, master_port, slave_ports)
 
 	logger.Printf("Defining replication data: %v\n", SmapToJson(data))
 	installation_message := "Installing and starting %s\n"
@@ -114,13 +263,17 @@ func CreateMasterSlaveReplication(sdef SandboxDef, origin string, nodes int, mas
 		fmt.Printf(installation_message, master_label)
 		logger.Printf(installation_message, master_label)
 	}
+	sdef.PerNodeOptions = node_options_by_role(sdef, nodes, []int{1}, node_range(2, nodes))
 	sdef.LoadGrants = true
 	sdef.Multi = true
 	sdef.Prompt = master_label
 	sdef.NodeNum = 1
 	sdef.SBType = "replication-node"
 	logger.Printf("Creating single sandbox for master\n")
-	exec_list := CreateSingleSandbox(sdef)
+	exec_list, err := CreateSingleSandbox(sdef)
+	if err != nil {
+		return err
+	}
 	for _, list := range exec_list {
 		exec_lists = append(exec_lists, list)
 	}
@@ -148,7 +301,7 @@ func CreateMasterSlaveReplication(sdef SandboxDef, origin string, nodes int, mas
 		sb_item.LogDirectory = common.DirName(sdef.LogFileName)
 	}
 
-	if common.GreaterOrEqualVersion(sdef.Version, []int{8, 0, 11}) {
+	if has_feature(sdef.Version, "mysqlx-default") {
 		sdef.MysqlXPort = base_mysqlx_port + 1
 		if !sdef.DisableMysqlX {
 			sb_desc.Port = append(sb_desc.Port, base_mysqlx_port+1)
@@ -160,23 +313,6 @@ func CreateMasterSlaveReplication(sdef SandboxDef, origin string, nodes int, mas
 	node_label := defaults.Defaults().NodePrefix
 	for i := 1; i <= slaves; i++ {
 		sdef.Port = base_port + i + 1
-		data["Slaves"] = append(data["Slaves"].([]common.Smap), common.Smap{
-			"Copyright":          Copyright,
-			"AppVersion":         common.VersionDef,
-			"DateTime":           timestamp.Format(time.UnixDate),
-			"Node":               i,
-			"NodeLabel":          node_label,
-			"NodePort":           sdef.Port,
-			"SlaveLabel":         slave_label,
-			"MasterAbbr":         master_abbr,
-			"SlaveAbbr":          slave_abbr,
-			"SandboxDir":         sdef.SandboxDir,
-			"MasterPort":         master_port,
-			"MasterIp":           master_ip,
-			"ChangeMasterExtra":  change_master_extra,
-			"MasterAutoPosition": master_auto_position,
-			"RplUser":            sdef.RplUser,
-			"RplPassword":        sdef.RplPassword})
 		sdef.LoadGrants = false
 		sdef.Prompt = fmt.Sprintf("%s%d", slave_label, i)
 		sdef.DirName = fmt.Sprintf("%s%d", node_label, i)
@@ -185,7 +321,7 @@ func CreateMasterSlaveReplication(sdef SandboxDef, origin string, nodes int, mas
 		sb_item.Nodes = append(sb_item.Nodes, sdef.DirName)
 		sb_item.Port = append(sb_item.Port, sdef.Port)
 		sb_desc.Port = append(sb_desc.Port, sdef.Port)
-		if common.GreaterOrEqualVersion(sdef.Version, []int{8, 0, 11}) {
+		if has_feature(sdef.Version, "mysqlx-default") {
 			sdef.MysqlXPort = base_mysqlx_port + i + 1
 			if !sdef.DisableMysqlX {
 				sb_desc.Port = append(sb_desc.Port, base_mysqlx_port+i+1)
@@ -203,74 +339,65 @@ func CreateMasterSlaveReplication(sdef SandboxDef, origin string, nodes int, mas
 			logger.Printf(installation_message, slave_label, i)
 		}
 		if sdef.SemiSyncOptions != "" {
-			sdef.SemiSyncOptions = SingleTemplates["semisync_slave_options"].Contents
+			sdef.SemiSyncOptions = DeploymentTemplates(sdef, SingleTemplates)["semisync_slave_options"].Contents
 		}
 		logger.Printf("Creating single sandbox for slave %d\n", i)
-		exec_list_node := CreateSingleSandbox(sdef)
+		exec_list_node, err := CreateSingleSandbox(sdef)
+		if err != nil {
+			return err
+		}
 		for _, list := range exec_list_node {
 			exec_lists = append(exec_lists, list)
 		}
-		var data_slave common.Smap = common.Smap{
-			"Copyright":          Copyright,
-			"AppVersion":         common.VersionDef,
-			"DateTime":           timestamp.Format(time.UnixDate),
-			"Node":               i,
-			"NodeLabel":          node_label,
-			"NodePort":           sdef.Port,
-			"SlaveLabel":         slave_label,
-			"MasterAbbr":         master_abbr,
-			"ChangeMasterExtra":  change_master_extra,
-			"MasterAutoPosition": master_auto_position,
-			"SlaveAbbr":          slave_abbr,
-			"SandboxDir":         sdef.SandboxDir,
-		}
-		logger.Printf("Defining replication node data: %v\n", SmapToJson(data_slave))
-		logger.Printf("Create slave script %d\n", i)
-		write_script(logger, ReplicationTemplates, fmt.Sprintf("%s%d", slave_abbr, i), "slave_template", sdef.SandboxDir, data_slave, true)
-		write_script(logger, ReplicationTemplates, fmt.Sprintf("n%d", i+1), "slave_template", sdef.SandboxDir, data_slave, true)
 	}
-	common.WriteSandboxDescription(sdef.SandboxDir, sb_desc)
+	write_sandbox_description(sdef.SandboxDir, sb_desc, 0)
 	logger.Printf("Create sandbox description\n")
-	defaults.UpdateCatalog(sdef.SandboxDir, sb_item)
+	err = update_catalog(sdef.Cleanup, sdef.SandboxDir, sb_item)
+	if err != nil {
+		return err
+	}
 
 	initialize_slaves := "initialize_" + slave_label + "s"
-	check_slaves := "check_" + slave_label + "s"
 
 	if sdef.SemiSyncOptions != "" {
-		write_script(logger, ReplicationTemplates, "post_initialization", "semi_sync_start_template", sdef.SandboxDir, data, true)
+		err = write_script(logger, DeploymentTemplates(sdef, ReplicationTemplates), "post_initialization", "semi_sync_start_template", sdef.SandboxDir, data, true)
+		if err != nil {
+			return err
+		}
 	}
 	logger.Printf("Create replication scripts\n")
-	write_script(logger, ReplicationTemplates, "start_all", "start_all_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, "restart_all", "restart_all_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, "status_all", "status_all_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, "test_sb_all", "test_sb_all_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, "stop_all", "stop_all_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, "clear_all", "clear_all_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, "send_kill_all", "send_kill_all_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, "use_all", "use_all_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, "use_all_slaves", "use_all_slaves_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, "use_all_masters", "use_all_masters_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, initialize_slaves, "init_slaves_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, check_slaves, "check_slaves_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, master_abbr, "master_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, "n1", "master_template", sdef.SandboxDir, data, true)
-	write_script(logger, ReplicationTemplates, "test_replication", "test_replication_template", sdef.SandboxDir, data, true)
+	err = write_master_slave_scripts(logger, sdef, data)
+	if err != nil {
+		return err
+	}
 	logger.Printf("Run concurrent sandbox scripts \n")
-	concurrent.RunParallelTasksByPriority(exec_lists)
+	run_tasks_by_priority(exec_lists)
 	if !sdef.SkipStart {
 		fmt.Println(common.ReplaceLiteralHome(sdef.SandboxDir) + "/" + initialize_slaves)
 		logger.Printf("Run replication initialization script \n")
-		common.Run_cmd(sdef.SandboxDir + "/" + initialize_slaves)
+		run_cmd(sdef.SandboxDir + "/" + initialize_slaves)
 	}
 	fmt.Printf("Replication directory installed in %s\n", common.ReplaceLiteralHome(sdef.SandboxDir))
 	fmt.Printf("run 'dbdeployer usage multiple' for basic instructions'\n")
+	return nil
 }
 
-func CreateReplicationSandbox(sdef SandboxDef, origin string, topology string, nodes int, master_ip, master_list, slave_list string) {
+func CreateReplicationSandbox(sdef SandboxDef, origin string, topology string, nodes int, master_ip, master_list, slave_list string) (err error) {
+
+	original_sdef := sdef
+	finish_cleanup := start_cleanup(&sdef)
+	defer func() {
+		finish_cleanup(err)
+	}()
 
 	Basedir := sdef.Basedir
 	if !common.DirExists(Basedir) {
-		common.Exitf(1, "Base directory %s does not exist", Basedir)
+		return &MissingBasedirError{Basedir: Basedir}
+	}
+
+	err = check_per_node_options(sdef, nodes)
+	if err != nil {
+		return err
 	}
 
 	sandbox_dir := sdef.SandboxDir
@@ -283,28 +410,52 @@ func CreateReplicationSandbox(sdef SandboxDef, origin string, topology string, n
 		} else {
 			sdef.SandboxDir += "/" + defaults.Defaults().GroupPrefix + common.VersionToName(origin)
 		}
-		if !common.GreaterOrEqualVersion(sdef.Version, []int{5, 7, 17}) {
-			common.Exit(1, "Group replication requires MySQL 5.7.17 or greater")
+		if err := check_feature(sdef.Version, "group-replication", "Group replication"); err != nil {
+			return err
 		}
 	case "fan-in":
-		if !common.GreaterOrEqualVersion(sdef.Version, []int{5, 7, 9}) {
-			common.Exit(1, "multi-source replication requires MySQL 5.7.9 or greater")
+		if err := check_feature(sdef.Version, "multi-source-replication", "multi-source replication"); err != nil {
+			return err
 		}
 		sdef.SandboxDir += "/" + defaults.Defaults().FanInPrefix + common.VersionToName(origin)
 	case "all-masters":
-		if !common.GreaterOrEqualVersion(sdef.Version, []int{5, 7, 9}) {
-			common.Exit(1, "multi-source replication requires MySQL 5.7.9 or greater")
+		if err := check_feature(sdef.Version, "multi-source-replication", "multi-source replication"); err != nil {
+			return err
 		}
 		sdef.SandboxDir += "/" + defaults.Defaults().AllMastersPrefix + common.VersionToName(origin)
+	case GaleraFlavor:
+		if !is_galera_version(sdef.Version) {
+			return &UnsupportedVersionError{Feature: "Galera cluster", Version: sdef.Version, MinVersion: "10.1.0 (MariaDB) or 5.6.0"}
+		}
+		sdef.SandboxDir += "/" + defaults.Defaults().GaleraPrefix + common.VersionToName(origin)
+	case PxcFlavor:
+		if err := check_feature(sdef.Version, "pxc", "Percona XtraDB Cluster"); err != nil {
+			return err
+		}
+		sdef.SandboxDir += "/" + defaults.Defaults().PxcPrefix + common.VersionToName(origin)
+	case "ndb":
+		sdef.SandboxDir += "/" + defaults.Defaults().NdbPrefix + common.VersionToName(origin)
+	case ChainTopology:
+		sdef.SandboxDir += "/" + defaults.Defaults().ChainPrefix + common.VersionToName(origin)
+	case RingTopology:
+		sdef.SandboxDir += "/" + defaults.Defaults().RingPrefix + common.VersionToName(origin)
+	case TreeTopology:
+		if sdef.ReplicationTree == "" {
+			return fmt.Errorf("Topology 'tree' requires a replication tree (e.g. \"1:2,3;2:4,5\")")
+		}
+		sdef.SandboxDir += "/" + defaults.Defaults().TreePrefix + common.VersionToName(origin)
 	default:
-		common.Exit(1, "Unrecognized topology. Accepted: 'master-slave', 'group', 'fan-in', 'all-masters'")
+		return fmt.Errorf("Unrecognized topology. Accepted: 'master-slave', 'group', 'fan-in', 'all-masters', 'galera', 'pxc', 'ndb', 'chain', 'tree', 'ring'")
 	}
 	if sdef.DirName != "" {
 		sdef.SandboxDir = sandbox_dir + "/" + sdef.DirName
 	}
 
 	if common.DirExists(sdef.SandboxDir) {
-		sdef = CheckDirectory(sdef)
+		sdef, err = CheckDirectory(sdef)
+		if err != nil {
+			return err
+		}
 	}
 
 	if sdef.HistoryDir == "REPL_DIR" {
@@ -312,12 +463,75 @@ func CreateReplicationSandbox(sdef SandboxDef, origin string, topology string, n
 	}
 	switch topology {
 	case "master-slave":
-		CreateMasterSlaveReplication(sdef, origin, nodes, master_ip)
+		err = CreateMasterSlaveReplication(sdef, origin, nodes, master_ip)
 	case "group":
-		CreateGroupReplication(sdef, origin, nodes, master_ip)
+		err = CreateGroupReplication(sdef, origin, nodes, master_ip)
 	case "fan-in":
-		CreateFanInReplication(sdef, origin, nodes, master_ip, master_list, slave_list)
+		err = CreateFanInReplication(sdef, origin, nodes, master_ip, master_list, slave_list)
 	case "all-masters":
-		CreateAllMastersReplication(sdef, origin, nodes, master_ip)
+		err = CreateAllMastersReplication(sdef, origin, nodes, master_ip)
+	case GaleraFlavor, PxcFlavor:
+		err = CreateGaleraReplication(sdef, origin, nodes, master_ip, topology)
+	case "ndb":
+		err = CreateNdbReplication(sdef, origin, nodes, master_ip)
+	case ChainTopology, TreeTopology, RingTopology:
+		err = CreateTreeReplication(sdef, origin, nodes, master_ip, topology)
+	}
+	if err != nil {
+		return err
+	}
+	// The base port is recorded as it was found, so that
+	// a new deployment will use the same ports
+	first_port := first_node_port(sdef.SandboxDir)
+	if first_port > 0 {
+		original_sdef.BasePort = first_port - 1
+	}
+	original_sdef.DirName = common.BaseName(sdef.SandboxDir)
+	return WriteDeploymentDefinition(sdef.SandboxDir, DeploymentDefinition{
+		Topology:   topology,
+		Origin:     origin,
+		Nodes:      nodes,
+		MasterIp:   master_ip,
+		MasterList: master_list,
+		SlaveList:  slave_list,
+		Sdef:       original_sdef,
+	})
+}
+
+// Generates again the scripts that operate on all the nodes of
+// a multiple or replication sandbox, after the list of nodes has changed
+func write_all_nodes_scripts(logger *defaults.Logger, sdef SandboxDef, dd DeploymentDefinition, nodes map[int]NodeDescription) error {
+	node_ports := make(map[int]int)
+	for N, node := range nodes {
+		node_ports[N] = node.Description.Port[0]
+	}
+	logger.Printf("Writing scripts for nodes %v\n", sorted_nodes(node_ports))
+	if dd.Topology == "master-slave" {
+		slave_ports := make(map[int]int)
+		for N, port := range node_ports {
+			if N > 1 {
+				slave_ports[N-1] = port
+			}
+		}
+		return write_master_slave_scripts(logger, sdef, master_slave_data(logger, sdef, dd.MasterIp, node_ports[1], slave_ports))
+	}
+	data := multiple_data(sdef.SandboxDir, node_ports)
+	err := write_multiple_scripts(logger, sdef, data)
+	if err != nil {
+		return err
+	}
+	for _, data_node := range data["Nodes"].([]common.Smap) {
+		err = write_script(logger, DeploymentTemplates(sdef, MultipleTemplates), fmt.Sprintf("n%d", data_node["Node"].(int)), "node_template", sdef.SandboxDir, data_node, true)
+		if err != nil {
+			return err
+		}
+	}
+	if dd.Topology == "multiple" {
+		return nil
+	}
+	masters, err := replication_tree_masters(dd)
+	if err != nil {
+		return err
 	}
+	return write_tree_scripts(logger, sdef, data, dd.Topology, masters, dd.MasterIp)
 }
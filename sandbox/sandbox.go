@@ -16,6 +16,7 @@
 package sandbox
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -39,6 +40,7 @@ type SandboxDef struct {
 	SkipReportHost       bool             // Do not add report-host to my.sandbox.cnf
 	SkipReportPort       bool             // Do not add report-port to my.sandbox.cnf
 	SkipStart            bool             // Do not start the server after deployment
+	SkipInit             bool             // Do not initialize the data directory (it will be copied from elsewhere)
 	InstalledPorts       []int            // Which ports should be skipped in port assignment for this SB
 	Port                 int              // Port assigned to this sandbox
 	MysqlXPort           int              // XPlugin port for this sandbox
@@ -60,6 +62,12 @@ type SandboxDef struct {
 	SemiSyncOptions      string           // Options for semi-synchronous replication
 	InitOptions          []string         // Options to be added to the initialization command
 	MyCnfOptions         []string         // Options to be added to my.sandbox.cnf
+	NodeOptions          []string         // Options to be added to my.sandbox.cnf of all nodes in replication
+	MasterOptions        []string         // Options to be added to my.sandbox.cnf of the masters in replication
+	SlaveOptions         []string         // Options to be added to my.sandbox.cnf of the slaves in replication
+	PerNodeOptions       map[int][]string // Options to be added to my.sandbox.cnf of a given node number
+	NdbNodes             int              // Number of data nodes in an NDB cluster
+	ReplicationTree      string           // Slaves of each master in tree replication ("1:2,3;2:4,5")
 	PreGrantsSql         []string         // SQL statements to execute before grants assignment
 	PreGrantsSqlFile     string           // SQL file to load before grants assignment
 	PostGrantsSql        []string         // SQL statements to run after grants assignment
@@ -78,6 +86,10 @@ type SandboxDef struct {
 	Force                bool             // Overwrite an existing sandbox with same target
 	ExposeDdTables       bool             // Show hidden data dictionary tables (MySQL 8.0.0+)
 	RunConcurrently      bool             // Run multiple sandbox creation concurrently
+
+	Cleanup   *common.CleanupActions `json:"-"` // Undoes the deployment, including the nodes of a multiple sandbox
+	Templates map[string]string      `json:"-"` // Contents replacing the built-in templates in this deployment (name: contents)
+	Context   context.Context        `json:"-"` // Interrupts the deployment before a node is created or while a server starts
 }
 
 func GetOptionsFromFile(filename string) (options []string) {
@@ -107,7 +119,9 @@ func GetOptionsFromFile(filename string) (options []string) {
 
 func SandboxDefToJson(sd SandboxDef) string {
 	b, err := json.MarshalIndent(sd, " ", "\t")
-	common.ErrCheckExitf(err, 1, "error encoding sandbox definition: %s", err)
+	if err != nil {
+		return fmt.Sprintf("error encoding sandbox definition: %s", err)
+	}
 	return fmt.Sprintf("%s", b)
 }
 
@@ -116,7 +130,9 @@ func SmapToJson(data common.Smap) string {
 	data["Copyright"] = "[skipped] (See 'Copyright' template for full text)"
 	b, err := json.MarshalIndent(data, " ", "\t")
 	data["Copyright"] = copyright
-	common.ErrCheckExitf(err, 1, "error encoding data: %s", err)
+	if err != nil {
+		return fmt.Sprintf("error encoding data: %s", err)
+	}
 	return fmt.Sprintf("%s", b)
 }
 
@@ -124,12 +140,12 @@ func is_locked(sb_dir string) bool {
 	return common.FileExists(sb_dir+"/no_clear") || common.FileExists(sb_dir+"/no_clear_all")
 }
 
-func CheckDirectory(sdef SandboxDef) SandboxDef {
+func CheckDirectory(sdef SandboxDef) (SandboxDef, error) {
 	sandbox_dir := sdef.SandboxDir
 	if common.DirExists(sandbox_dir) {
 		if sdef.Force {
 			if is_locked(sandbox_dir) {
-				common.Exitf(1, "Sandbox in %s is locked. Cannot be overwritten\nYou can unlock it with 'dbdeployer admin unlock %s'\n", sandbox_dir, common.DirName(sandbox_dir))
+				return sdef, &LockedDirectoryError{SandboxDir: sandbox_dir}
 			}
 			fmt.Printf("Overwriting directory %s\n", sandbox_dir)
 			stop_command := sandbox_dir + "/stop"
@@ -147,12 +163,16 @@ func CheckDirectory(sdef SandboxDef) SandboxDef {
 			}
 
 			log_directory := getLogDirFromSbDescription(sandbox_dir)
-			common.Run_cmd(stop_command)
-			err, _ := common.Run_cmd_with_args("rm", []string{"-rf", sandbox_dir})
-			common.ErrCheckExitf(err, 1, "Error while deleting sandbox %s", sandbox_dir)
+			run_cmd(stop_command)
+			err, _ := run_cmd_with_args("rm", []string{"-rf", sandbox_dir})
+			if err != nil {
+				return sdef, fmt.Errorf("Error while deleting sandbox %s: %s", sandbox_dir, err)
+			}
 			if log_directory != "" {
-				err, _ = common.Run_cmd_with_args("rm", []string{"-rf", log_directory})
-				common.ErrCheckExitf(err, 1, "Error while deleting log directory %s", log_directory)
+				err, _ = run_cmd_with_args("rm", []string{"-rf", log_directory})
+				if err != nil {
+					return sdef, fmt.Errorf("Error while deleting log directory %s: %s", log_directory, err)
+				}
 			}
 			var new_installed_ports []int
 			for _, port := range sdef.InstalledPorts {
@@ -162,21 +182,47 @@ func CheckDirectory(sdef SandboxDef) SandboxDef {
 			}
 			sdef.InstalledPorts = new_installed_ports
 		} else {
-			common.Exitf(1, "Directory %s already exists. Use --force to override.", sandbox_dir)
+			return sdef, fmt.Errorf("Directory %s already exists. Use --force to override.", sandbox_dir)
 		}
 	}
-	return sdef
+	return sdef, nil
 }
 
-func CheckPort(caller string, sandbox_type string, installed_ports []int, port int) {
-	conflict := 0
+func CheckPort(caller string, sandbox_type string, installed_ports []int, port int) error {
 	for _, p := range installed_ports {
 		if p == port {
-			conflict = p
+			return &PortConflictError{Caller: caller, SandboxType: sandbox_type, Port: port}
 		}
 	}
-	if conflict > 0 {
-		common.Exitf(1, "Port conflict detected for %s (%s). Port %d is already used", sandbox_type, caller, conflict)
+	return nil
+}
+
+// Returns the context of a deployment. Deployments without one can't be interrupted
+func deployment_context(sdef SandboxDef) context.Context {
+	if sdef.Context == nil {
+		return context.Background()
+	}
+	return sdef.Context
+}
+
+// Gives a deployment its cleanup actions, unless it is part of a larger
+// deployment (a node of a multiple sandbox) that has them already.
+// The returned function must be deferred with the error of the deployment.
+// When the deployment owns the actions, they are run if it failed, to remove
+// what it created, or discarded if it succeeded. Otherwise, they are left to
+// the outer deployment, which can still fail after this one has succeeded.
+func start_cleanup(sdef *SandboxDef) (finish func(err error)) {
+	if sdef.Cleanup != nil {
+		return func(error) {}
+	}
+	cleanup := common.NewCleanupActions()
+	sdef.Cleanup = cleanup
+	return func(err error) {
+		if err != nil {
+			cleanup.Run()
+		} else {
+			cleanup.Discard()
+		}
 	}
 }
 
@@ -193,7 +239,7 @@ func getmatch(key string, names []string, matches []string) string {
 }
 
 func FixServerUuid(sdef SandboxDef) (uuid_file, new_uuid string) {
-	if !common.GreaterOrEqualVersion(sdef.Version, []int{5, 6, 9}) {
+	if !has_feature(sdef.Version, "server-uuid") {
 		return
 	}
 	new_uuid = fmt.Sprintf("server-uuid=%s", common.MakeCustomizedUuid(sdef.Port, sdef.NodeNum))
@@ -224,13 +270,25 @@ func set_mysqlx_properties(sdef SandboxDef, global_tmp_dir string) SandboxDef {
 	return sdef
 }
 
-func CreateSingleSandbox(sdef SandboxDef) (exec_list []concurrent.ExecutionList) {
+func CreateSingleSandbox(sdef SandboxDef) (exec_list []concurrent.ExecutionList, err error) {
 
 	var sandbox_dir string
 
+	finish_cleanup := start_cleanup(&sdef)
+	defer func() {
+		finish_cleanup(err)
+	}()
+	// Every node of a multiple sandbox is created here: an interrupted
+	// deployment stops before the next node, and the cleanup removes
+	// the nodes created so far
+	if err = deployment_context(sdef).Err(); err != nil {
+		return exec_list, err
+	}
+
 	if sdef.SBType == "" {
 		sdef.SBType = "single"
 	}
+	original_sdef := sdef
 	log_name := sdef.SBType
 	if sdef.NodeNum > 0 {
 		log_name = fmt.Sprintf("%s-%d", log_name, sdef.NodeNum)
@@ -239,11 +297,11 @@ func CreateSingleSandbox(sdef SandboxDef) (exec_list []concurrent.ExecutionList)
 	sdef.LogFileName = common.ReplaceLiteralHome(fname)
 	logger.Printf("Single Sandbox Definition: %s\n", SandboxDefToJson(sdef))
 	if !common.DirExists(sdef.Basedir) {
-		common.Exitf(1, "Base directory %s does not exist", sdef.Basedir)
+		return exec_list, &MissingBasedirError{Basedir: sdef.Basedir}
 	}
 
 	if sdef.Port <= 1024 {
-		common.Exitf(1, "Port for sandbox must be > 1024 (given:%d)", sdef.Port)
+		return exec_list, fmt.Errorf("Port for sandbox must be > 1024 (given:%d)", sdef.Port)
 	}
 
 	version_fname := common.VersionToName(sdef.Version)
@@ -267,7 +325,7 @@ func CreateSingleSandbox(sdef SandboxDef) (exec_list []concurrent.ExecutionList)
 		global_tmp_dir = "/tmp"
 	}
 	if !common.DirExists(global_tmp_dir) {
-		common.Exitf(1, "TMP directory %s does not exist", global_tmp_dir)
+		return exec_list, fmt.Errorf("TMP directory %s does not exist", global_tmp_dir)
 	}
 	if sdef.NodeNum == 0 && !sdef.Force {
 		sdef.Port = common.FindFreePort(sdef.Port, sdef.InstalledPorts, 1)
@@ -276,29 +334,28 @@ func CreateSingleSandbox(sdef SandboxDef) (exec_list []concurrent.ExecutionList)
 	using_plugins := false
 	right_plugin_dir := true // Assuming we can use the right plugin directory
 	if sdef.EnableMysqlX {
-		if !common.GreaterOrEqualVersion(sdef.Version, []int{5, 7, 12}) {
-			common.Exit(1, "option --enable-mysqlx requires version 5.7.12+")
+		if err := check_feature(sdef.Version, "mysqlx-plugin", "option --enable-mysqlx"); err != nil {
+			return exec_list, err
 		}
-		// If the version is 8.0.11 or later, MySQL X is enabled already
-		if !common.GreaterOrEqualVersion(sdef.Version, []int{8, 0, 11}) {
+		// If MySQL X is enabled by default, there is no plugin to load
+		if !has_feature(sdef.Version, "mysqlx-default") {
 			sdef.MyCnfOptions = append(sdef.MyCnfOptions, "plugin_load=mysqlx=mysqlx.so")
 			sdef = set_mysqlx_properties(sdef, global_tmp_dir)
 			logger.Printf("Added mysqlx plugin to my.cnf\n")
 		}
 		using_plugins = true
 	}
-	if common.GreaterOrEqualVersion(sdef.Version, []int{8, 0, 11}) && !sdef.DisableMysqlX {
+	if has_feature(sdef.Version, "mysqlx-default") && !sdef.DisableMysqlX {
 		using_plugins = true
 	}
 	if sdef.ExposeDdTables {
-		if !common.GreaterOrEqualVersion(sdef.Version, []int{8, 0, 0}) {
-			common.Exit(1, "--expose-dd-tables requires MySQL 8.0.0+")
+		if err := check_feature(sdef.Version, "data-dictionary", "--expose-dd-tables"); err != nil {
+			return exec_list, err
 		}
-		sdef.PostGrantsSql = append(sdef.PostGrantsSql, SingleTemplates["expose_dd_tables"].Contents)
+		sdef.PostGrantsSql = append(sdef.PostGrantsSql, DeploymentTemplates(sdef, SingleTemplates)["expose_dd_tables"].Contents)
 		if sdef.CustomMysqld != "" && sdef.CustomMysqld != "mysqld-debug" {
-			common.Exit(1,
-				fmt.Sprintf("--expose-dd-tables requires mysqld-debug. A different file was indicated (--custom-mysqld=%s)", sdef.CustomMysqld),
-				"Either use \"mysqld-debug\" or remove --custom-mysqld")
+			return exec_list, fmt.Errorf("--expose-dd-tables requires mysqld-debug. A different file was indicated (--custom-mysqld=%s)\n%s",
+				sdef.CustomMysqld, "Either use \"mysqld-debug\" or remove --custom-mysqld")
 		}
 		sdef.CustomMysqld = "mysqld-debug"
 		logger.Printf("Using mysqld-debug for this sandbox\n")
@@ -306,9 +363,9 @@ func CreateSingleSandbox(sdef SandboxDef) (exec_list []concurrent.ExecutionList)
 	if sdef.CustomMysqld != "" {
 		custom_mysqld := sdef.Basedir + "/bin/" + sdef.CustomMysqld
 		if !common.ExecExists(custom_mysqld) {
-			common.Exit(1,
-				fmt.Sprintf("File %s not found or not executable", custom_mysqld),
-				fmt.Sprintf("The file \"%s\" (defined with --custom-mysqld) must be in the same directory as the regular mysqld", sdef.CustomMysqld))
+			return exec_list, fmt.Errorf("File %s not found or not executable\n"+
+				"The file \"%s\" (defined with --custom-mysqld) must be in the same directory as the regular mysqld",
+				custom_mysqld, sdef.CustomMysqld)
 		}
 		plugin_debug_dir := fmt.Sprintf("%s/lib/plugin/debug", sdef.Basedir)
 		if sdef.CustomMysqld == "mysqld-debug" && common.DirExists(plugin_debug_dir) {
@@ -317,7 +374,7 @@ func CreateSingleSandbox(sdef SandboxDef) (exec_list []concurrent.ExecutionList)
 			right_plugin_dir = false
 		}
 	}
-	if common.GreaterOrEqualVersion(sdef.Version, []int{5, 1, 0}) {
+	if has_feature(sdef.Version, "general-log") {
 		if sdef.EnableGeneralLog {
 			sdef.MyCnfOptions = append(sdef.MyCnfOptions, "general_log=1")
 			logger.Printf("Enabling general log\n")
@@ -327,14 +384,14 @@ func CreateSingleSandbox(sdef SandboxDef) (exec_list []concurrent.ExecutionList)
 			logger.Printf("Enabling general log during initialization\n")
 		}
 	}
-	if common.GreaterOrEqualVersion(sdef.Version, []int{8, 0, 4}) {
+	if has_feature(sdef.Version, "caching-sha2-password") {
 		if sdef.NativeAuthPlugin == true {
 			sdef.InitOptions = append(sdef.InitOptions, "--default_authentication_plugin=mysql_native_password")
 			sdef.MyCnfOptions = append(sdef.MyCnfOptions, "default_authentication_plugin=mysql_native_password")
 			logger.Printf("Using mysql_native_password for authentication\n")
 		}
 	}
-	if common.GreaterOrEqualVersion(sdef.Version, []int{8, 0, 11}) {
+	if has_feature(sdef.Version, "mysqlx-default") {
 		if sdef.DisableMysqlX {
 			sdef.MyCnfOptions = append(sdef.MyCnfOptions, "mysqlx=OFF")
 			logger.Printf("Disabling MySQLX\n")
@@ -356,6 +413,14 @@ func CreateSingleSandbox(sdef SandboxDef) (exec_list []concurrent.ExecutionList)
 			sdef.MyCnfOptions = append(sdef.MyCnfOptions, option)
 		}
 	}
+	node_options := sdef.PerNodeOptions[sdef.NodeNum]
+	if sdef.NodeNum > 0 && len(node_options) > 0 {
+		sdef.MyCnfOptions = append(sdef.MyCnfOptions, fmt.Sprintf("# options for node %d", sdef.NodeNum))
+		for _, option := range node_options {
+			sdef.MyCnfOptions = append(sdef.MyCnfOptions, option)
+		}
+		logger.Printf("Added options for node %d: %v\n", sdef.NodeNum, node_options)
+	}
 	if common.Includes(slice_to_text(sdef.MyCnfOptions), "plugin.load") {
 		using_plugins = true
 	}
@@ -364,19 +429,19 @@ func CreateSingleSandbox(sdef SandboxDef) (exec_list []concurrent.ExecutionList)
 	}
 	if using_plugins {
 		if !right_plugin_dir {
-			common.Exit(1,
-				"The request of using mysqld-debug can't be honored.",
-				"This deployment is using a plugin, but the debug",
+			return exec_list, fmt.Errorf("The request of using mysqld-debug can't be honored.\n" +
+				"This deployment is using a plugin, but the debug\n" +
 				"directory for plugins was not found")
 		}
 	}
 	timestamp := time.Now()
 	var data common.Smap = common.Smap{"Basedir": sdef.Basedir,
-		"Copyright":            SingleTemplates["Copyright"].Contents,
+		"Copyright":            DeploymentTemplates(sdef, SingleTemplates)["Copyright"].Contents,
 		"AppVersion":           common.VersionDef,
 		"DateTime":             timestamp.Format(time.UnixDate),
 		"SandboxDir":           sandbox_dir,
 		"CustomMysqld":         sdef.CustomMysqld,
+		"DbDeployer":           dbdeployer_executable(),
 		"Port":                 sdef.Port,
 		"MysqlXPort":           sdef.MysqlXPort,
 		"MysqlShell":           mysqlsh_executable,
@@ -417,32 +482,38 @@ func CreateSingleSandbox(sdef SandboxDef) (exec_list []concurrent.ExecutionList)
 		data["ServerId"] = ""
 	}
 	if common.DirExists(sandbox_dir) {
-		sdef = CheckDirectory(sdef)
+		sdef, err = CheckDirectory(sdef)
+		if err != nil {
+			return exec_list, err
+		}
 	}
 	logger.Printf("Checking port %d using CheckPort\n", sdef.Port)
-	CheckPort("CreateSingleSandbox", sdef.SBType, sdef.InstalledPorts, sdef.Port)
+	err = CheckPort("CreateSingleSandbox", sdef.SBType, sdef.InstalledPorts, sdef.Port)
+	if err != nil {
+		return exec_list, err
+	}
 
 	//fmt.Printf("creating: %s\n", sandbox_dir)
-	common.Mkdir(sandbox_dir)
+	make_top_dir(sdef.Cleanup, sandbox_dir)
 
 	logger.Printf("Created directory %s\n", sdef.SandboxDir)
 	logger.Printf("Single Sandbox template data: %s\n", SmapToJson(data))
 
 	// fmt.Printf("creating: %s\n", datadir)
-	common.Mkdir(datadir)
+	make_dir(datadir)
 	logger.Printf("Created directory %s\n", datadir)
 	// fmt.Printf("creating: %s\n", tmpdir)
-	common.Mkdir(tmpdir)
+	make_dir(tmpdir)
 	logger.Printf("Created directory %s\n", tmpdir)
 	script := sdef.Basedir + "/scripts/mysql_install_db"
 	init_script_flags := ""
-	if common.GreaterOrEqualVersion(sdef.Version, []int{5, 7, 0}) {
+	if has_feature(sdef.Version, "initialize") {
 		script = sdef.Basedir + "/bin/mysqld"
 		init_script_flags = "--initialize-insecure"
 	}
 	// fmt.Printf("Script: %s\n", script)
 	if !common.ExecExists(script) {
-		common.Exitf(1, "Script '%s' not found", script)
+		return exec_list, fmt.Errorf("Script '%s' not found", script)
 	}
 	if len(sdef.InitOptions) > 0 {
 		for _, op := range sdef.InitOptions {
@@ -467,8 +538,13 @@ func CreateSingleSandbox(sdef SandboxDef) (exec_list []concurrent.ExecutionList)
 		}
 	}
 
-	write_script(logger, SingleTemplates, "init_db", "init_db_template", sandbox_dir, data, true)
-	if sdef.RunConcurrently {
+	err = write_script(logger, DeploymentTemplates(sdef, SingleTemplates), "init_db", "init_db_template", sandbox_dir, data, true)
+	if err != nil {
+		return exec_list, err
+	}
+	if sdef.SkipInit {
+		logger.Printf("Skipping init_db script\n")
+	} else if sdef.RunConcurrently {
 		var eCommand = concurrent.ExecCommand{
 			Cmd:  sandbox_dir + "/init_db",
 			Args: []string{},
@@ -477,7 +553,7 @@ func CreateSingleSandbox(sdef SandboxDef) (exec_list []concurrent.ExecutionList)
 		exec_list = append(exec_list, concurrent.ExecutionList{Logger: logger, Priority: 0, Command: eCommand})
 	} else {
 		logger.Printf("Running init_db script \n")
-		err, _ := common.Run_cmd_ctrl(sandbox_dir+"/init_db", true)
+		err, _ := run_cmd_ctrl(sandbox_dir+"/init_db", true)
 		if err == nil {
 			if !sdef.Multi {
 				if defaults.UsingDbDeployer {
@@ -518,71 +594,91 @@ func CreateSingleSandbox(sdef SandboxDef) (exec_list []concurrent.ExecutionList)
 		}
 	}
 	logger.Printf("Writing single sandbox description\n")
-	common.WriteSandboxDescription(sandbox_dir, sb_desc)
+	write_sandbox_description(sandbox_dir, sb_desc, sdef.ServerId)
 	if sdef.SBType == "single" {
-		defaults.UpdateCatalog(sandbox_dir, sb_item)
+		err = update_catalog(sdef.Cleanup, sandbox_dir, sb_item)
+		if err != nil {
+			return exec_list, err
+		}
+		original_sdef.Port = sdef.Port
+		original_sdef.DirName = sdef.DirName
+		logger.Printf("Writing single sandbox definition\n")
+		err = WriteDeploymentDefinition(sandbox_dir, DeploymentDefinition{
+			Topology: "single",
+			Origin:   sdef.BasedirName,
+			Sdef:     original_sdef,
+		})
+		if err != nil {
+			return exec_list, err
+		}
 	}
 	logger.Printf("Writing single sandbox scripts\n")
-	write_script(logger, SingleTemplates, "start", "start_template", sandbox_dir, data, true)
-	write_script(logger, SingleTemplates, "status", "status_template", sandbox_dir, data, true)
-	write_script(logger, SingleTemplates, "stop", "stop_template", sandbox_dir, data, true)
-	write_script(logger, SingleTemplates, "clear", "clear_template", sandbox_dir, data, true)
-	write_script(logger, SingleTemplates, "use", "use_template", sandbox_dir, data, true)
+	sb := ScriptBatch{
+		tc:         DeploymentTemplates(sdef, SingleTemplates),
+		logger:     logger,
+		data:       data,
+		sandboxDir: sandbox_dir,
+		scripts: []ScriptDef{
+			{"start", "start_template", true},
+			{"status", "status_template", true},
+			{"stop", "stop_template", true},
+			{"clear", "clear_template", true},
+			{"use", "use_template", true},
+			{"show_log", "show_log_template", true},
+			{"send_kill", "send_kill_template", true},
+			{"restart", "restart_template", true},
+			{"load_grants", "load_grants_template", true},
+			{"add_option", "add_option_template", true},
+			{"my", "my_template", true},
+			{"show_binlog", "show_binlog_template", true},
+			{"show_relaylog", "show_relaylog_template", true},
+			{"test_sb", "test_sb_template", true},
+
+			{"my.sandbox.cnf", "my_cnf_template", false},
+			{"grants.mysql", "grants_template", false},
+			{"sb_include", "sb_include_template", false},
+		},
+	}
 	if sdef.MysqlXPort != 0 {
-		write_script(logger, SingleTemplates, "mysqlsh", "mysqlsh_template", sandbox_dir, data, true)
-	}
-	write_script(logger, SingleTemplates, "show_log", "show_log_template", sandbox_dir, data, true)
-	write_script(logger, SingleTemplates, "send_kill", "send_kill_template", sandbox_dir, data, true)
-	write_script(logger, SingleTemplates, "restart", "restart_template", sandbox_dir, data, true)
-	write_script(logger, SingleTemplates, "load_grants", "load_grants_template", sandbox_dir, data, true)
-	write_script(logger, SingleTemplates, "add_option", "add_option_template", sandbox_dir, data, true)
-	write_script(logger, SingleTemplates, "my", "my_template", sandbox_dir, data, true)
-	write_script(logger, SingleTemplates, "show_binlog", "show_binlog_template", sandbox_dir, data, true)
-	write_script(logger, SingleTemplates, "show_relaylog", "show_relaylog_template", sandbox_dir, data, true)
-	write_script(logger, SingleTemplates, "test_sb", "test_sb_template", sandbox_dir, data, true)
-
-	write_script(logger, SingleTemplates, "my.sandbox.cnf", "my_cnf_template", sandbox_dir, data, false)
-	switch {
-	case common.GreaterOrEqualVersion(sdef.Version, []int{8, 0, 0}):
-		write_script(logger, SingleTemplates, "grants.mysql", "grants_template8x", sandbox_dir, data, false)
-	case common.GreaterOrEqualVersion(sdef.Version, []int{5, 7, 6}):
-		write_script(logger, SingleTemplates, "grants.mysql", "grants_template57", sandbox_dir, data, false)
-	default:
-		write_script(logger, SingleTemplates, "grants.mysql", "grants_template5x", sandbox_dir, data, false)
-	}
-	write_script(logger, SingleTemplates, "sb_include", "sb_include_template", sandbox_dir, data, false)
-
-	pre_grant_sql_file := sandbox_dir + "/pre_grants.sql"
-	post_grant_sql_file := sandbox_dir + "/post_grants.sql"
-	if sdef.PreGrantsSqlFile != "" {
-		common.CopyFile(sdef.PreGrantsSqlFile, pre_grant_sql_file)
+		sb.scripts = append(sb.scripts, ScriptDef{"mysqlsh", "mysqlsh_template", true})
 	}
-	if sdef.PostGrantsSqlFile != "" {
-		common.CopyFile(sdef.PostGrantsSqlFile, post_grant_sql_file)
+	err = write_scripts(sb)
+	if err != nil {
+		return exec_list, err
 	}
 
-	if len(sdef.PreGrantsSql) > 0 {
-		if common.FileExists(pre_grant_sql_file) {
-			common.AppendStrings(sdef.PreGrantsSql, pre_grant_sql_file, ";")
-		} else {
-			common.WriteStrings(sdef.PreGrantsSql, pre_grant_sql_file, ";")
+	pre_grant_sql_file := sandbox_dir + "/pre_grants.sql"
+	post_grant_sql_file := sandbox_dir + "/post_grants.sql"
+	if is_dry_run() {
+		if sdef.PreGrantsSqlFile != "" || len(sdef.PreGrantsSql) > 0 {
+			plan_script(pre_grant_sql_file)
 		}
-	}
-	if len(sdef.PostGrantsSql) > 0 {
-		if common.FileExists(post_grant_sql_file) {
-			common.AppendStrings(sdef.PostGrantsSql, post_grant_sql_file, ";")
-		} else {
-			common.WriteStrings(sdef.PostGrantsSql, post_grant_sql_file, ";")
+		if sdef.PostGrantsSqlFile != "" || len(sdef.PostGrantsSql) > 0 {
+			plan_script(post_grant_sql_file)
 		}
+	} else {
+		write_grants_files(sdef, pre_grant_sql_file, post_grant_sql_file)
 	}
 	//common.Run_cmd(sandbox_dir + "/start", []string{})
 	if !sdef.SkipStart && sdef.RunConcurrently {
+		custom_mysqld := sdef.CustomMysqld
+		ctx := deployment_context(sdef)
 		var eCommand2 = concurrent.ExecCommand{
 			Cmd:  sandbox_dir + "/start",
 			Args: []string{},
+			// Starts the server directly: the script needs the dbdeployer
+			// executable, which library users may not have
+			Func: func() error {
+				return start_server(ctx, sandbox_dir, custom_mysqld)
+			},
 		}
 		logger.Printf("Adding start command to execution list\n")
 		exec_list = append(exec_list, concurrent.ExecutionList{Logger: logger, Priority: 2, Command: eCommand2})
+		if !is_dry_run() {
+			// The server is started by the caller: it must be stopped
+			// if the caller fails afterwards
+			sdef.Cleanup.Add(stop_server_on_cleanup, "stop_server", sandbox_dir)
+		}
 		if sdef.LoadGrants {
 			var eCommand3 = concurrent.ExecCommand{
 				Cmd:  sandbox_dir + "/load_grants",
@@ -605,26 +701,100 @@ func CreateSingleSandbox(sdef SandboxDef) (exec_list []concurrent.ExecutionList)
 		}
 	} else {
 		if !sdef.SkipStart {
-			logger.Printf("Running start script\n")
-			common.Run_cmd(sandbox_dir + "/start")
+			logger.Printf("Starting server\n")
+			err = start_server(deployment_context(sdef), sandbox_dir, sdef.CustomMysqld)
+			if err != nil {
+				return exec_list, err
+			}
+			if !is_dry_run() {
+				// Stops the server before its directory is removed
+				sdef.Cleanup.Add(stop_server_on_cleanup, "stop_server", sandbox_dir)
+			}
 			if sdef.LoadGrants {
 				logger.Printf("Running pre grants script\n")
-				common.Run_cmd_with_args(sandbox_dir+"/load_grants", []string{"pre_grants.sql"})
+				run_cmd_with_args(sandbox_dir+"/load_grants", []string{"pre_grants.sql"})
 				logger.Printf("Running load grants script\n")
-				common.Run_cmd(sandbox_dir + "/load_grants")
+				run_cmd(sandbox_dir + "/load_grants")
 				logger.Printf("Running post grants script\n")
-				common.Run_cmd_with_args(sandbox_dir+"/load_grants", []string{"post_grants.sql"})
+				run_cmd_with_args(sandbox_dir+"/load_grants", []string{"post_grants.sql"})
 			}
 		}
 	}
 	return
 }
 
-func write_script(logger *defaults.Logger, temp_var TemplateCollection, name, template_name, directory string, data common.Smap, make_executable bool) {
+// Writes the SQL files loaded before and after the grants
+func write_grants_files(sdef SandboxDef, pre_grant_sql_file, post_grant_sql_file string) {
+	if sdef.PreGrantsSqlFile != "" {
+		common.CopyFile(sdef.PreGrantsSqlFile, pre_grant_sql_file)
+	}
+	if sdef.PostGrantsSqlFile != "" {
+		common.CopyFile(sdef.PostGrantsSqlFile, post_grant_sql_file)
+	}
+
+	if len(sdef.PreGrantsSql) > 0 {
+		if common.FileExists(pre_grant_sql_file) {
+			common.AppendStrings(sdef.PreGrantsSql, pre_grant_sql_file, ";")
+		} else {
+			common.WriteStrings(sdef.PreGrantsSql, pre_grant_sql_file, ";")
+		}
+	}
+	if len(sdef.PostGrantsSql) > 0 {
+		if common.FileExists(post_grant_sql_file) {
+			common.AppendStrings(sdef.PostGrantsSql, post_grant_sql_file, ";")
+		} else {
+			common.WriteStrings(sdef.PostGrantsSql, post_grant_sql_file, ";")
+		}
+	}
+}
+
+// A script to be written from a template
+type ScriptDef struct {
+	ScriptName     string
+	TemplateName   string
+	MakeExecutable bool
+}
+
+// Scripts written in the same directory, from the same template collection
+// and with the same data
+type ScriptBatch struct {
+	tc         TemplateCollection
+	logger     *defaults.Logger
+	data       common.Smap
+	sandboxDir string
+	scripts    []ScriptDef
+}
+
+// Writes all the scripts of a batch, stopping at the first error
+func write_scripts(sb ScriptBatch) error {
+	for _, script := range sb.scripts {
+		err := write_script(sb.logger, sb.tc, script.ScriptName, script.TemplateName, sb.sandboxDir, sb.data, script.MakeExecutable)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes a file from a template. When template_name is a family of
+// templates, the variant is chosen using the version in data.
+func write_script(logger *defaults.Logger, temp_var TemplateCollection, name, template_name, directory string, data common.Smap, make_executable bool) error {
+	version, _ := data["Version"].(string)
+	template_name, err := ResolveTemplate(temp_var, template_name, version)
+	if err != nil {
+		return fmt.Errorf("error writing %s: %s", name, err)
+	}
 	template := temp_var[template_name].Contents
 	template = common.TrimmedLines(template)
 	data["TemplateName"] = template_name
-	text := common.Tprintf(template, data)
+	text, err := common.Tprintf(template, data)
+	if err != nil {
+		return fmt.Errorf("error filling template %s for %s: %s", template_name, name, err)
+	}
+	if is_dry_run() {
+		plan_script(directory + "/" + name)
+		return nil
+	}
 	executable_status := ""
 	if make_executable {
 		write_exec(name, text, directory)
@@ -635,6 +805,20 @@ func write_script(logger *defaults.Logger, temp_var TemplateCollection, name, te
 	if logger != nil {
 		logger.Printf("Creating%s script '%s/%s' using template '%s'\n", executable_status, common.ReplaceLiteralHome(directory), name, template_name)
 	}
+	return nil
+}
+
+// LockedClearData returns the data used by the script that replaces
+// the clear script of a locked sandbox ('admin lock')
+func LockedClearData(sandbox_name, clear_cmd, no_clear_cmd string) common.Smap {
+	return common.Smap{
+		"TemplateName": "sb_locked_template",
+		"SandboxDir":   sandbox_name,
+		"AppVersion":   common.VersionDef,
+		"Copyright":    Copyright,
+		"ClearCmd":     clear_cmd,
+		"NoClearCmd":   no_clear_cmd,
+	}
 }
 
 func write_exec(filename, text, directory string) {
@@ -666,19 +850,17 @@ func getLogDirFromSbDescription(full_path string) string {
 	return log_directory
 }
 
-func RemoveSandbox(sandbox_dir, sandbox string, run_concurrently bool) (exec_list []concurrent.ExecutionList) {
+func RemoveSandbox(sandbox_dir, sandbox string, run_concurrently bool) (exec_list []concurrent.ExecutionList, err error) {
 	full_path := sandbox_dir + "/" + sandbox
 	if !common.DirExists(full_path) {
-		common.Exitf(1, "Directory '%s' not found", full_path)
+		return exec_list, fmt.Errorf("Directory '%s' not found", full_path)
 	}
 	preserve := full_path + "/no_clear_all"
 	if !common.ExecExists(preserve) {
 		preserve = full_path + "/no_clear"
 	}
 	if common.ExecExists(preserve) {
-		fmt.Printf("The sandbox %s is locked\n", sandbox)
-		fmt.Printf("You need to unlock it with \"dbdeployer admin unlock\"\n")
-		return
+		return exec_list, &LockedDirectoryError{SandboxDir: full_path}
 	}
 	log_directory := getLogDirFromSbDescription(full_path)
 	stop := full_path + "/stop_all"
@@ -686,21 +868,28 @@ func RemoveSandbox(sandbox_dir, sandbox string, run_concurrently bool) (exec_lis
 		stop = full_path + "/stop"
 	}
 	if !common.ExecExists(stop) {
-		common.Exitf(1, "Executable '%s' not found", stop)
+		return exec_list, fmt.Errorf("Executable '%s' not found", stop)
 	}
 
+	// The servers are stopped directly: the script needs the dbdeployer
+	// executable, which library users may not have
 	if run_concurrently {
 		var eCommand1 = concurrent.ExecCommand{
 			Cmd:  stop,
 			Args: []string{},
+			Func: func() error {
+				return StopSandboxServers(context.Background(), full_path)
+			},
 		}
 		exec_list = append(exec_list, concurrent.ExecutionList{Logger: nil, Priority: 0, Command: eCommand1})
 	} else {
 		if defaults.UsingDbDeployer {
 			fmt.Printf("Running %s\n", stop)
 		}
-		err, _ := common.Run_cmd(stop)
-		common.ErrCheckExitf(err, 1, "Error while stopping sandbox %s", full_path)
+		err = StopSandboxServers(context.Background(), full_path)
+		if err != nil {
+			return exec_list, fmt.Errorf("Error while stopping sandbox %s: %s", full_path, err)
+		}
 	}
 
 	rm_targets := []string{full_path, log_directory}
@@ -724,8 +913,10 @@ func RemoveSandbox(sandbox_dir, sandbox string, run_concurrently bool) (exec_lis
 			if defaults.UsingDbDeployer && target != log_directory {
 				fmt.Printf("Running %s\n", cmd_str)
 			}
-			err, _ := common.Run_cmd_with_args("rm", rm_args)
-			common.ErrCheckExitf(err, 1, "Error while deleting directory %s", target)
+			err, _ = common.Run_cmd_with_args("rm", rm_args)
+			if err != nil {
+				return exec_list, fmt.Errorf("Error while deleting directory %s: %s", target, err)
+			}
 			if defaults.UsingDbDeployer && target != log_directory {
 				fmt.Printf("Directory %s deleted\n", target)
 			}
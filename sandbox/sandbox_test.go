@@ -16,9 +16,19 @@
 package sandbox
 
 import (
+	"context"
+	"fmt"
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/unpack"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func ok_executable_exists(t *testing.T, dir, executable string) {
@@ -41,7 +51,12 @@ func ok_dir_exists(t *testing.T, dir string) {
 }
 
 func ok_port_exists(t *testing.T, dir_name string, port int) {
-	sandbox_list := defaults.ReadCatalog()
+	sandbox_list, err := defaults.ReadCatalog()
+	if err != nil {
+		t.Logf("not ok - error reading catalog: %s\n", err)
+		t.Fail()
+		return
+	}
 	// In the sandbox catalog (a map of sandbox structures),
 	// each entry is indexed with the full path of the sandbox
 	// directory.
@@ -62,6 +77,28 @@ func ok_port_exists(t *testing.T, dir_name string, port int) {
 	t.Fail()
 }
 
+// Checks that a failed deployment has left neither its directory
+// nor its catalog entry
+func ok_cleaned_up(t *testing.T, sandbox_dir string) {
+	if common.DirExists(sandbox_dir) {
+		t.Logf("not ok - %s was not removed\n", sandbox_dir)
+		t.Fail()
+	} else {
+		t.Logf("ok - %s was removed\n", sandbox_dir)
+	}
+	catalog, err := defaults.ReadCatalog()
+	if err != nil {
+		t.Logf("not ok - error reading catalog: %s\n", err)
+		t.Fail()
+	}
+	if _, found := catalog[sandbox_dir]; found {
+		t.Logf("not ok - %s is still in the catalog\n", sandbox_dir)
+		t.Fail()
+	} else {
+		t.Logf("ok - %s is not in the catalog\n", sandbox_dir)
+	}
+}
+
 type version_rec struct {
 	version string
 	path    string
@@ -99,9 +136,11 @@ func TestCreateSandbox(t *testing.T) {
 			BindAddress:    "127.0.0.1",
 		}
 
-		CreateSingleSandbox(sdef)
-		//exec_list := CreateSingleSandbox(sdef)
-		//t.Logf("%#v", exec_list)
+		_, err := CreateSingleSandbox(sdef)
+		if err != nil {
+			t.Logf("not ok - error creating sandbox %s: %s\n", mysql_version, err)
+			t.Fail()
+		}
 		ok_dir_exists(t, sdef.Basedir)
 		sandbox_dir := sdef.SandboxDir + "/msb_" + path_version
 		ok_dir_exists(t, sandbox_dir)
@@ -112,6 +151,1156 @@ func TestCreateSandbox(t *testing.T) {
 		ok_executable_exists(t, sandbox_dir, "use")
 		ok_executable_exists(t, sandbox_dir, "stop")
 		ok_port_exists(t, sandbox_dir, sdef.Port)
+		server, err := NewServerProcess(sandbox_dir)
+		if err != nil {
+			t.Logf("not ok - %s\n", err)
+			t.Fail()
+			continue
+		}
+		// The mock server creates an empty pid file, which
+		// does not belong to a running process
+		if common.FileExists(server.PidFile) && common.FileExists(server.Socket) && !server.IsRunning() {
+			t.Logf("ok - pid file and socket created for %s\n", sandbox_dir)
+		} else {
+			t.Logf("not ok - unexpected server state for %s\n", sandbox_dir)
+			t.Fail()
+		}
+		err = server.Stop(DefaultStopTimeout)
+		if err == nil && !common.FileExists(server.PidFile) && !common.FileExists(server.Socket) {
+			t.Logf("ok - %s stopped\n", sandbox_dir)
+		} else {
+			t.Logf("not ok - %s not stopped (%v)\n", sandbox_dir, err)
+			t.Fail()
+		}
+	}
+	remove_mock_environment("mock_dir")
+}
+
+func TestCreateSandboxErrors(t *testing.T) {
+	set_mock_environment("mock_dir")
+	create_mock_version("5.7.22")
+	var sdef = SandboxDef{
+		Version:        "5.7.22",
+		Basedir:        mock_sandbox_binary + "/5.7.99",
+		SandboxDir:     mock_sandbox_home,
+		DirName:        "msb_5_7_22",
+		InstalledPorts: []int{1186, 3306, 33060},
+		Port:           5722,
+		DbUser:         "msandbox",
+		RplUser:        "rsandbox",
+		DbPassword:     "msandbox",
+		RplPassword:    "rsandbox",
+		RemoteAccess:   "127.%",
+		BindAddress:    "127.0.0.1",
+	}
+
+	_, err := CreateSingleSandbox(sdef)
+	if _, ok := err.(*MissingBasedirError); ok {
+		t.Logf("ok - missing base directory detected: %s\n", err)
+	} else {
+		t.Logf("not ok - expected MissingBasedirError, got %#v\n", err)
+		t.Fail()
+	}
+
+	sdef.Basedir = mock_sandbox_binary + "/5.7.22"
+	sdef.ExposeDdTables = true
+	_, err = CreateSingleSandbox(sdef)
+	if _, ok := err.(*UnsupportedVersionError); ok {
+		t.Logf("ok - unsupported version detected: %s\n", err)
+	} else {
+		t.Logf("not ok - expected UnsupportedVersionError, got %#v\n", err)
+		t.Fail()
+	}
+
+	err = CheckPort("TestCreateSandboxErrors", "single", sdef.InstalledPorts, 3306)
+	if port_error, ok := err.(*PortConflictError); ok && port_error.Port == 3306 {
+		t.Logf("ok - port conflict detected: %s\n", err)
+	} else {
+		t.Logf("not ok - expected PortConflictError for port 3306, got %#v\n", err)
+		t.Fail()
+	}
+
+	sdef.ExposeDdTables = false
+	sdef.Version = "5.7.16"
+	err = CreateReplicationSandbox(sdef, "5.7.16", "group", 3, "127.0.0.1", "", "")
+	if _, ok := err.(*UnsupportedVersionError); ok {
+		t.Logf("ok - unsupported version detected: %s\n", err)
+	} else {
+		t.Logf("not ok - expected UnsupportedVersionError, got %#v\n", err)
+		t.Fail()
+	}
+
+	// A broken template is reported to the caller, not by exiting
+	sdef.Version = "5.7.22"
+	sdef.DirName = "msb_broken_template"
+	saved_contents := SingleTemplates["start_template"].Contents
+	sdef.Templates = map[string]string{"start_template": "{{.SandboxDir"}
+	_, err = CreateSingleSandbox(sdef)
+	if err != nil && strings.Contains(err.Error(), "start_template") {
+		t.Logf("ok - broken template detected: %s\n", err)
+	} else {
+		t.Logf("not ok - expected an error for start_template, got %v\n", err)
+		t.Fail()
+	}
+	ok_cleaned_up(t, mock_sandbox_home+"/msb_broken_template")
+	// The template was replaced only in that deployment
+	if SingleTemplates["start_template"].Contents == saved_contents {
+		t.Logf("ok - built-in start_template unchanged\n")
+	} else {
+		t.Logf("not ok - built-in start_template changed by a deployment\n")
+		t.Fail()
+	}
+
+	// A failed replication deployment removes the nodes that it had created
+	sdef.DirName = "rsandbox_broken_template"
+	sdef.Templates = map[string]string{"slave_template": "{{.SandboxDir"}
+	err = CreateReplicationSandbox(sdef, "5.7.22", "master-slave", 3, "127.0.0.1", "", "")
+	if err != nil && strings.Contains(err.Error(), "slave_template") {
+		t.Logf("ok - broken template detected: %s\n", err)
+	} else {
+		t.Logf("not ok - expected an error for slave_template, got %v\n", err)
+		t.Fail()
+	}
+	ok_cleaned_up(t, mock_sandbox_home+"/rsandbox_broken_template")
+
+	// An interrupted deployment creates no more nodes, and removes
+	// the ones that it had created
+	sdef.Templates = nil
+	sdef.DirName = "rsandbox_interrupted"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sdef.Context = ctx
+	err = CreateReplicationSandbox(sdef, "5.7.22", "master-slave", 3, "127.0.0.1", "", "")
+	if err == context.Canceled {
+		t.Logf("ok - interrupted deployment detected: %s\n", err)
+	} else {
+		t.Logf("not ok - expected context.Canceled, got %v\n", err)
+		t.Fail()
+	}
+	ok_cleaned_up(t, mock_sandbox_home+"/rsandbox_interrupted")
+	remove_mock_environment("mock_dir")
+}
+
+func TestFailedNodeCleanup(t *testing.T) {
+	set_mock_environment("mock_dir")
+	create_mock_version("5.7.22")
+	// A mysqld_safe that runs a long lived process as the server, and
+	// records its pid. The server of the third node (node2) does not start
+	pid_list := common.DirName(mock_sandbox_home) + "/started_pids"
+	mysqld_safe := mock_sandbox_binary + "/5.7.22/bin/mysqld_safe"
+	common.WriteString(`#!/bin/bash
+defaults_file=$(echo $1 | sed 's/--defaults-file=//')
+case $defaults_file in
+    */node2/*) exit 1 ;;
+esac
+pid_file=$(grep pid-file $defaults_file | awk '{print $3}')
+socket=$(grep '^socket' $defaults_file | head -n 1 | awk '{print $3}')
+datadir=$(grep '^datadir' $defaults_file | head -n 1 | awk '{print $3}')
+sleep 300 &
+echo $! > $pid_file
+echo $! >> `+pid_list+`
+echo $datadir > $socket
+wait
+`, mysqld_safe)
+	os.Chmod(mysqld_safe, 0755)
+	var sdef = SandboxDef{
+		Version:        "5.7.22",
+		Basedir:        mock_sandbox_binary + "/5.7.22",
+		SandboxDir:     mock_sandbox_home,
+		DirName:        "rsandbox_failed_node",
+		LoadGrants:     true,
+		InstalledPorts: []int{1186, 3306, 33060},
+		DbUser:         "msandbox",
+		RplUser:        "rsandbox",
+		DbPassword:     "msandbox",
+		RplPassword:    "rsandbox",
+		RemoteAccess:   "127.%",
+		BindAddress:    "127.0.0.1",
+	}
+	err := CreateReplicationSandbox(sdef, "5.7.22", "master-slave", 3, "127.0.0.1", "", "")
+	if err != nil {
+		t.Logf("ok - failed node detected: %s\n", err)
+	} else {
+		t.Logf("not ok - expected an error for node2\n")
+		t.Fail()
+	}
+	ok_cleaned_up(t, mock_sandbox_home+"/rsandbox_failed_node")
+	contents, _ := ioutil.ReadFile(pid_list)
+	lines := strings.Fields(string(contents))
+	if len(lines) == 2 {
+		t.Logf("ok - master and node1 were started\n")
+	} else {
+		t.Logf("not ok - expected 2 started servers - got %d\n", len(lines))
+		t.Fail()
+	}
+	for _, line := range lines {
+		pid, _ := strconv.Atoi(line)
+		if pid > 0 && wait_for(5*time.Second, func() bool { return !process_exists(pid) }) {
+			t.Logf("ok - server %d stopped\n", pid)
+		} else {
+			t.Logf("not ok - server %d still running\n", pid)
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fail()
+		}
+	}
+	remove_mock_environment("mock_dir")
+}
+
+func TestNodeOptionsByRole(t *testing.T) {
+	sdef := SandboxDef{
+		NodeOptions:    []string{"max_connections=100"},
+		MasterOptions:  []string{"innodb_buffer_pool_size=1G"},
+		SlaveOptions:   []string{"read_only=1"},
+		PerNodeOptions: map[int][]string{3: []string{"log-slave-updates"}},
+	}
+	options := node_options_by_role(sdef, 3, []int{1}, node_range(2, 3))
+	var expected = map[int]string{
+		1: "max_connections=100 innodb_buffer_pool_size=1G",
+		2: "max_connections=100 read_only=1",
+		3: "max_connections=100 read_only=1 log-slave-updates",
+	}
+	for N, text := range expected {
+		found := strings.Join(options[N], " ")
+		if found == text {
+			t.Logf("ok - node %d: %s\n", N, found)
+		} else {
+			t.Logf("not ok - node %d: expected '%s' - found '%s'\n", N, text, found)
+			t.Fail()
+		}
+	}
+	err := check_per_node_options(sdef, 2)
+	if err != nil {
+		t.Logf("ok - options for a missing node detected: %s\n", err)
+	} else {
+		t.Logf("not ok - options for a missing node not detected\n")
+		t.Fail()
+	}
+}
+
+func TestGaleraVersion(t *testing.T) {
+	var versions = map[string]bool{
+		"10.2.15": true,
+		"10.1.0":  true,
+		"10.0.35": false,
+		"5.7.22":  true,
+		"5.6.40":  true,
+		"5.5.60":  false,
+	}
+	for version, expected := range versions {
+		if is_galera_version(version) == expected {
+			t.Logf("ok - %s galera: %v\n", version, expected)
+		} else {
+			t.Logf("not ok - %s galera: expected %v\n", version, expected)
+			t.Fail()
+		}
+	}
+}
+
+func TestGaleraSstMethod(t *testing.T) {
+	basedir, err := ioutil.TempDir("", "pxc")
+	if err != nil {
+		t.Fatalf("can't create basedir: %s", err)
+	}
+	defer os.RemoveAll(basedir)
+	type sst_case struct {
+		flavor   string
+		version  string
+		expected string
+	}
+	var cases = []sst_case{
+		{GaleraFlavor, "10.2.15", "rsync"},
+		{GaleraFlavor, "10.3.8", "rsync"},
+		{PxcFlavor, "5.7.22", "rsync"},
+	}
+	if !common.ExecExists("xtrabackup") {
+		// PXC 8.0 has no rsync SST, and needs xtrabackup
+		cases = append(cases, sst_case{PxcFlavor, "8.0.18", ""})
+	}
+	for _, c := range cases {
+		method, err := galera_sst_method(c.flavor, c.version, basedir)
+		if method == c.expected && (err == nil) == (c.expected != "") {
+			t.Logf("ok - %s %s SST method: '%s' (%v)\n", c.flavor, c.version, method, err)
+		} else {
+			t.Logf("not ok - %s %s SST method: expected '%s' - got '%s' (%v)\n", c.flavor, c.version, c.expected, method, err)
+			t.Fail()
+		}
+	}
+	err = os.MkdirAll(basedir+"/bin/pxc_extra", 0755)
+	if err != nil {
+		t.Fatalf("can't create pxc_extra: %s", err)
+	}
+	method, err := galera_sst_method(PxcFlavor, "8.0.18", basedir)
+	if err == nil && method == "xtrabackup-v2" {
+		t.Logf("ok - PXC 8.0 with bundled xtrabackup uses %s\n", method)
+	} else {
+		t.Logf("not ok - PXC 8.0 with bundled xtrabackup: got '%s' (%v)\n", method, err)
+		t.Fail()
+	}
+}
+
+func TestParseReplicationTree(t *testing.T) {
+	var good_trees = map[string]string{
+		"1:2,3;2:4,5": "map[2:1 3:1 4:2 5:2]",
+		"1:2;2:3;3:4": "map[2:1 3:2 4:3]",
+		"1:3;3:2":     "map[2:3 3:1]",
+		"1:2, 3;":     "map[2:1 3:1]",
+	}
+	for tree, expected := range good_trees {
+		masters, err := ParseReplicationTree(tree)
+		found := fmt.Sprintf("%v", masters)
+		if err == nil && found == expected {
+			t.Logf("ok - tree '%s': %s\n", tree, found)
+		} else {
+			t.Logf("not ok - tree '%s': expected %s - found %s (%v)\n", tree, expected, found, err)
+			t.Fail()
+		}
+	}
+	var bad_trees = []string{
+		"",
+		"1",
+		"1:2;3:4",
+		"1:2;2:1",
+		"1:2,3;2:3",
+		"1:2;3:4;4:3",
+		"1:x",
+		"1:2;2:2",
+	}
+	for _, tree := range bad_trees {
+		_, err := ParseReplicationTree(tree)
+		if err != nil {
+			t.Logf("ok - tree '%s' refused: %s\n", tree, err)
+		} else {
+			t.Logf("not ok - tree '%s' accepted\n", tree)
+			t.Fail()
+		}
+	}
+	ring := fmt.Sprintf("%v", ring_masters(3))
+	if ring == "map[1:3 2:1 3:2]" {
+		t.Logf("ok - ring of 3 nodes: %s\n", ring)
+	} else {
+		t.Logf("not ok - ring of 3 nodes: %s\n", ring)
+		t.Fail()
+	}
+	if ChainReplicationTree(4) == "1:2;2:3;3:4" {
+		t.Logf("ok - chain of 4 nodes\n")
+	} else {
+		t.Logf("not ok - chain of 4 nodes: %s\n", ChainReplicationTree(4))
+		t.Fail()
+	}
+}
+
+func TestHasSlaves(t *testing.T) {
+	var checks = []struct {
+		topology string
+		tree     string
+		nodes    int
+		node     int
+		expected bool
+	}{
+		{ChainTopology, "", 3, 2, true},
+		{ChainTopology, "", 3, 3, false},
+		{TreeTopology, "1:2,3;3:4", 4, 3, true},
+		{TreeTopology, "1:2,3;3:4", 4, 2, false},
+	}
+	for _, c := range checks {
+		dd := DeploymentDefinition{Topology: c.topology, Nodes: c.nodes}
+		dd.Sdef.ReplicationTree = c.tree
+		result := has_slaves(dd, c.node)
+		if result == c.expected {
+			t.Logf("ok - %s %s node %d has slaves: %v\n", c.topology, c.tree, c.node, result)
+		} else {
+			t.Logf("not ok - %s %s node %d has slaves: expected %v - got %v\n", c.topology, c.tree, c.node, c.expected, result)
+			t.Fail()
+		}
+	}
+}
+
+func TestCompactReplicationTree(t *testing.T) {
+	var checks = []struct {
+		tree     string
+		expected string
+	}{
+		{"1:2,3;3:4", "1:2,3;3:4"},
+		{"1:2,3;3:5", "1:2,3;3:4"},
+		{"1:3;3:5,6", "1:2;2:3,4"},
+	}
+	for _, c := range checks {
+		result, err := CompactReplicationTree(c.tree)
+		if err == nil && result == c.expected {
+			t.Logf("ok - tree '%s' compacted to '%s'\n", c.tree, result)
+		} else {
+			t.Logf("not ok - tree '%s': expected '%s' - got '%s' (%v)\n", c.tree, c.expected, result, err)
+			t.Fail()
+		}
+	}
+	ports := remove_ports([]int{8001, 8002, 18002, 8003}, []int{8002, 18002})
+	if fmt.Sprintf("%v", ports) == "[8001 8003]" {
+		t.Logf("ok - ports removed: %v\n", ports)
+	} else {
+		t.Logf("not ok - ports removed: %v\n", ports)
+		t.Fail()
+	}
+}
+
+func TestRelocateFiles(t *testing.T) {
+	old_path := "/home/user/sandboxes/msb_5_7_22"
+	new_path := "/home/user/sandboxes/msb_new"
+	sandbox_dir, err := ioutil.TempDir("", "relocate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(sandbox_dir)
+	common.Mkdir(sandbox_dir + "/node1")
+	common.Mkdir(sandbox_dir + "/data")
+	var files = []struct {
+		name     string
+		contents string
+		expected string
+	}{
+		{"start", "SBDIR=" + old_path + "\n", "SBDIR=" + new_path + "\n"},
+		{"node1/my.sandbox.cnf", "datadir = " + old_path + "/node1/data\n", "datadir = " + new_path + "/node1/data\n"},
+		{"data/binlog.index", old_path + "\n", old_path + "\n"},
+		{"binary_file", old_path + "\x00", old_path + "\x00"},
+	}
+	for _, f := range files {
+		common.WriteString(f.contents, sandbox_dir+"/"+f.name)
+	}
+	err = relocate_files(sandbox_dir, old_path, new_path)
+	if err != nil {
+		t.Logf("not ok - relocating files: %s\n", err)
+		t.Fail()
+	}
+	for _, f := range files {
+		contents, _ := ioutil.ReadFile(sandbox_dir + "/" + f.name)
+		if string(contents) == f.expected {
+			t.Logf("ok - %s relocated as expected\n", f.name)
+		} else {
+			t.Logf("not ok - %s: expected '%s' - got '%s'\n", f.name, f.expected, contents)
+			t.Fail()
+		}
+	}
+}
+
+func TestUndoMove(t *testing.T) {
+	base_dir, err := ioutil.TempDir("", "move")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base_dir)
+	old_dir := base_dir + "/msb_5_7_22"
+	new_dir := base_dir + "/msb_new"
+	common.Mkdir(old_dir)
+	common.WriteSandboxDescription(old_dir, common.SandboxDescription{SBType: "single", Version: "5.7.22", Port: []int{5722}})
+	start_contents := "SBDIR=" + old_dir + "\n"
+	common.WriteString(start_contents, old_dir+"/start")
+
+	// Simulates a move that failed after relocating the files
+	err, _ = common.Run_cmd_with_args("mv", []string{old_dir, new_dir})
+	if err != nil {
+		t.Fatalf("not ok - error moving %s: %s", old_dir, err)
+	}
+	err = relocate_files(new_dir, old_dir, new_dir)
+	if err != nil {
+		t.Fatalf("not ok - error relocating files: %s", err)
+	}
+	move_err := fmt.Errorf("simulated failure")
+	err = undo_move(old_dir, new_dir, move_err)
+	if err == move_err {
+		t.Logf("ok - original error returned\n")
+	} else {
+		t.Logf("not ok - expected '%s' - got '%v'\n", move_err, err)
+		t.Fail()
+	}
+	if common.DirExists(old_dir) && !common.DirExists(new_dir) {
+		t.Logf("ok - sandbox moved back to %s\n", old_dir)
+	} else {
+		t.Logf("not ok - sandbox not moved back to %s\n", old_dir)
+		t.Fail()
+	}
+	contents, _ := ioutil.ReadFile(old_dir + "/start")
+	if string(contents) == start_contents {
+		t.Logf("ok - files restored\n")
+	} else {
+		t.Logf("not ok - expected '%s' - got '%s'\n", start_contents, contents)
+		t.Fail()
+	}
+}
+
+func TestListSandboxes(t *testing.T) {
+	sandbox_home, err := ioutil.TempDir("", "listing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(sandbox_home)
+	single_dir := sandbox_home + "/msb_5_7_22"
+	common.Mkdir(single_dir)
+	common.WriteSandboxDescription(single_dir, common.SandboxDescription{SBType: "single", Version: "5.7.22", Port: []int{5722}})
+	common.WriteString("", single_dir+"/no_clear")
+	multi_dir := sandbox_home + "/multi_msb_5_7_22"
+	common.Mkdir(multi_dir)
+	common.WriteSandboxDescription(multi_dir, common.SandboxDescription{SBType: "multiple", Version: "5.7.22", Port: []int{27023, 27024}, Nodes: 2})
+	for N, port := range []int{27023, 27024} {
+		node_dir := fmt.Sprintf("%s/node%d", multi_dir, N+1)
+		common.Mkdir(node_dir)
+		common.Mkdir(node_dir + "/data")
+		common.WriteSandboxDescription(node_dir, common.SandboxDescription{SBType: "multiple-node", Version: "5.7.22", Port: []int{port}, NodeNum: N + 1})
+	}
+	// Only the first node has a running process. The second one has a stale pid file
+	common.WriteString(fmt.Sprintf("%d", os.Getpid()), multi_dir+"/node1/data/mysql_sandbox27023.pid")
+	common.WriteString("999999999", multi_dir+"/node2/data/mysql_sandbox27024.pid")
+	common.Mkdir(sandbox_home + "/not_a_sandbox")
+
+	list := ListSandboxes(sandbox_home)
+	if len(list) != 2 {
+		t.Fatalf("not ok - expected 2 sandboxes - got %d", len(list))
+	}
+	var checks = []struct {
+		label    string
+		value    interface{}
+		expected interface{}
+	}{
+		{"single name", list[0].Name, "msb_5_7_22"},
+		{"single locked", list[0].Locked, true},
+		{"single running", list[0].Running, false},
+		{"single nodes", len(list[0].Nodes), 0},
+		{"multiple name", list[1].Name, "multi_msb_5_7_22"},
+		{"multiple locked", list[1].Locked, false},
+		{"multiple nodes", len(list[1].Nodes), 2},
+		{"multiple running", list[1].Running, true},
+	}
+	for _, c := range checks {
+		if c.value == c.expected {
+			t.Logf("ok - %s: %v\n", c.label, c.value)
+		} else {
+			t.Logf("not ok - %s: expected %v - got %v\n", c.label, c.expected, c.value)
+			t.Fail()
+		}
+	}
+	if len(list[1].Nodes) == 2 {
+		node := list[1].Nodes[1]
+		if node.Name == "node2" && node.NodeNum == 2 && len(node.Port) == 1 && node.Port[0] == 27024 && !node.Running {
+			t.Logf("ok - node description %+v\n", node)
+		} else {
+			t.Logf("not ok - unexpected node description %+v\n", node)
+			t.Fail()
+		}
+	}
+}
+
+// A catalog that can't be decoded is reported to the callers,
+// and is not replaced by an update
+func TestCorruptedCatalog(t *testing.T) {
+	if !defaults.IsCatalogEnabled() {
+		t.Skip("catalog disabled")
+	}
+	catalog_dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(catalog_dir)
+	saved_dir, saved_registry, saved_lock := defaults.ConfigurationDir, defaults.SandboxRegistry, defaults.SandboxRegistryLock
+	defer func() {
+		defaults.ConfigurationDir, defaults.SandboxRegistry, defaults.SandboxRegistryLock = saved_dir, saved_registry, saved_lock
+	}()
+	defaults.ConfigurationDir = catalog_dir
+	defaults.SandboxRegistry = catalog_dir + "/sandboxes.json"
+	defaults.SandboxRegistryLock = catalog_dir + "/sandboxes.lock"
+	ok_error := func(label string, err error) {
+		if err != nil {
+			t.Logf("ok - %s: error '%s'\n", label, err)
+		} else {
+			t.Logf("not ok - %s: expected error\n", label)
+			t.Fail()
+		}
+	}
+	corrupted := "{ \"/sandboxes/msb_5_7_22\": "
+	common.WriteString(corrupted, defaults.SandboxRegistry)
+
+	_, err = defaults.ReadCatalog()
+	ok_error("ReadCatalog", err)
+	err = defaults.UpdateCatalog(catalog_dir+"/msb_8_0_11", defaults.SandboxItem{})
+	ok_error("UpdateCatalog", err)
+	err = defaults.DeleteFromCatalog(catalog_dir + "/msb_5_7_22")
+	ok_error("DeleteFromCatalog", err)
+	err = defaults.RenameInCatalog(catalog_dir+"/msb_5_7_22", catalog_dir+"/msb_5_7_23")
+	ok_error("RenameInCatalog", err)
+	_, err = CheckCatalog(catalog_dir)
+	ok_error("CheckCatalog", err)
+	err = RepairCatalog([]CatalogIssue{{Kind: CatalogPortMismatch, Sandbox: catalog_dir + "/msb_5_7_22"}})
+	ok_error("RepairCatalog", err)
+	if common.SlurpAsString(defaults.SandboxRegistry) == corrupted {
+		t.Logf("ok - catalog left unchanged\n")
+	} else {
+		t.Logf("not ok - catalog was overwritten\n")
+		t.Fail()
+	}
+}
+
+func TestServerStatus(t *testing.T) {
+	sandbox_dir, err := ioutil.TempDir("", "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(sandbox_dir)
+	common.Mkdir(sandbox_dir + "/data")
+	socket := sandbox_dir + "/mysql_sandbox5000.sock"
+	common.WriteString("[client]\nport = 5000\nsocket = "+socket+"\n", sandbox_dir+"/my.sandbox.cnf")
+	common.WriteString("0123456789", sandbox_dir+"/data/ibdata1")
+	pid_file := sandbox_dir + "/data/mysql_sandbox5000.pid"
+
+	var checks = []struct {
+		pid           string
+		expected      string
+		expected_pid  int
+		create_socket bool
+	}{
+		{"", StateStopped, 0, false},
+		{"999999999", StateStale, 999999999, false},
+		{fmt.Sprintf("%d", os.Getpid()), StateRunning, os.Getpid(), true},
+	}
+	for _, c := range checks {
+		if c.pid != "" {
+			common.WriteString(c.pid, pid_file)
+		}
+		if c.create_socket {
+			common.WriteString("", socket)
+		}
+		status := server_status(sandbox_dir, 5000, "", false)
+		if status.State == c.expected && status.PID == c.expected_pid && status.SocketExists == c.create_socket {
+			t.Logf("ok - pid '%s' gives state %s\n", c.pid, status.State)
+		} else {
+			t.Logf("not ok - pid '%s': expected %s/%d/%v - got %s/%d/%v\n", c.pid,
+				c.expected, c.expected_pid, c.create_socket, status.State, status.PID, status.SocketExists)
+			t.Fail()
+		}
+		if status.Socket != socket {
+			t.Logf("not ok - expected socket %s - got %s\n", socket, status.Socket)
+			t.Fail()
+		}
+		if status.DataSize < 10 {
+			t.Logf("not ok - expected data size of at least 10 - got %d\n", status.DataSize)
+			t.Fail()
+		}
+	}
+}
+
+func TestServerProcess(t *testing.T) {
+	base_dir, err := ioutil.TempDir("", "process")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base_dir)
+	basedir := base_dir + "/5.7.22"
+	sandbox_dir := base_dir + "/msb_5_7_22"
+	for _, dir := range []string{basedir, basedir + "/bin", sandbox_dir, sandbox_dir + "/data", sandbox_dir + "/tmp"} {
+		common.Mkdir(dir)
+	}
+	pid_file := sandbox_dir + "/data/mysql_sandbox5722.pid"
+	socket := sandbox_dir + "/tmp/mysql_sandbox5722.sock"
+	common.WriteSandboxDescription(sandbox_dir, common.SandboxDescription{Basedir: basedir, SBType: "single", Version: "5.7.22", Port: []int{5722}})
+	common.WriteString("[mysqld]\nport = 5722\nsocket = "+socket+"\npid-file = "+pid_file+"\n", sandbox_dir+"/my.sandbox.cnf")
+	// A fake mysqld_safe that launches a long running process as the server
+	mysqld_safe := basedir + "/bin/mysqld_safe"
+	common.WriteString("#!/bin/bash\nsleep 300 &\necho $! > "+pid_file+"\ntouch "+socket+"\nwait\n", mysqld_safe)
+	os.Chmod(mysqld_safe, 0755)
+	client := basedir + "/bin/mysql"
+	common.WriteString("#!/bin/bash\necho 1\n", client)
+	os.Chmod(client, 0755)
+
+	server, err := NewServerProcess(sandbox_dir)
+	if err != nil {
+		t.Fatalf("not ok - %s", err)
+	}
+	err = server.Start(context.Background(), "", nil, 10*time.Second)
+	if err == nil && server.IsRunning() && common.FileExists(socket) {
+		t.Logf("ok - server started with pid %d\n", server.Pid())
+	} else {
+		t.Logf("not ok - server not started: %v\n", err)
+		t.Fail()
+	}
+	pid := server.Pid()
+	err = server.Stop(10 * time.Second)
+	if err == nil && !server.IsRunning() && !process_exists(pid) && !common.FileExists(pid_file) {
+		t.Logf("ok - server stopped\n")
+	} else {
+		t.Logf("not ok - server not stopped: %v\n", err)
+		t.Fail()
+	}
+	err = server.Start(context.Background(), "", nil, 10*time.Second)
+	if err != nil || !server.IsRunning() {
+		t.Fatalf("not ok - server not restarted: %v\n", err)
+	}
+	pid = server.Pid()
+	safe_pid := server.mysqld_safe_pid()
+	err = server.Kill()
+	if err == nil && !process_exists(pid) && !common.FileExists(pid_file) {
+		t.Logf("ok - server killed\n")
+	} else {
+		t.Logf("not ok - server not killed: %v\n", err)
+		t.Fail()
+	}
+	// The killed mysqld_safe is reaped by the goroutine started with it
+	if safe_pid > 0 && wait_for(5*time.Second, func() bool { return !process_exists(safe_pid) }) {
+		t.Logf("ok - mysqld_safe killed\n")
+	} else {
+		t.Logf("not ok - mysqld_safe %d still running\n", safe_pid)
+		t.Fail()
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	base_dir, err := ioutil.TempDir("", "readiness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base_dir)
+	common.Mkdir(base_dir + "/bin")
+	common.Mkdir(base_dir + "/data")
+	common.WriteString("", base_dir+"/data/mysql_sandbox5722.pid")
+	client := base_dir + "/bin/mysql"
+	server := ServerProcess{
+		SandboxDir: base_dir,
+		Basedir:    base_dir,
+		Port:       5722,
+		PidFile:    base_dir + "/data/mysql_sandbox5722.pid",
+	}
+	var checks = []struct {
+		label    string
+		client   string
+		options  ReadinessOptions
+		expected string // failed check, or empty if ready
+		timeout  bool   // failure after the timeout, rather than immediate
+	}{
+		{"ready", "echo 1", ReadinessOptions{}, "", false},
+		{"not reachable", "echo \"ERROR 2002 (HY000): Can't connect\"; exit 1", ReadinessOptions{}, CheckConnection, true},
+		{"access denied", "echo \"ERROR 1045 (28000): Access denied\"; exit 1", ReadinessOptions{}, CheckConnection, false},
+		{"writable", "case \"$4\" in *read_only*) printf 'read_only\\tOFF\\n';; *) echo 1;; esac",
+			ReadinessOptions{Writable: true}, "", false},
+		{"read only", "case \"$4\" in *read_only*) printf 'read_only\\tOFF\\nsuper_read_only\\tON\\n';; *) echo 1;; esac",
+			ReadinessOptions{Writable: true}, CheckWritable, true},
+		{"online", "case \"$4\" in *MEMBER_STATE*) echo ONLINE;; *) echo 1;; esac",
+			ReadinessOptions{Group: true}, "", false},
+		{"recovering", "case \"$4\" in *MEMBER_STATE*) echo RECOVERING;; *) echo 1;; esac",
+			ReadinessOptions{Group: true}, CheckGroup, true},
+	}
+	for _, c := range checks {
+		common.WriteString("#!/bin/bash\n"+c.client+"\n", client)
+		os.Chmod(client, 0755)
+		c.options.Timeout = 300 * time.Millisecond
+		err := server.WaitReady(c.options)
+		if c.expected == "" {
+			if err == nil {
+				t.Logf("ok - %s: server ready\n", c.label)
+			} else {
+				t.Logf("not ok - %s: %s\n", c.label, err)
+				t.Fail()
+			}
+			continue
+		}
+		readiness_error, ok := err.(*ReadinessError)
+		if ok && readiness_error.Check == c.expected && (readiness_error.Timeout > 0) == c.timeout {
+			t.Logf("ok - %s: %s\n", c.label, err)
+		} else {
+			t.Logf("not ok - %s: expected failed check '%s' (timeout: %v) - got %v\n", c.label, c.expected, c.timeout, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestReadinessBeforeGrants(t *testing.T) {
+	set_mock_environment("mock_dir")
+	create_mock_version("8.0.11")
+	// A sandbox without grants, like a new slave, only accepts root
+	// without password when it starts
+	var sdef = SandboxDef{
+		Version:        "8.0.11",
+		Basedir:        mock_sandbox_binary + "/8.0.11",
+		SandboxDir:     mock_sandbox_home,
+		DirName:        "msb_8_0_11",
+		LoadGrants:     false,
+		InstalledPorts: []int{1186, 3306, 33060},
+		Port:           8111,
+		DbUser:         "msandbox",
+		RplUser:        "rsandbox",
+		DbPassword:     "msandbox",
+		RplPassword:    "rsandbox",
+		RemoteAccess:   "127.%",
+		BindAddress:    "127.0.0.1",
+	}
+	sandbox_dir := mock_sandbox_home + "/msb_8_0_11"
+	_, err := CreateSingleSandbox(sdef)
+	if err == nil {
+		t.Logf("ok - sandbox without grants started\n")
+	} else {
+		t.Logf("not ok - sandbox without grants not started: %s\n", err)
+		t.Fail()
+	}
+	server, err := NewServerProcess(sandbox_dir)
+	if err != nil {
+		remove_mock_environment("mock_dir")
+		t.Fatalf("not ok - %s", err)
+	}
+	client := server.Basedir + "/bin/mysql"
+	sandbox_login := []string{"--defaults-file=" + sandbox_dir + "/my.sandbox.cnf"}
+	root_login := []string{"--no-defaults", "--user=root", "--socket=" + server.Socket}
+	_, err = run_client(client, sandbox_login, "SELECT 1")
+	if err != nil && access_denied_re.MatchString(err.Error()) {
+		t.Logf("ok - sandbox user refused before grants\n")
+	} else {
+		t.Logf("not ok - sandbox user not refused before grants: %v\n", err)
+		t.Fail()
+	}
+
+	err, _ = common.Run_cmd(sandbox_dir + "/load_grants")
+	if err != nil {
+		t.Logf("not ok - grants not loaded: %s\n", err)
+		t.Fail()
+	}
+	_, err = run_client(client, root_login, "SELECT 1")
+	if err != nil && access_denied_re.MatchString(err.Error()) {
+		t.Logf("ok - root without password refused after grants\n")
+	} else {
+		t.Logf("not ok - root without password not refused after grants: %v\n", err)
+		t.Fail()
+	}
+	err = server.WaitReady(ReadinessOptions{Timeout: time.Second})
+	if err == nil {
+		t.Logf("ok - server ready after grants\n")
+	} else {
+		t.Logf("not ok - server not ready after grants: %s\n", err)
+		t.Fail()
+	}
+	server.Stop(DefaultStopTimeout)
+	remove_mock_environment("mock_dir")
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	set_mock_environment("mock_dir")
+	sandbox_dir := mock_sandbox_home + "/msb_5_7_22"
+	common.Mkdir(sandbox_dir)
+	common.Mkdir(sandbox_dir + "/data")
+	common.WriteSandboxDescription(sandbox_dir, common.SandboxDescription{SBType: "single", Version: "5.7.22"})
+	common.WriteString("", sandbox_dir+"/data/before_snapshot")
+	common.Mkdir(sandbox_dir + "/" + SnapshotDir)
+	good := sandbox_dir + "/" + SnapshotDir + "/good" + snapshot_suffix
+	err := unpack.PackTar(good, sandbox_dir, []string{"data"})
+	if err != nil {
+		remove_mock_environment("mock_dir")
+		t.Fatalf("not ok - error creating snapshot: %s", err)
+	}
+	marker := sandbox_dir + "/data/after_snapshot"
+	common.WriteString("", marker)
+	_, logger := defaults.NewLogger(common.LogDirName(), "restore")
+
+	// A snapshot that can't be extracted must not touch the current data
+	broken := sandbox_dir + "/" + SnapshotDir + "/broken" + snapshot_suffix
+	common.WriteString("not an archive", broken)
+	err = restore_snapshot(sandbox_dir, broken, logger)
+	if err != nil {
+		t.Logf("ok - broken snapshot refused: %s\n", err)
+	} else {
+		t.Logf("not ok - broken snapshot restored\n")
+		t.Fail()
+	}
+	if common.FileExists(marker) {
+		t.Logf("ok - data kept after failed restore\n")
+	} else {
+		t.Logf("not ok - data lost after failed restore\n")
+		t.Fail()
+	}
+
+	err = restore_snapshot(sandbox_dir, good, logger)
+	if err == nil {
+		t.Logf("ok - snapshot restored\n")
+	} else {
+		t.Logf("not ok - error restoring snapshot: %s\n", err)
+		t.Fail()
+	}
+	if !common.FileExists(marker) && common.FileExists(sandbox_dir+"/data/before_snapshot") {
+		t.Logf("ok - data replaced by the snapshot\n")
+	} else {
+		t.Logf("not ok - data not replaced by the snapshot\n")
+		t.Fail()
+	}
+	leftovers, _ := filepath.Glob(sandbox_dir + "/restore-*")
+	if len(leftovers) == 0 {
+		t.Logf("ok - no temporary directories left\n")
+	} else {
+		t.Logf("not ok - temporary directories left: %v\n", leftovers)
+		t.Fail()
+	}
+	remove_mock_environment("mock_dir")
+}
+
+func TestResolveTemplate(t *testing.T) {
+	type resolve_case struct {
+		name     string
+		version  string
+		expected string
+	}
+	var cases = []resolve_case{
+		{"grants_template", "5.5.60", "grants_template5x"},
+		{"grants_template", "5.7.5", "grants_template5x"},
+		{"grants_template", "5.7.22", "grants_template57"},
+		{"grants_template", "8.0.11", "grants_template8x"},
+		{"grants_template", "10.2.15", "grants_template5x"},
+		{"gtid_options", "5.6.40", "gtid_options_56"},
+		{"gtid_options", "8.0.11", "gtid_options_57"},
+		{"grants_template57", "5.5.60", "grants_template57"},
+	}
+	for _, c := range cases {
+		result, err := ResolveTemplate(SingleTemplates, c.name, c.version)
+		if err == nil && result == c.expected {
+			t.Logf("ok - %s %s: %s\n", c.name, c.version, result)
+		} else {
+			t.Logf("not ok - %s %s: expected %s - got %s (%v)\n", c.name, c.version, c.expected, result, err)
+			t.Fail()
+		}
+	}
+
+	var collection = TemplateCollection{
+		"one_a": TemplateDesc{Family: "one", Versions: ">=5.7.0"},
+		"one_b": TemplateDesc{Family: "one", Versions: "5.7.0-8.0.0"},
+		"two_a": TemplateDesc{Family: "two", Versions: ">=8.0.0"},
+	}
+	var failing = map[string]string{
+		"one":  "5.7.22", // more than one variant
+		"two":  "5.7.22", // no variant and no default
+		"none": "5.7.22", // no such template
+	}
+	for name, version := range failing {
+		result, err := ResolveTemplate(collection, name, version)
+		if err != nil {
+			t.Logf("ok - %s %s: error '%s'\n", name, version, err)
+		} else {
+			t.Logf("not ok - %s %s: expected error - got %s\n", name, version, result)
+			t.Fail()
+		}
+	}
+}
+
+// The variants must choose the same templates as the version checks
+// that they replaced. In particular, MariaDB 10 gets the default variants.
+func TestResolveTemplateLegacy(t *testing.T) {
+	legacy_grants := func(version string) string {
+		if common.GreaterOrEqualVersion(version, []int{8, 0, 0}) {
+			return "grants_template8x"
+		}
+		if common.GreaterOrEqualVersion(version, []int{5, 7, 6}) {
+			return "grants_template57"
+		}
+		return "grants_template5x"
+	}
+	legacy_gtid := func(version string) string {
+		if common.GreaterOrEqualVersion(version, []int{5, 7, 0}) {
+			return "gtid_options_57"
+		}
+		return "gtid_options_56"
+	}
+	var versions = []string{"5.0.96", "5.1.73", "5.5.60", "5.6.40", "5.7.0", "5.7.5", "5.7.6",
+		"5.7.22", "8.0.0", "8.0.11", "10.0.35", "10.1.34", "10.2.15", "10.3.8"}
+	for _, version := range versions {
+		for name, legacy := range map[string]func(string) string{"grants_template": legacy_grants, "gtid_options": legacy_gtid} {
+			expected := legacy(version)
+			result, err := ResolveTemplate(SingleTemplates, name, version)
+			if err == nil && result == expected {
+				t.Logf("ok - %s %s: %s\n", name, version, result)
+			} else {
+				t.Logf("not ok - %s %s: expected %s - got %s (%v)\n", name, version, expected, result, err)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestVersionFeatures(t *testing.T) {
+	var legacy = map[string][]int{
+		"general-log":              {5, 1, 0},
+		"pxc":                      {5, 6, 0},
+		"server-uuid":              {5, 6, 9},
+		"initialize":               {5, 7, 0},
+		"multi-source-replication": {5, 7, 9},
+		"mysqlx-plugin":            {5, 7, 12},
+		"group-replication":        {5, 7, 17},
+		"data-dictionary":          {8, 0, 0},
+		"caching-sha2-password":    {8, 0, 4},
+		"mysqlx-default":           {8, 0, 11},
+	}
+	if len(legacy) != len(VersionFeatures) {
+		t.Logf("not ok - %d features expected - found %d\n", len(legacy), len(VersionFeatures))
+		t.Fail()
+	}
+	var versions = []string{"5.0.96", "5.1.73", "5.6.8", "5.6.9", "5.7.0", "5.7.11", "5.7.12",
+		"5.7.17", "8.0.3", "8.0.4", "8.0.11", "10.1.34", "10.3.8"}
+	for feature, min_version := range legacy {
+		for _, version := range versions {
+			expected := common.GreaterOrEqualVersion(version, min_version)
+			result := has_feature(version, feature)
+			if result == expected {
+				t.Logf("ok - %s %s: %v\n", feature, version, result)
+			} else {
+				t.Logf("not ok - %s %s: expected %v - got %v\n", feature, version, expected, result)
+				t.Fail()
+			}
+		}
+		err := check_feature("5.0.96", feature, feature)
+		if err != nil && strings.Contains(err.Error(), fmt.Sprintf("%d.%d.%d", min_version[0], min_version[1], min_version[2])) {
+			t.Logf("ok - %s: %s\n", feature, err)
+		} else {
+			t.Logf("not ok - %s: unexpected error %v\n", feature, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	checked, failures := ValidateTemplates(AllTemplates)
+	if checked > 0 && len(failures) == 0 {
+		t.Logf("ok - %d built-in templates validated\n", checked)
+	} else {
+		t.Logf("not ok - %d templates checked - failures: %v\n", checked, failures)
+		t.Fail()
+	}
+	// Every built-in template must be filled with the data of a deployment
+	for group, collection := range AllTemplates {
+		for name := range collection {
+			if name != "Copyright" && len(template_sample_uses(group, name)) == 0 {
+				t.Logf("not ok - template %s/%s is not used by any deployment\n", group, name)
+				t.Fail()
+			}
+		}
+	}
+
+	var collections = AllTemplateCollection{
+		"single": TemplateCollection{
+			"good":    TemplateDesc{Contents: "port={{.Port}}"},
+			"syntax":  TemplateDesc{Contents: "port={{.Port}"},
+			"missing": TemplateDesc{Contents: "port={{.Prot}}"},
+		},
+		"group": TemplateCollection{
+			"nodes": TemplateDesc{Contents: "{{range .Nodes}}{{.Nodeport}}{{end}}"},
+		},
+	}
+	var expected = map[string]int{
+		"single/syntax":  0,
+		"single/missing": 3,
+		"group/nodes":    1,
+	}
+	checked, failures = ValidateTemplates(collections)
+	if checked == 4 && len(failures) == len(expected) {
+		t.Logf("ok - %d failures from %d templates\n", len(failures), checked)
+	} else {
+		t.Logf("not ok - expected %d failures from 4 templates - got %d from %d\n", len(expected), len(failures), checked)
+		t.Fail()
+	}
+	for _, failure := range failures {
+		name := failure.Group + "/" + failure.Name
+		sb_types, ok := expected[name]
+		if ok && len(failure.SandboxTypes) == sb_types {
+			t.Logf("ok - %s\n", failure)
+		} else {
+			t.Logf("not ok - unexpected failure %s\n", failure)
+			t.Fail()
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	set_mock_environment("mock_dir")
+	create_mock_version("5.7.22")
+	var sdef = SandboxDef{
+		Version:        "5.7.22",
+		Basedir:        mock_sandbox_binary + "/5.7.22",
+		SandboxDir:     mock_sandbox_home,
+		InstalledPorts: []int{1186, 3306, 33060},
+		Port:           5722,
+		DbUser:         "msandbox",
+		RplUser:        "rsandbox",
+		DbPassword:     "msandbox",
+		RplPassword:    "rsandbox",
+		RemoteAccess:   "127.%",
+		BindAddress:    "127.0.0.1",
+	}
+	log_directory := defaults.Defaults().LogDirectory
+	log_dir_existed := common.DirExists(log_directory)
+	defaults.LogSBOperations = true
+	plan := StartDryRun()
+	err := CreateReplicationSandbox(sdef, "5.7.22", "master-slave", 3, "127.0.0.1", "", "")
+	StopDryRun()
+	if err != nil {
+		t.Logf("not ok - error in dry run: %s\n", err)
+		t.Fail()
+	}
+	if defaults.LogSBOperations {
+		t.Logf("ok - operation logging restored after dry run\n")
+	} else {
+		t.Logf("not ok - operation logging still disabled after dry run\n")
+		t.Fail()
+	}
+	defaults.LogSBOperations = false
+	if !log_dir_existed && common.DirExists(log_directory) {
+		t.Logf("not ok - log directory %s created during dry run\n", log_directory)
+		t.Fail()
+	} else {
+		t.Logf("ok - no logs written during dry run\n")
+	}
+	sandbox_dir := mock_sandbox_home + "/rsandbox_5_7_22"
+	if common.DirExists(sandbox_dir) {
+		t.Logf("not ok - %s created during dry run\n", sandbox_dir)
+		t.Fail()
+	} else {
+		t.Logf("ok - %s not created\n", sandbox_dir)
+	}
+	catalog, err := defaults.ReadCatalog()
+	if err == nil && len(catalog) == 0 {
+		t.Logf("ok - catalog unchanged\n")
+	} else {
+		t.Logf("not ok - catalog changed during dry run\n")
+		t.Fail()
+	}
+	// three nodes and the replication directory
+	if len(plan.Sandboxes) == 4 {
+		t.Logf("ok - 4 sandboxes planned\n")
+	} else {
+		t.Logf("not ok - expected 4 sandboxes - got %d\n", len(plan.Sandboxes))
+		t.Fail()
+	}
+	var server_ids []int
+	for _, sb := range plan.Sandboxes {
+		if sb.SBType == "replication-node" {
+			server_ids = append(server_ids, sb.ServerId)
+		}
+	}
+	if fmt.Sprintf("%v", server_ids) == "[100 200 300]" {
+		t.Logf("ok - server IDs %v\n", server_ids)
+	} else {
+		t.Logf("not ok - expected server IDs [100 200 300] - got %v\n", server_ids)
+		t.Fail()
+	}
+	var expected_items = []struct {
+		label string
+		list  []string
+		item  string
+	}{
+		{"directory", plan.Directories, sandbox_dir + "/node1/data"},
+		{"script", plan.Scripts, sandbox_dir + "/master/my.sandbox.cnf"},
+		{"script", plan.Scripts, sandbox_dir + "/initialize_slaves"},
+		{"command", plan.Commands, sandbox_dir + "/node2/start"},
+		{"command", plan.Commands, sandbox_dir + "/initialize_slaves"},
+	}
+	for _, e := range expected_items {
+		found := false
+		for _, item := range e.list {
+			if item == e.item {
+				found = true
+			}
+		}
+		if found {
+			t.Logf("ok - %s %s planned\n", e.label, e.item)
+		} else {
+			t.Logf("not ok - %s %s not found in the plan\n", e.label, e.item)
+			t.Fail()
+		}
 	}
 	remove_mock_environment("mock_dir")
 }
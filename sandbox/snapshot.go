@@ -0,0 +1,202 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/unpack"
+)
+
+// Directory, inside the sandbox, where snapshots are stored
+const SnapshotDir = "snapshots"
+
+const snapshot_suffix = ".tar.gz"
+
+// Returns the data directories of a sandbox, relative to the sandbox directory
+func snapshot_items(sandbox_dir string) []string {
+	if common.ReadSandboxDescription(sandbox_dir).Nodes == 0 {
+		return []string{"data"}
+	}
+	var items []string
+	for _, node := range sandbox_nodes(sandbox_dir) {
+		items = append(items, node.Name+"/data")
+	}
+	// The data nodes of a NDB cluster keep their data outside the mysqld nodes
+	if common.DirExists(sandbox_dir + "/ndb_data") {
+		items = append(items, "ndb_data")
+	}
+	sort.Strings(items)
+	return items
+}
+
+// ListSnapshots returns the names of the snapshots of a sandbox
+func ListSnapshots(sandbox_dir string) []string {
+	files, _ := filepath.Glob(sandbox_dir + "/" + SnapshotDir + "/*" + snapshot_suffix)
+	var names []string
+	for _, fname := range files {
+		names = append(names, strings.TrimSuffix(common.BaseName(fname), snapshot_suffix))
+	}
+	return names
+}
+
+// SnapshotSandbox saves the data directories of a sandbox into a
+// compressed archive, inside the sandbox directory. All the nodes of a
+// multiple sandbox are stopped before archiving, so that their data is
+// consistent. The sandbox is restarted afterwards if it was running.
+// When name is empty, the snapshot is named after the current time.
+// It returns the name of the snapshot.
+func SnapshotSandbox(sandbox_dir, name string) (string, error) {
+	if name == "" {
+		name = time.Now().Format("20060102-150405")
+	}
+	if strings.Contains(name, "/") {
+		return "", fmt.Errorf("Invalid snapshot name '%s'", name)
+	}
+	tarball := sandbox_dir + "/" + SnapshotDir + "/" + name + snapshot_suffix
+	if common.FileExists(tarball) {
+		return "", fmt.Errorf("Snapshot '%s' already exists in %s", name, common.BaseName(sandbox_dir))
+	}
+	_, logger := defaults.NewLogger(common.LogDirName(), "snapshot")
+	logger.Printf("Stopping %s\n", sandbox_dir)
+	was_running, err := stop_sandbox(sandbox_dir)
+	if err != nil {
+		return "", err
+	}
+	if !common.DirExists(sandbox_dir + "/" + SnapshotDir) {
+		common.Mkdir(sandbox_dir + "/" + SnapshotDir)
+	}
+	items := snapshot_items(sandbox_dir)
+	logger.Printf("Saving %v into %s\n", items, tarball)
+	err = unpack.PackTar(tarball, sandbox_dir, items)
+	if err != nil {
+		os.Remove(tarball)
+		err = fmt.Errorf("Error creating snapshot %s: %s", tarball, err)
+	}
+	if was_running {
+		logger.Printf("Starting %s\n", sandbox_dir)
+		err = restart_after(sandbox_dir, err)
+	}
+	return name, err
+}
+
+// Starts a sandbox that was stopped before an operation.
+// If the operation failed (err != nil), a failure to restart
+// is reported together with the original error.
+func restart_after(sandbox_dir string, err error) error {
+	start_err := start_sandbox(sandbox_dir)
+	if start_err == nil {
+		return err
+	}
+	if err == nil {
+		return start_err
+	}
+	return fmt.Errorf("%s. Besides, the sandbox could not be restarted: %s", err, start_err)
+}
+
+// RestoreSandbox replaces the data directories of a sandbox with the
+// ones saved in a snapshot. The sandbox is stopped during the operation,
+// and restarted afterwards if it was running.
+// The snapshot is extracted into a temporary directory inside the sandbox
+// before replacing anything, so that the current data is kept if the
+// extraction fails.
+func RestoreSandbox(sandbox_dir, name string) error {
+	tarball := sandbox_dir + "/" + SnapshotDir + "/" + name + snapshot_suffix
+	if !common.FileExists(tarball) {
+		return fmt.Errorf("Snapshot '%s' not found in %s. Available snapshots: %v",
+			name, common.BaseName(sandbox_dir), ListSnapshots(sandbox_dir))
+	}
+	_, logger := defaults.NewLogger(common.LogDirName(), "restore")
+	logger.Printf("Stopping %s\n", sandbox_dir)
+	was_running, err := stop_sandbox(sandbox_dir)
+	if err != nil {
+		return err
+	}
+	err = restore_snapshot(sandbox_dir, tarball, logger)
+	if was_running {
+		logger.Printf("Starting %s\n", sandbox_dir)
+		err = restart_after(sandbox_dir, err)
+	}
+	return err
+}
+
+// Extracts a snapshot into a temporary directory, and then swaps the
+// extracted data directories with the current ones.
+// If any step fails, the current data directories are left in place.
+func restore_snapshot(sandbox_dir, tarball string, logger *defaults.Logger) error {
+	restore_dir, err := ioutil.TempDir(sandbox_dir, "restore-")
+	if err != nil {
+		return fmt.Errorf("Error creating temporary directory in %s: %s", sandbox_dir, err)
+	}
+	defer os.RemoveAll(restore_dir)
+	new_dir := restore_dir + "/new"
+	old_dir := restore_dir + "/old"
+	common.Mkdir(new_dir)
+	logger.Printf("Extracting %s into %s\n", tarball, new_dir)
+	// UnpackTar changes the current directory, which is going to be removed
+	current_dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	err = unpack.UnpackTar(tarball, new_dir, unpack.SILENT)
+	os.Chdir(current_dir)
+	if err != nil {
+		return fmt.Errorf("Error restoring snapshot %s: %s", tarball, err)
+	}
+	items := snapshot_items(sandbox_dir)
+	for _, item := range items {
+		if !common.DirExists(new_dir + "/" + item) {
+			return fmt.Errorf("Snapshot %s does not contain %s", tarball, item)
+		}
+	}
+	// Moves the current items out of the way, and the extracted ones in.
+	// On failure, the items already swapped are put back.
+	var swapped []string
+	undo := func() {
+		for N := len(swapped) - 1; N >= 0; N-- {
+			item := swapped[N]
+			os.Rename(sandbox_dir+"/"+item, new_dir+"/"+item)
+			os.Rename(old_dir+"/"+item, sandbox_dir+"/"+item)
+		}
+	}
+	for _, item := range items {
+		logger.Printf("Replacing %s/%s\n", sandbox_dir, item)
+		err = os.MkdirAll(common.DirName(old_dir+"/"+item), 0755)
+		if err == nil && common.DirExists(sandbox_dir+"/"+item) {
+			err = os.Rename(sandbox_dir+"/"+item, old_dir+"/"+item)
+		}
+		if err != nil {
+			undo()
+			return fmt.Errorf("Error moving %s/%s: %s", sandbox_dir, item, err)
+		}
+		err = os.Rename(new_dir+"/"+item, sandbox_dir+"/"+item)
+		if err != nil {
+			os.Rename(old_dir+"/"+item, sandbox_dir+"/"+item)
+			undo()
+			return fmt.Errorf("Error restoring %s/%s: %s", sandbox_dir, item, err)
+		}
+		swapped = append(swapped, item)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+)
+
+const (
+	StateRunning = "running"
+	StateStopped = "stopped"
+	StateStale   = "stale" // the pid file exists, but the process is gone
+)
+
+// The live state of a single database server
+type ServerStatus struct {
+	State          string `json:"state" yaml:"state"`
+	PID            int    `json:"pid" yaml:"pid"`
+	Socket         string `json:"socket" yaml:"socket"`
+	SocketExists   bool   `json:"socket-exists" yaml:"socket-exists"`
+	Uptime         int64  `json:"uptime" yaml:"uptime"`                                       // seconds
+	DataSize       int64  `json:"data-size" yaml:"data-size"`                                 // bytes
+	ReplicationLag *int64 `json:"replication-lag,omitempty" yaml:"replication-lag,omitempty"` // seconds
+}
+
+var socket_re = regexp.MustCompile(`^\s*socket\s*=\s*(\S+)`)
+var lag_re = regexp.MustCompile(`Seconds_Behind_(?:Master|Source):\s*(\S+)`)
+
+// Returns the socket defined in the options file of a sandbox
+func sandbox_socket(sandbox_dir string) string {
+	cnf_file := sandbox_dir + "/my.sandbox.cnf"
+	if !common.FileExists(cnf_file) {
+		return ""
+	}
+	for _, line := range common.SlurpAsLines(cnf_file) {
+		matches := socket_re.FindStringSubmatch(line)
+		if len(matches) > 1 {
+			return matches[1]
+		}
+	}
+	return ""
+}
+
+// Returns the total size of the files in a directory tree
+func dir_size(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// Asks the server for its replication lag, using the client in basedir.
+// Returns nil if the server is not a replica, or if the lag is unknown.
+// When a server replicates from several masters, the highest lag is reported.
+func replication_lag(sandbox_dir, basedir string) *int64 {
+	client := basedir + "/bin/mysql"
+	if !common.ExecExists(client) {
+		return nil
+	}
+	out, err := exec.Command(client, "--defaults-file="+sandbox_dir+"/my.sandbox.cnf",
+		"-e", "SHOW SLAVE STATUS\\G").Output()
+	if err != nil {
+		return nil
+	}
+	var lag *int64
+	for _, matches := range lag_re.FindAllStringSubmatch(string(out), -1) {
+		seconds, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if lag == nil || seconds > *lag {
+			lag = &seconds
+		}
+	}
+	return lag
+}
+
+// Collects the live state of the server in sandbox_dir, using its pid file
+// and socket. Replication lag is only checked when check_replication is set.
+func server_status(sandbox_dir string, port int, basedir string, check_replication bool) *ServerStatus {
+	status := ServerStatus{
+		State:    StateStopped,
+		Socket:   sandbox_socket(sandbox_dir),
+		DataSize: dir_size(sandbox_dir + "/data"),
+	}
+	if status.Socket != "" {
+		status.SocketExists = common.FileExists(status.Socket)
+	}
+	pid_file := fmt.Sprintf("%s/data/mysql_sandbox%d.pid", sandbox_dir, port)
+	info, err := os.Stat(pid_file)
+	if err != nil {
+		return &status
+	}
+	status.State = StateRunning
+	pid := read_pid(pid_file)
+	if pid > 0 {
+		status.PID = pid
+		if !process_exists(pid) {
+			status.State = StateStale
+			return &status
+		}
+	}
+	// The server writes its pid file at startup
+	status.Uptime = int64(time.Since(info.ModTime()).Seconds())
+	if check_replication {
+		status.ReplicationLag = replication_lag(sandbox_dir, basedir)
+	}
+	return &status
+}
+
+// AddSandboxStatus fills the live state of each sandbox and node in the list.
+// The running state of sandboxes and nodes is updated accordingly.
+func AddSandboxStatus(list []SandboxListItem) {
+	for i := range list {
+		sb := &list[i]
+		if !common.DirExists(sb.Destination) {
+			continue
+		}
+		if len(sb.Nodes) == 0 {
+			if len(sb.Port) > 0 {
+				sb.Status = server_status(sb.Destination, sb.Port[0], sb.Basedir, false)
+				sb.Running = sb.Status.State == StateRunning
+			}
+			continue
+		}
+		check_replication := sb.SBType != "multiple"
+		sb.Running = false
+		for j := range sb.Nodes {
+			node := &sb.Nodes[j]
+			if len(node.Port) == 0 {
+				continue
+			}
+			node.Status = server_status(sb.Destination+"/"+node.Name, node.Port[0], sb.Basedir, check_replication)
+			node.Running = node.Status.State == StateRunning
+			sb.Running = sb.Running || node.Running
+		}
+	}
+}
@@ -0,0 +1,288 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/datacharmer/dbdeployer/common"
+)
+
+// TemplateError describes a template that can't be parsed or that
+// fails to render with the data of a given sandbox type
+type TemplateError struct {
+	Group        string   // template collection
+	Name         string   // template name
+	SandboxTypes []string // sandbox types whose data fails (empty for syntax errors)
+	Err          error
+}
+
+func (e TemplateError) Error() string {
+	if len(e.SandboxTypes) == 0 {
+		return fmt.Sprintf("%s/%s: syntax error: %s", e.Group, e.Name, e.Err)
+	}
+	return fmt.Sprintf("%s/%s [%s]: %s", e.Group, e.Name, strings.Join(e.SandboxTypes, ","), e.Err)
+}
+
+// A deployment that fills templates of a collection, with the data
+// that it passes to them
+type template_use struct {
+	sb_type   string   // deployment, as reported in the errors
+	templates []string // templates filled by the deployment. When empty, all the templates not listed by other uses
+	data      func() common.Smap
+}
+
+// The sandbox definition used to build the sample data
+func sample_sdef(version string) SandboxDef {
+	return SandboxDef{
+		Version:     version,
+		Basedir:     "/opt/mysql/" + version,
+		SandboxDir:  "/sandboxes/sample",
+		DirName:     "node3",
+		RplUser:     "rsandbox",
+		RplPassword: "rsandbox",
+	}
+}
+
+// Ports of the nodes of a sample multiple sandbox
+var sample_node_ports = map[int]int{1: 5001, 2: 5002, 3: 5003}
+
+var multi_templates = []string{
+	"start_multi_template", "restart_multi_template", "status_multi_template", "test_sb_multi_template",
+	"stop_multi_template", "clear_multi_template", "send_kill_multi_template", "use_multi_template",
+}
+
+// The templates of each collection, with the deployments that fill them.
+// The data is built with the same functions that the deployments use
+var template_uses = map[string][]template_use{
+	"mock": {
+		{"mock", nil, func() common.Smap { return common.Smap{} }},
+	},
+	"single": {
+		{"single", nil, func() common.Smap { return sample_single_data("single") }},
+		{"replication-node", nil, func() common.Smap { return sample_single_data("replication-node") }},
+		{"group-node", nil, func() common.Smap { return sample_single_data("group-node") }},
+		{"admin-lock", []string{"sb_locked_template"}, func() common.Smap {
+			return LockedClearData("msb_sample", "clear", "no_clear")
+		}},
+	},
+	"multiple": {
+		{"multiple", multi_templates, sample_multiple_data},
+		{"group", multi_templates, sample_group_data},
+		{"galera", []string{"restart_multi_template", "status_multi_template", "test_sb_multi_template",
+			"clear_multi_template", "send_kill_multi_template", "use_multi_template"}, sample_galera_data},
+		{"ndb", []string{"restart_multi_template", "test_sb_multi_template",
+			"send_kill_multi_template", "use_multi_template"}, sample_ndb_data},
+		{"node", []string{"node_template"}, func() common.Smap {
+			return node_script_data("/sandboxes/sample", 1, 5001)
+		}},
+	},
+	"replication": {
+		{"master-slave", []string{"start_all_template", "restart_all_template", "status_all_template",
+			"test_sb_all_template", "stop_all_template", "clear_all_template", "send_kill_all_template",
+			"use_all_template", "use_all_slaves_template", "use_all_masters_template", "init_slaves_template",
+			"check_slaves_template", "master_template", "test_replication_template", "semi_sync_start_template"},
+			sample_master_slave_data},
+		{"master-slave-slave", []string{"slave_template"}, func() common.Smap {
+			return sample_master_slave_data()["Slaves"].([]common.Smap)[0]
+		}},
+		{"fan-in", []string{"slave_template", "multi_source_test_template", "check_multi_source_template",
+			"multi_source_use_slaves_template", "multi_source_use_masters_template", "multi_source_template"},
+			sample_fan_in_data},
+		{"tree", []string{"multi_source_use_slaves_template", "multi_source_use_masters_template",
+			"tree_init_slaves_template", "tree_check_slaves_template"}, sample_tree_data},
+		{"group", []string{"multi_source_use_slaves_template", "multi_source_use_masters_template",
+			"multi_source_test_template"}, sample_group_data},
+		{"add-slave", []string{"add_slave_template"}, func() common.Smap {
+			master := NodeDescription{Name: "node1", Description: common.SandboxDescription{Port: []int{5001}}}
+			return add_slave_data(nil, sample_sdef("5.7.22"), "127.0.0.1", master)
+		}},
+	},
+	"group": {
+		{"group", nil, sample_group_data},
+	},
+	"galera": {
+		{"galera", nil, sample_galera_data},
+		{"galera-node", []string{"galera_replication_options"}, func() common.Smap {
+			return galera_options_data(sample_sdef("10.2.15"), "/opt/mysql/10.2.15/lib/libgalera_smm.so",
+				"rsync", "wsrep_on=ON", "127.0.0.1", []string{"127.0.0.1:7001", "127.0.0.1:7004"}, 1, 7001)
+		}},
+	},
+	"ndb": {
+		{"ndb", nil, sample_ndb_data},
+	},
+}
+
+// Returns the deployments that fill a template. The templates not listed
+// by any deployment are filled by the ones that don't list their templates
+func template_sample_uses(group, name string) []template_use {
+	var listed, unlisted []template_use
+	for _, use := range template_uses[group] {
+		if len(use.templates) == 0 {
+			unlisted = append(unlisted, use)
+			continue
+		}
+		for _, template_name := range use.templates {
+			if template_name == name {
+				listed = append(listed, use)
+			}
+		}
+	}
+	if len(listed) > 0 {
+		return listed
+	}
+	return unlisted
+}
+
+// Data with the same keys that CreateSingleSandbox uses to fill
+// the single sandbox templates
+func sample_single_data(sb_type string) common.Smap {
+	node_num := 0
+	server_id := "server-id=100"
+	report_host := "report-host=single-5000"
+	version := "8.0.11"
+	switch sb_type {
+	case "replication-node":
+		node_num = 1
+		report_host = "report-host = node-1"
+		version = "5.7.22"
+	case "group-node":
+		node_num = 2
+		report_host = ""
+	}
+	return common.Smap{
+		"Basedir":              "/opt/mysql/" + version,
+		"Copyright":            Copyright,
+		"SandboxDir":           "/sandboxes/msb_sample",
+		"CustomMysqld":         "",
+		"DbDeployer":           "dbdeployer",
+		"Port":                 5000 + node_num,
+		"MysqlXPort":           15000 + node_num,
+		"MysqlShell":           "/opt/mysql/" + version + "/bin/mysqlsh",
+		"BasePort":             5000,
+		"Prompt":               "mysql",
+		"Version":              version,
+		"Datadir":              "/sandboxes/msb_sample/data",
+		"Tmpdir":               "/sandboxes/msb_sample/tmp",
+		"GlobalTmpDir":         "/tmp",
+		"DbUser":               "msandbox",
+		"DbPassword":           "msandbox",
+		"RplUser":              "rsandbox",
+		"RplPassword":          "rsandbox",
+		"RemoteAccess":         "127.%",
+		"BindAddress":          "127.0.0.1",
+		"OsUser":               "sample",
+		"ReplOptions":          "",
+		"GtidOptions":          "",
+		"ReplCrashSafeOptions": "",
+		"SemiSyncOptions":      "",
+		"ExtraOptions":         "",
+		"ReportHost":           report_host,
+		"ReportPort":           fmt.Sprintf("report-port=%d", 5000+node_num),
+		"HistoryDir":           "",
+		"ServerId":             server_id,
+		"InitScript":           "/opt/mysql/" + version + "/bin/mysqld",
+		"InitDefaults":         "--no-defaults",
+		"ExtraInitFlags":       "--initialize-insecure",
+		"FixUuidFile1":         "",
+		"FixUuidFile2":         "",
+	}
+}
+
+func sample_multiple_data() common.Smap {
+	return multiple_data("/sandboxes/sample", sample_node_ports)
+}
+
+func sample_master_slave_data() common.Smap {
+	return master_slave_data(nil, sample_sdef("5.7.22"), "127.0.0.1", 5001, map[int]int{1: 5002, 2: 5003})
+}
+
+// All-masters uses the same data, with all the nodes in both lists
+func sample_fan_in_data() common.Smap {
+	data := multiple_data("/sandboxes/sample", sample_node_ports)
+	add_multi_source_data(sample_sdef("8.0.11"), data, "127.0.0.1", "1 2", "3")
+	data["Node"] = 3
+	return data
+}
+
+func sample_tree_data() common.Smap {
+	data := multiple_data("/sandboxes/sample", sample_node_ports)
+	add_tree_data(nil, sample_sdef("8.0.11"), data, TreeTopology, map[int]int{2: 1, 3: 2}, "127.0.0.1")
+	return data
+}
+
+func sample_group_data() common.Smap {
+	return group_data(sample_sdef("8.0.11"), "127.0.0.1", "1 2 3", "1 2 3", 5000, 3)
+}
+
+func sample_galera_data() common.Smap {
+	return galera_data(sample_sdef("10.2.15"), "127.0.0.1", 5000, 3)
+}
+
+func sample_ndb_data() common.Smap {
+	return ndb_data(sample_sdef("8.0.11"), "127.0.0.1", 6000, 5000, 3, 3)
+}
+
+// ValidateTemplates parses every template in the given collections and
+// renders it with sample data for each deployment that uses it.
+// It returns the templates with syntax errors or missing variables.
+func ValidateTemplates(collections AllTemplateCollection) (checked int, failures []TemplateError) {
+	var groups []string
+	for group := range collections {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		var names []string
+		for name := range collections[group] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			// The copyright notice is plain text used as a variable
+			if name == "Copyright" {
+				continue
+			}
+			checked++
+			contents := common.TrimmedLines(collections[group][name].Contents)
+			_, err := template.New(name).Parse(contents)
+			if err != nil {
+				failures = append(failures, TemplateError{Group: group, Name: name, Err: err})
+				continue
+			}
+			var failed []string
+			var first_error error
+			for _, use := range template_sample_uses(group, name) {
+				data := use.data()
+				data["TemplateName"] = name
+				_, err = common.TprintfStrict(contents, data)
+				if err != nil {
+					if first_error == nil {
+						first_error = err
+					}
+					failed = append(failed, use.sb_type)
+				}
+			}
+			if first_error != nil {
+				failures = append(failures, TemplateError{Group: group, Name: name, SandboxTypes: failed, Err: first_error})
+			}
+		}
+	}
+	return
+}
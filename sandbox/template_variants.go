@@ -0,0 +1,76 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/datacharmer/dbdeployer/common"
+)
+
+// Templates that have different contents for different versions are
+// defined as variants of a family. Each variant declares the versions
+// it applies to, and the variant without versions is the default.
+// Asking for a family name gets the variant for the wanted version.
+// Ranges of MySQL variants end before 10.0.0, so that MariaDB 10
+// gets the default variant, like older MySQL versions.
+
+// ResolveTemplate returns the name of the template to use for a given
+// version. A name that is not a family is returned unchanged.
+func ResolveTemplate(collection TemplateCollection, name, version string) (string, error) {
+	if _, ok := collection[name]; ok {
+		return name, nil
+	}
+	var variants []string
+	for variant_name, template := range collection {
+		if template.Family == name {
+			variants = append(variants, variant_name)
+		}
+	}
+	if len(variants) == 0 {
+		return "", fmt.Errorf("template '%s' not found", name)
+	}
+	if version == "" {
+		return "", fmt.Errorf("a version is needed to choose a variant of template '%s'", name)
+	}
+	sort.Strings(variants)
+	default_variant := ""
+	var matching []string
+	for _, variant_name := range variants {
+		versions := collection[variant_name].Versions
+		if versions == "" {
+			default_variant = variant_name
+			continue
+		}
+		in_range, err := common.VersionInRange(version, versions)
+		if err != nil {
+			return "", fmt.Errorf("template '%s': %s", variant_name, err)
+		}
+		if in_range {
+			matching = append(matching, variant_name)
+		}
+	}
+	switch {
+	case len(matching) == 1:
+		return matching[0], nil
+	case len(matching) > 1:
+		return "", fmt.Errorf("version %s matches more than one variant of template '%s': %v", version, name, matching)
+	case default_variant != "":
+		return default_variant, nil
+	}
+	return "", fmt.Errorf("no variant of template '%s' applies to version %s", name, version)
+}
@@ -25,6 +25,8 @@ type TemplateDesc struct {
 	Description string
 	Notes       string
 	Contents    string
+	Family      string // generic name shared by the variants of a template
+	Versions    string // versions where this variant applies (see common.VersionInRange)
 }
 
 type TemplateCollection map[string]TemplateDesc
@@ -87,69 +89,8 @@ var (
 		{{.Copyright}}
 		# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
 		source {{.SandboxDir}}/sb_include
-		MYSQLD_SAFE="bin/mysqld_safe"
-		CUSTOM_MYSQLD={{.CustomMysqld}}
-		if [ -n "$CUSTOM_MYSQLD" ]
-		then
-    		CUSTOM_MYSQLD="--mysqld=$CUSTOM_MYSQLD"
-		fi
-		if [ ! -f $BASEDIR/$MYSQLD_SAFE ]
-		then
-			echo "mysqld_safe not found in $BASEDIR/bin/"
-			exit 1
-		fi
-		MYSQLD_SAFE_OK=$(sh -n $BASEDIR/$MYSQLD_SAFE 2>&1)
-		if [ "$MYSQLD_SAFE_OK" == "" ]
-		then
-			if [ "$SBDEBUG" == "2" ]
-			then
-				echo "$MYSQLD_SAFE OK"
-			fi
-		else
-			echo "$MYSQLD_SAFE has errors"
-			echo "((( $MYSQLD_SAFE_OK )))"
-			exit 1
-		fi
-
-		TIMEOUT=180
-		if [ -n "$(is_running)" ]
-		then
-			echo "sandbox server already started (found pid file $PIDFILE)"
-		else
-			if [ -f $PIDFILE ]
-			then
-				# Server is not running. Removing stale pid-file
-				rm -f $PIDFILE
-			fi
-			CURDIR=$(pwd)
-			cd $BASEDIR
-			if [ "$SBDEBUG" = "" ]
-			then
-				$MYSQLD_SAFE --defaults-file=$SBDIR/my.sandbox.cnf $CUSTOM_MYSQLD $@ > /dev/null 2>&1 &
-			else
-				$MYSQLD_SAFE --defaults-file=$SBDIR/my.sandbox.cnf $CUSTOM_MYSQLD $@ > "$SBDIR/start.log" 2>&1 &
-			fi
-			cd $CURDIR
-			ATTEMPTS=1
-			while [ ! -f $PIDFILE ]
-			do
-				ATTEMPTS=$(( $ATTEMPTS + 1 ))
-				echo -n "."
-				if [ $ATTEMPTS = $TIMEOUT ]
-				then
-					break
-				fi
-				sleep $SLEEP_TIME
-			done
-		fi
-
-		if [ -f $PIDFILE ]
-		then
-			echo " sandbox server started"
-		else
-			echo " sandbox server not started yet"
-			exit 1
-		fi
+		check_dbdeployer
+		$DBDEPLOYER admin start $SBDIR --custom-mysqld="{{.CustomMysqld}}" -- "$@"
 `
 	use_template string = `#!/bin/bash
 		{{.Copyright}}
@@ -210,27 +151,8 @@ fi
 		{{.Copyright}}
 		# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
 		source {{.SandboxDir}}/sb_include
-
-		MYSQL_ADMIN="$BASEDIR/bin/mysqladmin"
-
-		if [ -n "$(is_running)" ]
-		then
-			echo "stop $SBDIR"
-			# echo "$MYSQL_ADMIN --defaults-file=$SBDIR/my.sandbox.cnf $MYCLIENT_OPTIONS shutdown"
-			$MYSQL_ADMIN --defaults-file=$SBDIR/my.sandbox.cnf $MYCLIENT_OPTIONS shutdown
-			sleep $SLEEP_TIME
-		else
-			if [ -f $PIDFILE ]
-			then
-				rm -f $PIDFILE
-			fi
-		fi
-
-		if [ -n "$(is_running)" ]
-		then
-			# use the send_kill script if the server is not responsive
-			$SBDIR/send_kill
-		fi
+		check_dbdeployer
+		$DBDEPLOYER admin stop $SBDIR
 `
 	clear_template string = `#!/bin/bash
 		{{.Copyright}}
@@ -329,70 +251,22 @@ log-error={{.Datadir}}/msandbox.err
 		{{.Copyright}}
 		# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
 		source {{.SandboxDir}}/sb_include
-
-		TIMEOUT=30
-
-		mysqld_safe_pid=$(ps auxw | grep mysqld_safe | grep "defaults-file=$SBDIR" | awk '{print $2}')
-		if [ -n "$(is_running)" ]
-		then
-			MYPID=$(cat $PIDFILE)
-			kill -9 $mysqld_safe_pid
-			echo "Attempting normal termination --- kill -15 $MYPID"
-			kill -15 $MYPID
-			# give it a chance to exit peacefully
-			ATTEMPTS=1
-			while [ -f $PIDFILE ]
-			do
-				ATTEMPTS=$(( $ATTEMPTS + 1 ))
-				if [ $ATTEMPTS = $TIMEOUT ]
-				then
-					break
-				fi
-				sleep $SLEEP_TIME
-			done
-			if [ -f $PIDFILE ]
-			then
-				echo "SERVER UNRESPONSIVE --- kill -9 $MYPID"
-				kill -9 $MYPID
-				rm -f $PIDFILE
-			fi
-		else
-			# server not running - removing stale pid-file
-			if [ -f $PIDFILE ]
-			then
-				rm -f $PIDFILE
-			fi
-		fi
+		check_dbdeployer
+		$DBDEPLOYER admin stop $SBDIR --force
 `
 	status_template string = `#!/bin/bash
 		{{.Copyright}}
 		# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
 		source {{.SandboxDir}}/sb_include
-
-		baredir=$(basename $SBDIR)
-
-		node_status=off
-		exit_code=0
-		if [ -f $PIDFILE ]
-		then
-			MYPID=$(cat $PIDFILE)
-			running=$(ps -p $MYPID | grep $MYPID)
-			if [ -n "$running" ]
-			then
-				node_status=on
-				exit_code=0
-			fi
-		fi
-		echo "$baredir $node_status"
-		exit $exit_code
+		check_dbdeployer
+		$DBDEPLOYER admin status $SBDIR
 `
 	restart_template string = `#!/bin/bash
 		{{.Copyright}}
 		# Generated by dbdeployer {{.AppVersion}} using {{.TemplateName}} on {{.DateTime}}
 		source {{.SandboxDir}}/sb_include
-
-		$SBDIR/stop
-		$SBDIR/start $@
+		check_dbdeployer
+		$DBDEPLOYER admin restart $SBDIR --custom-mysqld="{{.CustomMysqld}}" -- "$@"
 `
 	load_grants_template string = `#!/bin/bash
 		{{.Copyright}}
@@ -974,6 +848,103 @@ fi
 
 exit $exit_code`
 
+	mysql_mock_template string = `#!/bin/bash
+# This script mimicks the minimal behavior of the mysql client,
+# answering the queries that dbdeployer uses to check whether
+# a server is ready.
+# Like a newly initialized server, it accepts only root without
+# password until the grants are loaded, and afterwards only users
+# with a password.
+query=""
+defaults_file=""
+socket=""
+user=""
+password=""
+while [ -n "$1" ]
+do
+    case "$1" in
+        -e)
+            shift
+            query="$1"
+            ;;
+        --defaults-file=*)
+            defaults_file="${1#--defaults-file=}"
+            ;;
+        --socket=*)
+            socket="${1#--socket=}"
+            ;;
+        -u)
+            shift
+            user="$1"
+            ;;
+        --user=*)
+            user="${1#--user=}"
+            ;;
+        --password=*)
+            password="${1#--password=}"
+            ;;
+        -p?*)
+            password="${1#-p}"
+            ;;
+    esac
+    shift
+done
+
+if [ -n "$defaults_file" -a -f "$defaults_file" ]
+then
+    [ -z "$user" ] && user=$(grep '^user' $defaults_file | head -n 1 | awk '{print $3}')
+    [ -z "$password" ] && password=$(grep '^password' $defaults_file | head -n 1 | awk '{print $3}')
+    [ -z "$socket" ] && socket=$(grep '^socket' $defaults_file | head -n 1 | awk '{print $3}')
+fi
+[ -z "$user" ] && user=root
+
+if [ -n "$socket" -a ! -e "$socket" ]
+then
+    echo "ERROR 2002 (HY000): Can't connect to local MySQL server through socket '$socket'" >&2
+    exit 1
+fi
+
+# The mock server writes its data directory into the socket file
+datadir=""
+[ -n "$socket" ] && datadir=$(cat $socket)
+if [ -n "$datadir" -a -d "$datadir" ]
+then
+    grants_loaded=""
+    [ -f $datadir/mock_grants_loaded ] && grants_loaded=1
+    using_password=NO
+    [ -n "$password" ] && using_password=YES
+    if [ -z "$grants_loaded" -a \( "$user" != "root" -o -n "$password" \) ] || [ -n "$grants_loaded" -a -z "$password" ]
+    then
+        echo "ERROR 1045 (28000): Access denied for user '$user'@'localhost' (using password: $using_password)" >&2
+        exit 1
+    fi
+    # The grants are loaded by a script, or replicated from a master
+    statements="$query"
+    [ -z "$statements" ] && statements=$(cat)
+    if [ -n "$(echo "$statements" | grep -i 'grant\|start slave')" ]
+    then
+        touch $datadir/mock_grants_loaded
+    fi
+fi
+
+case "$query" in
+    "SELECT 1")
+        echo 1
+        ;;
+    *read_only*)
+        printf "read_only\tOFF\nsuper_read_only\tOFF\n"
+        ;;
+    *MEMBER_STATE*)
+        echo ONLINE
+        ;;
+esac
+
+if [ -n "$MOCKMSG" ]
+then
+    echo $MOCKMSG
+fi
+exit ${FAILMOCK:-0}`
+
 	mysqld_safe_mock_template string = `#!/bin/bash
 # This script mimicks the minimal behavior of mysqld_safe
 # so that we can run tests for dbdeployer without using the real
@@ -1008,6 +979,13 @@ fi
 
 touch $pid_file
 
+socket=$(grep '^socket' $defaults_file | head -n 1 | awk '{print $3}')
+datadir=$(grep '^datadir' $defaults_file | head -n 1 | awk '{print $3}')
+if [ -n "$socket" ]
+then
+    echo $datadir > $socket
+fi
+
 exit 0
 `
 	sb_include_template string = `
@@ -1034,6 +1012,27 @@ function is_running
     fi
 }
 
+# The lifecycle scripts (start, stop, restart, status, send_kill)
+# delegate their work to dbdeployer.
+# The executable is resolved at run time: $DBDEPLOYER, when set,
+# then dbdeployer in $PATH, then the one that created the sandbox.
+function check_dbdeployer
+{
+    candidates="dbdeployer {{.DbDeployer}}"
+    [ -n "$DBDEPLOYER" ] && candidates="$DBDEPLOYER"
+    for candidate in $candidates
+    do
+        found=$(which $candidate 2> /dev/null)
+        if [ -n "$found" ]
+        then
+            DBDEPLOYER=$found
+            return
+        fi
+    done
+    echo "dbdeployer executable not found in \$PATH. Set DBDEPLOYER to its path"
+    exit 1
+}
+
 function check_output
 {
     # Checks if the output is a terminal or a pipe
@@ -1084,13 +1083,16 @@ function check_output
 		},
 		"gtid_options_56": TemplateDesc{
 			Description: "GTID options for my.cnf 5.6.x",
-			Notes:       "",
+			Notes:       "Default variant of gtid_options. Also used for MariaDB 10",
 			Contents:    gtid_options_56,
+			Family:      "gtid_options",
 		},
 		"gtid_options_57": TemplateDesc{
 			Description: "GTID options for my.cnf 5.7.x and 8.0",
 			Notes:       "",
 			Contents:    gtid_options_57,
+			Family:      "gtid_options",
+			Versions:    ">=5.7.0,<10.0.0",
 		},
 		"repl_crash_safe_options": TemplateDesc{
 			Description: "Replication crash safe options",
@@ -1109,7 +1111,7 @@ function check_output
 		},
 		"start_template": TemplateDesc{
 			Description: "starts the database in a single sandbox (with optional mysqld arguments)",
-			Notes:       "",
+			Notes:       "Calls 'dbdeployer admin start'",
 			Contents:    start_template,
 		},
 		"use_template": TemplateDesc{
@@ -1124,7 +1126,7 @@ function check_output
 		},
 		"stop_template": TemplateDesc{
 			Description: "Stops a database in a single sandbox",
-			Notes:       "",
+			Notes:       "Calls 'dbdeployer admin stop'",
 			Contents:    stop_template,
 		},
 		"clear_template": TemplateDesc{
@@ -1139,17 +1141,17 @@ function check_output
 		},
 		"status_template": TemplateDesc{
 			Description: "Shows the status of a single sandbox",
-			Notes:       "",
+			Notes:       "Calls 'dbdeployer admin status'",
 			Contents:    status_template,
 		},
 		"restart_template": TemplateDesc{
 			Description: "Restarts the database (with optional mysqld arguments)",
-			Notes:       "",
+			Notes:       "Calls 'dbdeployer admin restart'",
 			Contents:    restart_template,
 		},
 		"send_kill_template": TemplateDesc{
 			Description: "Sends a kill signal to the database",
-			Notes:       "",
+			Notes:       "Calls 'dbdeployer admin stop --force'",
 			Contents:    send_kill_template,
 		},
 		"load_grants_template": TemplateDesc{
@@ -1159,18 +1161,23 @@ function check_output
 		},
 		"grants_template5x": TemplateDesc{
 			Description: "Grants for sandboxes up to 5.6",
-			Notes:       "",
+			Notes:       "Default variant of grants_template. Also used for MariaDB 10",
 			Contents:    grants_template5x,
+			Family:      "grants_template",
 		},
 		"grants_template57": TemplateDesc{
 			Description: "Grants for sandboxes from 5.7+",
 			Notes:       "",
 			Contents:    grants_template57,
+			Family:      "grants_template",
+			Versions:    ">=5.7.6,<8.0.0",
 		},
 		"grants_template8x": TemplateDesc{
 			Description: "Grants for sandboxes from 8.0+",
 			Notes:       "",
 			Contents:    grants_template8x,
+			Family:      "grants_template",
+			Versions:    ">=8.0.0,<10.0.0",
 		},
 		"my_template": TemplateDesc{
 			Description: "Prefix script to run every my* command line tool",
@@ -1219,6 +1226,11 @@ function check_output
 			Notes:       "Used for internal tests",
 			Contents:    no_op_mock_template,
 		},
+		"mysql_mock_template": TemplateDesc{
+			Description: "mock script for the mysql client",
+			Notes:       "Used for internal tests",
+			Contents:    mysql_mock_template,
+		},
 		"mysqld_safe_mock_template": TemplateDesc{
 			Description: "mock script for mysqld_safe",
 			Notes:       "Used for internal tests",
@@ -1232,5 +1244,7 @@ function check_output
 		"multiple":    MultipleTemplates,
 		"replication": ReplicationTemplates,
 		"group":       GroupTemplates,
+		"galera":      GaleraTemplates,
+		"ndb":         NdbTemplates,
 	}
 )
@@ -0,0 +1,378 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+)
+
+const (
+	ChainTopology = "chain"
+	TreeTopology  = "tree"
+	RingTopology  = "ring"
+)
+
+// ParseReplicationTree reads a replication tree description, where each
+// item separated by ";" lists the slaves of a master (e.g. "1:2,3;2:4,5").
+// It returns the master of each slave.
+// Node 1 is the root of the tree. Every other node, from 2 to the highest
+// node number, must have exactly one master.
+func ParseReplicationTree(tree string) (map[int]int, error) {
+	return parse_replication_tree(tree, false)
+}
+
+// Parses a replication tree. When allow_gaps is set, the node numbers
+// don't need to be contiguous, as it happens in a sandbox where some
+// nodes were removed.
+func parse_replication_tree(tree string, allow_gaps bool) (map[int]int, error) {
+	masters := make(map[int]int)
+	max_node := 1
+	to_node := func(s string) (int, error) {
+		node, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || node < 1 {
+			return 0, fmt.Errorf("Invalid node number '%s' in replication tree '%s'", s, tree)
+		}
+		if node > max_node {
+			max_node = node
+		}
+		return node, nil
+	}
+	for _, item := range strings.Split(tree, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("Invalid item '%s' in replication tree. Expected format: master:slave[,slave]", item)
+		}
+		master, err := to_node(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range strings.Split(parts[1], ",") {
+			slave, err := to_node(s)
+			if err != nil {
+				return nil, err
+			}
+			if slave == 1 {
+				return nil, fmt.Errorf("Node 1 is the root of the replication tree and can't be a slave")
+			}
+			if slave == master {
+				return nil, fmt.Errorf("Node %d can't be a slave of itself", slave)
+			}
+			if masters[slave] != 0 {
+				return nil, fmt.Errorf("Node %d has more than one master (%d and %d)", slave, masters[slave], master)
+			}
+			masters[slave] = master
+		}
+	}
+	if len(masters) == 0 {
+		return nil, fmt.Errorf("No slaves defined in replication tree '%s'", tree)
+	}
+	for N := 2; N <= max_node && !allow_gaps; N++ {
+		if masters[N] == 0 {
+			return nil, fmt.Errorf("Node %d is not a slave of any node in replication tree '%s'", N, tree)
+		}
+	}
+	// Every node must reach the root by following its masters
+	for slave := range masters {
+		node := slave
+		for steps := 0; node != 1; steps++ {
+			if steps > len(masters) {
+				return nil, fmt.Errorf("Circular replication found for node %d in replication tree '%s'", slave, tree)
+			}
+			node = masters[node]
+		}
+	}
+	return masters, nil
+}
+
+// ChainReplicationTree returns the replication tree where each node
+// is the master of the next one (1:2;2:3;...)
+func ChainReplicationTree(nodes int) string {
+	var items []string
+	for N := 1; N < nodes; N++ {
+		items = append(items, fmt.Sprintf("%d:%d", N, N+1))
+	}
+	return strings.Join(items, ";")
+}
+
+// Returns the replication tree that corresponds to the given masters.
+// It is the inverse of ParseReplicationTree.
+func format_replication_tree(masters map[int]int) string {
+	slaves := tree_slaves(masters)
+	var master_list []int
+	for master := range slaves {
+		master_list = append(master_list, master)
+	}
+	sort.Ints(master_list)
+	var items []string
+	for _, master := range master_list {
+		items = append(items, fmt.Sprintf("%d:%s", master, strings.Trim(strings.Replace(fmt.Sprint(slaves[master]), " ", ",", -1), "[]")))
+	}
+	return strings.Join(items, ";")
+}
+
+// CompactReplicationTree numbers again the nodes of a replication tree
+// where some nodes were removed, so that they go from 1 to the number of
+// nodes, as they would in a new deployment.
+func CompactReplicationTree(tree string) (string, error) {
+	masters, err := parse_replication_tree(tree, true)
+	if err != nil {
+		return "", err
+	}
+	var nodes []int
+	for slave := range masters {
+		nodes = append(nodes, slave)
+	}
+	sort.Ints(nodes)
+	new_number := map[int]int{1: 1}
+	for i, N := range nodes {
+		new_number[N] = i + 2
+	}
+	compact := make(map[int]int)
+	for slave, master := range masters {
+		compact[new_number[slave]] = new_number[master]
+	}
+	return format_replication_tree(compact), nil
+}
+
+// Returns the masters of the nodes of an installed chain, tree, or ring sandbox
+func replication_tree_masters(dd DeploymentDefinition) (map[int]int, error) {
+	switch dd.Topology {
+	case RingTopology:
+		return ring_masters(dd.Nodes), nil
+	case ChainTopology:
+		return ParseReplicationTree(ChainReplicationTree(dd.Nodes))
+	}
+	return parse_replication_tree(dd.Sdef.ReplicationTree, true)
+}
+
+// Returns the masters of a ring, where each node is the master of the
+// next one, and the last node is the master of the first one
+func ring_masters(nodes int) map[int]int {
+	masters := make(map[int]int)
+	for N := 1; N <= nodes; N++ {
+		masters[N] = N - 1
+	}
+	masters[1] = nodes
+	return masters
+}
+
+// Returns the sorted list of slaves for each master
+func tree_slaves(masters map[int]int) map[int][]int {
+	slaves := make(map[int][]int)
+	for slave, master := range masters {
+		slaves[master] = append(slaves[master], slave)
+	}
+	for master := range slaves {
+		sort.Ints(slaves[master])
+	}
+	return slaves
+}
+
+// Visits the nodes of the tree, each master before its slaves,
+// starting from node 1. Nodes already visited are skipped, so that
+// a ring is visited only once.
+func walk_replication_tree(slaves map[int][]int, node, depth int, visited map[int]bool, visit func(node, depth int)) {
+	if visited[node] {
+		return
+	}
+	visited[node] = true
+	visit(node, depth)
+	for _, slave := range slaves[node] {
+		walk_replication_tree(slaves, slave, depth+1, visited, visit)
+	}
+}
+
+// Adds to the data of a multiple sandbox, as created by multiple_data,
+// the data used by the replication scripts of chain, tree, and ring topologies
+func add_tree_data(logger *defaults.Logger, sdef SandboxDef, data common.Smap, topology string, masters map[int]int, master_ip string) {
+	slaves := tree_slaves(masters)
+	node_ports := make(map[int]int)
+	var master_list, slave_list []int
+	for _, node_data := range data["Nodes"].([]common.Smap) {
+		N := node_data["Node"].(int)
+		node_ports[N] = node_data["NodePort"].(int)
+		if len(slaves[N]) > 0 {
+			master_list = append(master_list, N)
+		}
+		if masters[N] != 0 {
+			slave_list = append(slave_list, N)
+		}
+	}
+	master_auto_position, change_master_extra := change_master_options(logger, sdef)
+	node_label := defaults.Defaults().NodePrefix
+	var tree_nodes []common.Smap
+	var slaves_data []common.Smap
+	walk_replication_tree(slaves, 1, 0, make(map[int]bool), func(node, depth int) {
+		if topology == RingTopology {
+			depth = 0
+		}
+		var slave_names []string
+		for _, slave := range slaves[node] {
+			slave_names = append(slave_names, fmt.Sprintf("%s%d", node_label, slave))
+		}
+		tree_nodes = append(tree_nodes, common.Smap{
+			"Node":       node,
+			"NodeLabel":  node_label,
+			"MasterNode": masters[node],
+			"SlaveList":  strings.Join(slave_names, " "),
+			"Indent":     strings.Repeat("    ", depth),
+		})
+		if masters[node] == 0 {
+			return
+		}
+		slaves_data = append(slaves_data, common.Smap{
+			"Node":               node,
+			"NodeLabel":          node_label,
+			"MasterNode":         masters[node],
+			"MasterIp":           master_ip,
+			"MasterPort":         node_ports[masters[node]],
+			"RplUser":            sdef.RplUser,
+			"RplPassword":        sdef.RplPassword,
+			"ChangeMasterExtra":  change_master_extra,
+			"MasterAutoPosition": master_auto_position,
+		})
+	})
+	data["TreeNodes"] = tree_nodes
+	data["Slaves"] = slaves_data
+	data["MasterList"] = strings.Trim(fmt.Sprint(master_list), "[]")
+	data["SlaveList"] = strings.Trim(fmt.Sprint(slave_list), "[]")
+	data["NodeLabel"] = node_label
+}
+
+// Writes the replication scripts of chain, tree, and ring topologies.
+// The data must contain the nodes of the sandbox, as created by multiple_data
+func write_tree_scripts(logger *defaults.Logger, sdef SandboxDef, data common.Smap, topology string, masters map[int]int, master_ip string) error {
+	sandbox_dir := data["SandboxDir"].(string)
+	add_tree_data(logger, sdef, data, topology, masters, master_ip)
+	logger.Printf("Defining %s replication data: %v\n", topology, SmapToJson(data))
+
+	slave_label := defaults.Defaults().SlavePrefix
+	logger.Printf("Writing %s replication scripts in %s\n", topology, sandbox_dir)
+	return write_scripts(ScriptBatch{
+		tc:         DeploymentTemplates(sdef, ReplicationTemplates),
+		logger:     logger,
+		data:       data,
+		sandboxDir: sandbox_dir,
+		scripts: []ScriptDef{
+			{"use_all_slaves", "multi_source_use_slaves_template", true},
+			{"use_all_masters", "multi_source_use_masters_template", true},
+			{"initialize_" + slave_label + "s", "tree_init_slaves_template", true},
+			{"check_" + slave_label + "s", "tree_check_slaves_template", true},
+		},
+	})
+}
+
+func CreateTreeReplication(sdef SandboxDef, origin string, nodes int, master_ip string, topology string) (err error) {
+	finish_cleanup := start_cleanup(&sdef)
+	defer func() {
+		finish_cleanup(err)
+	}()
+	sdef.SBType = topology
+
+	var masters map[int]int
+	tree := sdef.ReplicationTree
+	switch topology {
+	case RingTopology:
+		masters = ring_masters(nodes)
+		tree = ChainReplicationTree(nodes) + fmt.Sprintf(";%d:1", nodes)
+	case ChainTopology:
+		tree = ChainReplicationTree(nodes)
+		fallthrough
+	default:
+		masters, err = ParseReplicationTree(tree)
+		if err != nil {
+			return err
+		}
+		if len(masters)+1 != nodes {
+			return fmt.Errorf("Replication tree '%s' has %d nodes. Expected: %d", tree, len(masters)+1, nodes)
+		}
+	}
+	fname, logger := defaults.NewLogger(common.LogDirName(), topology)
+	sdef.LogFileName = common.ReplaceLiteralHome(fname)
+	sdef.Logger = logger
+	if sdef.DirName == "" {
+		switch topology {
+		case ChainTopology:
+			sdef.DirName = defaults.Defaults().ChainPrefix + common.VersionToName(origin)
+		case RingTopology:
+			sdef.DirName = defaults.Defaults().RingPrefix + common.VersionToName(origin)
+		default:
+			sdef.DirName = defaults.Defaults().TreePrefix + common.VersionToName(origin)
+		}
+	}
+	if sdef.BasePort == 0 {
+		sdef.BasePort = defaults.Defaults().TreeReplicationBasePort
+	}
+	sandbox_dir := sdef.SandboxDir
+	sdef.SandboxDir = common.DirName(sdef.SandboxDir)
+	logger.Printf("Replication tree: %s\n", tree)
+
+	slaves := tree_slaves(masters)
+	var master_list, slave_list []int
+	for N := 1; N <= nodes; N++ {
+		if len(slaves[N]) > 0 {
+			master_list = append(master_list, N)
+		}
+		if masters[N] != 0 {
+			slave_list = append(slave_list, N)
+		}
+	}
+	sdef.PerNodeOptions = node_options_by_role(sdef, nodes, master_list, slave_list)
+	// Relay nodes must write the events they receive to their own
+	// binary log, or their slaves would not get them
+	for _, N := range master_list {
+		if masters[N] != 0 {
+			logger.Printf("Adding log-slave-updates to node %d\n", N)
+			sdef.PerNodeOptions[N] = append([]string{"log-slave-updates"}, sdef.PerNodeOptions[N]...)
+		}
+	}
+	// In a ring, every node accepts writes. Each node generates
+	// different auto-increment values, to avoid conflicts
+	if topology == RingTopology {
+		for N := 1; N <= nodes; N++ {
+			auto_increment := []string{
+				fmt.Sprintf("auto_increment_increment=%d", nodes),
+				fmt.Sprintf("auto_increment_offset=%d", N),
+			}
+			sdef.PerNodeOptions[N] = append(auto_increment, sdef.PerNodeOptions[N]...)
+		}
+	}
+	data, err := CreateMultipleSandbox(sdef, origin, nodes)
+	if err != nil {
+		return err
+	}
+
+	err = write_tree_scripts(logger, sdef, data, topology, masters, master_ip)
+	if err != nil {
+		return err
+	}
+	initialize_slaves := "initialize_" + defaults.Defaults().SlavePrefix + "s"
+	if !sdef.SkipStart {
+		logger.Printf("Initializing %s replication\n", topology)
+		fmt.Println(common.ReplaceLiteralHome(sandbox_dir) + "/" + initialize_slaves)
+		run_cmd(sandbox_dir + "/" + initialize_slaves)
+	}
+	return nil
+}
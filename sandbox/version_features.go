@@ -0,0 +1,72 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+)
+
+// Server features that change how a sandbox is deployed, with the
+// versions that have them (see common.VersionInRange).
+// As with template variants, supporting a new series means changing
+// these ranges rather than the code that checks them.
+// The ranges end before 10.0.0, so that MariaDB 10 is treated as an
+// older MySQL version, as it has always been.
+// Checks that depend on the flavor as well as on the version
+// (Galera, PXC state transfer) stay with their topology.
+var VersionFeatures = map[string]string{
+	"general-log":              ">=5.1.0,<10.0.0",
+	"pxc":                      ">=5.6.0,<10.0.0",
+	"server-uuid":              ">=5.6.9,<10.0.0",
+	"initialize":               ">=5.7.0,<10.0.0",
+	"multi-source-replication": ">=5.7.9,<10.0.0",
+	"mysqlx-plugin":            ">=5.7.12,<10.0.0",
+	"group-replication":        ">=5.7.17,<10.0.0",
+	"data-dictionary":          ">=8.0.0,<10.0.0",
+	"caching-sha2-password":    ">=8.0.4,<10.0.0",
+	"mysqlx-default":           ">=8.0.11,<10.0.0",
+}
+
+// Returns true if the given version has the feature.
+// An unknown feature is a programming error.
+func has_feature(version, feature string) bool {
+	version_range, ok := VersionFeatures[feature]
+	if !ok {
+		common.Exitf(1, "unknown server feature '%s'", feature)
+	}
+	in_range, err := common.VersionInRange(version, version_range)
+	return err == nil && in_range
+}
+
+// Returns the first version that has the feature, for error messages
+func feature_min_version(feature string) string {
+	for _, condition := range strings.Split(VersionFeatures[feature], ",") {
+		if strings.HasPrefix(condition, ">=") {
+			return strings.TrimPrefix(condition, ">=")
+		}
+	}
+	return ""
+}
+
+// Returns an error if the given version does not have the feature
+func check_feature(version, feature, description string) error {
+	if has_feature(version, feature) {
+		return nil
+	}
+	return &UnsupportedVersionError{Feature: description, Version: version, MinVersion: feature_min_version(feature)}
+}
@@ -0,0 +1,86 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2018 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// PackTar creates a compressed tarball with the given items (files or
+// directories) of base_dir. The names in the archive are relative to
+// base_dir, so that UnpackTar(tarball, base_dir) puts them back in place.
+func PackTar(tarball string, base_dir string, items []string) (err error) {
+	var file *os.File
+	if file, err = os.Create(tarball); err != nil {
+		return err
+	}
+	defer file.Close()
+	compressor := gzip.NewWriter(file)
+	defer compressor.Close()
+	writer := tar.NewWriter(compressor)
+	defer writer.Close()
+
+	for _, item := range items {
+		err = filepath.Walk(base_dir+"/"+item, func(filename string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			return packTarFile(writer, base_dir, filename, info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func packTarFile(writer *tar.Writer, base_dir, filename string, info os.FileInfo) (err error) {
+	var name string
+	if name, err = filepath.Rel(base_dir, filename); err != nil {
+		return err
+	}
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(filename); err != nil {
+			return err
+		}
+	} else if !info.IsDir() && !info.Mode().IsRegular() {
+		// Sockets and other special files are not archived
+		return nil
+	}
+	var header *tar.Header
+	if header, err = tar.FileInfoHeader(info, link); err != nil {
+		return err
+	}
+	header.Name = name
+	if err = writer.WriteHeader(header); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	var reader *os.File
+	if reader, err = os.Open(filename); err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = io.Copy(writer, reader)
+	return err
+}